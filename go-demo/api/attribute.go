@@ -0,0 +1,186 @@
+package api
+
+import (
+	"api-demo/domain"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/swaggo/swag"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// AttributesDto holds the custom attributes of a user. In xml every attribute
+// is an element named after it, as in <department>eng</department>
+type AttributesDto map[string]any
+
+func (a AttributesDto) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := e.EncodeElement(domain.FormatAttribute(a[name]), xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML reads every attribute as a string, the attribute schema turns
+// them into their type
+func (a *AttributesDto) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	attributes := make(AttributesDto)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			var value string
+			if err := d.DecodeElement(&value, &tok); err != nil {
+				return err
+			}
+			attributes[tok.Name.Local] = value
+		case xml.EndElement:
+			*a = attributes
+			return nil
+		}
+	}
+}
+
+// AttributeDefDto is the definition of a custom attribute in an attribute
+// schema file
+type AttributeDefDto struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required"`
+	Values      []string `json:"values,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// ParseAttributeSchema parses a json list of AttributeDefDto
+func ParseAttributeSchema(data []byte) (domain.AttributeSchema, error) {
+	var defs []AttributeDefDto
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return domain.AttributeSchema{}, fmt.Errorf("could not parse attribute schema: %w", err)
+	}
+	domainDefs := make([]domain.AttributeDef, 0, len(defs))
+	for _, def := range defs {
+		domainDefs = append(domainDefs, domain.AttributeDef{
+			Name:        def.Name,
+			Type:        domain.AttributeType(def.Type),
+			Required:    def.Required,
+			Values:      def.Values,
+			Description: def.Description,
+		})
+	}
+	return domain.NewAttributeSchema(domainDefs...)
+}
+
+// WithAttributeSchema documents the custom attributes of the deployment in
+// the swagger output
+func WithAttributeSchema(schema domain.AttributeSchema) UserApiOption {
+	return func(u *UserApi) {
+		u.schema = schema
+	}
+}
+
+// attributesSwagger returns the swagger schema of the attributes of a user
+func attributesSwagger(schema domain.AttributeSchema, withRequired bool) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for _, def := range schema.Defs() {
+		property := map[string]any{}
+		switch def.Type {
+		case domain.AttributeInt:
+			property["type"], property["format"] = "integer", "int64"
+		case domain.AttributeBool:
+			property["type"] = "boolean"
+		case domain.AttributeEnum:
+			property["type"], property["enum"] = "string", def.Values
+		case domain.AttributeDate:
+			property["type"], property["format"] = "string", "date"
+		default:
+			property["type"] = "string"
+		}
+		if def.Description != "" {
+			property["description"] = def.Description
+		}
+		properties[def.Name] = property
+		if def.Required {
+			required = append(required, def.Name)
+		}
+	}
+	resp := map[string]any{
+		"type":                 "object",
+		"description":          "Custom attributes of the user",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if withRequired && len(required) > 0 {
+		resp["required"] = required
+	}
+	return resp
+}
+
+// swaggerDoc serves the generated swagger document with the attributes of
+// the user DTOs described by the attribute schema
+func (u *UserApi) swaggerDoc(c *fiber.Ctx) error {
+	doc, err := swag.ReadDoc()
+	if err != nil {
+		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+
+	var spec map[string]any
+	if err := json.Unmarshal([]byte(doc), &spec); err != nil {
+		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	definitions, _ := spec["definitions"].(map[string]any)
+	for name, withRequired := range map[string]bool{"api.UserDto": false, "api.CreateUserDto": true, "api.UpdateUserDto": true} {
+		definition, _ := definitions[name].(map[string]any)
+		if properties, ok := definition["properties"].(map[string]any); ok {
+			properties["attributes"] = attributesSwagger(u.schema, withRequired)
+		}
+	}
+	// the generic attribute query parameter becomes one per attribute
+	paths, _ := spec["paths"].(map[string]any)
+	users, _ := paths["/users"].(map[string]any)
+	if get, ok := users["get"].(map[string]any); ok {
+		params, _ := get["parameters"].([]any)
+		kept := make([]any, 0, len(params))
+		for _, param := range params {
+			if p, _ := param.(map[string]any); p["name"] != domain.AttributePrefix+"name" {
+				kept = append(kept, param)
+			}
+		}
+		for _, def := range u.schema.Defs() {
+			param := map[string]any{
+				"name":        domain.AttributePrefix + def.Name,
+				"in":          "query",
+				"type":        "string",
+				"description": "Only users with this value of the custom attribute " + def.Name,
+			}
+			if def.Type == domain.AttributeEnum {
+				param["enum"] = def.Values
+			}
+			kept = append(kept, param)
+		}
+		get["parameters"] = kept
+	}
+	return c.JSON(spec)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ParseAttributeSchema(t *testing.T) {
+	schema, err := ParseAttributeSchema([]byte(`[
+		{"name":"department","type":"enum","required":true,"values":["eng","ops"]},
+		{"name":"startDate","type":"date","description":"First day at work"}
+	]`))
+	assert.NoError(t, err)
+	def, ok := schema.Def("startDate")
+	assert.True(t, ok, "expected startDate to be defined")
+	assert.Equal(t, domain.AttributeDef{Name: "startDate", Type: domain.AttributeDate, Description: "First day at work"}, def)
+
+	_, err = ParseAttributeSchema([]byte(`[{"name":"height","type":"float"}]`))
+	assert.Error(t, err, "expected unknown type to fail")
+
+	_, err = ParseAttributeSchema([]byte(`{`))
+	assert.Error(t, err, "expected malformed schema to fail")
+}
+
+func Test_AttributesDto_XML(t *testing.T) {
+	dto := UserDto{Id: "1", Name: "Sasi", Attributes: AttributesDto{"remote": true, "department": "eng", "level": int64(3)}}
+
+	b, err := XMLCodec{}.Marshal(dto)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b),
+		`<attributes><department>eng</department><level>3</level><remote>true</remote></attributes>`)
+
+	var decoded UserDto
+	assert.NoError(t, XMLCodec{}.Unmarshal(b, &decoded))
+	assert.Equal(t, AttributesDto{"remote": "true", "department": "eng", "level": "3"}, decoded.Attributes,
+		"expected xml attributes to decode as strings")
+}
+
+func Test_CreateUser_Attributes(t *testing.T) {
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		CreateUser(context.Background(), domain.User{Name: "Sasi", Role: "user", Attributes: map[string]any{"department": "sales"}}).
+		Return(domain.User{}, fmt.Errorf("could not create user: %w",
+			domain.ErrInvalidAttribute{Name: "department", Msg: "expected one of eng, ops, got sales"}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users",
+		bytes.NewBufferString(`{"name":"Sasi","role":"user","attributes":{"department":"sales"}}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func Test_GetUsersByProperty_Attributes(t *testing.T) {
+	user := domain.NewUser("Sasi", "user")
+	user.Attributes = map[string]any{"department": "eng", "level": int64(3)}
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		GetByProperty(context.Background(), userPropertiesMatcher{domain.UserProperties{
+			Attributes: map[string]string{"department": "eng", "level": "3"},
+		}}).
+		Return([]domain.User{user}, nil)
+	userService.EXPECT().
+		GetByProperty(context.Background(), gomock.Any()).
+		Return(nil, domain.ErrInvalidAttribute{Name: "height", Msg: "unknown attribute"})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users?attributes.department=eng&attributes.level=3", nil), -1)
+	assert.NoError(t, err, "get users api failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Contains(t, string(respB), `"attributes":{"department":"eng","level":3}`)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users?attributes.height=tall", nil), -1)
+	assert.NoError(t, err, "get users api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_PatchUser_Attributes(t *testing.T) {
+	user := domain.NewUser("Sasi", "user")
+	user.Attributes = map[string]any{"department": "eng", "location": "Berlin"}
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		PatchUser(context.Background(), user.Id, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ any, patch domain.UserPatch) (domain.User, error) {
+			return patch(user)
+		}).
+		Times(2)
+
+	resp, body := patchRequest(t, app, user.Id.String(), MergePatchMediaType, `{"attributes":{"location":null,"level":3}}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, body)
+	var dto UserDto
+	assert.NoError(t, json.Unmarshal([]byte(body), &dto))
+	assert.Equal(t, AttributesDto{"department": "eng", "level": float64(3)}, dto.Attributes)
+
+	resp, body = patchRequest(t, app, user.Id.String(), MergePatchMediaType, `{"attributes":"eng"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, body)
+}
+
+func Test_SwaggerDoc_Attributes(t *testing.T) {
+	schema, err := domain.NewAttributeSchema(
+		domain.AttributeDef{Name: "department", Type: domain.AttributeEnum, Required: true, Values: []string{"eng", "ops"}},
+	)
+	assert.NoError(t, err)
+	_, app, _ := setup(t, WithAttributeSchema(schema))
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/docs/doc.json", nil), -1)
+	assert.NoError(t, err, "get swagger doc failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var spec struct {
+		Definitions map[string]struct {
+			Properties map[string]struct {
+				Properties map[string]map[string]any `json:"properties"`
+				Required   []string                  `json:"required"`
+			} `json:"properties"`
+		} `json:"definitions"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&spec))
+	attributes := spec.Definitions["api.CreateUserDto"].Properties["attributes"]
+	assert.Equal(t, []string{"department"}, attributes.Required)
+	assert.Equal(t, []any{"eng", "ops"}, attributes.Properties["department"]["enum"])
+	assert.Contains(t, spec.Definitions["api.UserDto"].Properties["attributes"].Properties, "department")
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/docs/doc.json", nil), -1)
+	assert.NoError(t, err, "get swagger doc failed")
+	var params struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&params))
+	var names []string
+	for _, param := range params.Paths["/users"]["get"].Parameters {
+		names = append(names, param.Name)
+	}
+	assert.Contains(t, names, "attributes.department")
+	assert.NotContains(t, names, "attributes.name")
+}
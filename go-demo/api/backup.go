@@ -0,0 +1,104 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+)
+
+// Backups takes and restores backup archives of every user, as
+// repo.Backups does
+type Backups interface {
+	Backup(ctx context.Context) ([]byte, error)
+	Restore(ctx context.Context, archive []byte) (int, error)
+}
+
+type RestoreDto struct {
+	Restored int `json:"restored"`
+}
+
+// BackupApi serves backups of every user of every tenant. Like TenantApi, it
+// expects routes under /admin to be guarded
+type BackupApi struct {
+	backups Backups
+}
+
+func NewBackupApi(backups Backups) (BackupApi, error) {
+	if backups == nil {
+		return BackupApi{}, fmt.Errorf("cannot create backup api, missing backups")
+	}
+	return BackupApi{backups: backups}, nil
+}
+
+// backupErrorStatus maps the errors of backups and restores to their status
+func backupErrorStatus(err error) int {
+	var invalid domain.ErrInvalidBackup
+	var conflict domain.ErrConflict
+	switch {
+	case errors.Is(err, domain.ErrSnapshotUnsupported):
+		return http.StatusNotImplemented
+	case errors.As(err, &invalid):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &conflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// @Summary      Back up every user
+// @Description  Take a consistent snapshot of every user of every tenant while writes go on, returned as a versioned archive with a checksum. Encrypted fields stay encrypted
+// @ID           backup
+// @Tags         admin
+// @Produce      json
+// @Success      200  {file}    file
+// @Failure      401  {object}  string
+// @Failure      403  {object}  string
+// @Failure      500  {object}  string
+// @Failure      501  {object}  string
+// @Router       /admin/backup [post]
+func (b *BackupApi) backup(c *fiber.Ctx) error {
+	archive, err := b.backups.Backup(c.UserContext())
+	if err != nil {
+		return sendError(c, backupErrorStatus(err), err)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="users.backup"`)
+	return c.Send(archive)
+}
+
+// @Summary      Restore every user
+// @Description  Replace every user of every tenant with those of a backup archive. The archive is validated against its format, checksum and count first, nothing is replaced if it is invalid
+// @ID           restore
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        archive  body  string  true  "Backup archive"
+// @Success      200  {object}  RestoreDto
+// @Failure      401  {object}  string
+// @Failure      403  {object}  string
+// @Failure      409  {object}  string
+// @Failure      422  {object}  string
+// @Failure      500  {object}  string
+// @Failure      501  {object}  string
+// @Router       /admin/restore [post]
+func (b *BackupApi) restore(c *fiber.Ctx) error {
+	restored, err := b.backups.Restore(c.UserContext(), c.Body())
+	if err != nil {
+		return sendError(c, backupErrorStatus(err), err)
+	}
+	return c.JSON(RestoreDto{Restored: restored})
+}
+
+func (b *BackupApi) AddRoutes(app *fiber.App) {
+	app.Post("/admin/backup", func(c *fiber.Ctx) error {
+		return b.backup(c)
+	})
+
+	app.Post("/admin/restore", func(c *fiber.Ctx) error {
+		return b.restore(c)
+	})
+}
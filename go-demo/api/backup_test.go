@@ -0,0 +1,82 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeBackups answers with archive, keeping the archive restored, and fails
+// with err
+type fakeBackups struct {
+	archive  []byte
+	restored []byte
+	err      error
+}
+
+func (f *fakeBackups) Backup(context.Context) ([]byte, error) {
+	return f.archive, f.err
+}
+
+func (f *fakeBackups) Restore(_ context.Context, archive []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.restored = archive
+	return 2, nil
+}
+
+func Test_BackupRestore(t *testing.T) {
+	backups := &fakeBackups{archive: []byte(`{"format":1}`)}
+
+	backupApi, err := NewBackupApi(backups)
+	assert.NoError(t, err, "backup api creation cannot fail")
+	app := fiber.New()
+	app.Use(PrincipalMiddleware(""))
+	app.Use("/admin", RequirePrincipal("root"))
+	backupApi.AddRoutes(app)
+
+	request := func(path, principal, body string) (*http.Response, string) {
+		req := httptest.NewRequest(http.MethodPost, "http://acme.com"+path, strings.NewReader(body))
+		if principal != "" {
+			req.Header.Set(DefaultPrincipalHeader, principal)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, "request failed")
+		respB, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err, "expected no error here")
+		return resp, string(respB)
+	}
+
+	resp, body := request("/admin/backup", "root", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"format":1}`, body)
+	assert.Equal(t, `attachment; filename="users.backup"`, resp.Header.Get(fiber.HeaderContentDisposition))
+
+	resp, _ = request("/admin/backup", "sasi", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, body = request("/admin/restore", "root", `{"format":1}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"restored":2}`, body)
+	assert.Equal(t, `{"format":1}`, string(backups.restored))
+
+	for err, status := range map[error]int{
+		domain.ErrInvalidBackup{Msg: "unknown format 2"}:                       http.StatusUnprocessableEntity,
+		fmt.Errorf("could not restore users: %w", domain.ErrConflict{}):        http.StatusConflict,
+		fmt.Errorf("cannot create backups: %w", domain.ErrSnapshotUnsupported): http.StatusNotImplemented,
+		errors.New("disk full"): http.StatusInternalServerError,
+	} {
+		backups.err = err
+		resp, _ = request("/admin/restore", "root", `{}`)
+		assert.Equal(t, status, resp.StatusCode, err.Error())
+	}
+}
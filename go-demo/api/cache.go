@@ -0,0 +1,73 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultCacheControl lets clients store user reads but makes them
+// revalidate every time, which conditional GETs make cheap
+const DefaultCacheControl = "private, no-cache"
+
+// entityTag returns a strong entity tag for an encoded representation
+func entityTag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches tag, using the
+// weak comparison RFC 9110 requires for If-None-Match
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates the preconditions of a GET or HEAD request against
+// the current validators of the representation. If-None-Match takes
+// precedence over If-Modified-Since
+func notModified(c *fiber.Ctx, tag string, lastModified time.Time) bool {
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+		return false
+	}
+	if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" {
+		return etagMatches(inm, tag)
+	}
+	if ims := c.Get(fiber.HeaderIfModifiedSince); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		// Last-Modified only has a precision of one second
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// cachePolicy maps route paths to the Cache-Control of their GET responses
+type cachePolicy map[string]string
+
+// cacheHeaders sets the validators and the Cache-Control of the route in
+// policy on a response with the given body, and reports whether the
+// request's preconditions make it a 304
+func cacheHeaders(c *fiber.Ctx, body []byte, lastModified time.Time, policy cachePolicy) bool {
+	tag := entityTag(body)
+	c.Set(fiber.HeaderETag, tag)
+	if !lastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+	if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		if cacheControl, ok := policy[c.Route().Path]; ok {
+			c.Set(fiber.HeaderCacheControl, cacheControl)
+		}
+	}
+	return notModified(c, tag, lastModified)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_EtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"a"`, `"a"`))
+	assert.True(t, etagMatches(`"b", W/"a"`, `"a"`))
+	assert.True(t, etagMatches(`*`, `"a"`))
+	assert.False(t, etagMatches(`"b"`, `"a"`))
+}
+
+func getRequest(t *testing.T, app *fiber.App, target string, header map[string]string) (*http.Response, string) {
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com"+target, nil).
+		WithContext(context.Background())
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "get api failed")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	return resp, string(respB)
+}
+
+func Test_GetUserById_ConditionalGet(t *testing.T) {
+	updatedAt := time.Date(2022, 10, 1, 12, 30, 15, 500, time.UTC)
+	user := domain.NewUser("Shashank Pachava", "admin")
+	user.CreatedAt, user.UpdatedAt = updatedAt, updatedAt
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil).Times(5)
+
+	target := "/users/" + user.Id.String()
+	resp, body := getRequest(t, app, target, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, "Sat, 01 Oct 2022 12:30:15 GMT", resp.Header.Get("Last-Modified"))
+	assert.Equal(t, DefaultCacheControl, resp.Header.Get("Cache-Control"))
+	assert.NotEmpty(t, body)
+
+	resp, body = getRequest(t, app, target, map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Empty(t, body)
+
+	resp, _ = getRequest(t, app, target, map[string]string{"If-Modified-Since": "Sat, 01 Oct 2022 12:30:15 GMT"})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, _ = getRequest(t, app, target, map[string]string{"If-Modified-Since": "Sat, 01 Oct 2022 12:30:14 GMT"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// If-None-Match takes precedence
+	resp, _ = getRequest(t, app, target, map[string]string{
+		"If-None-Match":     `"stale"`,
+		"If-Modified-Since": "Sat, 01 Oct 2022 12:30:15 GMT",
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func Test_GetUserById_EtagPerRepresentation(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil).Times(3)
+
+	target := "/users/" + user.Id.String()
+	resp, _ := getRequest(t, app, target, nil)
+	jsonEtag := resp.Header.Get("ETag")
+
+	resp, _ = getRequest(t, app, target, map[string]string{"Accept": "application/yaml"})
+	assert.NotEqual(t, jsonEtag, resp.Header.Get("ETag"))
+
+	resp, _ = getRequest(t, app, target+"?fields=id", nil)
+	assert.NotEqual(t, jsonEtag, resp.Header.Get("ETag"))
+}
+
+func Test_GetUsers_CollectionEtag(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	user1 := domain.NewUser("Sasi", "user")
+	user2 := domain.NewUser("Sridhar", "user")
+	user1.CreatedAt, user2.CreatedAt = now, now.Add(time.Second)
+
+	_, app, userService := setup(t)
+
+	// the service returns users in no particular order
+	userService.EXPECT().GetByProperty(context.Background(), userPropertiesMatcher{}).Return([]domain.User{user1, user2}, nil)
+	userService.EXPECT().GetByProperty(context.Background(), userPropertiesMatcher{}).Return([]domain.User{user2, user1}, nil)
+
+	resp, _ := getRequest(t, app, "/users", nil)
+	etag := resp.Header.Get("ETag")
+	assert.Empty(t, resp.Header.Get("Last-Modified"))
+
+	resp, _ = getRequest(t, app, "/users", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	user2.Role = "admin"
+	user2.UpdatedAt = now.Add(time.Minute)
+	userService.EXPECT().GetByProperty(context.Background(), userPropertiesMatcher{}).Return([]domain.User{user1, user2}, nil)
+
+	resp, _ = getRequest(t, app, "/users", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+}
+
+func Test_CacheControlPerRoute(t *testing.T) {
+	_, app, userService := setup(t,
+		WithCacheControl("/users/:id", "public, max-age=60"),
+		WithCacheControl("/users", ""),
+	)
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	userService.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil)
+	userService.EXPECT().GetByProperty(context.Background(), userPropertiesMatcher{}).Return(nil, nil)
+
+	resp, _ := getRequest(t, app, "/users/"+user.Id.String(), nil)
+	assert.Equal(t, "public, max-age=60", resp.Header.Get("Cache-Control"))
+
+	resp, _ = getRequest(t, app, "/users", nil)
+	assert.Empty(t, resp.Header.Get("Cache-Control"))
+}
@@ -0,0 +1,368 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Codec encodes and decodes one representation of the api's DTOs
+type Codec interface {
+	// MediaTypes lists the media types of the representation, the first one is
+	// the canonical one
+	MediaTypes() []string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) MediaTypes() []string {
+	return []string{"application/json"}
+}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// MessagePackCodec uses the json struct tags of the DTOs
+type MessagePackCodec struct{}
+
+func (MessagePackCodec) MediaTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+}
+
+func (MessagePackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	// sorted maps keep the representation, and so its ETag, stable
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MessagePackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// cborEncMode encodes times as RFC 3339 strings, like the json
+// representation, and sorts map keys so that the encoding is stable
+var cborEncMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano, Sort: cbor.SortBytewiseLexical}.EncMode()
+
+// CBORCodec uses the json struct tags of the DTOs
+type CBORCodec struct{}
+
+func (CBORCodec) MediaTypes() []string {
+	return []string{"application/cbor"}
+}
+
+func (CBORCodec) Marshal(v any) ([]byte, error) {
+	return cborEncMode.Marshal(v)
+}
+
+func (CBORCodec) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+// XMLCodec uses the xml struct tags of the DTOs. Lists are wrapped in an
+// items element
+type XMLCodec struct{}
+
+func (XMLCodec) MediaTypes() []string {
+	return []string{"application/xml", "text/xml"}
+}
+
+func (XMLCodec) Marshal(v any) ([]byte, error) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		return xml.Marshal(struct {
+			XMLName xml.Name `xml:"items"`
+			Items   any
+		}{Items: v})
+	}
+	return xml.Marshal(v)
+}
+
+func (XMLCodec) Unmarshal(data []byte, v any) error {
+	return xml.Unmarshal(data, v)
+}
+
+// YAMLCodec goes through the json representation, so it follows the json
+// struct tags and field order of the DTOs
+type YAMLCodec struct{}
+
+func (YAMLCodec) MediaTypes() []string {
+	return []string{"application/yaml", "application/x-yaml", "text/yaml"}
+}
+
+func (YAMLCodec) Marshal(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// json is yaml, decoding it into a node keeps the order of the fields
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	blockStyle(&node)
+	return yaml.Marshal(&node)
+}
+
+// blockStyle resets the flow style the json input gave to node and its children
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+func (YAMLCodec) Unmarshal(data []byte, v any) error {
+	var generic any
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrNotAcceptable        = errors.New("no acceptable media type")
+)
+
+// Codecs is a registry of codecs, in order of preference
+type Codecs struct {
+	codecs []Codec
+}
+
+func NewCodecs(codecs ...Codec) (Codecs, error) {
+	if len(codecs) == 0 {
+		return Codecs{}, fmt.Errorf("cannot create codecs, missing codec")
+	}
+	return Codecs{codecs: codecs}, nil
+}
+
+// DefaultCodecs prefers json, followed by MessagePack, CBOR, XML and YAML
+func DefaultCodecs() Codecs {
+	return Codecs{codecs: []Codec{JSONCodec{}, MessagePackCodec{}, CBORCodec{}, XMLCodec{}, YAMLCodec{}}}
+}
+
+// MediaTypes lists the canonical media type of every codec
+func (r Codecs) MediaTypes() []string {
+	mediaTypes := make([]string, 0, len(r.codecs))
+	for _, codec := range r.codecs {
+		mediaTypes = append(mediaTypes, codec.MediaTypes()[0])
+	}
+	return mediaTypes
+}
+
+// Decoder returns the codec for a request with the given Content-Type. A
+// missing Content-Type is read with the preferred codec
+func (r Codecs) Decoder(contentType string) (Codec, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return r.codecs[0], nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, err.Error())
+	}
+	for _, codec := range r.codecs {
+		for _, t := range codec.MediaTypes() {
+			if t == mediaType {
+				return codec, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnsupportedMediaType, mediaType, strings.Join(r.MediaTypes(), ", "))
+}
+
+// mediaRange is an entry of an Accept header
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// specificity ranks */* below type/* below a full media type
+func (m mediaRange) specificity() int {
+	switch {
+	case m.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(m.mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (m mediaRange) matches(mediaType string) bool {
+	switch m.specificity() {
+	case 0:
+		return true
+	case 1:
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(m.mediaType, "*"))
+	default:
+		return m.mediaType == mediaType
+	}
+}
+
+// parseAccept parses an Accept header, skipping malformed entries
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, entry := range strings.Split(accept, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(entry)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+	// the most specific range matching a media type decides its quality
+	sort.SliceStable(ranges, func(a, b int) bool {
+		return ranges[a].specificity() > ranges[b].specificity()
+	})
+	return ranges
+}
+
+// quality of mediaType according to ranges
+func quality(ranges []mediaRange, mediaType string) float64 {
+	for _, r := range ranges {
+		if r.matches(mediaType) {
+			return r.q
+		}
+	}
+	return 0
+}
+
+// Encoder returns the codec and media type of the response to a request with
+// the given Accept header. The highest quality wins, ties go to the preferred
+// codec. A missing Accept header gets the preferred codec
+func (r Codecs) Encoder(accept string) (Codec, string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return r.codecs[0], r.codecs[0].MediaTypes()[0], nil
+	}
+
+	ranges := parseAccept(accept)
+	var (
+		best          Codec
+		bestMediaType string
+		bestQ         float64
+	)
+	for _, codec := range r.codecs {
+		for _, mediaType := range codec.MediaTypes() {
+			if q := quality(ranges, mediaType); q > bestQ {
+				best, bestMediaType, bestQ = codec, mediaType, q
+			}
+		}
+	}
+	if best == nil {
+		return nil, "", fmt.Errorf("%w in %q, expected one of %s", ErrNotAcceptable, accept, strings.Join(r.MediaTypes(), ", "))
+	}
+	return best, bestMediaType, nil
+}
+
+// locals keys of the negotiated codecs
+const (
+	decoderLocal   = "api.decoder"
+	encoderLocal   = "api.encoder"
+	mediaTypeLocal = "api.mediaType"
+)
+
+// Negotiate picks the codecs of the request and response before the handler
+// runs, answering 415 for a request body it cannot decode and 406 when no
+// representation is acceptable
+func (r Codecs) Negotiate(c *fiber.Ctx) error {
+	// PATCH bodies are patch documents, the handler checks their media type
+	if len(c.Body()) > 0 && c.Method() != fiber.MethodPatch {
+		decoder, err := r.Decoder(c.Get(fiber.HeaderContentType))
+		if err != nil {
+			if writeErr := c.Status(http.StatusUnsupportedMediaType).SendString(err.Error()); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+		c.Locals(decoderLocal, decoder)
+	}
+
+	encoder, mediaType, err := r.Encoder(c.Get(fiber.HeaderAccept))
+	if err != nil {
+		if writeErr := c.Status(http.StatusNotAcceptable).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	c.Locals(encoderLocal, encoder)
+	c.Locals(mediaTypeLocal, mediaType)
+	c.Vary(fiber.HeaderAccept)
+	return c.Next()
+}
+
+// decodeBody decodes the request body into v with the negotiated codec,
+// defaulting to json
+func decodeBody(c *fiber.Ctx, v any) error {
+	decoder, ok := c.Locals(decoderLocal).(Codec)
+	if !ok {
+		decoder = JSONCodec{}
+	}
+	return decoder.Unmarshal(c.Body(), v)
+}
+
+// responseEncoder returns the negotiated codec and media type of the
+// response, defaulting to json
+func responseEncoder(c *fiber.Ctx) (Codec, string) {
+	encoder, ok := c.Locals(encoderLocal).(Codec)
+	mediaType, _ := c.Locals(mediaTypeLocal).(string)
+	if !ok || mediaType == "" {
+		return JSONCodec{}, JSONCodec{}.MediaTypes()[0]
+	}
+	return encoder, mediaType
+}
+
+// sendEncoded writes v with the negotiated codec and status
+func sendEncoded(c *fiber.Ctx, status int, v any) error {
+	encoder, mediaType := responseEncoder(c)
+	b, err := encoder.Marshal(v)
+	if err != nil {
+		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	c.Set(fiber.HeaderContentType, mediaType)
+	if _, writeErr := c.Status(status).Write(b); writeErr != nil {
+		log.Println("could not write to response body", writeErr.Error())
+	}
+	return nil
+}
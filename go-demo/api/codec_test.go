@@ -0,0 +1,177 @@
+package api
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Codecs_Encoder(t *testing.T) {
+	codecs := DefaultCodecs()
+	tests := []struct {
+		accept    string
+		mediaType string
+	}{
+		{accept: "", mediaType: "application/json"},
+		{accept: "*/*", mediaType: "application/json"},
+		{accept: "application/cbor", mediaType: "application/cbor"},
+		{accept: "text/xml", mediaType: "text/xml"},
+		{accept: "application/xml;q=0.5, application/msgpack;q=0.8", mediaType: "application/msgpack"},
+		{accept: "application/*;q=0.5, application/yaml", mediaType: "application/yaml"},
+		{accept: "*/*;q=0.1, application/json;q=0", mediaType: "application/msgpack"},
+		{accept: "text/html, text/*;q=0.2", mediaType: "text/xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			_, mediaType, err := codecs.Encoder(tt.accept)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.mediaType, mediaType)
+		})
+	}
+
+	_, _, err := codecs.Encoder("text/html, application/json;q=0")
+	assert.True(t, errors.Is(err, ErrNotAcceptable))
+}
+
+func Test_Codecs_Decoder(t *testing.T) {
+	codecs := DefaultCodecs()
+
+	codec, err := codecs.Decoder("")
+	assert.NoError(t, err)
+	assert.Equal(t, JSONCodec{}, codec)
+
+	codec, err = codecs.Decoder("application/x-yaml; charset=utf-8")
+	assert.NoError(t, err)
+	assert.Equal(t, YAMLCodec{}, codec)
+
+	_, err = codecs.Decoder("text/plain")
+	assert.True(t, errors.Is(err, ErrUnsupportedMediaType))
+}
+
+func Test_Codecs_RoundTrip(t *testing.T) {
+	deletedAt := time.Date(2022, 10, 2, 0, 0, 0, 0, time.UTC)
+	dto := UserDto{
+		Id:         "00000000-0000-0000-0000-000000000001",
+		Name:       "Shashank Pachava",
+		Role:       "admin",
+		Attributes: AttributesDto{"department": "eng"},
+		CreatedAt:  time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:  time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC),
+		DeletedAt:  &deletedAt,
+	}
+	for _, codec := range []Codec{JSONCodec{}, MessagePackCodec{}, CBORCodec{}, XMLCodec{}, YAMLCodec{}} {
+		t.Run(codec.MediaTypes()[0], func(t *testing.T) {
+			b, err := codec.Marshal(dto)
+			assert.NoError(t, err)
+
+			var decoded UserDto
+			assert.NoError(t, codec.Unmarshal(b, &decoded))
+			assert.Equal(t, dto.Id, decoded.Id)
+			assert.Equal(t, dto.Name, decoded.Name)
+			assert.Equal(t, dto.Attributes, decoded.Attributes)
+			assert.True(t, dto.CreatedAt.Equal(decoded.CreatedAt))
+			assert.True(t, dto.UpdatedAt.Equal(decoded.UpdatedAt))
+			if assert.NotNil(t, decoded.DeletedAt) {
+				assert.True(t, dto.DeletedAt.Equal(*decoded.DeletedAt))
+			}
+		})
+	}
+}
+
+func Test_CodecEncoding(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	dto := UserDto{Id: "1", Name: "Sasi", Role: "user", CreatedAt: now, UpdatedAt: now}
+
+	b, err := XMLCodec{}.Marshal([]UserDto{dto})
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`<items><user><id>1</id><name>Sasi</name><role>user</role>`+
+			`<createdAt>2022-10-01T00:00:00Z</createdAt><updatedAt>2022-10-01T00:00:00Z</updatedAt></user></items>`,
+		string(b))
+
+	b, err = YAMLCodec{}.Marshal(dto)
+	assert.NoError(t, err)
+	assert.Equal(t, "id: \"1\"\nname: Sasi\nrole: user\ncreatedAt: \"2022-10-01T00:00:00Z\"\nupdatedAt: \"2022-10-01T00:00:00Z\"\n", string(b))
+}
+
+func Test_CreateUser_MessagePack(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		CreateUser(context.Background(), domain.User{Name: user.Name, Role: user.Role}).
+		Return(user, nil)
+
+	body, err := MessagePackCodec{}.Marshal(CreateUserDto{Name: user.Name, Role: user.Role})
+	assert.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", bytes.NewReader(body)).
+		WithContext(context.Background())
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Accept", "application/cbor")
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/cbor", resp.Header.Get("Content-Type"))
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	var dto UserDto
+	assert.NoError(t, CBORCodec{}.Unmarshal(respB, &dto))
+	assert.Equal(t, user.Id.String(), dto.Id)
+	assert.Equal(t, user.Name, dto.Name)
+}
+
+func Test_UnsupportedMediaType(t *testing.T) {
+	_, app, _ := setup(t)
+
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", bytes.NewReader([]byte("name=Sasi"))).
+		WithContext(context.Background())
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func Test_NotAcceptable(t *testing.T) {
+	_, app, userService := setup(t)
+
+	userService.EXPECT().GetByProperty(gomock.Any(), gomock.Any()).Times(0)
+
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users", nil).
+		WithContext(context.Background())
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+	assert.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+}
+
+func Test_GetUserById_XMLFields(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+user.Id.String()+"?fields=name", nil).
+		WithContext(context.Background())
+	req.Header.Set("Accept", "application/xml")
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "get user api failed")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Equal(t, "<user><name>Shashank Pachava</name></user>", string(respB))
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FieldsQueryParam is the query parameter clients use to request a sparse
+// fieldset, e.g. ?fields=id,name
+const FieldsQueryParam = "fields"
+
+// ErrUnknownField is returned when a sparse fieldset names a field the DTO
+// does not have
+type ErrUnknownField struct {
+	Field   string
+	Allowed []string
+}
+
+func (e ErrUnknownField) Error() string {
+	return fmt.Sprintf("unknown field %q, expected one of %s", e.Field, strings.Join(e.Allowed, ", "))
+}
+
+// dtoFieldsCache caches the result of dtoFields by type
+var dtoFieldsCache sync.Map
+
+// dtoFields returns the json names of the fields of struct type t, in
+// declaration order
+func dtoFields(t reflect.Type) []string {
+	if cached, ok := dtoFieldsCache.Load(t); ok {
+		return cached.([]string)
+	}
+
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || f.Name == "XMLName" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+	}
+
+	dtoFieldsCache.Store(t, fields)
+	return fields
+}
+
+// dtoType returns the struct type of a DTO, or of the elements of a list of DTOs
+func dtoType(v any) (reflect.Type, bool) {
+	t := reflect.TypeOf(v)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	return t, t != nil && t.Kind() == reflect.Struct
+}
+
+// parseFieldset validates a comma separated list of fields against the fields
+// of t. An empty list selects every field and returns nil
+func parseFieldset(list string, t reflect.Type) (map[string]bool, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	allowed := dtoFields(t)
+	fieldset := make(map[string]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		found := false
+		for _, a := range allowed {
+			if a == field {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrUnknownField{Field: field, Allowed: allowed}
+		}
+		fieldset[field] = true
+	}
+	return fieldset, nil
+}
+
+// shapedTypes caches the result of shapedType by type and fieldset
+var shapedTypes sync.Map
+
+// shapedType returns a struct type with the fields of t in fieldset, keeping
+// their tags, and its XMLName so the xml element keeps its name
+func shapedType(t reflect.Type, fieldset map[string]bool) reflect.Type {
+	names := make([]string, 0, len(fieldset))
+	for name := range fieldset {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := t.String() + "|" + strings.Join(names, ",")
+	if cached, ok := shapedTypes.Load(key); ok {
+		return cached.(reflect.Type)
+	}
+
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		if f.Name == "XMLName" || fieldset[name] {
+			fields = append(fields, f)
+		}
+	}
+	shaped := reflect.StructOf(fields)
+	shapedTypes.Store(key, shaped)
+	return shaped
+}
+
+// shapeFieldset returns v, a DTO or a list of DTOs, with only the fields in
+// fieldset. A nil fieldset keeps every field
+func shapeFieldset(v any, fieldset map[string]bool) any {
+	t, ok := dtoType(v)
+	if !ok || fieldset == nil {
+		return v
+	}
+	shaped := shapedType(t, fieldset)
+
+	shape := func(dto reflect.Value) reflect.Value {
+		for dto.Kind() == reflect.Pointer {
+			dto = dto.Elem()
+		}
+		out := reflect.New(shaped).Elem()
+		for i := 0; i < shaped.NumField(); i++ {
+			out.Field(i).Set(dto.FieldByName(shaped.Field(i).Name))
+		}
+		return out
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		list := reflect.MakeSlice(reflect.SliceOf(shaped), 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			list = reflect.Append(list, shape(rv.Index(i)))
+		}
+		return list.Interface()
+	}
+	return shape(rv).Interface()
+}
+
+// fieldsetParam checks the sparse fieldset requested with FieldsQueryParam
+// against the fields of dto, writing a 400 if it names an unknown field.
+// Handlers that write call it before changing anything, so that a bad
+// fieldset does not fail a request whose change went through
+func fieldsetParam(c *fiber.Ctx, dto any) bool {
+	t, ok := dtoType(dto)
+	if !ok {
+		return true
+	}
+	if _, err := parseFieldset(c.Query(FieldsQueryParam), t); err != nil {
+		_ = sendError(c, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// encodeResponse writes v, a DTO or a list of DTOs, with the negotiated codec,
+// honouring the sparse fieldset requested with FieldsQueryParam and the
+// conditional headers of the request, with the Cache-Control of the route in
+// policy. A zero lastModified is not sent
+func encodeResponse(c *fiber.Ctx, v any, lastModified time.Time, policy cachePolicy) error {
+	var fieldset map[string]bool
+	if t, ok := dtoType(v); ok {
+		var err error
+		fieldset, err = parseFieldset(c.Query(FieldsQueryParam), t)
+		if err != nil {
+			if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+	}
+
+	encoder, mediaType := responseEncoder(c)
+	b, err := encoder.Marshal(shapeFieldset(v, fieldset))
+	if err != nil {
+		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	c.Set(fiber.HeaderContentType, mediaType)
+	if cacheHeaders(c, b, lastModified, policy) {
+		c.Status(http.StatusNotModified)
+		return nil
+	}
+	if _, writeErr := c.Write(b); writeErr != nil {
+		log.Println("could not write to response body", writeErr.Error())
+	}
+	return nil
+}
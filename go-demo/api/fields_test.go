@@ -0,0 +1,73 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fieldsTestDto struct {
+	Id       string  `json:"id"`
+	Comment  *string `json:"comment,omitempty"`
+	Internal string  `json:"-"`
+	Plain    int
+	hidden   bool
+}
+
+func Test_DtoFields(t *testing.T) {
+	assert.Equal(t, []string{"id", "comment", "Plain"}, dtoFields(reflect.TypeOf(fieldsTestDto{})))
+}
+
+func Test_ShapeFieldset(t *testing.T) {
+	dto := fieldsTestDto{Id: "a", Internal: "secret", Plain: 1}
+	tests := []struct {
+		name   string
+		v      any
+		fields string
+		want   string
+	}{
+		{name: "all fields", v: dto, fields: "", want: `{"id":"a","Plain":1}`},
+		{name: "declaration order", v: dto, fields: "Plain,id", want: `{"id":"a","Plain":1}`},
+		{name: "omitted field", v: dto, fields: "comment", want: `{}`},
+		{name: "list", v: []fieldsTestDto{dto, dto}, fields: "id", want: `[{"id":"a"},{"id":"a"}]`},
+		{name: "empty list", v: []fieldsTestDto{}, fields: "id", want: `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, ok := dtoType(tt.v)
+			assert.True(t, ok)
+			fieldset, err := parseFieldset(tt.fields, typ)
+			assert.NoError(t, err)
+			b, err := JSONCodec{}.Marshal(shapeFieldset(tt.v, fieldset))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(b))
+		})
+	}
+}
+
+func Test_ParseFieldset_Unknown(t *testing.T) {
+	_, err := parseFieldset("id,Internal", reflect.TypeOf(fieldsTestDto{}))
+	assert.Equal(t, ErrUnknownField{Field: "Internal", Allowed: []string{"id", "comment", "Plain"}}, err)
+}
+
+func Test_EncodeResponse(t *testing.T) {
+	app := fiber.New()
+	app.Use(DefaultCodecs().Negotiate)
+	app.Get("/things", func(c *fiber.Ctx) error {
+		return encodeResponse(c, fieldsTestDto{Id: "a", Plain: 1}, time.Time{}, cachePolicy{"/things": "public, max-age=60"})
+	})
+
+	resp, body := getRequest(t, app, "/things?fields=id", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"id":"a"}`, body)
+	assert.Equal(t, "public, max-age=60", resp.Header.Get(fiber.HeaderCacheControl))
+
+	resp, _ = getRequest(t, app, "/things?fields=id", map[string]string{fiber.HeaderIfNoneMatch: resp.Header.Get(fiber.HeaderETag)})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, _ = getRequest(t, app, "/things?fields=Internal", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,445 @@
+package api
+
+import (
+	"api-demo/domain"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type CreateGroupDto struct {
+	XMLName     xml.Name `json:"-" xml:"group"`
+	Name        string   `json:"name" xml:"name"`
+	Description string   `json:"description" xml:"description"`
+}
+
+type UpdateGroupDto struct {
+	XMLName     xml.Name `json:"-" xml:"group"`
+	Name        string   `json:"name" xml:"name"`
+	Description string   `json:"description" xml:"description"`
+}
+
+type AddMemberDto struct {
+	XMLName xml.Name `json:"-" xml:"member"`
+	UserId  string   `json:"userId" xml:"userId" sensitive:"true" example:"[REDACTED]"`
+}
+
+type GroupDto struct {
+	XMLName     xml.Name  `json:"-" xml:"group"`
+	Id          string    `json:"id" xml:"id"`
+	Name        string    `json:"name" xml:"name"`
+	Description string    `json:"description,omitempty" xml:"description,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" xml:"createdAt"`
+	CreatedBy   string    `json:"createdBy,omitempty" xml:"createdBy,omitempty" sensitive:"true" example:"[REDACTED]"`
+	UpdatedAt   time.Time `json:"updatedAt" xml:"updatedAt"`
+	UpdatedBy   string    `json:"updatedBy,omitempty" xml:"updatedBy,omitempty" sensitive:"true" example:"[REDACTED]"`
+}
+
+// MemberPageDto is a page of the members of a group, Total counts all of them
+type MemberPageDto struct {
+	XMLName xml.Name  `json:"-" xml:"members"`
+	Members []UserDto `json:"members" xml:"user"`
+	Offset  int       `json:"offset" xml:"offset"`
+	Limit   int       `json:"limit" xml:"limit"`
+	Total   int       `json:"total" xml:"total"`
+}
+
+func groupToDto(g domain.Group) GroupDto {
+	return GroupDto{
+		Id:          g.Id.String(),
+		Name:        g.Name,
+		Description: g.Description,
+		CreatedAt:   g.CreatedAt,
+		CreatedBy:   g.CreatedBy,
+		UpdatedAt:   g.UpdatedAt,
+		UpdatedBy:   g.UpdatedBy,
+	}
+}
+
+func groupsToDto(groups []domain.Group) []GroupDto {
+	resp := make([]GroupDto, 0, len(groups))
+	for _, group := range groups {
+		resp = append(resp, groupToDto(group))
+	}
+	return resp
+}
+
+type GroupApi struct {
+	service      domain.GroupService
+	codecs       Codecs
+	cacheControl cachePolicy
+}
+
+func NewGroupApi(service domain.GroupService) (GroupApi, error) {
+	if service == nil {
+		return GroupApi{}, fmt.Errorf("cannot create group api, missing service")
+	}
+	return GroupApi{
+		service: service,
+		codecs:  DefaultCodecs(),
+		cacheControl: cachePolicy{
+			"/groups":             DefaultCacheControl,
+			"/groups/:id":         DefaultCacheControl,
+			"/groups/:id/members": DefaultCacheControl,
+			"/users/:id/groups":   DefaultCacheControl,
+		},
+	}, nil
+}
+
+// groupErrorStatus maps the errors of the group service to their status
+func groupErrorStatus(err error) int {
+	var groupNotFound domain.ErrGroupIdNotFound
+	var userNotFound domain.ErrUserIdNotFound
+	var membershipNotFound domain.ErrMembershipNotFound
+	switch {
+	case errors.Is(err, domain.ErrBadGroupId), errors.Is(err, domain.ErrBadUserId), errors.Is(err, domain.ErrGroupNameRequired):
+		return http.StatusBadRequest
+	case errors.As(err, &groupNotFound), errors.As(err, &userNotFound), errors.As(err, &membershipNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// sendError writes err with the status it maps to. The ConflictDto of a
+// broken unique constraint is written with the negotiated codec
+func sendError(c *fiber.Ctx, status int, err error) error {
+	var conflict domain.ErrConflict
+	if status == http.StatusConflict && errors.As(err, &conflict) {
+		// the id is a reference the caller may read, only logs mask it
+		return sendEncoded(c, status, ConflictDto{
+			Error:      err.Error(),
+			Constraint: conflict.Constraint.String(),
+			ExistingId: conflict.ExistingId.String(),
+		})
+	}
+	if writeErr := c.Status(status).SendString(err.Error()); writeErr != nil {
+		log.Println("could not write to response body", writeErr.Error())
+	}
+	return nil
+}
+
+// uuidParam parses the path parameter name as a uuid, writing a 400 if it
+// is not one
+func uuidParam(c *fiber.Ctx, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Params(name))
+	if err != nil {
+		_ = sendError(c, http.StatusBadRequest, err)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// @Summary      Create a group
+// @Description  Create a group by passing its name and description
+// @ID           create-group
+// @Tags         groups
+// @Accept       json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        body   body    CreateGroupDto  true  "Group's name and description"
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Success      201  {object}  GroupDto
+// @Failure      400  {object}  string
+// @Failure      406  {object}  string
+// @Failure      415  {object}  string
+// @Failure      500  {object}  string
+// @Router       /groups [post]
+func (g *GroupApi) createGroup(c *fiber.Ctx) error {
+	if !fieldsetParam(c, GroupDto{}) {
+		return nil
+	}
+	var createDto CreateGroupDto
+	if err := decodeBody(c, &createDto); err != nil {
+		return sendError(c, http.StatusBadRequest, err)
+	}
+	group, err := g.service.CreateGroup(c.UserContext(), domain.Group{Name: createDto.Name, Description: createDto.Description})
+	if err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	c.Status(http.StatusCreated)
+	return encodeResponse(c, groupToDto(group), group.UpdatedAt, g.cacheControl)
+}
+
+// @Summary      List groups
+// @Description  List every group ordered by name
+// @ID           list-groups
+// @Tags         groups
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Param        If-None-Match   header      string  false  "ETag of a cached representation"
+// @Success      200  {object}  []GroupDto
+// @Failure      400  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /groups [get]
+func (g *GroupApi) listGroups(c *fiber.Ctx) error {
+	groups, err := g.service.ListGroups(c.UserContext())
+	if err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	return encodeResponse(c, groupsToDto(groups), time.Time{}, g.cacheControl)
+}
+
+// @Summary      Get a group by its ID
+// @Description  Get a group by its ID. ID must be a valid UUID
+// @ID           get-group-by-id
+// @Tags         groups
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path      string  true  "Group ID"
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Param        If-None-Match   header      string  false  "ETag of a cached representation"
+// @Param        If-Modified-Since   header      string  false  "Last-Modified of a cached representation"
+// @Success      200  {object}  GroupDto
+// @Header       200  {string}  ETag  "Strong entity tag of the representation"
+// @Header       200  {string}  Last-Modified  "When the group was last updated"
+// @Success      304
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /groups/{id} [get]
+func (g *GroupApi) getGroupById(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	group, err := g.service.GetGroupById(c.UserContext(), id)
+	if err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	return encodeResponse(c, groupToDto(group), group.UpdatedAt, g.cacheControl)
+}
+
+// @Summary      Replace a group
+// @Description  Replace the name and description of a group, the name is required
+// @ID           update-group
+// @Tags         groups
+// @Accept       json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "Group ID"
+// @Param        body body    UpdateGroupDto  true  "Group's name and description"
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Success      200  {object}  GroupDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      415  {object}  string
+// @Failure      500  {object}  string
+// @Router       /groups/{id} [put]
+func (g *GroupApi) updateGroup(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok || !fieldsetParam(c, GroupDto{}) {
+		return nil
+	}
+	var updateDto UpdateGroupDto
+	if err := decodeBody(c, &updateDto); err != nil {
+		return sendError(c, http.StatusBadRequest, err)
+	}
+	group, err := g.service.UpdateGroup(c.UserContext(), id, domain.UpdateGroup{
+		Name:        &updateDto.Name,
+		Description: &updateDto.Description,
+	})
+	if err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	return encodeResponse(c, groupToDto(group), group.UpdatedAt, g.cacheControl)
+}
+
+// @Summary      Delete a group
+// @Description  Delete a group and its memberships, its members are not deleted
+// @ID           delete-group
+// @Tags         groups
+// @Param        id   path    string  true  "Group ID"
+// @Success      204
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      500  {object}  string
+// @Router       /groups/{id} [delete]
+func (g *GroupApi) deleteGroup(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	if err := g.service.DeleteGroup(c.UserContext(), id); err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// @Summary      Add a member to a group
+// @Description  Add a user to a group, adding a member twice does nothing
+// @ID           add-group-member
+// @Tags         groups
+// @Accept       json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "Group ID"
+// @Param        body body    AddMemberDto  true  "ID of the user to add"
+// @Success      204
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      415  {object}  string
+// @Failure      500  {object}  string
+// @Router       /groups/{id}/members [post]
+func (g *GroupApi) addMember(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	var addDto AddMemberDto
+	if err := decodeBody(c, &addDto); err != nil {
+		return sendError(c, http.StatusBadRequest, err)
+	}
+	userId, err := uuid.Parse(addDto.UserId)
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, err)
+	}
+	if err := g.service.AddMember(c.UserContext(), id, userId); err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// @Summary      Remove a member from a group
+// @Description  Remove a user from a group
+// @ID           remove-group-member
+// @Tags         groups
+// @Param        id   path    string  true  "Group ID"
+// @Param        userId   path    string  true  "User ID"
+// @Success      204
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      500  {object}  string
+// @Router       /groups/{id}/members/{userId} [delete]
+func (g *GroupApi) removeMember(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	userId, ok := uuidParam(c, "userId")
+	if !ok {
+		return nil
+	}
+	if err := g.service.RemoveMember(c.UserContext(), id, userId); err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// @Summary      List the members of a group
+// @Description  List a page of the members of a group, in the order they were added
+// @ID           list-group-members
+// @Tags         groups
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "Group ID"
+// @Param        offset   query      int  false  "Members to skip"
+// @Param        limit   query      int  false  "Members to return, defaults to 50 and is capped to 500"
+// @Param        fields   query      string  false  "Comma separated fields of the page to include, e.g. members,total"
+// @Param        If-None-Match   header      string  false  "ETag of a cached representation"
+// @Success      200  {object}  MemberPageDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /groups/{id}/members [get]
+func (g *GroupApi) listMembers(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	offset, err := strconv.Atoi(c.Query("offset", "0"))
+	if err != nil || offset < 0 {
+		return sendError(c, http.StatusBadRequest, errors.New("invalid offset"))
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "0"))
+	if err != nil || limit < 0 {
+		return sendError(c, http.StatusBadRequest, errors.New("invalid limit"))
+	}
+
+	page, err := g.service.ListMembers(c.UserContext(), id, domain.Page{Offset: offset, Limit: limit})
+	if err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	resp := MemberPageDto{
+		Members: make([]UserDto, 0, len(page.Members)),
+		Offset:  page.Page.Offset,
+		Limit:   page.Page.Limit,
+		Total:   page.Total,
+	}
+	for _, user := range page.Members {
+		resp.Members = append(resp.Members, userToDto(user))
+	}
+	return encodeResponse(c, resp, time.Time{}, g.cacheControl)
+}
+
+// @Summary      List the groups of a user
+// @Description  List the groups a user is a member of, ordered by name
+// @ID           get-user-groups
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User ID"
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Param        If-None-Match   header      string  false  "ETag of a cached representation"
+// @Success      200  {object}  []GroupDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /users/{id}/groups [get]
+func (g *GroupApi) getUserGroups(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	groups, err := g.service.GroupsOfUser(c.UserContext(), id)
+	if err != nil {
+		return sendError(c, groupErrorStatus(err), err)
+	}
+	return encodeResponse(c, groupsToDto(groups), time.Time{}, g.cacheControl)
+}
+
+// AddRoutes add routes to fiber.App. GET /users/:id/groups relies on the
+// content negotiation UserApi sets up for /users
+func (g *GroupApi) AddRoutes(app *fiber.App) {
+	app.Use("/groups", g.codecs.Negotiate)
+
+	app.Get("/groups", func(c *fiber.Ctx) error {
+		return g.listGroups(c)
+	})
+
+	app.Post("/groups", func(c *fiber.Ctx) error {
+		return g.createGroup(c)
+	})
+
+	app.Get("/groups/:id", func(c *fiber.Ctx) error {
+		return g.getGroupById(c)
+	})
+
+	app.Put("/groups/:id", func(c *fiber.Ctx) error {
+		return g.updateGroup(c)
+	})
+
+	app.Delete("/groups/:id", func(c *fiber.Ctx) error {
+		return g.deleteGroup(c)
+	})
+
+	app.Get("/groups/:id/members", func(c *fiber.Ctx) error {
+		return g.listMembers(c)
+	})
+
+	app.Post("/groups/:id/members", func(c *fiber.Ctx) error {
+		return g.addMember(c)
+	})
+
+	app.Delete("/groups/:id/members/:userId", func(c *fiber.Ctx) error {
+		return g.removeMember(c)
+	})
+
+	app.Get("/users/:id/groups", func(c *fiber.Ctx) error {
+		return g.getUserGroups(c)
+	})
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"api-demo/domain"
+	mockDomain "api-demo/mock/domain"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupGroupApi(t *testing.T) (*fiber.App, *mockDomain.MockGroupService) {
+	ctrl := gomock.NewController(t)
+	groupService := mockDomain.NewMockGroupService(ctrl)
+
+	groupApi, err := NewGroupApi(groupService)
+	assert.NoError(t, err, "group api creation cannot fail")
+
+	app := fiber.New()
+	groupApi.AddRoutes(app)
+
+	return app, groupService
+}
+
+func groupRequest(t *testing.T, app *fiber.App, method, target, body string) (*http.Response, string) {
+	req := httptest.NewRequest(method, "http://acme.com"+target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "request failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	return resp, string(respB)
+}
+
+func Test_CreateGroup(t *testing.T) {
+	app, groupService := setupGroupApi(t)
+
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	group := domain.Group{Id: uuid.New(), Name: "Platform", CreatedAt: now, UpdatedAt: now}
+	groupService.EXPECT().
+		CreateGroup(context.Background(), domain.Group{Name: "Platform"}).
+		Return(group, nil)
+
+	resp, body := groupRequest(t, app, http.MethodPost, "/groups", `{"name":"Platform"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.JSONEq(t, `{"id":"`+group.Id.String()+`","name":"Platform","createdAt":"2022-10-01T00:00:00Z","updatedAt":"2022-10-01T00:00:00Z"}`, body)
+
+	groupService.EXPECT().
+		CreateGroup(context.Background(), domain.Group{}).
+		Return(domain.Group{}, domain.ErrGroupNameRequired)
+
+	resp, _ = groupRequest(t, app, http.MethodPost, "/groups", `{}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_GetGroupById_NotFound(t *testing.T) {
+	app, groupService := setupGroupApi(t)
+
+	id := uuid.New()
+	groupService.EXPECT().GetGroupById(context.Background(), id).Return(domain.Group{}, domain.ErrGroupIdNotFound{Id: id})
+
+	resp, _ := groupRequest(t, app, http.MethodGet, "/groups/"+id.String(), "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = groupRequest(t, app, http.MethodGet, "/groups/nope", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_GroupMembers(t *testing.T) {
+	app, groupService := setupGroupApi(t)
+
+	groupId, userId := uuid.New(), uuid.New()
+	groupService.EXPECT().AddMember(context.Background(), groupId, userId).Return(nil)
+	groupService.EXPECT().RemoveMember(context.Background(), groupId, userId).Return(domain.ErrMembershipNotFound{GroupId: groupId, UserId: userId})
+
+	target := "/groups/" + groupId.String() + "/members"
+	resp, _ := groupRequest(t, app, http.MethodPost, target, `{"userId":"`+userId.String()+`"}`)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, _ = groupRequest(t, app, http.MethodDelete, target+"/"+userId.String(), "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = groupRequest(t, app, http.MethodPost, target, `{"userId":"nope"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_ListGroupMembers(t *testing.T) {
+	app, groupService := setupGroupApi(t)
+
+	groupId := uuid.New()
+	user := domain.NewUser("Sasi", "user")
+	groupService.EXPECT().
+		ListMembers(context.Background(), groupId, domain.Page{Offset: 10, Limit: 5}).
+		Return(domain.MemberPage{Members: []domain.User{user}, Page: domain.Page{Offset: 10, Limit: 5}, Total: 11}, nil)
+
+	target := "/groups/" + groupId.String() + "/members"
+	resp, body := groupRequest(t, app, http.MethodGet, target+"?offset=10&limit=5", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{
+		"members":[{"id":"`+user.Id.String()+`","name":"Sasi","role":"user","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}],
+		"offset":10,"limit":5,"total":11
+	}`, body)
+
+	resp, _ = groupRequest(t, app, http.MethodGet, target+"?limit=-1", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_GetUserGroups(t *testing.T) {
+	app, groupService := setupGroupApi(t)
+
+	userId := uuid.New()
+	group := domain.Group{Id: uuid.New(), Name: "Platform"}
+	groupService.EXPECT().GroupsOfUser(context.Background(), userId).Return([]domain.Group{group}, nil)
+
+	resp, body := groupRequest(t, app, http.MethodGet, "/users/"+userId.String()+"/groups", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `[{"id":"`+group.Id.String()+`","name":"Platform","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}]`, body)
+}
+
+func Test_GetGroupById_FieldsAndCaching(t *testing.T) {
+	app, groupService := setupGroupApi(t)
+
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	group := domain.Group{Id: uuid.New(), Name: "Platform", CreatedAt: now, UpdatedAt: now}
+	groupService.EXPECT().GetGroupById(context.Background(), group.Id).Return(group, nil).Times(2)
+	target := "/groups/" + group.Id.String()
+
+	resp, body := getRequest(t, app, target+"?fields=id,name", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"id":"`+group.Id.String()+`","name":"Platform"}`, body)
+	assert.Equal(t, DefaultCacheControl, resp.Header.Get(fiber.HeaderCacheControl))
+	assert.Equal(t, "Sat, 01 Oct 2022 00:00:00 GMT", resp.Header.Get(fiber.HeaderLastModified))
+
+	resp, _ = getRequest(t, app, target+"?fields=id,name", map[string]string{fiber.HeaderIfNoneMatch: resp.Header.Get(fiber.HeaderETag)})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	groupService.EXPECT().ListGroups(context.Background()).Return([]domain.Group{group}, nil)
+	resp, _ = getRequest(t, app, "/groups?fields=members", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "expected an unknown field of GroupDto to be refused")
+}
+
+func Test_UpdateGroup_UnknownField(t *testing.T) {
+	app, _ := setupGroupApi(t)
+
+	resp, _ := groupRequest(t, app, http.MethodPut, "/groups/"+uuid.NewString()+"?fields=members", `{"name":"Platform"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "expected the fieldset to be checked before the group is updated")
+}
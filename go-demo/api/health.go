@@ -0,0 +1,115 @@
+package api
+
+import (
+	"api-demo/domain"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"time"
+)
+
+// Server roles and health statuses reported by HealthApi
+const (
+	RolePrimary  = "primary"
+	RoleFollower = "follower"
+
+	HealthOK      = "ok"
+	HealthLagging = "lagging"
+)
+
+// Replica tells how far a follower is behind, as repo.Follower does
+type Replica interface {
+	Status() domain.ReplicationStatus
+}
+
+type ReplicationStatusDto struct {
+	Primary       string     `json:"primary"`
+	FeedId        string     `json:"feedId,omitempty"`
+	Applied       uint64     `json:"applied"`
+	Last          uint64     `json:"last"`
+	Behind        uint64     `json:"behind"`
+	LastContactAt *time.Time `json:"lastContactAt,omitempty"`
+	CaughtUpAt    *time.Time `json:"caughtUpAt,omitempty"`
+	LagSeconds    float64    `json:"lagSeconds"`
+	Error         string     `json:"error,omitempty"`
+}
+
+type HealthDto struct {
+	Status      string                `json:"status"`
+	Role        string                `json:"role"`
+	Replication *ReplicationStatusDto `json:"replication,omitempty"`
+}
+
+func replicationStatusToDto(status domain.ReplicationStatus) ReplicationStatusDto {
+	dto := ReplicationStatusDto{
+		Primary:    status.Primary,
+		FeedId:     status.FeedId,
+		Applied:    status.Applied,
+		Last:       status.Last,
+		Behind:     status.Behind(),
+		LagSeconds: status.Lag.Seconds(),
+		Error:      status.Err,
+	}
+	if !status.LastContactAt.IsZero() {
+		dto.LastContactAt = &status.LastContactAt
+	}
+	if !status.CaughtUpAt.IsZero() {
+		dto.CaughtUpAt = &status.CaughtUpAt
+	}
+	return dto
+}
+
+// HealthApi reports whether the server is up and, on a follower, how far it
+// is behind its primary
+type HealthApi struct {
+	replica Replica
+	maxLag  time.Duration
+}
+
+// HealthApiOption configures optional behaviour of HealthApi
+type HealthApiOption func(*HealthApi)
+
+// WithReplica makes the server report as a follower replicating with
+// replica. It is unhealthy once it lags more than maxLag behind, 0 never
+func WithReplica(replica Replica, maxLag time.Duration) HealthApiOption {
+	return func(h *HealthApi) {
+		h.replica = replica
+		h.maxLag = maxLag
+	}
+}
+
+func NewHealthApi(opts ...HealthApiOption) (HealthApi, error) {
+	var healthApi HealthApi
+	for _, opt := range opts {
+		opt(&healthApi)
+	}
+	return healthApi, nil
+}
+
+// @Summary      Check health
+// @Description  Tell whether the server is up, whether it is the primary or a follower, and how far a follower lags behind its primary. A follower lagging more than the configured maximum is unhealthy
+// @ID           health
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  HealthDto
+// @Failure      503  {object}  HealthDto
+// @Router       /health [get]
+func (h *HealthApi) health(c *fiber.Ctx) error {
+	if h.replica == nil {
+		return c.JSON(HealthDto{Status: HealthOK, Role: RolePrimary})
+	}
+
+	status := h.replica.Status()
+	replication := replicationStatusToDto(status)
+	health := HealthDto{Status: HealthOK, Role: RoleFollower, Replication: &replication}
+	if h.maxLag > 0 && status.Lag > h.maxLag {
+		health.Status = HealthLagging
+		return c.Status(http.StatusServiceUnavailable).JSON(health)
+	}
+	return c.JSON(health)
+}
+
+func (h *HealthApi) AddRoutes(app *fiber.App) {
+	app.Get("/health", func(c *fiber.Ctx) error {
+		return h.health(c)
+	})
+}
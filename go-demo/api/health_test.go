@@ -0,0 +1,60 @@
+package api
+
+import (
+	"api-demo/domain"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeReplica reports status
+type fakeReplica struct {
+	status domain.ReplicationStatus
+}
+
+func (f *fakeReplica) Status() domain.ReplicationStatus {
+	return f.status
+}
+
+func Test_Health(t *testing.T) {
+	request := func(opts ...HealthApiOption) (int, string) {
+		healthApi, err := NewHealthApi(opts...)
+		assert.NoError(t, err, "health api creation cannot fail")
+		app := fiber.New()
+		healthApi.AddRoutes(app)
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/health", nil), -1)
+		assert.NoError(t, err, "request failed")
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err, "expected no error here")
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := request()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"status":"ok","role":"primary"}`, body)
+
+	caughtUpAt := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	replica := &fakeReplica{status: domain.ReplicationStatus{
+		Primary:       "http://primary:3000",
+		FeedId:        "feed",
+		Applied:       7,
+		Last:          9,
+		LastContactAt: caughtUpAt.Add(time.Second),
+		CaughtUpAt:    caughtUpAt,
+		Lag:           1500 * time.Millisecond,
+	}}
+	status, body = request(WithReplica(replica, 2*time.Second))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"status":"ok","role":"follower","replication":{"primary":"http://primary:3000","feedId":"feed","applied":7,"last":9,"behind":2,"lastContactAt":"2022-10-01T00:00:01Z","caughtUpAt":"2022-10-01T00:00:00Z","lagSeconds":1.5}}`, body)
+
+	replica.status.Lag = time.Minute
+	replica.status.Err = "could not read change feed"
+	status, body = request(WithReplica(replica, 2*time.Second))
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Contains(t, body, `"status":"lagging"`)
+	assert.Contains(t, body, `"error":"could not read change feed"`)
+}
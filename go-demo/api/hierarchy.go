@@ -0,0 +1,123 @@
+package api
+
+import (
+	"api-demo/domain"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ReportDto is a user in the subtree of a manager, Depth is 1 for direct
+// reports
+type ReportDto struct {
+	XMLName xml.Name `json:"-" xml:"report"`
+	Depth   int      `json:"depth" xml:"depth"`
+	User    UserDto  `json:"user" xml:"user"`
+}
+
+// hierarchyErrorStatus maps the errors of the org chart queries to their
+// status
+func hierarchyErrorStatus(err error) int {
+	var notFound domain.ErrUserIdNotFound
+	switch {
+	case errors.Is(err, domain.ErrBadUserId):
+		return http.StatusBadRequest
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// @Summary      List the direct reports of a user
+// @Description  List the users a user manages directly, ordered by name
+// @ID           get-user-reports
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User ID"
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Success      200  {object}  []UserDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /users/{id}/reports [get]
+func (u *UserApi) getDirectReports(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	reports, err := u.service.DirectReports(c.UserContext(), id)
+	if err != nil {
+		return sendError(c, hierarchyErrorStatus(err), err)
+	}
+	return u.usersDtoResponse(c, reports)
+}
+
+// @Summary      List the subtree of a user
+// @Description  List the users a user manages directly or indirectly, breadth first, with their depth below the user
+// @ID           get-user-subtree
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User ID"
+// @Param        depth   query    int  false  "How many levels to walk down, at most 20"
+// @Success      200  {object}  []ReportDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /users/{id}/subtree [get]
+func (u *UserApi) getSubtree(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	depth := 0
+	if d := c.Query("depth"); d != "" {
+		var err error
+		depth, err = strconv.Atoi(d)
+		if err != nil || depth < 1 || depth > domain.MaxReportDepth {
+			return sendError(c, http.StatusBadRequest,
+				fmt.Errorf("depth must be between 1 and %d", domain.MaxReportDepth))
+		}
+	}
+
+	subtree, err := u.service.Subtree(c.UserContext(), id, depth)
+	if err != nil {
+		return sendError(c, hierarchyErrorStatus(err), err)
+	}
+	resp := make([]ReportDto, 0, len(subtree))
+	for _, report := range subtree {
+		resp = append(resp, ReportDto{Depth: report.Depth, User: userToDto(report.User)})
+	}
+	return encodeResponse(c, resp, time.Time{}, u.cacheControl)
+}
+
+// @Summary      List the management chain of a user
+// @Description  List the managers of a user, from their direct manager up to the top of the hierarchy
+// @ID           get-user-chain
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User ID"
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Success      200  {object}  []UserDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /users/{id}/chain [get]
+func (u *UserApi) getManagementChain(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	chain, err := u.service.ManagementChain(c.UserContext(), id)
+	if err != nil {
+		return sendError(c, hierarchyErrorStatus(err), err)
+	}
+	return u.usersDtoResponse(c, chain)
+}
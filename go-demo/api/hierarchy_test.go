@@ -0,0 +1,150 @@
+package api
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_CreateUser_Manager(t *testing.T) {
+	manager := domain.NewUser("Shashank Pachava", "admin")
+	user := domain.NewUser("Sasi", "user")
+	user.ManagerId = manager.Id
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		CreateUser(context.Background(), domain.User{Name: "Sasi", Role: "user", ManagerId: manager.Id}).
+		Return(user, nil)
+	userService.EXPECT().
+		CreateUser(context.Background(), domain.User{Name: "Sasi", Role: "user", ManagerId: user.Id}).
+		Return(domain.User{}, fmt.Errorf("could not create user: %w", domain.ErrManagerNotFound{Id: user.Id}))
+
+	body := `{"name":"Sasi","role":"user","managerId":"` + manager.Id.String() + `"}`
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", bytes.NewBufferString(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Contains(t, string(respB), `"managerId":"`+manager.Id.String()+`"`)
+
+	body = `{"name":"Sasi","role":"user","managerId":"` + user.Id.String() + `"}`
+	req = httptest.NewRequest(http.MethodPost, "http://acme.com/users", bytes.NewBufferString(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	req = httptest.NewRequest(http.MethodPost, "http://acme.com/users",
+		bytes.NewBufferString(`{"name":"Sasi","role":"user","managerId":"boss"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_UpdateUser_Manager(t *testing.T) {
+	user := domain.NewUser("Sasi", "user")
+	name, role := "Sasi", "user"
+
+	_, app, userService := setup(t)
+
+	// leaving out the manager and attributes of a full replacement removes them
+	userService.EXPECT().
+		UpdateUser(context.Background(), user.Id, domain.UpdateUser{Name: &name, Role: &role, ManagerId: new(uuid.UUID), Attributes: map[string]any{}}).
+		Return(domain.User{}, fmt.Errorf("could not update user: %w", domain.ErrManagerCycle))
+
+	req := httptest.NewRequest(http.MethodPut, "http://acme.com/users/"+user.Id.String(),
+		bytes.NewBufferString(`{"name":"Sasi","role":"user"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "update user api failed")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func Test_DeleteUser_HasReports(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		Delete(context.Background(), user.Id).
+		Return(fmt.Errorf("could not delete user by id: %w", domain.ErrHasReports{Id: user.Id, Reports: 2}))
+
+	req := httptest.NewRequest(http.MethodDelete, "http://acme.com/users/"+user.Id.String(), nil)
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "delete user api failed")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func Test_GetDirectReports(t *testing.T) {
+	manager := domain.NewUser("Shashank Pachava", "admin")
+	report := domain.NewUser("Sasi", "user")
+	report.ManagerId = manager.Id
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().DirectReports(context.Background(), manager.Id).Return([]domain.User{report}, nil)
+	userService.EXPECT().ManagementChain(context.Background(), report.Id).Return([]domain.User{manager}, nil)
+	userService.EXPECT().
+		DirectReports(context.Background(), report.Id).
+		Return(nil, fmt.Errorf("could not fetch user by id: %w", domain.ErrUserIdNotFound{Id: report.Id}))
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+manager.Id.String()+"/reports?fields=id", nil), -1)
+	assert.NoError(t, err, "get reports api failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Equal(t, `[{"id":"`+report.Id.String()+`"}]`, string(respB))
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+report.Id.String()+"/chain?fields=name", nil), -1)
+	assert.NoError(t, err, "get chain api failed")
+	respB, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Equal(t, `[{"name":"Shashank Pachava"}]`, string(respB))
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+report.Id.String()+"/reports", nil), -1)
+	assert.NoError(t, err, "get reports api failed")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func Test_GetSubtree(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	manager := domain.NewUser("Shashank Pachava", "admin")
+	report := domain.User{Id: uuid.New(), Name: "Sasi", Role: "user", ManagerId: manager.Id, CreatedAt: now, UpdatedAt: now}
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		Subtree(context.Background(), manager.Id, 2).
+		Return([]domain.Report{{User: report, Depth: 1}}, nil)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+manager.Id.String()+"/subtree?depth=2", nil), -1)
+	assert.NoError(t, err, "get subtree api failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Equal(t,
+		`[{"depth":1,"user":{"id":"`+report.Id.String()+`","name":"Sasi","role":"user","managerId":"`+manager.Id.String()+`",`+
+			`"createdAt":"2022-10-01T00:00:00Z","updatedAt":"2022-10-01T00:00:00Z"}}]`,
+		string(respB))
+
+	for _, depth := range []string{"0", "21", "deep"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+manager.Id.String()+"/subtree?depth="+depth, nil), -1)
+		assert.NoError(t, err, "get subtree api failed")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, depth)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"api-demo/domain"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// UserVersionDto is a state a user was saved in, its updatedAt is when
+type UserVersionDto struct {
+	XMLName xml.Name `json:"-" xml:"version"`
+	Version int      `json:"version" xml:"number"`
+	User    UserDto  `json:"user" xml:"user"`
+}
+
+// historyErrorStatus maps the errors of history reads and reverts to their
+// status
+func historyErrorStatus(err error) int {
+	var notFound domain.ErrUserIdNotFound
+	var versionNotFound domain.ErrVersionNotFound
+	switch {
+	case errors.Is(err, domain.ErrHistoryUnavailable):
+		return http.StatusNotImplemented
+	case errors.Is(err, domain.ErrBadUserId):
+		return http.StatusBadRequest
+	case errors.As(err, &notFound), errors.As(err, &versionNotFound):
+		return http.StatusNotFound
+	default:
+		return validationErrorStatus(err, tenantErrorStatus(err, http.StatusInternalServerError))
+	}
+}
+
+// getUserAsOf writes the user with id as it was at the time in the asOf
+// query parameter
+func (u *UserApi) getUserAsOf(c *fiber.Ctx, asOf string) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	at, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, fmt.Errorf("invalid asOf: %w", err))
+	}
+	user, err := u.service.GetUserAsOf(c.UserContext(), id, at)
+	if err != nil {
+		return sendError(c, historyErrorStatus(err), err)
+	}
+	return u.dtoResponse(c, user)
+}
+
+// @Summary      List the versions of a user
+// @Description  List every state a user was saved in, oldest first, including its deletion and restoration
+// @ID           get-user-history
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User ID"
+// @Success      200  {object}  []UserVersionDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Failure      501  {object}  string
+// @Router       /users/{id}/history [get]
+func (u *UserApi) getHistory(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	versions, err := u.service.History(c.UserContext(), id)
+	if err != nil {
+		return sendError(c, historyErrorStatus(err), err)
+	}
+	resp := make([]UserVersionDto, 0, len(versions))
+	for _, version := range versions {
+		resp = append(resp, UserVersionDto{Version: version.Version, User: userToDto(version.User)})
+	}
+	return encodeResponse(c, resp, time.Time{}, u.cacheControl)
+}
+
+// @Summary      Revert a user to one of its versions
+// @Description  Bring the name, role, manager and attributes of a user back to those of a version listed in its history, recording a new version
+// @ID           revert-user
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User ID"
+// @Param        version   query    int  true  "Version to revert to"
+// @Success      200  {object}  UserDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      409  {object}  string
+// @Failure      422  {object}  string
+// @Failure      500  {object}  string
+// @Failure      501  {object}  string
+// @Router       /users/{id}/revert [post]
+func (u *UserApi) revertUser(c *fiber.Ctx) error {
+	if !fieldsetParam(c, UserDto{}) {
+		return nil
+	}
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	version, err := strconv.Atoi(c.Query("version"))
+	if err != nil || version < 1 {
+		return sendError(c, http.StatusBadRequest, errors.New("version must be a positive number"))
+	}
+	user, err := u.service.Revert(c.UserContext(), id, version)
+	if err != nil {
+		return sendError(c, historyErrorStatus(err), err)
+	}
+	return u.dtoResponse(c, user)
+}
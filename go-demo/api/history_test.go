@@ -0,0 +1,88 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_GetHistory(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	user := domain.User{Id: uuid.New(), Name: "Sasi", Role: "user", CreatedAt: now, UpdatedAt: now}
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().History(context.Background(), user.Id).Return([]domain.UserVersion{{Version: 1, User: user}}, nil)
+	userService.EXPECT().History(context.Background(), user.Id).Return(nil, domain.ErrHistoryUnavailable)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+user.Id.String()+"/history", nil), -1)
+	assert.NoError(t, err, "get history api failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Equal(t,
+		`[{"version":1,"user":{"id":"`+user.Id.String()+`","name":"Sasi","role":"user",`+
+			`"createdAt":"2022-10-01T00:00:00Z","updatedAt":"2022-10-01T00:00:00Z"}}]`,
+		string(respB))
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+user.Id.String()+"/history", nil), -1)
+	assert.NoError(t, err, "get history api failed")
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func Test_GetUserById_AsOf(t *testing.T) {
+	asOf := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	user := domain.NewUser("Sasi", "user")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().GetUserAsOf(context.Background(), user.Id, asOf).Return(user, nil)
+	userService.EXPECT().
+		GetUserAsOf(context.Background(), user.Id, asOf.Add(-time.Hour)).
+		Return(domain.User{}, fmt.Errorf("could not fetch user as of: %w", domain.ErrUserIdNotFound{Id: user.Id}))
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+user.Id.String()+"?asOf=2022-10-01T12:00:00Z&fields=name", nil), -1)
+	assert.NoError(t, err, "get user api failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Equal(t, `{"name":"Sasi"}`, string(respB))
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+user.Id.String()+"?asOf=2022-10-01T11:00:00Z", nil), -1)
+	assert.NoError(t, err, "get user api failed")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+user.Id.String()+"?asOf=yesterday", nil), -1)
+	assert.NoError(t, err, "get user api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_RevertUser(t *testing.T) {
+	user := domain.NewUser("Sasi", "user")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().Revert(context.Background(), user.Id, 1).Return(user, nil)
+	userService.EXPECT().
+		Revert(context.Background(), user.Id, 7).
+		Return(domain.User{}, domain.ErrVersionNotFound{Id: user.Id, Version: 7})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodPost, "http://acme.com/users/"+user.Id.String()+"/revert?version=1", nil), -1)
+	assert.NoError(t, err, "revert user api failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodPost, "http://acme.com/users/"+user.Id.String()+"/revert?version=7", nil), -1)
+	assert.NoError(t, err, "revert user api failed")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	for _, version := range []string{"", "0", "latest"} {
+		resp, err = app.Test(httptest.NewRequest(http.MethodPost, "http://acme.com/users/"+user.Id.String()+"/revert?version="+version, nil), -1)
+		assert.NoError(t, err, "revert user api failed")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, version)
+	}
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader is the header clients use to make a mutation safe to retry
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed from a stored record
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// DefaultIdempotencyTTL is how long a stored response can be replayed
+	DefaultIdempotencyTTL = 24 * time.Hour
+)
+
+// IdempotencyStore keeps the responses of requests made with an idempotency key
+type IdempotencyStore interface {
+	// Reserve claims record.Key for a request in flight. If the key is
+	// already claimed, the existing record is returned and reserved is false
+	Reserve(ctx context.Context, record domain.IdempotencyRecord) (existing domain.IdempotencyRecord, reserved bool, err error)
+	// Complete stores the response of the request that reserved the key
+	Complete(ctx context.Context, record domain.IdempotencyRecord) error
+	// Release gives up a reservation, so that the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// RepoIdempotencyStore is an IdempotencyStore persisting records through a
+// domain.IdempotencyRepo, so they live alongside the data they protect
+type RepoIdempotencyStore struct {
+	repo domain.IdempotencyRepo
+}
+
+// NewRepoIdempotencyStore returns a new instance of RepoIdempotencyStore
+func NewRepoIdempotencyStore(repo domain.IdempotencyRepo) (RepoIdempotencyStore, error) {
+	if repo == nil {
+		return RepoIdempotencyStore{}, fmt.Errorf("cannot create idempotency store, missing repo")
+	}
+	return RepoIdempotencyStore{repo: repo}, nil
+}
+
+func (s RepoIdempotencyStore) Reserve(ctx context.Context, record domain.IdempotencyRecord) (domain.IdempotencyRecord, bool, error) {
+	return s.repo.ReserveRecord(ctx, record)
+}
+
+func (s RepoIdempotencyStore) Complete(ctx context.Context, record domain.IdempotencyRecord) error {
+	return s.repo.SaveRecord(ctx, record)
+}
+
+func (s RepoIdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.repo.DeleteRecord(ctx, key)
+}
+
+// IdempotencyConfig configures Idempotency. Methods defaults to POST and
+// PATCH, TTL to DefaultIdempotencyTTL, KeyFunc, which scopes keys to a
+// client, to DefaultKeyFunc and Clock to domain.RealClock
+type IdempotencyConfig struct {
+	Store   IdempotencyStore
+	TTL     time.Duration
+	Methods []string
+	KeyFunc KeyFunc
+	Clock   domain.Clock
+}
+
+// Idempotency is a middleware replaying the stored response of mutations
+// retried with the same Idempotency-Key
+type Idempotency struct {
+	config  IdempotencyConfig
+	methods map[string]bool
+}
+
+// NewIdempotency returns a new instance of Idempotency
+func NewIdempotency(config IdempotencyConfig) (Idempotency, error) {
+	if config.Store == nil {
+		return Idempotency{}, fmt.Errorf("cannot create idempotency middleware, missing store")
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultIdempotencyTTL
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = DefaultKeyFunc
+	}
+	if config.Clock == nil {
+		config.Clock = domain.RealClock{}
+	}
+	methods := make(map[string]bool, len(config.Methods))
+	for _, m := range config.Methods {
+		methods[m] = true
+	}
+	return Idempotency{config: config, methods: methods}, nil
+}
+
+// userIdsLocal is the locals key of the users a response describes
+const userIdsLocal = "api.userIds"
+
+// describesUsers marks the response as describing the users with ids, so
+// that a stored idempotent response can be deleted when one is erased
+func describesUsers(c *fiber.Ctx, ids ...uuid.UUID) {
+	userIds, _ := c.Locals(userIdsLocal).([]uuid.UUID)
+	for _, id := range ids {
+		if id != uuid.Nil {
+			userIds = append(userIds, id)
+		}
+	}
+	c.Locals(userIdsLocal, userIds)
+}
+
+// tenantScope prefixes keys with the tenant of the request, so that tenants
+// never see each other's responses whatever the KeyFunc
+func tenantScope(c *fiber.Ctx) string {
+	if tenant, ok := domain.TenantFromContext(c.UserContext()); ok {
+		return tenant + "|"
+	}
+	return ""
+}
+
+// fingerprint identifies the request a key was first used with
+func fingerprint(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method()))
+	h.Write([]byte{0})
+	h.Write([]byte(c.OriginalURL()))
+	h.Write([]byte{0})
+	h.Write(c.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Handler is the fiber.Handler applying idempotency to the configured methods
+func (i *Idempotency) Handler(c *fiber.Ctx) error {
+	key := c.Get(IdempotencyKeyHeader)
+	if key == "" || !i.methods[c.Method()] {
+		return c.Next()
+	}
+
+	now := i.config.Clock.Now()
+	tenant, _ := domain.TenantFromContext(c.UserContext())
+	record := domain.IdempotencyRecord{
+		Key:         tenantScope(c) + i.config.KeyFunc(c) + "|" + key,
+		TenantId:    tenant,
+		Fingerprint: fingerprint(c),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(i.config.TTL),
+	}
+
+	existing, reserved, err := i.config.Store.Reserve(c.UserContext(), record)
+	if err != nil {
+		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+
+	if !reserved {
+		return replay(c, existing, record.Fingerprint)
+	}
+
+	if err := c.Next(); err != nil {
+		i.release(c, record.Key)
+		return err
+	}
+
+	resp := c.Response()
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		// server errors are not a final outcome, let the client retry
+		i.release(c, record.Key)
+		return nil
+	}
+
+	record.Completed = true
+	record.StatusCode = resp.StatusCode()
+	record.ContentType = string(resp.Header.ContentType())
+	record.Body = append([]byte(nil), resp.Body()...)
+	record.UserIds, _ = c.Locals(userIdsLocal).([]uuid.UUID)
+	if err := i.config.Store.Complete(c.UserContext(), record); err != nil {
+		// a reservation left behind would answer every retry with a 409
+		log.Println("could not store idempotent response", err.Error())
+		i.release(c, record.Key)
+	}
+	return nil
+}
+
+func (i *Idempotency) release(c *fiber.Ctx, key string) {
+	if err := i.config.Store.Release(c.UserContext(), key); err != nil {
+		log.Println("could not release idempotency key", err.Error())
+	}
+}
+
+func replay(c *fiber.Ctx, record domain.IdempotencyRecord, fingerprint string) error {
+	if record.Fingerprint != fingerprint {
+		if writeErr := c.Status(http.StatusUnprocessableEntity).
+			SendString("idempotency key was already used with a different request"); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+
+	if !record.Completed {
+		if writeErr := c.Status(http.StatusConflict).
+			SendString("a request with this idempotency key is still in progress"); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+
+	c.Set(IdempotentReplayedHeader, "true")
+	if record.ContentType != "" {
+		c.Set(fiber.HeaderContentType, record.ContentType)
+	}
+	if writeErr := c.Status(record.StatusCode).Send(record.Body); writeErr != nil {
+		log.Println("could not write to response body", writeErr.Error())
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"api-demo/domain"
+	mockDomain "api-demo/mock/domain"
+	"api-demo/repo"
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keyStore is an IdempotencyStore remembering the last key reserved
+type keyStore struct {
+	IdempotencyStore
+	key string
+}
+
+func (s *keyStore) Reserve(ctx context.Context, record domain.IdempotencyRecord) (domain.IdempotencyRecord, bool, error) {
+	s.key = record.Key
+	return s.IdempotencyStore.Reserve(ctx, record)
+}
+
+func newIdempotencyStore(t *testing.T) (*keyStore, *repo.InMemIdempotencyRepo) {
+	idempotencyRepo := repo.NewInMemIdempotencyRepo()
+	store, err := NewRepoIdempotencyStore(&idempotencyRepo)
+	assert.NoError(t, err, "idempotency store creation cannot fail")
+	return &keyStore{IdempotencyStore: store}, &idempotencyRepo
+}
+
+func setupIdempotency(t *testing.T, store IdempotencyStore, status *int) (*fiber.App, *int) {
+	idempotency, err := NewIdempotency(IdempotencyConfig{Store: store, TTL: time.Hour})
+	assert.NoError(t, err, "idempotency creation cannot fail")
+
+	calls := 0
+	app := fiber.New()
+	app.Use(idempotency.Handler)
+	app.Post("/users", func(c *fiber.Ctx) error {
+		calls++
+		return c.Status(*status).JSON(fiber.Map{"call": calls})
+	})
+
+	return app, &calls
+}
+
+func postWithKey(t *testing.T, app *fiber.App, key, body string) (*http.Response, string) {
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "request failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	return resp, string(respB)
+}
+
+func Test_NewIdempotency(t *testing.T) {
+	_, err := NewIdempotency(IdempotencyConfig{})
+	assert.Error(t, err, "expected missing store error")
+}
+
+func Test_Idempotency(t *testing.T) {
+	status := http.StatusOK
+	store, _ := newIdempotencyStore(t)
+	app, calls := setupIdempotency(t, store, &status)
+
+	resp, body := postWithKey(t, app, "abc", `{"name":"Shashank Pachava"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"call":1}`, body)
+	assert.Empty(t, resp.Header.Get(IdempotentReplayedHeader))
+
+	resp, body = postWithKey(t, app, "abc", `{"name":"Shashank Pachava"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"call":1}`, body, "expected stored response to be replayed")
+	assert.Equal(t, "true", resp.Header.Get(IdempotentReplayedHeader))
+	assert.Equal(t, fiber.MIMEApplicationJSON, resp.Header.Get(fiber.HeaderContentType))
+
+	resp, _ = postWithKey(t, app, "abc", `{"name":"Shank"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	_, body = postWithKey(t, app, "", `{"name":"Shashank Pachava"}`)
+	assert.Equal(t, `{"call":2}`, body, "requests without a key are not deduplicated")
+	assert.Equal(t, 2, *calls)
+}
+
+func Test_Idempotency_ServerErrorReleasesKey(t *testing.T) {
+	status := http.StatusInternalServerError
+	store, _ := newIdempotencyStore(t)
+	app, calls := setupIdempotency(t, store, &status)
+
+	resp, _ := postWithKey(t, app, "abc", `{}`)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	status = http.StatusOK
+	resp, body := postWithKey(t, app, "abc", `{}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"call":2}`, body)
+	assert.Equal(t, 2, *calls)
+}
+
+func Test_Idempotency_InProgress(t *testing.T) {
+	store, idempotencyRepo := newIdempotencyStore(t)
+	status := http.StatusOK
+	app, _ := setupIdempotency(t, store, &status)
+
+	// simulate a request holding the key
+	_, body := postWithKey(t, app, "abc", `{}`)
+	record, reserved, err := idempotencyRepo.ReserveRecord(context.Background(), domain.IdempotencyRecord{Key: store.key})
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+	record.Completed = false
+	assert.NoError(t, idempotencyRepo.SaveRecord(context.Background(), record))
+
+	resp, _ := postWithKey(t, app, "abc", `{}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, `{"call":1}`, body)
+}
+
+func Test_Idempotency_CompleteFailureReleasesKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	idempotencyRepo := mockDomain.NewMockIdempotencyRepo(ctrl)
+	store, err := NewRepoIdempotencyStore(idempotencyRepo)
+	assert.NoError(t, err, "expected no error")
+	status := http.StatusOK
+	app, _ := setupIdempotency(t, store, &status)
+
+	var key string
+	idempotencyRepo.EXPECT().ReserveRecord(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, record domain.IdempotencyRecord) (domain.IdempotencyRecord, bool, error) {
+			key = record.Key
+			return domain.IdempotencyRecord{}, true, nil
+		})
+	idempotencyRepo.EXPECT().SaveRecord(gomock.Any(), gomock.Any()).Return(fmt.Errorf("disk full"))
+	idempotencyRepo.EXPECT().DeleteRecord(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, released string) error {
+			assert.Equal(t, key, released, "expected the reserved key to be released")
+			return nil
+		})
+
+	resp, body := postWithKey(t, app, "abc", `{}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"call":1}`, body)
+}
+
+func Test_Idempotency_DescribedUsers(t *testing.T) {
+	store, idempotencyRepo := newIdempotencyStore(t)
+	idempotency, err := NewIdempotency(IdempotencyConfig{Store: store, TTL: time.Hour})
+	assert.NoError(t, err, "idempotency creation cannot fail")
+
+	user, manager := uuid.New(), uuid.New()
+	app := fiber.New()
+	app.Use(idempotency.Handler)
+	app.Post("/users", func(c *fiber.Ctx) error {
+		describesUsers(c, user, uuid.Nil)
+		describesUsers(c, manager)
+		return c.JSON(fiber.Map{"name": "Sasi"})
+	})
+
+	postWithKey(t, app, "abc", `{}`)
+	record, _, err := idempotencyRepo.ReserveRecord(context.Background(), domain.IdempotencyRecord{Key: store.key})
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{user, manager}, record.UserIds)
+	assert.True(t, record.References(manager))
+	assert.False(t, record.References(uuid.New()))
+}
+
+func TestRepoIdempotencyStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	idempotencyRepo := mockDomain.NewMockIdempotencyRepo(ctrl)
+
+	_, err := NewRepoIdempotencyStore(nil)
+	assert.Equal(t, fmt.Errorf("cannot create idempotency store, missing repo"), err)
+
+	store, err := NewRepoIdempotencyStore(idempotencyRepo)
+	assert.NoError(t, err, "expected no error")
+
+	record := domain.IdempotencyRecord{Key: "abc", Fingerprint: "f"}
+	idempotencyRepo.EXPECT().ReserveRecord(context.Background(), record).Return(domain.IdempotencyRecord{}, true, nil)
+	idempotencyRepo.EXPECT().SaveRecord(context.Background(), record).Return(nil)
+	idempotencyRepo.EXPECT().DeleteRecord(context.Background(), "abc").Return(nil)
+
+	_, reserved, err := store.Reserve(context.Background(), record)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+	assert.NoError(t, store.Complete(context.Background(), record))
+	assert.NoError(t, store.Release(context.Background(), "abc"))
+}
+
+func Test_Idempotency_ScopedToTenant(t *testing.T) {
+	store, _ := newIdempotencyStore(t)
+	idempotency, err := NewIdempotency(IdempotencyConfig{Store: store, TTL: time.Hour})
+	assert.NoError(t, err, "idempotency creation cannot fail")
+
+	calls := 0
+	app := fiber.New()
+	app.Use(TenantMiddleware(TenantConfig{Resolvers: []TenantResolver{TenantFromHeader("")}}))
+	app.Use(idempotency.Handler)
+	app.Post("/users", func(c *fiber.Ctx) error {
+		calls++
+		return c.JSON(fiber.Map{"call": calls})
+	})
+
+	post := func(tenant string) string {
+		req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "abc")
+		req.Header.Set(DefaultTenantHeader, tenant)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, "request failed")
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err, "expected no error here")
+		return string(body)
+	}
+
+	assert.Equal(t, `{"call":1}`, post("acme"))
+	assert.Equal(t, `{"call":2}`, post("globex"), "expected another tenant's response not to be replayed")
+	assert.Equal(t, `{"call":1}`, post("acme"))
+}
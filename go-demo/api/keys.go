@@ -0,0 +1,94 @@
+package api
+
+import (
+	"api-demo/domain"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"time"
+)
+
+// KeyRotations starts key rotations and reports their progress, as
+// domain.KeyRotationJob does
+type KeyRotations interface {
+	Start() (domain.KeyRotation, error)
+	Status() domain.KeyRotation
+}
+
+type KeyRotationDto struct {
+	Running    bool       `json:"running"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Rotated    int        `json:"rotated"`
+	Error      string     `json:"error,omitempty"`
+}
+
+func keyRotationToDto(rotation domain.KeyRotation) KeyRotationDto {
+	dto := KeyRotationDto{Running: rotation.Running, Rotated: rotation.Rotated, Error: rotation.Err}
+	if !rotation.StartedAt.IsZero() {
+		dto.StartedAt = &rotation.StartedAt
+	}
+	if !rotation.FinishedAt.IsZero() {
+		dto.FinishedAt = &rotation.FinishedAt
+	}
+	return dto
+}
+
+// KeyApi serves the rotation of the keys users are encrypted with at rest.
+// Like TenantApi, it expects routes under /admin to be guarded
+type KeyApi struct {
+	rotations KeyRotations
+}
+
+func NewKeyApi(rotations KeyRotations) (KeyApi, error) {
+	if rotations == nil {
+		return KeyApi{}, fmt.Errorf("cannot create key api, missing rotations")
+	}
+	return KeyApi{rotations: rotations}, nil
+}
+
+// @Summary      Rotate encryption keys
+// @Description  Reload the keyring, then re-encrypt every user with its current key in the background. Users are served as usual meanwhile
+// @ID           rotate-keys
+// @Tags         admin
+// @Produce      json
+// @Success      202  {object}  KeyRotationDto
+// @Failure      401  {object}  string
+// @Failure      403  {object}  string
+// @Failure      409  {object}  KeyRotationDto
+// @Failure      500  {object}  string
+// @Router       /admin/keys/rotate [post]
+func (k *KeyApi) rotateKeys(c *fiber.Ctx) error {
+	rotation, err := k.rotations.Start()
+	if errors.Is(err, domain.ErrRotationRunning) {
+		return c.Status(http.StatusConflict).JSON(keyRotationToDto(rotation))
+	}
+	if err != nil {
+		return sendError(c, http.StatusInternalServerError, err)
+	}
+	return c.Status(http.StatusAccepted).JSON(keyRotationToDto(rotation))
+}
+
+// @Summary      Get the progress of the key rotation
+// @Description  Get the progress of the latest key rotation
+// @ID           get-key-rotation
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  KeyRotationDto
+// @Failure      401  {object}  string
+// @Failure      403  {object}  string
+// @Router       /admin/keys/rotation [get]
+func (k *KeyApi) getRotation(c *fiber.Ctx) error {
+	return c.JSON(keyRotationToDto(k.rotations.Status()))
+}
+
+func (k *KeyApi) AddRoutes(app *fiber.App) {
+	app.Post("/admin/keys/rotate", func(c *fiber.Ctx) error {
+		return k.rotateKeys(c)
+	})
+
+	app.Get("/admin/keys/rotation", func(c *fiber.Ctx) error {
+		return k.getRotation(c)
+	})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"api-demo/domain"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeKeyRotations answers with rotation, failing Start with err
+type fakeKeyRotations struct {
+	rotation domain.KeyRotation
+	err      error
+}
+
+func (f *fakeKeyRotations) Start() (domain.KeyRotation, error) {
+	return f.rotation, f.err
+}
+
+func (f *fakeKeyRotations) Status() domain.KeyRotation {
+	return f.rotation
+}
+
+func Test_RotateKeys(t *testing.T) {
+	startedAt := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	rotations := &fakeKeyRotations{rotation: domain.KeyRotation{Running: true, StartedAt: startedAt, Rotated: 4}}
+
+	keyApi, err := NewKeyApi(rotations)
+	assert.NoError(t, err, "key api creation cannot fail")
+	app := fiber.New()
+	app.Use(PrincipalMiddleware(""))
+	app.Use("/admin", RequirePrincipal("root"))
+	keyApi.AddRoutes(app)
+
+	request := func(method, path, principal string) (int, string) {
+		req := httptest.NewRequest(method, "http://acme.com"+path, nil)
+		if principal != "" {
+			req.Header.Set(DefaultPrincipalHeader, principal)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, "request failed")
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err, "expected no error here")
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := request(http.MethodPost, "/admin/keys/rotate", "root")
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, `{"running":true,"startedAt":"2022-10-01T00:00:00Z","rotated":4}`, body)
+
+	status, _ = request(http.MethodPost, "/admin/keys/rotate", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	rotations.err = domain.ErrRotationRunning
+	status, _ = request(http.MethodPost, "/admin/keys/rotate", "root")
+	assert.Equal(t, http.StatusConflict, status)
+
+	rotations.err = errors.New("could not reload keys")
+	status, _ = request(http.MethodPost, "/admin/keys/rotate", "root")
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	rotations.rotation = domain.KeyRotation{StartedAt: startedAt, FinishedAt: startedAt.Add(time.Minute), Rotated: 9, Err: "unknown encryption key"}
+	status, body = request(http.MethodGet, "/admin/keys/rotation", "root")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"running":false,"startedAt":"2022-10-01T00:00:00Z","finishedAt":"2022-10-01T00:01:00Z","rotated":9,"error":"unknown encryption key"}`, body)
+}
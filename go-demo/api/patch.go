@@ -0,0 +1,381 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types of the patch documents accepted by PATCH endpoints
+const (
+	MergePatchMediaType = "application/merge-patch+json"
+	JSONPatchMediaType  = "application/json-patch+json"
+)
+
+// ErrPatchTestFailed is returned when a test operation of a JSON Patch does
+// not hold
+type ErrPatchTestFailed struct {
+	Path string
+}
+
+func (e ErrPatchTestFailed) Error() string {
+	return fmt.Sprintf("patch test failed at %q", e.Path)
+}
+
+// ErrInvalidPatch is returned when a well formed patch cannot be applied, or
+// its result is not a valid document
+type ErrInvalidPatch struct {
+	Msg string
+}
+
+func (e ErrInvalidPatch) Error() string {
+	return "invalid patch: " + e.Msg
+}
+
+// mergePatch applies an RFC 7396 JSON Merge Patch to target
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergePatch(t[key], value)
+	}
+	return t
+}
+
+// patchOp is an operation of an RFC 6902 JSON Patch
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+
+	path, from []string
+	value      any
+}
+
+// jsonPatch is an RFC 6902 JSON Patch
+type jsonPatch []patchOp
+
+// parseJSONPatch parses and validates a JSON Patch document
+func parseJSONPatch(data []byte) (jsonPatch, error) {
+	var patch jsonPatch
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, err
+	}
+	for i := range patch {
+		op := &patch[i]
+		var err error
+		if op.path, err = parsePointer(op.Path); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: %s is missing value", i, op.Op)
+			}
+			if err := json.Unmarshal(op.Value, &op.value); err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+		case "move", "copy":
+			if op.from, err = parsePointer(op.From); err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			if op.Op == "move" && isProperPrefix(op.from, op.path) {
+				return nil, fmt.Errorf("operation %d: cannot move %q into itself", i, op.From)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+	}
+	return patch, nil
+}
+
+// apply applies every operation in order to doc, failing as a whole if any
+// operation fails. doc is modified in place
+func (p jsonPatch) apply(doc any) (any, error) {
+	var err error
+	for _, op := range p {
+		switch op.Op {
+		case "add":
+			doc, err = addValue(doc, op.path, op.value)
+		case "remove":
+			doc, _, err = removeValue(doc, op.path)
+		case "replace":
+			if len(op.path) == 0 {
+				doc = op.value
+			} else if _, err = getValue(doc, op.path); err == nil {
+				if doc, _, err = removeValue(doc, op.path); err == nil {
+					doc, err = addValue(doc, op.path, op.value)
+				}
+			}
+		case "move":
+			var value any
+			if doc, value, err = removeValue(doc, op.from); err == nil {
+				doc, err = addValue(doc, op.path, value)
+			}
+		case "copy":
+			var value any
+			if value, err = getValue(doc, op.from); err == nil {
+				doc, err = addValue(doc, op.path, deepCopy(value))
+			}
+		case "test":
+			var value any
+			if value, err = getValue(doc, op.path); err == nil && !reflect.DeepEqual(value, op.value) {
+				return nil, ErrPatchTestFailed{Path: op.Path}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("json pointer %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isProperPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex parses token as an index into an array of length n. The end
+// token "-" is only allowed when appending
+func arrayIndex(token string, n int, appending bool) (int, error) {
+	if token == "-" && appending {
+		return n, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.Trim(token, "0123456789") != "" {
+		return 0, ErrInvalidPatch{Msg: fmt.Sprintf("invalid array index %q", token)}
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i > n || (i == n && !appending) {
+		return 0, ErrInvalidPatch{Msg: fmt.Sprintf("array index %q out of bounds", token)}
+	}
+	return i, nil
+}
+
+func getValue(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, ErrInvalidPatch{Msg: fmt.Sprintf("path %q does not exist", token)}
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, ErrInvalidPatch{Msg: fmt.Sprintf("cannot descend into %q", token)}
+		}
+	}
+	return doc, nil
+}
+
+// addValue adds value at path, returning the new doc
+func addValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+	switch node := doc.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			node[token] = value
+			return node, nil
+		}
+		child, ok := node[token]
+		if !ok {
+			return nil, ErrInvalidPatch{Msg: fmt.Sprintf("path %q does not exist", token)}
+		}
+		child, err := addValue(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []any:
+		i, err := arrayIndex(token, len(node), len(rest) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		child, err := addValue(node[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	default:
+		return nil, ErrInvalidPatch{Msg: fmt.Sprintf("cannot descend into %q", token)}
+	}
+}
+
+// removeValue removes the value at path, returning the new doc and the
+// removed value
+func removeValue(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, ErrInvalidPatch{Msg: "cannot remove the whole document"}
+	}
+	token, rest := path[0], path[1:]
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, ErrInvalidPatch{Msg: fmt.Sprintf("path %q does not exist", token)}
+		}
+		if len(rest) == 0 {
+			delete(node, token)
+			return node, child, nil
+		}
+		child, removed, err := removeValue(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = child
+		return node, removed, nil
+	case []any:
+		i, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := node[i]
+			return append(node[:i], node[i+1:]...), removed, nil
+		}
+		child, removed, err := removeValue(node[i], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		node[i] = child
+		return node, removed, nil
+	default:
+		return nil, nil, ErrInvalidPatch{Msg: fmt.Sprintf("cannot descend into %q", token)}
+	}
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, child := range v {
+			c[key] = deepCopy(child)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, child := range v {
+			c[i] = deepCopy(child)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// patchableUserFields are the fields of UserDto a patch may change, the
+// others are read-only
+var patchableUserFields = map[string]bool{"name": true, "role": true, "managerId": true, "attributes": true}
+
+// patchUser applies a patch to the json representation of user. Removing a
+// patchable field clears it, changing a read-only field is an error
+func patchUser(user UserDto, apply func(doc any) (any, error)) (UserDto, error) {
+	b, err := json.Marshal(user)
+	if err != nil {
+		return UserDto{}, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return UserDto{}, err
+	}
+	original := deepCopy(doc).(map[string]any)
+
+	result, err := apply(doc)
+	if err != nil {
+		return UserDto{}, err
+	}
+	patched, ok := result.(map[string]any)
+	if !ok {
+		return UserDto{}, ErrInvalidPatch{Msg: "user must be an object"}
+	}
+
+	for key, value := range patched {
+		if _, known := original[key]; !known && !patchableUserFields[key] {
+			return UserDto{}, ErrInvalidPatch{Msg: fmt.Sprintf("unknown field %q", key)}
+		}
+		if !patchableUserFields[key] && !reflect.DeepEqual(original[key], value) {
+			return UserDto{}, ErrInvalidPatch{Msg: fmt.Sprintf("field %q is read-only", key)}
+		}
+	}
+	for key := range original {
+		if _, kept := patched[key]; !kept && !patchableUserFields[key] {
+			return UserDto{}, ErrInvalidPatch{Msg: fmt.Sprintf("field %q is read-only", key)}
+		}
+	}
+
+	for field := range patchableUserFields {
+		value, ok := patched[field]
+		if !ok {
+			continue
+		}
+		if field == "attributes" {
+			if _, isObject := value.(map[string]any); !isObject {
+				return UserDto{}, ErrInvalidPatch{Msg: fmt.Sprintf("field %q must be an object", field)}
+			}
+			continue
+		}
+		if _, isString := value.(string); !isString {
+			return UserDto{}, ErrInvalidPatch{Msg: fmt.Sprintf("field %q must be a string", field)}
+		}
+	}
+	user.Name, _ = patched["name"].(string)
+	user.Role, _ = patched["role"].(string)
+	user.ManagerId, _ = patched["managerId"].(string)
+	user.Attributes, _ = patched["attributes"].(map[string]any)
+	if _, err := parseManagerId(user.ManagerId); err != nil {
+		return UserDto{}, ErrInvalidPatch{Msg: err.Error()}
+	}
+	return user, nil
+}
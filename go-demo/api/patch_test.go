@@ -0,0 +1,236 @@
+package api
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func decodeJSON(t *testing.T, s string) any {
+	var v any
+	assert.NoError(t, json.Unmarshal([]byte(s), &v))
+	return v
+}
+
+func Test_MergePatch(t *testing.T) {
+	// examples from RFC 7396 appendix A
+	tests := []struct {
+		target, patch, want string
+	}{
+		{target: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{target: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{target: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{target: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{target: `{"e":null}`, patch: `{"a":1}`, want: `{"e":null,"a":1}`},
+		{target: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.patch, func(t *testing.T) {
+			got := mergePatch(decodeJSON(t, tt.target), decodeJSON(t, tt.patch))
+			assert.Equal(t, decodeJSON(t, tt.want), got)
+		})
+	}
+}
+
+func Test_JSONPatch(t *testing.T) {
+	// mostly examples from RFC 6902 appendix A
+	tests := []struct {
+		name, doc, patch, want string
+		err                    error
+	}{
+		{name: "add object member", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux"}]`, want: `{"baz":"qux","foo":"bar"}`},
+		{name: "add array element", doc: `{"foo":["bar","baz"]}`, patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, want: `{"foo":["bar","qux","baz"]}`},
+		{name: "append array element", doc: `{"foo":["bar"]}`, patch: `[{"op":"add","path":"/foo/-","value":"qux"}]`, want: `{"foo":["bar","qux"]}`},
+		{name: "remove object member", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, want: `{"foo":"bar"}`},
+		{name: "remove array element", doc: `{"foo":["bar","qux","baz"]}`, patch: `[{"op":"remove","path":"/foo/1"}]`, want: `{"foo":["bar","baz"]}`},
+		{name: "replace", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"replace","path":"/baz","value":"boo"}]`, want: `{"baz":"boo","foo":"bar"}`},
+		{name: "move", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, want: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{name: "move array element", doc: `{"foo":["all","grass","cows","eat"]}`, patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, want: `{"foo":["all","cows","eat","grass"]}`},
+		{name: "copy", doc: `{"foo":{"a":1}}`, patch: `[{"op":"copy","from":"/foo","path":"/bar"}]`, want: `{"foo":{"a":1},"bar":{"a":1}}`},
+		{name: "test success", doc: `{"baz":"qux","foo":["a",2,"c"]}`, patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, want: `{"baz":"qux","foo":["a",2,"c"]}`},
+		{name: "test failure", doc: `{"baz":"qux"}`, patch: `[{"op":"test","path":"/baz","value":"bar"}]`, err: ErrPatchTestFailed{Path: "/baz"}},
+		{name: "escaped pointer", doc: `{"/":9,"~1":10}`, patch: `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, want: `{"~1":10}`},
+		{name: "add to nonexistent target", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`, err: ErrInvalidPatch{Msg: `path "baz" does not exist`}},
+		{name: "array index out of bounds", doc: `{"foo":["bar"]}`, patch: `[{"op":"add","path":"/foo/2","value":"qux"}]`, err: ErrInvalidPatch{Msg: `array index "2" out of bounds`}},
+		{name: "replace whole document", doc: `{"foo":"bar"}`, patch: `[{"op":"replace","path":"","value":{"baz":"qux"}}]`, want: `{"baz":"qux"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := parseJSONPatch([]byte(tt.patch))
+			assert.NoError(t, err)
+
+			got, err := patch.apply(decodeJSON(t, tt.doc))
+			if tt.err != nil {
+				assert.Equal(t, tt.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, decodeJSON(t, tt.want), got)
+		})
+	}
+}
+
+func Test_ParseJSONPatch_Invalid(t *testing.T) {
+	for _, patch := range []string{
+		`{"op":"add"}`,
+		`[{"op":"frobnicate","path":"/a"}]`,
+		`[{"op":"add","path":"/a"}]`,
+		`[{"op":"add","path":"a","value":1}]`,
+		`[{"op":"move","from":"/a","path":"/a/b"}]`,
+	} {
+		_, err := parseJSONPatch([]byte(patch))
+		assert.Error(t, err, patch)
+	}
+}
+
+func Test_PatchUserDto(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	dto := UserDto{Id: "1", Name: "Sasi", Role: "user", CreatedAt: now, UpdatedAt: now}
+
+	patched, err := patchUser(dto, func(doc any) (any, error) {
+		return mergePatch(doc, decodeJSON(t, `{"role":null,"name":"Sasi P"}`)), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, UserDto{Id: "1", Name: "Sasi P", CreatedAt: now, UpdatedAt: now}, patched)
+
+	for patch, msg := range map[string]string{
+		`{"id":"2"}`:     `field "id" is read-only`,
+		`{"id":null}`:    `field "id" is read-only`,
+		`{"email":"a"}`:  `unknown field "email"`,
+		`{"role":false}`: `field "role" must be a string`,
+	} {
+		_, err := patchUser(dto, func(doc any) (any, error) {
+			return mergePatch(doc, decodeJSON(t, patch)), nil
+		})
+		assert.Equal(t, ErrInvalidPatch{Msg: msg}, err, patch)
+	}
+
+	_, err = patchUser(dto, func(doc any) (any, error) {
+		return mergePatch(doc, decodeJSON(t, `{"managerId":"boss"}`)), nil
+	})
+	assert.ErrorAs(t, err, &ErrInvalidPatch{}, "expected manager id to be a uuid")
+}
+
+func patchRequest(t *testing.T, app *fiber.App, id, contentType, body string) (*http.Response, string) {
+	req := httptest.NewRequest(http.MethodPatch, "http://acme.com/users/"+id, bytes.NewReader([]byte(body))).
+		WithContext(context.Background())
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "patch user api failed")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	return resp, string(respB)
+}
+
+func Test_PatchUser(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+	manager := domain.NewUser("Sasi", "admin").Id
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		want        domain.User
+	}{
+		{
+			name:        "merge patch clears role",
+			contentType: MergePatchMediaType,
+			body:        `{"name":"Shank","role":null}`,
+			status:      http.StatusOK,
+			want:        domain.User{Id: user.Id, Name: "Shank"},
+		},
+		{
+			name:        "json patch test then set",
+			contentType: JSONPatchMediaType,
+			body:        `[{"op":"test","path":"/role","value":"admin"},{"op":"replace","path":"/role","value":"user"}]`,
+			status:      http.StatusOK,
+			want:        domain.User{Id: user.Id, Name: user.Name, Role: "user"},
+		},
+		{
+			name:        "json patch test fails",
+			contentType: JSONPatchMediaType,
+			body:        `[{"op":"test","path":"/role","value":"user"},{"op":"replace","path":"/role","value":"admin"}]`,
+			status:      http.StatusConflict,
+		},
+		{
+			name:        "merge patch sets manager",
+			contentType: MergePatchMediaType,
+			body:        `{"managerId":"` + manager.String() + `"}`,
+			status:      http.StatusOK,
+			want:        domain.User{Id: user.Id, Name: user.Name, Role: user.Role, ManagerId: manager},
+		},
+		{
+			name:        "read-only field",
+			contentType: MergePatchMediaType,
+			body:        `{"createdBy":"someone"}`,
+			status:      http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, app, userService := setup(t)
+
+			userService.EXPECT().
+				PatchUser(context.Background(), user.Id, gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ any, patch domain.UserPatch) (domain.User, error) {
+					return patch(user)
+				})
+
+			resp, body := patchRequest(t, app, user.Id.String(), tt.contentType, tt.body)
+			assert.Equal(t, tt.status, resp.StatusCode, body)
+			if tt.status != http.StatusOK {
+				return
+			}
+			expectedB, err := json.Marshal(userToDto(tt.want))
+			assert.NoError(t, err, "expected no error here")
+			assert.Equal(t, string(expectedB), body)
+		})
+	}
+}
+
+func Test_PatchUser_BadRequest(t *testing.T) {
+	_, app, _ := setup(t)
+
+	id := domain.NewUser("Shashank Pachava", "admin").Id.String()
+
+	resp, _ := patchRequest(t, app, id, "application/json", `{"name":"Shank"}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	assert.Equal(t, MergePatchMediaType+", "+JSONPatchMediaType, resp.Header.Get("Accept-Patch"))
+
+	resp, _ = patchRequest(t, app, id, JSONPatchMediaType, `[{"op":"add"}]`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = patchRequest(t, app, id, MergePatchMediaType, `{`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_UpdateUser_RequiresAllFields(t *testing.T) {
+	_, app, _ := setup(t)
+
+	req := httptest.NewRequest(http.MethodPut, "http://acme.com/users/"+domain.NewUser("Sasi", "user").Id.String(),
+		bytes.NewReader([]byte(`{"name":"Shank"}`))).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "update user api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
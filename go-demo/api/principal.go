@@ -0,0 +1,54 @@
+package api
+
+import (
+	"api-demo/domain"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+)
+
+// DefaultPrincipalHeader is the header an authenticating proxy in front of
+// the api uses to pass along the identity of the caller
+const DefaultPrincipalHeader = "X-Forwarded-User"
+
+// PrincipalMiddleware copies the caller identity found in header into the
+// user context, so that the domain layer can read it with
+// domain.PrincipalFromContext
+func PrincipalMiddleware(header string) fiber.Handler {
+	if header == "" {
+		header = DefaultPrincipalHeader
+	}
+	return func(c *fiber.Ctx) error {
+		if principal := c.Get(header); principal != "" {
+			c.SetUserContext(domain.WithPrincipal(c.UserContext(), principal))
+		}
+		return c.Next()
+	}
+}
+
+// RequirePrincipal only lets through requests whose caller, as set by
+// PrincipalMiddleware, is one of allowed. Others get a 401 if anonymous and a
+// 403 otherwise
+func RequirePrincipal(allowed ...string) fiber.Handler {
+	set := make(map[string]bool, len(allowed))
+	for _, principal := range allowed {
+		set[principal] = true
+	}
+	return func(c *fiber.Ctx) error {
+		principal, ok := domain.PrincipalFromContext(c.UserContext())
+		status := 0
+		switch {
+		case !ok:
+			status = http.StatusUnauthorized
+		case !set[principal]:
+			status = http.StatusForbidden
+		}
+		if status != 0 {
+			if writeErr := c.Status(status).SendString(http.StatusText(status)); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"api-demo/domain"
+	"encoding/xml"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+// PersonalDataDto is everything held about a user, its user is left out if
+// it was purged while its history was kept
+type PersonalDataDto struct {
+	XMLName    xml.Name         `json:"-" xml:"personalData"`
+	ExportedAt time.Time        `json:"exportedAt" xml:"exportedAt"`
+	User       *UserDto         `json:"user,omitempty" xml:"user,omitempty"`
+	History    []UserVersionDto `json:"history" xml:"history>version"`
+	Groups     []GroupDto       `json:"groups" xml:"groups>group"`
+}
+
+// ErasureReceiptDto records that a user was erased, hash is the sha256 of
+// the receipt chained to the previous one of the tenant by prevHash
+type ErasureReceiptDto struct {
+	XMLName  xml.Name  `json:"-" xml:"erasureReceipt"`
+	Id       string    `json:"id" xml:"id"`
+	TenantId string    `json:"tenantId" xml:"tenantId"`
+	UserId   string    `json:"userId" xml:"userId" sensitive:"true" example:"[REDACTED]"`
+	ErasedAt time.Time `json:"erasedAt" xml:"erasedAt"`
+	ErasedBy string    `json:"erasedBy,omitempty" xml:"erasedBy,omitempty" sensitive:"true" example:"[REDACTED]"`
+	Erased   []string  `json:"erased" xml:"erased>item"`
+	Seq      uint64    `json:"seq" xml:"seq"`
+	PrevHash string    `json:"prevHash,omitempty" xml:"prevHash,omitempty"`
+	Hash     string    `json:"hash" xml:"hash"`
+}
+
+// ErasureReceiptsDto lists the erasure receipts of a tenant, verified
+// reports whether they form an unbroken chain, error why not
+type ErasureReceiptsDto struct {
+	XMLName  xml.Name            `json:"-" xml:"erasureReceipts"`
+	Verified bool                `json:"verified" xml:"verified"`
+	Error    string              `json:"error,omitempty" xml:"error,omitempty"`
+	Receipts []ErasureReceiptDto `json:"receipts" xml:"receipt"`
+}
+
+func erasureReceiptToDto(receipt domain.ErasureReceipt) ErasureReceiptDto {
+	erased := receipt.Erased
+	if erased == nil {
+		erased = []string{}
+	}
+	return ErasureReceiptDto{
+		Id:       receipt.Id.String(),
+		TenantId: receipt.TenantId,
+		UserId:   receipt.UserId.String(),
+		ErasedAt: receipt.ErasedAt,
+		ErasedBy: receipt.ErasedBy,
+		Erased:   erased,
+		Seq:      receipt.Seq,
+		PrevHash: receipt.PrevHash,
+		Hash:     receipt.Hash,
+	}
+}
+
+// privacyErrorStatus maps the errors of exports and erasures to their status
+func privacyErrorStatus(err error) int {
+	if errors.Is(err, domain.ErrErasureUnavailable) {
+		return http.StatusNotImplemented
+	}
+	return historyErrorStatus(err)
+}
+
+// @Summary      Export the personal data of a user
+// @Description  Export everything held about a user, deleted or not: its profile, every version of its history with who made each change and when, and its groups. The history of a purged user is exported on its own. There is no audit log apart from the history, so there are no separate audit entries
+// @ID           export-personal-data
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User ID"
+// @Success      200  {object}  PersonalDataDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /users/{id}/personal-data [get]
+func (u *UserApi) getPersonalData(c *fiber.Ctx) error {
+	id, ok := uuidParam(c, "id")
+	if !ok {
+		return nil
+	}
+	data, err := u.service.ExportPersonalData(c.UserContext(), id)
+	if err != nil {
+		return sendError(c, privacyErrorStatus(err), err)
+	}
+	resp := PersonalDataDto{
+		ExportedAt: data.ExportedAt,
+		History:    make([]UserVersionDto, 0, len(data.Versions)),
+		Groups:     make([]GroupDto, 0, len(data.Groups)),
+	}
+	if data.User != nil {
+		user := userToDto(*data.User)
+		resp.User = &user
+	}
+	for _, version := range data.Versions {
+		resp.History = append(resp.History, UserVersionDto{Version: version.Version, User: userToDto(version.User)})
+	}
+	for _, group := range data.Groups {
+		resp.Groups = append(resp.Groups, groupToDto(group))
+	}
+	return encodeResponse(c, resp, time.Time{}, u.cacheControl)
+}
+
+// eraseUser erases the user with id and writes the receipt of the erasure
+func (u *UserApi) eraseUser(c *fiber.Ctx, id uuid.UUID) error {
+	if !fieldsetParam(c, ErasureReceiptDto{}) {
+		return nil
+	}
+	receipt, err := u.service.Erase(c.UserContext(), id)
+	if err != nil {
+		return sendError(c, privacyErrorStatus(err), err)
+	}
+	return encodeResponse(c, erasureReceiptToDto(receipt), time.Time{}, u.cacheControl)
+}
+
+// @Summary      List erasure receipts
+// @Description  List the receipts of the users erased in the tenant, oldest first, and verify that none of them was changed or removed
+// @ID           list-erasure-receipts
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Success      200  {object}  ErasureReceiptsDto
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Failure      501  {object}  string
+// @Router       /users/erasures [get]
+func (u *UserApi) getErasureReceipts(c *fiber.Ctx) error {
+	receipts, err := u.service.ErasureReceipts(c.UserContext())
+	if err != nil {
+		return sendError(c, privacyErrorStatus(err), err)
+	}
+	resp := ErasureReceiptsDto{Verified: true, Receipts: make([]ErasureReceiptDto, 0, len(receipts))}
+	if err := domain.VerifyReceipts(receipts); err != nil {
+		resp.Verified = false
+		resp.Error = err.Error()
+	}
+	for _, receipt := range receipts {
+		resp.Receipts = append(resp.Receipts, erasureReceiptToDto(receipt))
+	}
+	return encodeResponse(c, resp, time.Time{}, u.cacheControl)
+}
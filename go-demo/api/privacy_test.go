@@ -0,0 +1,103 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_GetPersonalData(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	user := domain.User{Id: uuid.New(), Name: "Sasi", Role: "user", CreatedAt: now, UpdatedAt: now}
+	group := domain.Group{Id: uuid.New(), Name: "engineering", CreatedAt: now, UpdatedAt: now}
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().ExportPersonalData(context.Background(), user.Id).Return(domain.PersonalData{
+		User:       &user,
+		Versions:   []domain.UserVersion{{Version: 1, User: user}},
+		Groups:     []domain.Group{group},
+		ExportedAt: now,
+	}, nil)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+user.Id.String()+"/personal-data", nil), -1)
+	assert.NoError(t, err, "export personal data api failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var data PersonalDataDto
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&data))
+	assert.Equal(t, now, data.ExportedAt)
+	assert.Equal(t, "Sasi", data.User.Name)
+	assert.Len(t, data.History, 1)
+	assert.Equal(t, []GroupDto{groupToDto(group)}, data.Groups)
+
+	purged := uuid.New()
+	userService.EXPECT().ExportPersonalData(context.Background(), purged).Return(domain.PersonalData{ExportedAt: now}, nil)
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+purged.String()+"/personal-data", nil), -1)
+	assert.NoError(t, err, "export personal data api failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Equal(t, `{"exportedAt":"2022-10-01T00:00:00Z","history":[],"groups":[]}`, string(respB))
+}
+
+func Test_DeleteUser_Erase(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	receipt := domain.ChainReceipt(domain.ErasureReceipt{}, domain.ErasureReceipt{
+		Id:       uuid.New(),
+		TenantId: "acme",
+		UserId:   id,
+		ErasedAt: now,
+		Erased:   []string{"profile", "history"},
+	})
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().Erase(context.Background(), id).Return(receipt, nil)
+	userService.EXPECT().Erase(context.Background(), id).Return(domain.ErasureReceipt{}, domain.ErrErasureUnavailable)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodDelete, "http://acme.com/users/"+id.String()+"?erase=true", nil), -1)
+	assert.NoError(t, err, "erase user api failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var dto ErasureReceiptDto
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&dto))
+	assert.Equal(t, erasureReceiptToDto(receipt), dto)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodDelete, "http://acme.com/users/"+id.String()+"?erase=true", nil), -1)
+	assert.NoError(t, err, "erase user api failed")
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodDelete, "http://acme.com/users/"+id.String()+"?erase=maybe", nil), -1)
+	assert.NoError(t, err, "erase user api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_GetErasureReceipts(t *testing.T) {
+	first := domain.ChainReceipt(domain.ErasureReceipt{}, domain.ErasureReceipt{Id: uuid.New(), TenantId: "acme", UserId: uuid.New()})
+	second := domain.ChainReceipt(first, domain.ErasureReceipt{Id: uuid.New(), TenantId: "acme", UserId: uuid.New()})
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().ErasureReceipts(context.Background()).Return([]domain.ErasureReceipt{first, second}, nil)
+	userService.EXPECT().ErasureReceipts(context.Background()).Return([]domain.ErasureReceipt{second}, nil)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/erasures", nil), -1)
+	assert.NoError(t, err, "list erasure receipts api failed")
+	var dto ErasureReceiptsDto
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&dto))
+	assert.True(t, dto.Verified)
+	assert.Len(t, dto.Receipts, 2)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/users/erasures", nil), -1)
+	assert.NoError(t, err, "list erasure receipts api failed")
+	dto = ErasureReceiptsDto{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&dto))
+	assert.False(t, dto.Verified, "expected a missing receipt to be reported")
+	assert.Equal(t, domain.ErrReceiptChainBroken{Seq: 2}.Error(), dto.Error)
+}
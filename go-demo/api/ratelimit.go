@@ -0,0 +1,255 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// APIKeyHeader is the header clients use to identify themselves with an api key
+const APIKeyHeader = "X-API-Key"
+
+// RateLimit describes a token bucket holding at most Requests tokens, which is
+// refilled from empty to full over Per. A zero RateLimit disables limiting
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (r RateLimit) enabled() bool {
+	return r.Requests > 0 && r.Per > 0
+}
+
+// tokensPerSecond is the rate at which the bucket refills
+func (r RateLimit) tokensPerSecond() float64 {
+	return float64(r.Requests) / r.Per.Seconds()
+}
+
+// RateLimitResult is the state of a bucket after trying to take a token from it
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next token is available, zero if
+	// the request was allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets used by RateLimiter
+type RateLimitStore interface {
+	// Take removes a single token from the bucket identified by key
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	fullAt time.Time
+}
+
+// bucketSweepInterval is how often InMemRateLimitStore drops buckets that
+// have refilled completely, since those are indistinguishable from new ones
+const bucketSweepInterval = time.Minute
+
+// InMemRateLimitStore is an in-memory implementation of RateLimitStore
+type InMemRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	clock     domain.Clock
+}
+
+// NewInMemRateLimitStore returns a new instance of InMemRateLimitStore, a nil
+// clock defaults to domain.RealClock
+func NewInMemRateLimitStore(clock domain.Clock) *InMemRateLimitStore {
+	if clock == nil {
+		clock = domain.RealClock{}
+	}
+	return &InMemRateLimitStore{
+		buckets: make(map[string]*tokenBucket),
+		clock:   clock,
+	}
+}
+
+func (s *InMemRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	if !limit.enabled() {
+		return RateLimitResult{}, fmt.Errorf("invalid rate limit %d per %s", limit.Requests, limit.Per)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.sweep(now)
+
+	rate := limit.tokensPerSecond()
+	capacity := float64(limit.Requests)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		s.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	result := RateLimitResult{Limit: limit.Requests}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - bucket.tokens) / rate)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = secondsToDuration((capacity - bucket.tokens) / rate)
+	bucket.fullAt = now.Add(result.Reset)
+
+	return result, nil
+}
+
+// sweep removes buckets that are full, it must be called with the lock held
+func (s *InMemRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < bucketSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// KeyFunc identifies the client a request belongs to. An empty string means
+// the client could not be identified
+type KeyFunc func(c *fiber.Ctx) string
+
+// KeyByAPIKey identifies clients by the api key they send. The key is not
+// checked, so only use it behind a proxy that authenticates api keys: a
+// client could otherwise escape its limit by sending a new key every time
+func KeyByAPIKey(c *fiber.Ctx) string {
+	if key := c.Get(APIKeyHeader); key != "" {
+		return "key:" + key
+	}
+	return ""
+}
+
+// KeyByPrincipal identifies clients by the principal set in the user context.
+// Like KeyByAPIKey, it trusts the proxy in front of the api to have
+// authenticated the principal
+func KeyByPrincipal(c *fiber.Ctx) string {
+	if principal, ok := domain.PrincipalFromContext(c.UserContext()); ok {
+		return "principal:" + principal
+	}
+	return ""
+}
+
+// KeyByIP identifies clients by their ip address
+func KeyByIP(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// FirstKey returns a KeyFunc that uses the first non-empty key produced by fns
+func FirstKey(fns ...KeyFunc) KeyFunc {
+	return func(c *fiber.Ctx) string {
+		for _, fn := range fns {
+			if key := fn(c); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+// DefaultKeyFunc identifies clients by their ip, as clients can send any
+// principal or api key header
+var DefaultKeyFunc = KeyFunc(KeyByIP)
+
+// TrustedProxyKeyFunc prefers the api key, then the principal, then the
+// client ip. Only use it behind a proxy that authenticates api keys and
+// callers, and overwrites the headers carrying them
+var TrustedProxyKeyFunc = FirstKey(KeyByAPIKey, KeyByPrincipal, KeyByIP)
+
+// RateLimiterConfig configures a RateLimiter. Read applies to safe methods
+// (GET, HEAD, OPTIONS) and Write to everything else
+type RateLimiterConfig struct {
+	Read    RateLimit
+	Write   RateLimit
+	KeyFunc KeyFunc
+	Store   RateLimitStore
+}
+
+// RateLimiter is a middleware limiting the request rate of each client
+type RateLimiter struct {
+	config RateLimiterConfig
+}
+
+// NewRateLimiter returns a new instance of RateLimiter
+func NewRateLimiter(config RateLimiterConfig) (RateLimiter, error) {
+	if config.Store == nil {
+		return RateLimiter{}, fmt.Errorf("cannot create rate limiter, missing store")
+	}
+	if config.Read.Requests < 0 || config.Write.Requests < 0 {
+		return RateLimiter{}, fmt.Errorf("cannot create rate limiter, negative limit")
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = DefaultKeyFunc
+	}
+	return RateLimiter{config: config}, nil
+}
+
+// Handler is the fiber.Handler enforcing the configured limits
+func (r *RateLimiter) Handler(c *fiber.Ctx) error {
+	group, limit := "write", r.config.Write
+	switch c.Method() {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		group, limit = "read", r.config.Read
+	}
+	if !limit.enabled() {
+		return c.Next()
+	}
+
+	key := r.config.KeyFunc(c)
+	if key == "" {
+		return c.Next()
+	}
+
+	result, err := r.config.Store.Take(c.UserContext(), group+":"+key, limit)
+	if err != nil {
+		// fail open, an unavailable store should not take the api down with it
+		log.Println("could not apply rate limit", err.Error())
+		return c.Next()
+	}
+
+	c.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		if writeErr := c.Status(http.StatusTooManyRequests).SendString("rate limit exceeded"); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+
+	return c.Next()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"api-demo/domain/domaintest"
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func setupRateLimiter(t *testing.T, config RateLimiterConfig) (*fiber.App, *domaintest.FakeClock) {
+	clock := domaintest.NewFakeClock(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC))
+	config.Store = NewInMemRateLimitStore(clock)
+
+	limiter, err := NewRateLimiter(config)
+	assert.NoError(t, err, "rate limiter creation cannot fail")
+
+	app := fiber.New()
+	app.Use(PrincipalMiddleware(""))
+	app.Use(limiter.Handler)
+	app.Get("/users", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Post("/users", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	return app, clock
+}
+
+func Test_NewRateLimiter(t *testing.T) {
+	_, err := NewRateLimiter(RateLimiterConfig{})
+	assert.Error(t, err, "expected missing store error")
+}
+
+func Test_RateLimiter(t *testing.T) {
+	app, clock := setupRateLimiter(t, RateLimiterConfig{
+		Read:    RateLimit{Requests: 5, Per: time.Minute},
+		Write:   RateLimit{Requests: 2, Per: time.Minute},
+		KeyFunc: TrustedProxyKeyFunc,
+	})
+
+	post := func(principal string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", nil).
+			WithContext(context.Background())
+		req.Header.Set(DefaultPrincipalHeader, principal)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, "request failed")
+		return resp
+	}
+
+	resp := post("a")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", resp.Header.Get("RateLimit-Reset"))
+
+	assert.Equal(t, http.StatusOK, post("a").StatusCode)
+
+	resp = post("a")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	// other clients and the read group have their own buckets
+	assert.Equal(t, http.StatusOK, post("b").StatusCode)
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users", nil)
+	req.Header.Set(DefaultPrincipalHeader, "a")
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "request failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("RateLimit-Limit"))
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, http.StatusOK, post("a").StatusCode)
+}
+
+func Test_RateLimiter_RotatingHeaders(t *testing.T) {
+	app, _ := setupRateLimiter(t, RateLimiterConfig{
+		Write: RateLimit{Requests: 2, Per: time.Minute},
+	})
+
+	for n := 0; n < 3; n++ {
+		req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", nil)
+		req.Header.Set(DefaultPrincipalHeader, fmt.Sprintf("principal-%d", n))
+		req.Header.Set(APIKeyHeader, fmt.Sprintf("key-%d", n))
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, "request failed")
+		if n < 2 {
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			continue
+		}
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "expected new principals and api keys not to escape the limit of the ip")
+	}
+}
+
+func Test_RateLimiter_Disabled(t *testing.T) {
+	app, _ := setupRateLimiter(t, RateLimiterConfig{
+		Write: RateLimit{Requests: 1, Per: time.Minute},
+	})
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://acme.com/users", nil)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, "request failed")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	}
+}
+
+func Test_KeyFunc(t *testing.T) {
+	app := fiber.New()
+	app.Use(PrincipalMiddleware(""))
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString(DefaultKeyFunc(c)) })
+	app.Get("/trusted", func(c *fiber.Ctx) error { return c.SendString(TrustedProxyKeyFunc(c)) })
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    string
+	}{
+		{name: "ip", target: "/", want: "ip:0.0.0.0"},
+		{name: "principal ignored", target: "/", headers: map[string]string{DefaultPrincipalHeader: "bob"}, want: "ip:0.0.0.0"},
+		{name: "api key ignored", target: "/", headers: map[string]string{APIKeyHeader: "abc", DefaultPrincipalHeader: "bob"}, want: "ip:0.0.0.0"},
+		{name: "trusted api key", target: "/trusted", headers: map[string]string{APIKeyHeader: "abc", DefaultPrincipalHeader: "bob"}, want: "key:abc"},
+		{name: "trusted principal", target: "/trusted", headers: map[string]string{DefaultPrincipalHeader: "bob"}, want: "principal:bob"},
+		{name: "trusted ip", target: "/trusted", want: "ip:0.0.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://acme.com"+tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err, "request failed")
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err, "expected no error here")
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+func TestInMemRateLimitStore_Sweep(t *testing.T) {
+	clock := domaintest.NewFakeClock(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC))
+	store := NewInMemRateLimitStore(clock)
+
+	limit := RateLimit{Requests: 1, Per: time.Second}
+	_, err := store.Take(context.Background(), "a", limit)
+	assert.NoError(t, err)
+	assert.Len(t, store.buckets, 1)
+
+	clock.Advance(2 * bucketSweepInterval)
+	_, err = store.Take(context.Background(), "b", limit)
+	assert.NoError(t, err)
+	assert.Len(t, store.buckets, 1, "expected full bucket to be swept")
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"api-demo/domain"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// RecoverPanics answers a request whose handler panicked with a bare 500 and
+// logs the stack. The panic value may hold personal data, so it is neither
+// sent nor logged unless domain.Unredacted. Register it before any other
+// handler, it only covers those registered after it
+func RecoverPanics(c *fiber.Ctx) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("recovered from panic of type %T: %s\n%s", r, domain.Mask(r), debug.Stack())
+		err = sendError(c, http.StatusInternalServerError, errors.New(http.StatusText(http.StatusInternalServerError)))
+	}()
+	return c.Next()
+}
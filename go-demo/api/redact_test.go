@@ -0,0 +1,51 @@
+package api
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_RecoverPanics(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+	})
+
+	app := fiber.New()
+	app.Use(RecoverPanics)
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		panic("could not greet Shashank Pachava")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://acme.com/panic", nil), -1)
+	assert.NoError(t, err, "expected the panic to be recovered")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), string(respB))
+	assert.NotContains(t, logs.String(), "Shashank", "expected the panic value to be redacted")
+	assert.Contains(t, logs.String(), "Test_RecoverPanics", "expected the stack to be logged")
+}
+
+func Test_SensitiveDtoExamples(t *testing.T) {
+	for _, dto := range []any{CreateUserDto{}, UpdateUserDto{}, UserDto{}, AddMemberDto{}, GroupDto{}, ErasureReceiptDto{}} {
+		dtoType := reflect.TypeOf(dto)
+		for n := 0; n < dtoType.NumField(); n++ {
+			field := dtoType.Field(n)
+			if !domain.Sensitive(field) || field.Type.Kind() == reflect.Map {
+				continue
+			}
+			assert.Equal(t, domain.RedactedMask, field.Tag.Get("example"), "expected the example of %s.%s to be redacted", dtoType.Name(), field.Name)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"strconv"
+)
+
+// FeedPublisher serves the change feed of the user repo to followers, as
+// repo.FeedPublisher does
+type FeedPublisher interface {
+	Changes(ctx context.Context, after uint64, limit int) ([]byte, error)
+	Snapshot(ctx context.Context) ([]byte, error)
+}
+
+// ReplicationApi serves the change feed followers replicate. Like TenantApi,
+// it expects routes under /admin to be guarded
+type ReplicationApi struct {
+	feed FeedPublisher
+}
+
+func NewReplicationApi(feed FeedPublisher) (ReplicationApi, error) {
+	if feed == nil {
+		return ReplicationApi{}, fmt.Errorf("cannot create replication api, missing feed")
+	}
+	return ReplicationApi{feed: feed}, nil
+}
+
+// @Summary      Read the change feed
+// @Description  List the writes to users following a change, in the order they were applied. Followers resume from the last change they applied, and start over from a snapshot on a 410
+// @ID           replication-changes
+// @Tags         admin
+// @Produce      json
+// @Param        after  query  int  false  "Seq of the last change applied"
+// @Param        limit  query  int  false  "Maximum number of changes returned"
+// @Success      200  {file}    file
+// @Failure      400  {object}  string
+// @Failure      401  {object}  string
+// @Failure      403  {object}  string
+// @Failure      410  {object}  string
+// @Failure      500  {object}  string
+// @Router       /admin/replication/changes [get]
+func (r *ReplicationApi) changes(c *fiber.Ctx) error {
+	after, err := strconv.ParseUint(c.Query("after", "0"), 10, 64)
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, fmt.Errorf("invalid after: %w", err))
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "0"))
+	if err != nil || limit < 0 {
+		return sendError(c, http.StatusBadRequest, fmt.Errorf("invalid limit %q", c.Query("limit")))
+	}
+
+	batch, err := r.feed.Changes(c.UserContext(), after, limit)
+	if errors.As(err, &domain.ErrFeedGap{}) {
+		return sendError(c, http.StatusGone, err)
+	}
+	if err != nil {
+		return sendError(c, http.StatusInternalServerError, err)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(batch)
+}
+
+// @Summary      Snapshot the change feed
+// @Description  Take a snapshot of every user as of a change of the feed, for followers to start from. Encrypted fields stay encrypted
+// @ID           replication-snapshot
+// @Tags         admin
+// @Produce      json
+// @Success      200  {file}    file
+// @Failure      401  {object}  string
+// @Failure      403  {object}  string
+// @Failure      500  {object}  string
+// @Router       /admin/replication/snapshot [get]
+func (r *ReplicationApi) snapshot(c *fiber.Ctx) error {
+	snapshot, err := r.feed.Snapshot(c.UserContext())
+	if err != nil {
+		return sendError(c, http.StatusInternalServerError, err)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(snapshot)
+}
+
+func (r *ReplicationApi) AddRoutes(app *fiber.App) {
+	app.Get("/admin/replication/changes", func(c *fiber.Ctx) error {
+		return r.changes(c)
+	})
+
+	app.Get("/admin/replication/snapshot", func(c *fiber.Ctx) error {
+		return r.snapshot(c)
+	})
+}
+
+// ReadOnly lets through the reads of a follower, and redirects its writes
+// with a 307 to the same url on primary, the base url of the primary
+func ReadOnly(primary string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+		c.Set(fiber.HeaderLocation, primary+string(c.Request().URI().RequestURI()))
+		return sendError(c, http.StatusTemporaryRedirect, domain.ErrReadOnly{Primary: primary})
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeFeedPublisher answers with changes following after, and keeps the
+// limit asked for
+type fakeFeedPublisher struct {
+	last  uint64
+	limit int
+}
+
+func (f *fakeFeedPublisher) Changes(_ context.Context, after uint64, limit int) ([]byte, error) {
+	if after > f.last {
+		return nil, domain.ErrFeedGap{After: after}
+	}
+	f.limit = limit
+	return []byte(`{"changes":[]}`), nil
+}
+
+func (f *fakeFeedPublisher) Snapshot(context.Context) ([]byte, error) {
+	return []byte(`{"seq":3}`), nil
+}
+
+func Test_ReplicationFeed(t *testing.T) {
+	feed := &fakeFeedPublisher{last: 3}
+	replicationApi, err := NewReplicationApi(feed)
+	assert.NoError(t, err, "replication api creation cannot fail")
+	app := fiber.New()
+	app.Use(PrincipalMiddleware(""))
+	app.Use("/admin", RequirePrincipal("root"))
+	replicationApi.AddRoutes(app)
+
+	request := func(path, principal string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "http://acme.com"+path, nil)
+		if principal != "" {
+			req.Header.Set(DefaultPrincipalHeader, principal)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, "request failed")
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err, "expected no error here")
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := request("/admin/replication/changes?after=2&limit=10", "root")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"changes":[]}`, body)
+	assert.Equal(t, 10, feed.limit)
+
+	status, _ = request("/admin/replication/changes?after=4", "root")
+	assert.Equal(t, http.StatusGone, status)
+	status, _ = request("/admin/replication/changes?after=-1", "root")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request("/admin/replication/changes?limit=-1", "root")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request("/admin/replication/changes", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, body = request("/admin/replication/snapshot", "root")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"seq":3}`, body)
+}
+
+func Test_ReadOnly(t *testing.T) {
+	app := fiber.New()
+	app.Use(ReadOnly("http://primary:3000"))
+	app.Get("/users", func(c *fiber.Ctx) error {
+		return c.SendString("users")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "http://follower/users", nil), -1)
+	assert.NoError(t, err, "request failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodPatch, "http://follower/users/1?fields=name", nil), -1)
+	assert.NoError(t, err, "request failed")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, "http://primary:3000/users/1?fields=name", resp.Header.Get(fiber.HeaderLocation))
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"api-demo/domain"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTenantHeader is the header clients use to name their tenant
+const DefaultTenantHeader = "X-Tenant-ID"
+
+// TenantResolver finds the tenant a request is made for, returning an empty
+// string if it does not name one
+type TenantResolver func(c *fiber.Ctx) string
+
+// TenantFromHeader reads the tenant from header, DefaultTenantHeader if empty.
+// Clients can send any header, so only use it behind a proxy that
+// authenticates callers and sets the header to their tenant
+func TenantFromHeader(header string) TenantResolver {
+	if header == "" {
+		header = DefaultTenantHeader
+	}
+	return func(c *fiber.Ctx) string {
+		return strings.TrimSpace(c.Get(header))
+	}
+}
+
+// TenantFromSubdomain reads the tenant from the label in front of baseDomain,
+// so acme.example.com is tenant acme for base domain example.com. Hosts
+// nested deeper or outside of baseDomain name no tenant. Like
+// TenantFromHeader, it trusts the proxy in front of the api to only let
+// callers reach the hosts of their tenant
+func TenantFromSubdomain(baseDomain string) TenantResolver {
+	suffix := "." + strings.ToLower(strings.Trim(baseDomain, "."))
+	return func(c *fiber.Ctx) string {
+		host := strings.ToLower(c.Hostname())
+		if n := strings.LastIndexByte(host, ':'); n >= 0 && !strings.Contains(host[n:], "]") {
+			host = host[:n]
+		}
+		sub := strings.TrimSuffix(host, suffix)
+		if sub == host || strings.Contains(sub, ".") {
+			return ""
+		}
+		return sub
+	}
+}
+
+// TenantFromTokenClaim reads the tenant from a string claim of the JWT in the
+// Authorization bearer token. Only tokens signed with HS256 under key, and not
+// expired, name a tenant
+func TenantFromTokenClaim(claim string, key []byte) (TenantResolver, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("cannot create token claim resolver, missing key")
+	}
+	return func(c *fiber.Ctx) string {
+		token := strings.TrimSpace(c.Get(fiber.HeaderAuthorization))
+		if len(token) < 7 || !strings.EqualFold(token[:7], "bearer ") {
+			return ""
+		}
+		claims, ok := verifyToken(strings.TrimSpace(token[7:]), key)
+		if !ok {
+			return ""
+		}
+		tenant, _ := claims[claim].(string)
+		return tenant
+	}, nil
+}
+
+// verifyToken returns the claims of a JWT signed with HS256 under key, and
+// false if its signature does not match or it expired
+func verifyToken(token string, key []byte) (map[string]any, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if !decodeTokenPart(parts[0], &header) || header.Alg != "HS256" {
+		return nil, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, false
+	}
+	var claims map[string]any
+	if !decodeTokenPart(parts[1], &claims) {
+		return nil, false
+	}
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() >= int64(exp) {
+		return nil, false
+	}
+	return claims, true
+}
+
+func decodeTokenPart(part string, v any) bool {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(b, v) == nil
+}
+
+// TenantConfig configures TenantMiddleware. Resolvers are tried in order and
+// the first tenant found wins, Default is used when none finds one
+type TenantConfig struct {
+	Resolvers []TenantResolver
+	Default   string
+}
+
+// TenantMiddleware scopes the user context of each request to its tenant, so
+// that services and repos only see the users of that tenant. Requests naming
+// no tenant, an invalid one, or different tenants through different
+// resolvers, such as a header naming another tenant than the token, are
+// rejected
+func TenantMiddleware(config TenantConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenant := ""
+		conflicting := false
+		for _, resolve := range config.Resolvers {
+			named := resolve(c)
+			switch {
+			case named == "":
+			case tenant == "":
+				tenant = named
+			case named != tenant:
+				conflicting = true
+			}
+		}
+		if tenant == "" {
+			tenant = config.Default
+		}
+
+		msg := ""
+		switch {
+		case conflicting:
+			msg = "conflicting tenants"
+		case tenant == "":
+			msg = "missing tenant"
+		case !domain.ValidTenantId(tenant):
+			msg = domain.ErrInvalidTenantId.Error()
+		}
+		if msg != "" {
+			if writeErr := c.Status(http.StatusBadRequest).SendString(msg); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+
+		c.SetUserContext(domain.WithTenant(c.UserContext(), tenant))
+		return c.Next()
+	}
+}
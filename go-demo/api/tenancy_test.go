@@ -0,0 +1,136 @@
+package api
+
+import (
+	"api-demo/domain"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testTokenKey = []byte("secret")
+
+// signToken returns a bearer token of a JWT with payload, signed with HS256
+// under key
+func signToken(key []byte, header, payload string) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return "Bearer " + unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// resolve runs resolver against a request to target with header
+func resolve(t *testing.T, resolver TenantResolver, target string, header map[string]string) string {
+	tenant := ""
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		tenant = resolver(c)
+		return nil
+	})
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	_, err := app.Test(req, -1)
+	assert.NoError(t, err, "request failed")
+	return tenant
+}
+
+func Test_TenantFromHeader(t *testing.T) {
+	resolver := TenantFromHeader("")
+	assert.Equal(t, "acme", resolve(t, resolver, "http://example.com/", map[string]string{DefaultTenantHeader: "acme"}))
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", nil))
+}
+
+func Test_TenantFromSubdomain(t *testing.T) {
+	resolver := TenantFromSubdomain("example.com")
+	assert.Equal(t, "acme", resolve(t, resolver, "http://acme.example.com/", nil))
+	assert.Equal(t, "acme", resolve(t, resolver, "http://acme.example.com:3000/", nil))
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", nil))
+	assert.Empty(t, resolve(t, resolver, "http://a.acme.example.com/", nil))
+	assert.Empty(t, resolve(t, resolver, "http://acme.other.com/", nil))
+}
+
+func Test_TenantFromTokenClaim(t *testing.T) {
+	_, err := TenantFromTokenClaim("tenant", nil)
+	assert.Equal(t, fmt.Errorf("cannot create token claim resolver, missing key"), err)
+
+	resolver, err := TenantFromTokenClaim("tenant", testTokenKey)
+	assert.NoError(t, err)
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	token := func(token string) map[string]string {
+		return map[string]string{fiber.HeaderAuthorization: token}
+	}
+	assert.Equal(t, "acme", resolve(t, resolver, "http://example.com/", token(signToken(testTokenKey, hs256, `{"sub":"sasi","tenant":"acme"}`))))
+	future := time.Now().Add(time.Hour).Unix()
+	assert.Equal(t, "acme", resolve(t, resolver, "http://example.com/", token(signToken(testTokenKey, hs256, fmt.Sprintf(`{"tenant":"acme","exp":%d}`, future)))))
+
+	past := time.Now().Add(-time.Hour).Unix()
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", token(signToken(testTokenKey, hs256, fmt.Sprintf(`{"tenant":"acme","exp":%d}`, past)))), "expected an expired token to name no tenant")
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", token(signToken([]byte("forged"), hs256, `{"tenant":"acme"}`))), "expected a token signed with another key to name no tenant")
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", token(signToken(testTokenKey, `{"alg":"none"}`, `{"tenant":"acme"}`))))
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", token("Bearer e30."+base64.RawURLEncoding.EncodeToString([]byte(`{"tenant":"acme"}`))+".sig")), "expected an unsigned token to name no tenant")
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", token(signToken(testTokenKey, hs256, `{"tenant":42}`))))
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", token(signToken(testTokenKey, hs256, `not json`))))
+	assert.Empty(t, resolve(t, resolver, "http://example.com/", token("Basic abc")))
+}
+
+func Test_TenantMiddleware(t *testing.T) {
+	newApp := func(config TenantConfig) *fiber.App {
+		app := fiber.New()
+		app.Use(TenantMiddleware(config))
+		app.Get("/users", func(c *fiber.Ctx) error {
+			tenant, err := domain.RequireTenant(c.UserContext())
+			if err != nil {
+				return err
+			}
+			return c.SendString(tenant)
+		})
+		return app
+	}
+
+	app := newApp(TenantConfig{
+		Resolvers: []TenantResolver{TenantFromHeader(""), TenantFromSubdomain("example.com")},
+		Default:   "default",
+	})
+
+	resp, body := getRequest(t, app, "/users", map[string]string{DefaultTenantHeader: "globex"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "globex", body)
+
+	resp, body = getRequest(t, app, "/users", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "default", body)
+
+	resp, _ = getRequest(t, app, "/users", map[string]string{DefaultTenantHeader: "Not A Slug"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// a header cannot name another tenant than the token
+	claimResolver, err := TenantFromTokenClaim("tenant", testTokenKey)
+	assert.NoError(t, err)
+	app = newApp(TenantConfig{
+		Resolvers: []TenantResolver{TenantFromHeader(""), claimResolver},
+		Default:   "default",
+	})
+	token := signToken(testTokenKey, `{"alg":"HS256"}`, `{"tenant":"acme"}`)
+	resp, body = getRequest(t, app, "/users", map[string]string{fiber.HeaderAuthorization: token, DefaultTenantHeader: "globex"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "conflicting tenants", body)
+	resp, body = getRequest(t, app, "/users", map[string]string{fiber.HeaderAuthorization: token, DefaultTenantHeader: "acme"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "acme", body)
+	resp, body = getRequest(t, app, "/users", map[string]string{fiber.HeaderAuthorization: token})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "acme", body)
+
+	app = newApp(TenantConfig{Resolvers: []TenantResolver{TenantFromHeader("")}})
+	resp, body = getRequest(t, app, "/users", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "missing tenant", body)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"api-demo/domain"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+	"time"
+)
+
+type CreateTenantDto struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	MaxUsers int    `json:"maxUsers"`
+}
+
+type TenantDto struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	MaxUsers  int       `json:"maxUsers"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+}
+
+func tenantToDto(t domain.Tenant) TenantDto {
+	return TenantDto{
+		Id:        t.Id,
+		Name:      t.Name,
+		MaxUsers:  t.MaxUsers,
+		CreatedAt: t.CreatedAt,
+		CreatedBy: t.CreatedBy,
+	}
+}
+
+// TenantApi serves the administration of tenants. It does not authorize
+// callers itself, routes under /admin are expected to be guarded, e.g. with
+// RequirePrincipal
+type TenantApi struct {
+	service domain.TenantService
+}
+
+func NewTenantApi(service domain.TenantService) (TenantApi, error) {
+	if service == nil {
+		return TenantApi{}, fmt.Errorf("cannot create tenant api, missing service")
+	}
+	return TenantApi{service: service}, nil
+}
+
+// @Summary      Create a tenant
+// @Description  Create a tenant, its id must be a lower case slug. A max users of 0 is unlimited
+// @ID           create-tenant
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        body   body    CreateTenantDto  true  "Tenant's id, name and quota"
+// @Success      201  {object}  TenantDto
+// @Failure      400  {object}  string
+// @Failure      401  {object}  string
+// @Failure      403  {object}  string
+// @Failure      409  {object}  string
+// @Failure      500  {object}  string
+// @Router       /admin/tenants [post]
+func (t *TenantApi) createTenant(c *fiber.Ctx) error {
+	var createDto CreateTenantDto
+	if err := c.BodyParser(&createDto); err != nil {
+		if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	if createDto.MaxUsers < 0 {
+		if writeErr := c.Status(http.StatusBadRequest).SendString("maxUsers cannot be negative"); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+
+	tenant, err := t.service.CreateTenant(c.UserContext(), domain.Tenant{
+		Id:       createDto.Id,
+		Name:     createDto.Name,
+		MaxUsers: createDto.MaxUsers,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		var exists domain.ErrTenantExists
+		switch {
+		case errors.Is(err, domain.ErrInvalidTenantId):
+			status = http.StatusBadRequest
+		case errors.As(err, &exists):
+			status = http.StatusConflict
+		}
+		if writeErr := c.Status(status).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	return c.Status(http.StatusCreated).JSON(tenantToDto(tenant))
+}
+
+// @Summary      List tenants
+// @Description  List every tenant ordered by id
+// @ID           list-tenants
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  []TenantDto
+// @Failure      401  {object}  string
+// @Failure      403  {object}  string
+// @Failure      500  {object}  string
+// @Router       /admin/tenants [get]
+func (t *TenantApi) listTenants(c *fiber.Ctx) error {
+	tenants, err := t.service.ListTenants(c.UserContext())
+	if err != nil {
+		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	resp := make([]TenantDto, 0, len(tenants))
+	for _, tenant := range tenants {
+		resp = append(resp, tenantToDto(tenant))
+	}
+	return c.JSON(resp)
+}
+
+// AddRoutes add routes to fiber.App
+func (t *TenantApi) AddRoutes(app *fiber.App) {
+	app.Post("/admin/tenants", func(c *fiber.Ctx) error {
+		return t.createTenant(c)
+	})
+
+	app.Get("/admin/tenants", func(c *fiber.Ctx) error {
+		return t.listTenants(c)
+	})
+}
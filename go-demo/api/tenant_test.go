@@ -0,0 +1,93 @@
+package api
+
+import (
+	"api-demo/domain"
+	mockDomain "api-demo/mock/domain"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupTenantApi(t *testing.T) (*fiber.App, *mockDomain.MockTenantService) {
+	ctrl := gomock.NewController(t)
+	tenantService := mockDomain.NewMockTenantService(ctrl)
+
+	tenantApi, err := NewTenantApi(tenantService)
+	assert.NoError(t, err, "tenant api creation cannot fail")
+
+	app := fiber.New()
+	app.Use(PrincipalMiddleware(""))
+	app.Use("/admin", RequirePrincipal("root"))
+	tenantApi.AddRoutes(app)
+
+	return app, tenantService
+}
+
+func postTenant(t *testing.T, app *fiber.App, principal, body string) (*http.Response, string) {
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/admin/tenants", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if principal != "" {
+		req.Header.Set(DefaultPrincipalHeader, principal)
+	}
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "request failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	return resp, string(respB)
+}
+
+func Test_CreateTenant(t *testing.T) {
+	app, tenantService := setupTenantApi(t)
+
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	ctx := domain.WithPrincipal(context.Background(), "root")
+	tenantService.EXPECT().
+		CreateTenant(ctx, domain.Tenant{Id: "acme", Name: "Acme", MaxUsers: 5}).
+		Return(domain.Tenant{Id: "acme", Name: "Acme", MaxUsers: 5, CreatedAt: now, CreatedBy: "root"}, nil)
+
+	resp, body := postTenant(t, app, "root", `{"id":"acme","name":"Acme","maxUsers":5}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.JSONEq(t, `{"id":"acme","name":"Acme","maxUsers":5,"createdAt":"2022-10-01T00:00:00Z","createdBy":"root"}`, body)
+
+	tenantService.EXPECT().
+		CreateTenant(ctx, domain.Tenant{Id: "acme"}).
+		Return(domain.Tenant{}, domain.ErrTenantExists{Id: "acme"})
+
+	resp, _ = postTenant(t, app, "root", `{"id":"acme"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, _ = postTenant(t, app, "root", `{"id":"acme","maxUsers":-1}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_CreateTenant_RequiresAdmin(t *testing.T) {
+	app, _ := setupTenantApi(t)
+
+	resp, _ := postTenant(t, app, "", `{"id":"acme"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, _ = postTenant(t, app, "sasi", `{"id":"acme"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func Test_ListTenants(t *testing.T) {
+	app, tenantService := setupTenantApi(t)
+
+	tenantService.EXPECT().
+		ListTenants(domain.WithPrincipal(context.Background(), "root")).
+		Return([]domain.Tenant{{Id: "acme", Name: "acme"}, {Id: "globex", Name: "Globex", MaxUsers: 3}}, nil)
+
+	resp, body := getRequest(t, app, "/admin/tenants", map[string]string{DefaultPrincipalHeader: "root"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `[
+		{"id":"acme","name":"acme","maxUsers":0,"createdAt":"0001-01-01T00:00:00Z"},
+		{"id":"globex","name":"Globex","maxUsers":3,"createdAt":"0001-01-01T00:00:00Z"}
+	]`, body)
+}
@@ -2,65 +2,155 @@ package api
 
 import (
 	"api-demo/domain"
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
-	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/swagger"
 	"github.com/google/uuid"
 	"log"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "api-demo/docs"
 )
 
+// CreateUserDto is a new user. Like on domain.User, fields tagged sensitive
+// hold personal data, their swagger example is domain.RedactedMask
 type CreateUserDto struct {
-	Name string `json:"name"`
-	Role string `json:"role"`
+	XMLName   xml.Name `json:"-" xml:"user"`
+	Name      string   `json:"name" xml:"name" sensitive:"true" example:"[REDACTED]"`
+	Role      string   `json:"role" xml:"role"`
+	ManagerId string   `json:"managerId,omitempty" xml:"managerId,omitempty" sensitive:"true" example:"[REDACTED]"`
+	// Attributes must follow the attribute schema of the deployment
+	Attributes AttributesDto `json:"attributes,omitempty" xml:"attributes,omitempty" sensitive:"true"`
 }
 
 type UpdateUserDto struct {
-	Name *string `json:"name,omitempty"`
-	Role *string `json:"role,omitempty"`
+	XMLName xml.Name `json:"-" xml:"user"`
+	// Name and Role are required, updateUser rejects a body missing either
+	Name *string `json:"name" xml:"name" sensitive:"true" example:"[REDACTED]"`
+	Role *string `json:"role" xml:"role"`
+	// ManagerId and Attributes are optional, leaving them out removes them
+	ManagerId  string        `json:"managerId,omitempty" xml:"managerId,omitempty" sensitive:"true" example:"[REDACTED]"`
+	Attributes AttributesDto `json:"attributes,omitempty" xml:"attributes,omitempty" sensitive:"true"`
 }
 
 type UserDto struct {
-	Id   string `json:"id"`
-	Name string `json:"name"`
-	Role string `json:"role"`
+	XMLName    xml.Name      `json:"-" xml:"user"`
+	Id         string        `json:"id" xml:"id" sensitive:"true" example:"[REDACTED]"`
+	Name       string        `json:"name" xml:"name" sensitive:"true" example:"[REDACTED]"`
+	Role       string        `json:"role" xml:"role"`
+	ManagerId  string        `json:"managerId,omitempty" xml:"managerId,omitempty" sensitive:"true" example:"[REDACTED]"`
+	Attributes AttributesDto `json:"attributes,omitempty" xml:"attributes,omitempty" sensitive:"true"`
+	CreatedAt  time.Time     `json:"createdAt" xml:"createdAt"`
+	CreatedBy  string        `json:"createdBy,omitempty" xml:"createdBy,omitempty" sensitive:"true" example:"[REDACTED]"`
+	UpdatedAt  time.Time     `json:"updatedAt" xml:"updatedAt"`
+	UpdatedBy  string        `json:"updatedBy,omitempty" xml:"updatedBy,omitempty" sensitive:"true" example:"[REDACTED]"`
+	DeletedAt  *time.Time    `json:"deletedAt,omitempty" xml:"deletedAt,omitempty"`
 }
 
 func userToDto(u domain.User) UserDto {
-	return UserDto{
-		u.Id.String(),
-		u.Name,
-		u.Role,
+	dto := UserDto{
+		Id:         u.Id.String(),
+		Name:       u.Name,
+		Role:       u.Role,
+		Attributes: u.Attributes,
+		CreatedAt:  u.CreatedAt,
+		CreatedBy:  u.CreatedBy,
+		UpdatedAt:  u.UpdatedAt,
+		UpdatedBy:  u.UpdatedBy,
 	}
+	if u.ManagerId != uuid.Nil {
+		dto.ManagerId = u.ManagerId.String()
+	}
+	if u.Deleted() {
+		deletedAt := u.DeletedAt
+		dto.DeletedAt = &deletedAt
+	}
+	return dto
 }
 
 type UserApi struct {
-	service domain.UserService
+	service      domain.UserService
+	codecs       Codecs
+	cacheControl cachePolicy
+	schema       domain.AttributeSchema
+}
+
+// UserApiOption configures optional behaviour of UserApi
+type UserApiOption func(*UserApi)
+
+// WithCodecs sets the representations the api speaks, defaults to
+// DefaultCodecs
+func WithCodecs(codecs Codecs) UserApiOption {
+	return func(u *UserApi) {
+		u.codecs = codecs
+	}
 }
 
-func NewUserApi(service domain.UserService) (UserApi, error) {
+// WithCacheControl sets the Cache-Control of GET responses on route, a path
+// such as /users/:id. An empty policy sends none. GET /users and
+// GET /users/:id default to DefaultCacheControl
+func WithCacheControl(route string, policy string) UserApiOption {
+	return func(u *UserApi) {
+		if policy == "" {
+			delete(u.cacheControl, route)
+			return
+		}
+		u.cacheControl[route] = policy
+	}
+}
+
+func NewUserApi(service domain.UserService, opts ...UserApiOption) (UserApi, error) {
 	if service == nil {
 		return UserApi{}, fmt.Errorf("cannot create user api")
 	}
-	return UserApi{service: service}, nil
+	userApi := UserApi{
+		service: service,
+		codecs:  DefaultCodecs(),
+		cacheControl: cachePolicy{
+			"/users":     DefaultCacheControl,
+			"/users/:id": DefaultCacheControl,
+		},
+	}
+	for _, opt := range opts {
+		opt(&userApi)
+	}
+	return userApi, nil
 }
 
 // @Summary      Get a user by their ID
 // @Description  Get a user by their ID. ID must be a valid UUID
 // @ID           get-user-by-id
 // @Tags         users
-// @Produce      json
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
 // @Param        id   path      string  true  "User ID"
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Param        asOf   query      string  false  "RFC 3339 time to read the user as it was then"
+// @Param        If-None-Match   header      string  false  "ETag of a cached representation"
+// @Param        If-Modified-Since   header      string  false  "Last-Modified of a cached representation"
 // @Success      200  {object}  UserDto
+// @Header       200  {string}  ETag  "Strong entity tag of the representation"
+// @Header       200  {string}  Last-Modified  "When the user was last updated"
+// @Success      304
 // @Failure      400  {object}  string
+// @Failure      406  {object}  string
 // @Failure      500  {object}  string
+// @Failure      404  {object}  string
+// @Failure      501  {object}  string
 // @Router       /users/{id} [get]
 func (u *UserApi) getUserById(c *fiber.Ctx) error {
+	if asOf := c.Query("asOf"); asOf != "" {
+		return u.getUserAsOf(c, asOf)
+	}
 	id := c.Params("id")
 	parsedId, err := uuid.Parse(id)
 	if err != nil {
@@ -76,18 +166,30 @@ func (u *UserApi) getUserById(c *fiber.Ctx) error {
 		}
 		return nil
 	}
-	return dtoResponse(c, user)
+	return u.dtoResponse(c, user)
 }
 
 // @Summary      Get a users filtering on properties
 // @Description  Get a users filtering on properties
 // @ID           get-users-by-property
 // @Tags         users
-// @Produce      json
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
 // @Param        name   query      string  false  "User's name"
 // @Param        role   query      string  false  "User's role"
+// @Param        include   query      string  false  "Set to deleted to include soft deleted users"
+// @Param        filter   query      string  false  "Filter expression, e.g. role in (\"admin\", \"ops\") and attributes.department eq \"eng\""
+// @Param        createdSince   query      string  false  "Only users created at or after this RFC 3339 time"
+// @Param        createdBefore   query      string  false  "Only users created before this RFC 3339 time"
+// @Param        updatedSince   query      string  false  "Only users last updated at or after this RFC 3339 time"
+// @Param        updatedBefore   query      string  false  "Only users last updated before this RFC 3339 time"
+// @Param        attributes.name   query      string  false  "Value of the custom attribute name, any attribute of the schema can be given this way"
+// @Param        fields   query      string  false  "Comma separated fields to include, e.g. id,name"
+// @Param        If-None-Match   header      string  false  "ETag of a cached representation"
 // @Success      200  {object}  []UserDto
+// @Header       200  {string}  ETag  "Strong entity tag of the representation, changes when any user in it changes"
+// @Success      304
 // @Failure      400  {object}  string
+// @Failure      406  {object}  string
 // @Failure      500  {object}  string
 // @Router       /users [get]
 func (u *UserApi) getUserByProperty(c *fiber.Ctx) error {
@@ -98,71 +200,211 @@ func (u *UserApi) getUserByProperty(c *fiber.Ctx) error {
 	if role := c.Query("role"); role != "" {
 		prop.Role = &role
 	}
-	users, err := u.service.GetByProperty(c.UserContext(), &prop)
-	if err != nil {
-		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
-			log.Println("could not write to response body", err.Error())
+	if filter := c.Query("filter"); filter != "" {
+		parsed, err := domain.ParseFilter(filter)
+		if err != nil {
+			if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+		prop.Filter = parsed
+	}
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		if name := string(key); strings.HasPrefix(name, domain.AttributePrefix) {
+			if prop.Attributes == nil {
+				prop.Attributes = make(map[string]string)
+			}
+			prop.Attributes[strings.TrimPrefix(name, domain.AttributePrefix)] = string(value)
+		}
+	})
+	for name, bound := range map[string]**time.Time{
+		"createdSince":  &prop.CreatedSince,
+		"createdBefore": &prop.CreatedBefore,
+		"updatedSince":  &prop.UpdatedSince,
+		"updatedBefore": &prop.UpdatedBefore,
+	} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if writeErr := c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("invalid %s: %s", name, err)); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+		*bound = &t
+	}
+	for _, include := range strings.Split(c.Query("include"), ",") {
+		switch include {
+		case "":
+		case "deleted":
+			prop.Deleted = domain.IncludeDeleted
+		default:
+			if writeErr := c.Status(http.StatusBadRequest).SendString("unknown include value " + include); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+	}
+	return u.usersResponse(c, &prop)
+}
+
+// @Summary      Search users by name
+// @Description  Search users by partial or misspelled names, most relevant first
+// @ID           search-users
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        q   query      string  true  "Search query"
+// @Param        limit   query      int  false  "Maximum number of results, defaults to 20 and is capped at 100"
+// @Success      200  {object}  []UserDto
+// @Failure      400  {object}  string
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Failure      501  {object}  string
+// @Router       /users/search [get]
+func (u *UserApi) searchUsers(c *fiber.Ctx) error {
+	query := c.Query("q")
+	if strings.TrimSpace(query) == "" {
+		if writeErr := c.Status(http.StatusBadRequest).SendString("missing search query q"); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "0"))
+	if err != nil || limit < 0 {
+		if writeErr := c.Status(http.StatusBadRequest).SendString("invalid limit"); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
 		}
 		return nil
 	}
 
-	resp := make([]UserDto, 0, len(users))
-	for _, user := range users {
-		resp = append(resp, userToDto(user))
+	users, err := u.service.Search(c.UserContext(), query, limit)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, domain.ErrSearchUnavailable) {
+			status = http.StatusNotImplemented
+		}
+		return sendError(c, status, err)
 	}
-	b, err := json.Marshal(resp)
+	return u.usersDtoResponse(c, users)
+}
+
+// @Summary      List soft deleted users
+// @Description  List users that were deleted and can still be restored
+// @ID           get-deleted-users
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Success      200  {object}  []UserDto
+// @Failure      406  {object}  string
+// @Failure      500  {object}  string
+// @Router       /users/trash [get]
+func (u *UserApi) getDeletedUsers(c *fiber.Ctx) error {
+	return u.usersResponse(c, &domain.UserProperties{Deleted: domain.OnlyDeleted})
+}
+
+func (u *UserApi) usersResponse(c *fiber.Ctx, prop *domain.UserProperties) error {
+	users, err := u.service.GetByProperty(c.UserContext(), prop)
 	if err != nil {
-		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
-			log.Println("could not write to response body", err.Error())
+		status := http.StatusInternalServerError
+		var invalidAttribute domain.ErrInvalidAttribute
+		if errors.As(err, &invalidAttribute) {
+			status = http.StatusBadRequest
 		}
-		return nil
+		return sendError(c, status, err)
 	}
-	if _, writeErr := c.Write(b); writeErr != nil {
-		log.Println("could not write to response body", err.Error())
+
+	// a stable order keeps the representation, and so its ETag, stable
+	sort.Slice(users, func(a, b int) bool {
+		if !users[a].CreatedAt.Equal(users[b].CreatedAt) {
+			return users[a].CreatedAt.Before(users[b].CreatedAt)
+		}
+		return bytes.Compare(users[a].Id[:], users[b].Id[:]) < 0
+	})
+	return u.usersDtoResponse(c, users)
+}
+
+// usersDtoResponse writes a list of users. It has no Last-Modified, as users
+// leaving the list do not make it newer, but its ETag changes with any member
+func (u *UserApi) usersDtoResponse(c *fiber.Ctx, users []domain.User) error {
+	resp := make([]UserDto, 0, len(users))
+	for _, user := range users {
+		resp = append(resp, userToDto(user))
 	}
-	return nil
+	return encodeResponse(c, resp, time.Time{}, u.cacheControl)
 }
 
 // @Summary      Create a user
 // @Description  Create a user by passing their name and role
 // @ID           create-user
 // @Tags         users
-// @Produce      json
+// @Accept       json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
 // @Param        body   body    CreateUserDto  true  "User's name and role"
+// @Param        X-Tenant-ID   header      string  false  "Tenant to create the user in"
 // @Success      200  {object}  UserDto
 // @Failure      400  {object}  string
+// @Failure      403  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      409  {object}  ConflictDto
+// @Failure      415  {object}  string
+// @Failure      422  {object}  string
 // @Failure      500  {object}  string
 // @Router       /users [post]
 func (u *UserApi) createUser(c *fiber.Ctx) error {
+	if !fieldsetParam(c, UserDto{}) {
+		return nil
+	}
 	var createDto CreateUserDto
-	if err := json.Unmarshal(c.Body(), &createDto); err != nil {
+	if err := decodeBody(c, &createDto); err != nil {
 		if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
 			log.Println("could not write to response body", err.Error())
 		}
 		return nil
 	}
-	user, err := u.service.CreateUser(c.UserContext(), domain.NewUser(createDto.Name, createDto.Role))
+	managerId, err := parseManagerId(createDto.ManagerId)
 	if err != nil {
-		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
+		if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
 			log.Println("could not write to response body", err.Error())
 		}
 		return nil
 	}
-	return dtoResponse(c, user)
+	user, err := u.service.CreateUser(c.UserContext(), domain.User{
+		Name:       createDto.Name,
+		Role:       createDto.Role,
+		ManagerId:  managerId,
+		Attributes: createDto.Attributes,
+	})
+	if err != nil {
+		status := validationErrorStatus(err, tenantErrorStatus(err, http.StatusInternalServerError))
+		return sendError(c, status, err)
+	}
+	return u.dtoResponse(c, user)
 }
 
-// @Summary      Update a user
-// @Description  Update a user by passing their name and role. Name or role can be omitted, but not both
+// @Summary      Replace a user
+// @Description  Replace the name, role and manager of a user, name and role are required and leaving out the manager removes it. Use PATCH for partial updates
 // @ID           update-user
 // @Tags         users
-// @Produce      json
+// @Accept       json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
 // @Param        id   path    string  true  "User's ID"
 // @Param        body body    UpdateUserDto  true  "User's updated name and role"
 // @Success      200  {object}  UserDto
 // @Failure      400  {object}  string
+// @Failure      406  {object}  string
+// @Failure      409  {object}  ConflictDto
+// @Failure      415  {object}  string
+// @Failure      422  {object}  string
 // @Failure      500  {object}  string
 // @Router       /users/{id} [put]
 func (u *UserApi) updateUser(c *fiber.Ctx) error {
+	if !fieldsetParam(c, UserDto{}) {
+		return nil
+	}
 	id := c.Params("id")
 	parsedId, parseErr := uuid.Parse(id)
 	if parseErr != nil {
@@ -173,39 +415,152 @@ func (u *UserApi) updateUser(c *fiber.Ctx) error {
 	}
 
 	var updateDto UpdateUserDto
-	if err := json.Unmarshal(c.Body(), &updateDto); err != nil {
+	if err := decodeBody(c, &updateDto); err != nil {
 		if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
 			log.Println("could not write to response body", err.Error())
 		}
 		return nil
 	}
-	var updateUser domain.UpdateUser
-	if updateDto.Name != nil {
-		updateUser.Name = updateDto.Name
-	}
-	if updateDto.Role != nil {
-		updateUser.Role = updateDto.Role
+	if updateDto.Name == nil || updateDto.Role == nil {
+		if writeErr := c.Status(http.StatusBadRequest).SendString("name and role are required, use PATCH for partial updates"); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
 	}
 
-	user, err := u.service.UpdateUser(c.UserContext(), parsedId, updateUser)
+	managerId, err := parseManagerId(updateDto.ManagerId)
 	if err != nil {
-		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
+		if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
 			log.Println("could not write to response body", err.Error())
 		}
 		return nil
 	}
-	return dtoResponse(c, user)
+
+	attributes := map[string]any(updateDto.Attributes)
+	if attributes == nil {
+		attributes = make(map[string]any)
+	}
+
+	user, err := u.service.UpdateUser(c.UserContext(), parsedId, domain.UpdateUser{
+		Name:       updateDto.Name,
+		Role:       updateDto.Role,
+		ManagerId:  &managerId,
+		Attributes: attributes,
+	})
+	if err != nil {
+		return sendError(c, validationErrorStatus(err, http.StatusInternalServerError), err)
+	}
+	return u.dtoResponse(c, user)
+}
+
+// @Summary      Patch a user
+// @Description  Partially update a user with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902). Only name, role and managerId can be changed, removing them clears them. The patch is applied atomically
+// @ID           patch-user
+// @Tags         users
+// @Accept       application/merge-patch+json,application/json-patch+json
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User's ID"
+// @Param        body body    string  true  "Patch document"
+// @Success      200  {object}  UserDto
+// @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      406  {object}  string
+// @Failure      409  {object}  ConflictDto
+// @Failure      415  {object}  string
+// @Failure      422  {object}  string
+// @Failure      500  {object}  string
+// @Router       /users/{id} [patch]
+func (u *UserApi) patchUser(c *fiber.Ctx) error {
+	if !fieldsetParam(c, UserDto{}) {
+		return nil
+	}
+	id := c.Params("id")
+	parsedId, parseErr := uuid.Parse(id)
+	if parseErr != nil {
+		if writeErr := c.Status(http.StatusBadRequest).SendString(parseErr.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+
+	var apply func(doc any) (any, error)
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	switch mediaType {
+	case MergePatchMediaType:
+		var patch any
+		if err := json.Unmarshal(c.Body(), &patch); err != nil {
+			if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+		apply = func(doc any) (any, error) {
+			return mergePatch(doc, patch), nil
+		}
+	case JSONPatchMediaType:
+		patch, err := parseJSONPatch(c.Body())
+		if err != nil {
+			if writeErr := c.Status(http.StatusBadRequest).SendString(err.Error()); writeErr != nil {
+				log.Println("could not write to response body", writeErr.Error())
+			}
+			return nil
+		}
+		apply = patch.apply
+	default:
+		c.Set("Accept-Patch", MergePatchMediaType+", "+JSONPatchMediaType)
+		if writeErr := c.Status(http.StatusUnsupportedMediaType).
+			SendString(fmt.Sprintf("unsupported patch media type %q", mediaType)); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+
+	user, err := u.service.PatchUser(c.UserContext(), parsedId, func(user domain.User) (domain.User, error) {
+		dto, err := patchUser(userToDto(user), apply)
+		if err != nil {
+			return domain.User{}, err
+		}
+		user.Name, user.Role = dto.Name, dto.Role
+		// patchUser has checked that the manager id parses
+		user.ManagerId, _ = parseManagerId(dto.ManagerId)
+		user.Attributes = dto.Attributes
+		return user, nil
+	})
+	if err != nil {
+		status := validationErrorStatus(err, http.StatusInternalServerError)
+		var notFound domain.ErrUserIdNotFound
+		var testFailed ErrPatchTestFailed
+		var invalid ErrInvalidPatch
+		switch {
+		case errors.As(err, &notFound):
+			status = http.StatusNotFound
+		case errors.As(err, &testFailed):
+			status = http.StatusConflict
+		case errors.As(err, &invalid):
+			status = http.StatusUnprocessableEntity
+		}
+		if writeErr := c.Status(status).SendString(err.Error()); writeErr != nil {
+			log.Println("could not write to response body", writeErr.Error())
+		}
+		return nil
+	}
+	return u.dtoResponse(c, user)
 }
 
 // @Summary      Delete a user
-// @Description  Delete a user by passing their ID. ID must be valid
+// @Description  Soft delete a user by passing their ID. ID must be valid. Deleted users can be restored until they are purged. Their direct reports are handled by the manager delete policy. With erase=true, the user, deleted or not, is instead irreversibly erased from the repo, its groups, the search index and the history, and the receipt of the erasure is returned
 // @ID           delete-user
 // @Tags         users
-// @Produce      json
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
 // @Param        id   path    string  true  "User's ID"
-// @Success      200
+// @Param        erase   query    bool  false  "Erase the user irreversibly"
+// @Success      200  {object}  ErasureReceiptDto
 // @Failure      400  {object}  string
+// @Failure      404  {object}  string
+// @Failure      409  {object}  string
+// @Failure      406  {object}  string
 // @Failure      500  {object}  string
+// @Failure      501  {object}  string
 // @Router       /users/{id} [delete]
 func (u *UserApi) deleteUser(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -217,29 +572,116 @@ func (u *UserApi) deleteUser(c *fiber.Ctx) error {
 		return nil
 	}
 
+	if erase := c.Query("erase"); erase != "" {
+		ok, err := strconv.ParseBool(erase)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, fmt.Errorf("invalid erase: %w", err))
+		}
+		if ok {
+			return u.eraseUser(c, parsedId)
+		}
+	}
+
 	if err := u.service.Delete(c.UserContext(), parsedId); err != nil {
+		return sendError(c, validationErrorStatus(err, http.StatusBadRequest), err)
+	}
+
+	return nil
+}
+
+// @Summary      Restore a deleted user
+// @Description  Restore a soft deleted user by passing their ID
+// @ID           restore-user
+// @Tags         users
+// @Produce      json,application/msgpack,application/cbor,application/xml,application/yaml
+// @Param        id   path    string  true  "User's ID"
+// @Success      200  {object}  UserDto
+// @Failure      400  {object}  string
+// @Failure      403  {object}  string
+// @Failure      404  {object}  string
+// @Failure      409  {object}  string
+// @Router       /users/{id}/restore [post]
+func (u *UserApi) restoreUser(c *fiber.Ctx) error {
+	if !fieldsetParam(c, UserDto{}) {
+		return nil
+	}
+	id := c.Params("id")
+	parsedId, parseErr := uuid.Parse(id)
+	if parseErr != nil {
 		if writeErr := c.Status(http.StatusBadRequest).SendString(parseErr.Error()); writeErr != nil {
 			log.Println("could not write to response body", parseErr.Error())
 		}
 		return nil
 	}
 
-	return nil
+	user, err := u.service.Restore(c.UserContext(), parsedId)
+	if err != nil {
+		status := validationErrorStatus(err, tenantErrorStatus(err, http.StatusBadRequest))
+		if errors.Is(err, domain.ErrUserNotDeleted) {
+			status = http.StatusConflict
+		}
+		return sendError(c, status, err)
+	}
+	return u.dtoResponse(c, user)
+}
+
+// tenantErrorStatus maps the errors of a full or unknown tenant to their
+// status, and any other error to fallback
+func tenantErrorStatus(err error, fallback int) int {
+	var quota domain.ErrQuotaExceeded
+	var notFound domain.ErrTenantNotFound
+	switch {
+	case errors.As(err, &quota):
+		return http.StatusForbidden
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	default:
+		return fallback
+	}
 }
 
-func dtoResponse(c *fiber.Ctx, user domain.User) error {
-	dto := userToDto(user)
-	b, err := json.Marshal(dto)
+// parseManagerId parses the manager id of a DTO, an empty id means no manager
+func parseManagerId(id string) (uuid.UUID, error) {
+	if id == "" {
+		return uuid.Nil, nil
+	}
+	managerId, err := uuid.Parse(id)
 	if err != nil {
-		if writeErr := c.Status(http.StatusInternalServerError).SendString(err.Error()); writeErr != nil {
-			log.Println("could not write to response body", err.Error())
-		}
-		return nil
+		return uuid.Nil, fmt.Errorf("invalid manager id: %w", err)
 	}
-	if _, writeErr := c.Write(b); writeErr != nil {
-		log.Println("could not write to response body", err.Error())
+	return managerId, nil
+}
+
+// ConflictDto is the body of a 409 for a broken unique constraint, naming
+// the user already holding the clashing values
+type ConflictDto struct {
+	XMLName    xml.Name `json:"-" xml:"conflict"`
+	Error      string   `json:"error" xml:"error"`
+	Constraint string   `json:"constraint" xml:"constraint"`
+	ExistingId string   `json:"existingId" xml:"existingId"`
+}
+
+// validationErrorStatus maps the errors of an invalid reporting line, of
+// attributes not following the schema, or of a broken unique constraint, to
+// their status, and any other error to fallback
+func validationErrorStatus(err error, fallback int) int {
+	var notFound domain.ErrManagerNotFound
+	var hasReports domain.ErrHasReports
+	var invalidAttribute domain.ErrInvalidAttribute
+	var conflict domain.ErrConflict
+	switch {
+	case errors.Is(err, domain.ErrManagerCycle), errors.As(err, &notFound), errors.As(err, &invalidAttribute):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &hasReports), errors.As(err, &conflict):
+		return http.StatusConflict
+	default:
+		return fallback
 	}
-	return nil
+}
+
+func (u *UserApi) dtoResponse(c *fiber.Ctx, user domain.User) error {
+	describesUsers(c, user.Id, user.ManagerId)
+	return encodeResponse(c, userToDto(user), user.UpdatedAt, u.cacheControl)
 }
 
 // AddRoutes add routes to fiber.App
@@ -253,10 +695,24 @@ func dtoResponse(c *fiber.Ctx, user domain.User) error {
 // @license.url http://www.apache.org/licenses/LICENSE-2.0.html
 // @BasePath /
 func (u *UserApi) AddRoutes(app *fiber.App) {
+	app.Use("/users", u.codecs.Negotiate)
+
 	app.Get("/users", func(c *fiber.Ctx) error {
 		return u.getUserByProperty(c)
 	})
 
+	app.Get("/users/search", func(c *fiber.Ctx) error {
+		return u.searchUsers(c)
+	})
+
+	app.Get("/users/trash", func(c *fiber.Ctx) error {
+		return u.getDeletedUsers(c)
+	})
+
+	app.Get("/users/erasures", func(c *fiber.Ctx) error {
+		return u.getErasureReceipts(c)
+	})
+
 	app.Get("/users/:id", func(c *fiber.Ctx) error {
 		return u.getUserById(c)
 	})
@@ -265,6 +721,10 @@ func (u *UserApi) AddRoutes(app *fiber.App) {
 		return u.updateUser(c)
 	})
 
+	app.Patch("/users/:id", func(c *fiber.Ctx) error {
+		return u.patchUser(c)
+	})
+
 	app.Post("/users", func(c *fiber.Ctx) error {
 		return u.createUser(c)
 	})
@@ -273,10 +733,40 @@ func (u *UserApi) AddRoutes(app *fiber.App) {
 		return u.deleteUser(c)
 	})
 
+	app.Post("/users/:id/restore", func(c *fiber.Ctx) error {
+		return u.restoreUser(c)
+	})
+
+	app.Get("/users/:id/reports", func(c *fiber.Ctx) error {
+		return u.getDirectReports(c)
+	})
+
+	app.Get("/users/:id/subtree", func(c *fiber.Ctx) error {
+		return u.getSubtree(c)
+	})
+
+	app.Get("/users/:id/chain", func(c *fiber.Ctx) error {
+		return u.getManagementChain(c)
+	})
+
+	app.Get("/users/:id/history", func(c *fiber.Ctx) error {
+		return u.getHistory(c)
+	})
+
+	app.Post("/users/:id/revert", func(c *fiber.Ctx) error {
+		return u.revertUser(c)
+	})
+
+	app.Get("/users/:id/personal-data", func(c *fiber.Ctx) error {
+		return u.getPersonalData(c)
+	})
+
 	// swagger
+	app.Get("/docs/doc.json", func(c *fiber.Ctx) error {
+		return u.swaggerDoc(c)
+	})
 	app.Get("/docs/*", swagger.HandlerDefault)
 
 	// middleware
 	app.Use(compress.New())
-	app.Use(recover.New())
 }
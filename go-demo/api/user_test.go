@@ -2,10 +2,12 @@ package api
 
 import (
 	"api-demo/domain"
+	"api-demo/domain/domaintest"
 	mockDomain "api-demo/mock/domain"
 	"bytes"
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang/mock/gomock"
@@ -14,7 +16,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"testing"
+	"time"
 )
 
 type nopCloser struct {
@@ -23,13 +27,13 @@ type nopCloser struct {
 
 func (nopCloser) Close() error { return nil }
 
-func setup(t *testing.T) (UserApi, *fiber.App, *mockDomain.MockUserService) {
+func setup(t *testing.T, opts ...UserApiOption) (UserApi, *fiber.App, *mockDomain.MockUserService) {
 	ctrl := gomock.NewController(t)
 	userService := mockDomain.NewMockUserService(ctrl)
 
 	app := fiber.New()
 
-	userApi, err := NewUserApi(userService)
+	userApi, err := NewUserApi(userService, opts...)
 	assert.NoError(t, err, "user api creation cannot fail")
 
 	userApi.AddRoutes(app)
@@ -37,29 +41,21 @@ func setup(t *testing.T) (UserApi, *fiber.App, *mockDomain.MockUserService) {
 	return userApi, app, userService
 }
 
-type createUserMatcher struct {
-	expectedUser domain.User
-}
-
-func (c createUserMatcher) Matches(i interface{}) bool {
-	user, ok := i.(domain.User)
-	if !ok {
-		return false
-	}
-	c.expectedUser.Id = user.Id
-	return c.expectedUser == user
-}
-
-func (createUserMatcher) String() string {
-	return "create user params"
-}
-
 func Test_CreateUser(t *testing.T) {
-	user := domain.NewUser("Shashank Pachava", "admin")
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	user := domain.User{
+		Id:        domaintest.SequentialID(1),
+		Name:      "Shashank Pachava",
+		Role:      "admin",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
 
 	_, app, userService := setup(t)
 
-	userService.EXPECT().CreateUser(context.Background(), createUserMatcher{user}).Return(user, nil)
+	userService.EXPECT().
+		CreateUser(context.Background(), domain.User{Name: user.Name, Role: user.Role}).
+		Return(user, nil)
 
 	// http.Request
 	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", nil).
@@ -78,19 +74,13 @@ func Test_CreateUser(t *testing.T) {
 	resp, err := app.Test(req, -1)
 	assert.NoError(t, err, "create user api failed")
 
-	createdUser := UserDto{
-		Id:   user.Id.String(),
-		Name: user.Name,
-		Role: user.Role,
-	}
-
-	expectedB, err := json.Marshal(createdUser)
-	assert.NoError(t, err, "expected no error here")
-
 	respB, err := io.ReadAll(resp.Body)
 	assert.NoError(t, err, "expected no error here")
 
-	assert.Equal(t, expectedB, respB, "expected different resp body")
+	assert.Equal(t,
+		`{"id":"00000000-0000-0000-0000-000000000001","name":"Shashank Pachava","role":"admin",`+
+			`"createdAt":"2022-10-01T00:00:00Z","updatedAt":"2022-10-01T00:00:00Z"}`,
+		string(respB), "expected different resp body")
 }
 
 func Test_GetUserById(t *testing.T) {
@@ -150,6 +140,31 @@ func (u userPropertiesMatcher) Matches(i interface{}) bool {
 			return false
 		}
 	}
+	if u.expectedProperties.Deleted != properties.Deleted {
+		return false
+	}
+	if !reflect.DeepEqual(u.expectedProperties.Attributes, properties.Attributes) {
+		return false
+	}
+	if (u.expectedProperties.Filter == nil) != (properties.Filter == nil) {
+		return false
+	}
+	if u.expectedProperties.Filter != nil && u.expectedProperties.Filter.String() != properties.Filter.String() {
+		return false
+	}
+	for _, bounds := range [][2]*time.Time{
+		{u.expectedProperties.CreatedSince, properties.CreatedSince},
+		{u.expectedProperties.CreatedBefore, properties.CreatedBefore},
+		{u.expectedProperties.UpdatedSince, properties.UpdatedSince},
+		{u.expectedProperties.UpdatedBefore, properties.UpdatedBefore},
+	} {
+		if (bounds[0] == nil) != (bounds[1] == nil) {
+			return false
+		}
+		if bounds[0] != nil && !bounds[0].Equal(*bounds[1]) {
+			return false
+		}
+	}
 
 	return true
 }
@@ -219,3 +234,364 @@ func Test_GetUsersByProperty_NoUsers(t *testing.T) {
 
 	assert.Equal(t, string(expectedB), string(respB), "expected different resp body")
 }
+
+func Test_GetUsersByProperty_IncludeDeleted(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+	user.DeletedAt = time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		GetByProperty(context.Background(), userPropertiesMatcher{expectedProperties: domain.UserProperties{
+			Deleted: domain.IncludeDeleted,
+		}}).
+		Return([]domain.User{user}, nil)
+
+	// http.Request
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users?include=deleted", nil).
+		WithContext(context.Background())
+
+	// http.Response
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+
+	assert.Equal(t,
+		`[{"id":"`+user.Id.String()+`","name":"Shashank Pachava","role":"admin","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z","deletedAt":"2022-10-01T00:00:00Z"}]`,
+		string(respB), "expected different resp body")
+}
+
+func Test_GetUsersByProperty_BadInclude(t *testing.T) {
+	_, app, _ := setup(t)
+
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users?include=everything", nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_GetDeletedUsers(t *testing.T) {
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		GetByProperty(context.Background(), userPropertiesMatcher{expectedProperties: domain.UserProperties{
+			Deleted: domain.OnlyDeleted,
+		}}).
+		Return(nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users/trash", nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "trash api failed")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+
+	assert.Equal(t, "[]", string(respB), "expected different resp body")
+}
+
+func Test_RestoreUser(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().Restore(context.Background(), user.Id).Return(user, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users/"+user.Id.String()+"/restore", nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "restore user api failed")
+
+	expectedB, err := json.Marshal(userToDto(user))
+	assert.NoError(t, err, "expected no error here")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+
+	assert.Equal(t, expectedB, respB, "expected different resp body")
+}
+
+func Test_RestoreUser_NotDeleted(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().Restore(context.Background(), user.Id).Return(domain.User{}, domain.ErrUserNotDeleted)
+
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users/"+user.Id.String()+"/restore", nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "restore user api failed")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func Test_GetUsersByProperty_TimeRange(t *testing.T) {
+	_, app, userService := setup(t)
+
+	since := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2022, 10, 8, 0, 0, 0, 0, time.UTC)
+
+	userService.EXPECT().
+		GetByProperty(context.Background(), userPropertiesMatcher{expectedProperties: domain.UserProperties{
+			CreatedSince:  &since,
+			UpdatedBefore: &before,
+		}}).
+		Return(nil, nil)
+
+	queries := make(url.Values)
+	queries.Set("createdSince", since.Format(time.RFC3339))
+	queries.Set("updatedBefore", before.Format(time.RFC3339))
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users?"+queries.Encode(), nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req = httptest.NewRequest(http.MethodGet, "http://acme.com/users?createdSince=yesterday", nil).
+		WithContext(context.Background())
+
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_GetUsersByProperty_Filter(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	filter, err := domain.ParseFilter(`role in ("admin", "ops") and name startswith "Sha"`)
+	assert.NoError(t, err, "expected no error here")
+
+	userService.EXPECT().
+		GetByProperty(context.Background(), userPropertiesMatcher{expectedProperties: domain.UserProperties{
+			Filter: filter,
+		}}).
+		Return([]domain.User{user}, nil)
+
+	queries := make(url.Values)
+	queries.Set("filter", `role in ("admin","ops") and name startswith "Sha"`)
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users?"+queries.Encode(), nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	queries.Set("filter", `role like "admin"`)
+	req = httptest.NewRequest(http.MethodGet, "http://acme.com/users?"+queries.Encode(), nil).
+		WithContext(context.Background())
+
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_SearchUsers(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().Search(context.Background(), "shashnak", 5).Return([]domain.User{user}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users/search?q=shashnak&limit=5", nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "search users api failed")
+
+	expectedB, err := json.Marshal([]UserDto{userToDto(user)})
+	assert.NoError(t, err, "expected no error here")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+
+	assert.Equal(t, string(expectedB), string(respB), "expected different resp body")
+}
+
+func Test_SearchUsers_BadRequest(t *testing.T) {
+	_, app, _ := setup(t)
+
+	for _, target := range []string{"/users/search", "/users/search?q=a&limit=x"} {
+		req := httptest.NewRequest(http.MethodGet, "http://acme.com"+target, nil).
+			WithContext(context.Background())
+
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, "search users api failed")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, target)
+	}
+}
+
+func Test_GetUserById_Fields(t *testing.T) {
+	user := domain.NewUser("Shashank Pachava", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users/"+user.Id.String()+"?fields=name,id", nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "get user api failed")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+
+	assert.Equal(t, fmt.Sprintf(`{"id":"%s","name":"Shashank Pachava"}`, user.Id), string(respB))
+}
+
+func Test_GetUsersByProperty_Fields(t *testing.T) {
+	admin := domain.NewUser("Shashank Pachava", "admin")
+	user := domain.NewUser("Sasi", "user")
+	// users are listed oldest first
+	user.CreatedAt = admin.CreatedAt.Add(time.Second)
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		GetByProperty(context.Background(), gomock.Any()).
+		Return([]domain.User{admin, user}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users?fields=role", nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+
+	assert.Equal(t, `[{"role":"admin"},{"role":"user"}]`, string(respB))
+}
+
+func Test_GetUsersByProperty_UnknownField(t *testing.T) {
+	_, app, userService := setup(t)
+
+	userService.EXPECT().GetByProperty(context.Background(), gomock.Any()).Return(nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://acme.com/users?fields=id,password", nil).
+		WithContext(context.Background())
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "filter users api failed")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Contains(t, string(respB), `unknown field "password"`)
+}
+
+func Test_WriteUser_UnknownField(t *testing.T) {
+	id := "f6b8a4a2-3c1e-4d5b-9a7e-2b1c0d9e8f70"
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+	}{
+		{name: "create", method: http.MethodPost, target: "/users?fields=id,password", contentType: fiber.MIMEApplicationJSON, body: `{"name":"Sasi","role":"user"}`},
+		{name: "update", method: http.MethodPut, target: "/users/" + id + "?fields=id,password", contentType: fiber.MIMEApplicationJSON, body: `{"name":"Sasi","role":"user"}`},
+		{name: "patch", method: http.MethodPatch, target: "/users/" + id + "?fields=id,password", contentType: MergePatchMediaType, body: `{"name":"Sasi"}`},
+		{name: "restore", method: http.MethodPost, target: "/users/" + id + "/restore?fields=id,password"},
+		{name: "revert", method: http.MethodPost, target: "/users/" + id + "/revert?version=1&fields=id,password"},
+		{name: "erase", method: http.MethodDelete, target: "/users/" + id + "?erase=true&fields=id,password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no call to the service is expected, the fieldset is checked first
+			_, app, _ := setup(t)
+
+			req := httptest.NewRequest(tt.method, "http://acme.com"+tt.target, bytes.NewBufferString(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set(fiber.HeaderContentType, tt.contentType)
+			}
+
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err, "write user api failed")
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			respB, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err, "expected no error here")
+			assert.Contains(t, string(respB), `unknown field "password"`)
+		})
+	}
+}
+
+func Test_CreateUser_QuotaExceeded(t *testing.T) {
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		CreateUser(context.Background(), domain.User{Name: "Sasi", Role: "user"}).
+		Return(domain.User{}, fmt.Errorf("could not create user: %w", domain.ErrQuotaExceeded{Tenant: "acme", MaxUsers: 2}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", bytes.NewBufferString(`{"name":"Sasi","role":"user"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func Test_CreateUser_Conflict(t *testing.T) {
+	existing := domain.NewUser("Sasi", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		CreateUser(context.Background(), domain.User{Name: "sasi", Role: "user"}).
+		Return(domain.User{}, fmt.Errorf("could not create user: %w", domain.ErrConflict{
+			Constraint: domain.UniqueConstraint{Fields: []string{"name"}, CaseInsensitive: true},
+			ExistingId: existing.Id,
+		}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", bytes.NewBufferString(`{"name":"sasi","role":"user"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	assert.Contains(t, string(respB), existing.Id.String())
+	var conflict ConflictDto
+	assert.NoError(t, json.Unmarshal(respB, &conflict))
+	assert.Equal(t, existing.Id.String(), conflict.ExistingId)
+	assert.Equal(t, "name:ci", conflict.Constraint)
+	assert.NotContains(t, conflict.Error, existing.Id.String(), "expected the message, which is logged, to be redacted")
+}
+
+func Test_CreateUser_ConflictNegotiated(t *testing.T) {
+	existing := domain.NewUser("Sasi", "admin")
+
+	_, app, userService := setup(t)
+
+	userService.EXPECT().
+		CreateUser(context.Background(), domain.User{Name: "sasi", Role: "user"}).
+		Return(domain.User{}, domain.ErrConflict{Constraint: domain.UniqueConstraint{Fields: []string{"name"}}, ExistingId: existing.Id})
+
+	req := httptest.NewRequest(http.MethodPost, "http://acme.com/users", bytes.NewBufferString(`{"name":"sasi","role":"user"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationXML)
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "create user api failed")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, fiber.MIMEApplicationXML, resp.Header.Get(fiber.HeaderContentType))
+
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	var conflict ConflictDto
+	assert.NoError(t, xml.Unmarshal(respB, &conflict), "expected the conflict in the negotiated representation")
+	assert.Equal(t, existing.Id.String(), conflict.ExistingId)
+	assert.Equal(t, "name", conflict.Constraint)
+}
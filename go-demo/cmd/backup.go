@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"api-demo/api"
+	"api-demo/repo"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// BackupUsers asks a running server for a backup of every user, checks its
+// integrity and writes it to a file
+func BackupUsers(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	var server, principalHeader, principal, out string
+	flags.StringVar(&server, "server", "http://localhost:3000", "Base url of the server to back up")
+	flags.StringVar(&principalHeader, "principal-header", api.DefaultPrincipalHeader, "Header carrying the caller")
+	flags.StringVar(&principal, "principal", "", "Admin principal to call the server as")
+	flags.StringVar(&out, "out", "users.backup", "File to write the backup to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	archive, err := adminRequest(strings.TrimSuffix(server, "/")+"/admin/backup", principalHeader, principal, nil)
+	if err != nil {
+		return fmt.Errorf("could not back up users: %w", err)
+	}
+	backup, err := repo.DecodeBackup(archive)
+	if err != nil {
+		return fmt.Errorf("the server sent a corrupt backup: %w", err)
+	}
+	// written aside first, so that a failed backup never replaces a good one
+	if err := os.WriteFile(out+".tmp", archive, 0o600); err != nil {
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+	if err := os.Rename(out+".tmp", out); err != nil {
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+	fmt.Printf("backed up %d users taken at %s to %s\n", len(backup.Users), backup.CreatedAt.Format(time.RFC3339), out)
+	return nil
+}
+
+// RestoreUsers checks the integrity of a backup file, then has a running
+// server replace every user with those of the backup
+func RestoreUsers(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	var server, principalHeader, principal, in string
+	flags.StringVar(&server, "server", "http://localhost:3000", "Base url of the server to restore")
+	flags.StringVar(&principalHeader, "principal-header", api.DefaultPrincipalHeader, "Header carrying the caller")
+	flags.StringVar(&principal, "principal", "", "Admin principal to call the server as")
+	flags.StringVar(&in, "in", "users.backup", "Backup file to restore")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	archive, err := os.ReadFile(in)
+	if err != nil {
+		return fmt.Errorf("could not read backup: %w", err)
+	}
+	if _, err := repo.DecodeBackup(archive); err != nil {
+		return fmt.Errorf("refusing to restore %s: %w", in, err)
+	}
+
+	body, err := adminRequest(strings.TrimSuffix(server, "/")+"/admin/restore", principalHeader, principal, archive)
+	if err != nil {
+		return fmt.Errorf("could not restore users: %w", err)
+	}
+	var restore api.RestoreDto
+	if err := json.Unmarshal(body, &restore); err != nil {
+		return fmt.Errorf("could not read response: %w", err)
+	}
+	fmt.Printf("restored %d users\n", restore.Restored)
+	return nil
+}
+
+// adminRequest posts body to an admin route, returning the body of a 200
+// response and the error the server sent otherwise
+func adminRequest(url, principalHeader, principal string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if principal != "" {
+		req.Header.Set(principalHeader, principal)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"api-demo/api"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RotateKeys asks a running server to reload its keyring and re-encrypt
+// every user with the current key, then follows the rotation until it ends
+func RotateKeys(args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	var server, principalHeader, principal string
+	var wait bool
+	var poll time.Duration
+	flags.StringVar(&server, "server", "http://localhost:3000", "Base url of the server to rotate the keys of")
+	flags.StringVar(&principalHeader, "principal-header", api.DefaultPrincipalHeader, "Header carrying the caller")
+	flags.StringVar(&principal, "principal", "", "Admin principal to call the server as")
+	flags.BoolVar(&wait, "wait", true, "Wait for the rotation to finish")
+	flags.DurationVar(&poll, "poll", time.Second, "How often to check on the rotation while waiting")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	server = strings.TrimSuffix(server, "/")
+
+	rotation, status, err := keyRotationRequest(http.MethodPost, server+"/admin/keys/rotate", principalHeader, principal)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusAccepted:
+		fmt.Println("key rotation started")
+	case http.StatusConflict:
+		fmt.Println("a key rotation is already running, following it")
+	default:
+		return fmt.Errorf("could not start key rotation: %s", rotation.Error)
+	}
+
+	for wait && rotation.Running {
+		time.Sleep(poll)
+		rotation, status, err = keyRotationRequest(http.MethodGet, server+"/admin/keys/rotation", principalHeader, principal)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("could not get key rotation: %s", rotation.Error)
+		}
+		fmt.Printf("rotated %d users\n", rotation.Rotated)
+	}
+	if rotation.Error != "" {
+		return fmt.Errorf("key rotation failed after %d users: %s", rotation.Rotated, rotation.Error)
+	}
+	if !rotation.Running {
+		fmt.Printf("key rotation finished, %d users rotated\n", rotation.Rotated)
+	}
+	return nil
+}
+
+// keyRotationRequest calls a key rotation route. The error of a response
+// that is not a rotation is put in KeyRotationDto.Error
+func keyRotationRequest(method, url, principalHeader, principal string) (api.KeyRotationDto, int, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return api.KeyRotationDto{}, 0, err
+	}
+	if principal != "" {
+		req.Header.Set(principalHeader, principal)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return api.KeyRotationDto{}, 0, fmt.Errorf("could not reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return api.KeyRotationDto{}, resp.StatusCode, fmt.Errorf("could not read response: %w", err)
+	}
+	var rotation api.KeyRotationDto
+	if err := json.Unmarshal(body, &rotation); err != nil {
+		rotation.Error = strings.TrimSpace(string(body))
+	}
+	return rotation, resp.StatusCode, nil
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"api-demo/api"
+	"context"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// startServer starts a server configured by args, listening on a free port
+// of the loopback interface, and returns its base url
+func startServer(t *testing.T, args ...string) (*Server, string) {
+	config, err := ParseConfig(args)
+	assert.NoError(t, err, "expected valid flags")
+	server, err := NewServer(config)
+	t.Cleanup(server.Close)
+	assert.NoError(t, err, "server creation cannot fail")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "expected a free port")
+	go func() {
+		_ = server.App.Listener(listener)
+	}()
+	t.Cleanup(func() {
+		_ = server.App.Shutdown()
+	})
+	return server, "http://" + listener.Addr().String()
+}
+
+func request(t *testing.T, app *fiber.App, method, path, body string) (*http.Response, string) {
+	return requestWithHeaders(t, app, method, path, body, nil)
+}
+
+func requestWithHeaders(t *testing.T, app *fiber.App, method, path, body string, headers map[string]string) (*http.Response, string) {
+	req := httptest.NewRequest(method, "http://acme.com"+path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if method == http.MethodPatch {
+		req.Header.Set(fiber.HeaderContentType, "application/merge-patch+json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err, "request failed")
+	respB, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err, "expected no error here")
+	return resp, string(respB)
+}
+
+func TestReplication(t *testing.T) {
+	primary, primaryURL := startServer(t, "-admin-principals", "replicator", "-purge-retention", "0")
+	follower, _ := startServer(t,
+		"-replicate-from", primaryURL,
+		"-replication-principal", "replicator",
+		"-replication-interval", "1h",
+	)
+	ctx := context.Background()
+
+	resp, body := request(t, primary.App, http.MethodPost, "/users", `{"name":"Sasi","role":"user"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var sasi api.UserDto
+	assert.NoError(t, json.Unmarshal([]byte(body), &sasi))
+
+	resp, _ = request(t, follower.App, http.MethodGet, "/users/"+sasi.Id, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "expected the follower not to have synced yet")
+	assert.NoError(t, follower.Follower.Sync(ctx))
+	resp, body = request(t, follower.App, http.MethodGet, "/users/"+sasi.Id, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"name":"Sasi"`)
+
+	resp, _ = request(t, primary.App, http.MethodPatch, "/users/"+sasi.Id, `{"name":"Sasi K"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = request(t, primary.App, http.MethodPost, "/users", `{"name":"Jim","role":"user"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, follower.Follower.Sync(ctx))
+	_, body = request(t, follower.App, http.MethodGet, "/users/search?q=Sasi", "")
+	assert.Contains(t, body, `"name":"Sasi K"`, "expected the follower to index replicated users")
+	_, body = request(t, follower.App, http.MethodGet, "/users", "")
+	var users []api.UserDto
+	assert.NoError(t, json.Unmarshal([]byte(body), &users))
+	assert.Len(t, users, 2)
+
+	resp, _ = request(t, follower.App, http.MethodDelete, "/users/"+sasi.Id, "")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, "expected writes to the follower to be redirected")
+	assert.Equal(t, primaryURL+"/users/"+sasi.Id, resp.Header.Get(fiber.HeaderLocation))
+	resp, _ = request(t, primary.App, http.MethodDelete, "/users/"+sasi.Id, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, follower.Follower.Sync(ctx))
+	resp, _ = request(t, follower.App, http.MethodGet, "/users/"+sasi.Id, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "expected the deleted user to be gone")
+
+	resp, body = request(t, follower.App, http.MethodGet, "/health", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var health api.HealthDto
+	assert.NoError(t, json.Unmarshal([]byte(body), &health))
+	assert.Equal(t, api.RoleFollower, health.Role)
+	assert.Equal(t, primaryURL, health.Replication.Primary)
+	assert.Equal(t, uint64(4), health.Replication.Applied)
+	assert.Equal(t, uint64(0), health.Replication.Behind)
+	_, body = request(t, primary.App, http.MethodGet, "/health", "")
+	assert.Equal(t, `{"status":"ok","role":"primary"}`, body)
+}
+
+func TestReplication_Unauthorized(t *testing.T) {
+	_, primaryURL := startServer(t, "-admin-principals", "replicator")
+	follower, _ := startServer(t, "-replicate-from", primaryURL, "-replication-interval", "1h")
+
+	assert.Error(t, follower.Follower.Sync(context.Background()))
+	_, body := request(t, follower.App, http.MethodGet, "/health", "")
+	assert.Contains(t, body, `"error":"could not read snapshot of change feed: primary answered 401: Unauthorized"`)
+}
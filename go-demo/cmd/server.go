@@ -4,10 +4,16 @@ import (
 	"api-demo/api"
 	"api-demo/domain"
 	"api-demo/repo"
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"log"
+	"os"
+	"strings"
+	"time"
 )
 
 func getPort(portInput string) string {
@@ -17,32 +23,513 @@ func getPort(portInput string) string {
 	return portInput
 }
 
+// splitList splits a comma separated flag, dropping empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Config is the configuration of a server, as set by its flags
+type Config struct {
+	Port, PrincipalHeader, IdFormat, UserCacheControl, UsersCacheControl          string
+	TenantHeader, TenantDomain, TenantClaim, DefaultTenant, AdminPrincipals       string
+	TokenKeyPath                                                                  string
+	ManagerDeletePolicy, AttributeSchemaPath, UniqueConstraints, EventLogPath     string
+	KeyringPath, ErasureLogPath, ReplicateFrom, ReplicationPrincipal              string
+	ReadLimit, WriteLimit, UserCacheSize, SnapshotEvery, BodyLimit, FeedRetention int
+	Unredacted, TrustedProxy                                                      bool
+	LimitWindow, IdempotencyTTL, PurgeRetention, PurgeInterval, UserCacheTTL      time.Duration
+	ReplicationInterval, MaxReplicationLag                                        time.Duration
+}
+
+// ParseConfig reads the configuration of a server from its flags
+func ParseConfig(args []string) (Config, error) {
+	var c Config
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.StringVar(&c.Port, "port", ":3000", "Port to use")
+	flags.StringVar(&c.PrincipalHeader, "principal-header", api.DefaultPrincipalHeader, "Header carrying the authenticated caller")
+	flags.IntVar(&c.ReadLimit, "read-limit", 300, "Read requests allowed per client each limit window, 0 disables")
+	flags.IntVar(&c.WriteLimit, "write-limit", 60, "Write requests allowed per client each limit window, 0 disables")
+	flags.DurationVar(&c.LimitWindow, "limit-window", time.Minute, "Window the rate limits apply to")
+	flags.DurationVar(&c.IdempotencyTTL, "idempotency-ttl", api.DefaultIdempotencyTTL, "How long responses to requests with an Idempotency-Key are replayed")
+	flags.DurationVar(&c.PurgeRetention, "purge-retention", 30*24*time.Hour, "How long deleted users can be restored before they are purged, 0 disables purging")
+	flags.DurationVar(&c.PurgeInterval, "purge-interval", time.Hour, "How often deleted users are purged")
+	flags.StringVar(&c.IdFormat, "id-format", domain.IDFormatUUIDv4, "Format of new user ids: uuidv4, uuidv7 or ulid")
+	flags.StringVar(&c.UserCacheControl, "user-cache-control", api.DefaultCacheControl, "Cache-Control of GET /users/:id responses, empty sends none")
+	flags.StringVar(&c.UsersCacheControl, "users-cache-control", api.DefaultCacheControl, "Cache-Control of GET /users responses, empty sends none")
+	flags.IntVar(&c.UserCacheSize, "user-cache-size", 0, "Users cached in front of the user repo, 0 disables the cache")
+	flags.DurationVar(&c.UserCacheTTL, "user-cache-ttl", 0, "How long users stay cached, 0 keeps them until evicted")
+	flags.BoolVar(&c.TrustedProxy, "trusted-proxy", false, "Trust the headers set by the proxy in front of the api to name the tenant of the authenticated caller, and rate limit callers by their api key or principal rather than their ip. Only set it if the proxy authenticates every request and overwrites these headers")
+	flags.StringVar(&c.TenantHeader, "tenant-header", "", "Header naming the tenant of a request, e.g. "+api.DefaultTenantHeader+", needs -trusted-proxy, empty disables")
+	flags.StringVar(&c.TenantDomain, "tenant-domain", "", "Base domain whose subdomains name tenants, e.g. example.com, needs -trusted-proxy, empty disables")
+	flags.StringVar(&c.TenantClaim, "tenant-claim", "", "Claim of the bearer token naming the tenant, needs -token-key, empty disables")
+	flags.StringVar(&c.TokenKeyPath, "token-key", "", "File holding the HMAC key bearer tokens are signed with using HS256")
+	flags.StringVar(&c.DefaultTenant, "default-tenant", "default", "Tenant of requests naming none, created at startup, empty rejects them")
+	flags.StringVar(&c.AdminPrincipals, "admin-principals", "", "Comma separated callers allowed to manage tenants")
+	flags.StringVar(&c.ManagerDeletePolicy, "manager-delete-policy", string(domain.ReassignReports), "What happens to the reports of deleted users: reassign to the skip-level manager, block the delete, or orphan them")
+	flags.StringVar(&c.AttributeSchemaPath, "attribute-schema", "", "JSON file defining the custom attributes of users, empty allows none")
+	flags.StringVar(&c.UniqueConstraints, "unique", "", "Comma separated fields users of a tenant cannot share, join fields with + and add :ci to ignore case, e.g. name:ci,attributes.employeeNumber")
+	flags.StringVar(&c.EventLogPath, "event-log", "", "File to persist users to as a log of events, with snapshots next to it, empty keeps users in memory only")
+	flags.IntVar(&c.SnapshotEvery, "snapshot-every", repo.DefaultSnapshotEvery, "Events appended to the event log between snapshots")
+	flags.StringVar(&c.KeyringPath, "keyring", "", "JSON keyring file of the AES keys user names are encrypted with at rest, empty stores them in the clear")
+	flags.IntVar(&c.BodyLimit, "body-limit", fiber.DefaultBodyLimit, "Largest request body accepted in bytes, restored backups included")
+	flags.BoolVar(&c.Unredacted, "unredacted", false, "Show personal data in logs and error messages, for local development only: the server then only listens on 127.0.0.1")
+	flags.StringVar(&c.ErasureLogPath, "erasure-log", "", "File to keep the receipts of erased users in, empty keeps them in memory only")
+	flags.IntVar(&c.FeedRetention, "feed-retention", repo.DefaultFeedRetention, "Latest user changes the change feed of a primary keeps for followers, 0 disables the feed")
+	flags.StringVar(&c.ReplicateFrom, "replicate-from", "", "Base url of the primary to follow, the server then only serves reads and redirects writes to it")
+	flags.StringVar(&c.ReplicationPrincipal, "replication-principal", "", "Admin principal a follower calls its primary as")
+	flags.DurationVar(&c.ReplicationInterval, "replication-interval", repo.DefaultFollowInterval, "How often a follower polls the change feed of its primary")
+	flags.DurationVar(&c.MaxReplicationLag, "max-replication-lag", 0, "Lag behind its primary past which a follower reports itself unhealthy, 0 never does")
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if (c.TenantHeader != "" || c.TenantDomain != "") && !c.TrustedProxy {
+		return Config{}, fmt.Errorf("-tenant-header and -tenant-domain trust the client to name its tenant, they need -trusted-proxy")
+	}
+	if c.TenantClaim != "" && c.TokenKeyPath == "" {
+		return Config{}, fmt.Errorf("-tenant-claim needs -token-key to verify tokens with")
+	}
+	return c, nil
+}
+
+// Server is the app of a server along with the jobs it runs in the
+// background and the files it holds open
+type Server struct {
+	App  *fiber.App
+	Addr string
+	// Follower is set on followers
+	Follower *repo.Follower
+
+	jobs    []func(ctx context.Context)
+	closers []func() error
+}
+
+// Start runs the background jobs of the server until ctx is done
+func (s *Server) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		go job(ctx)
+	}
+}
+
+// Close closes the files the server holds open
+func (s *Server) Close() {
+	for n := len(s.closers) - 1; n >= 0; n-- {
+		if err := s.closers[n](); err != nil {
+			log.Println("could not close", err.Error())
+		}
+	}
+}
+
 // Run is the entrypoint of the function
 func Run() error {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	var port string
-	flag.StringVar(&port, "port", ":3000", "Port to use")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rotate-keys":
+			return RotateKeys(os.Args[2:])
+		case "backup":
+			return BackupUsers(os.Args[2:])
+		case "restore":
+			return RestoreUsers(os.Args[2:])
+		}
+	}
+
+	config, err := ParseConfig(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	domain.SetUnredacted(config.Unredacted)
+
+	server, err := NewServer(config)
+	defer server.Close()
+	if err != nil {
+		return err
+	}
+	server.Start(context.Background())
+
+	if config.Unredacted {
+		log.Println("personal data is not redacted, listening on", server.Addr, "only")
+	}
+	return server.App.Listen(server.Addr)
+}
+
+// NewServer wires up a server configured by config. The server must be
+// closed even when it fails
+func NewServer(config Config) (*Server, error) {
+	server := &Server{}
+
+	clock := domain.RealClock{}
+	ids, err := domain.NewIDGenerator(config.IdFormat, clock)
+	if err != nil {
+		return server, fmt.Errorf("could not create id generator: %w", err)
+	}
+	deletePolicy, err := domain.ParseManagerDeletePolicy(config.ManagerDeletePolicy)
+	if err != nil {
+		return server, err
+	}
+	var attributeSchema domain.AttributeSchema
+	if config.AttributeSchemaPath != "" {
+		data, err := os.ReadFile(config.AttributeSchemaPath)
+		if err != nil {
+			return server, fmt.Errorf("could not read attribute schema: %w", err)
+		}
+		if attributeSchema, err = api.ParseAttributeSchema(data); err != nil {
+			return server, err
+		}
+	}
+
+	constraints, err := domain.ParseUniqueConstraints(config.UniqueConstraints)
+	if err != nil {
+		return server, err
+	}
+	var keyring repo.Keyring
+	if config.KeyringPath != "" {
+		if keyring, err = repo.LoadKeyring(config.KeyringPath); err != nil {
+			return server, err
+		}
+		for _, constraint := range constraints {
+			for _, field := range constraint.Fields {
+				if repo.SealedField(field) {
+					return server, fmt.Errorf("unique constraint %s cannot be enforced on %s, which is encrypted", constraint, field)
+				}
+			}
+		}
+	}
+	following := config.ReplicateFrom != ""
 
 	// create repo
-	userRepo := repo.NewInMemUserRepo()
+	var userRepo domain.UserRepo
+	if config.EventLogPath != "" {
+		eventStore, err := repo.OpenFileEventStore(config.EventLogPath)
+		if err != nil {
+			return server, err
+		}
+		server.closers = append(server.closers, eventStore.Close)
+		eventSourcedUserRepo, err := repo.NewEventSourcedUserRepo(&eventStore, repo.EventSourcedConfig{
+			Snapshots:     repo.NewFileSnapshotStore(config.EventLogPath + ".snapshot"),
+			SnapshotEvery: config.SnapshotEvery,
+			Constraints:   constraints,
+		})
+		if err != nil {
+			return server, fmt.Errorf("could not open user event log: %w", err)
+		}
+		userRepo = &eventSourcedUserRepo
+	} else {
+		inMemUserRepo := repo.NewInMemUserRepo(constraints...)
+		userRepo = &inMemUserRepo
+	}
+	// the feed records users as they are stored, with their fields sealed
+	var changeFeed *repo.ChangeFeedUserRepo
+	if !following && config.FeedRetention > 0 {
+		feed, err := repo.NewChangeFeedUserRepo(userRepo, repo.FeedConfig{
+			Retention: config.FeedRetention,
+			Clock:     clock,
+		})
+		if err != nil {
+			return server, fmt.Errorf("could not create change feed: %w", err)
+		}
+		changeFeed = &feed
+		userRepo = changeFeed
+	}
+	var encryptingUserRepo *repo.EncryptingUserRepo
+	if config.KeyringPath != "" {
+		encrypting, err := repo.NewEncryptingUserRepo(userRepo, repo.EncryptionConfig{
+			Keyring: keyring,
+			Reload: func() (repo.Keyring, error) {
+				return repo.LoadKeyring(config.KeyringPath)
+			},
+		})
+		if err != nil {
+			return server, fmt.Errorf("could not create encrypting user repo: %w", err)
+		}
+		encryptingUserRepo = &encrypting
+		userRepo = encryptingUserRepo
+	}
+	var cacheStats func() repo.CacheStats
+	if config.UserCacheSize > 0 {
+		cachingUserRepo, err := repo.NewCachingUserRepo(userRepo, repo.CacheConfig{
+			Size:  config.UserCacheSize,
+			TTL:   config.UserCacheTTL,
+			Clock: clock,
+		})
+		if err != nil {
+			return server, fmt.Errorf("could not create user cache: %w", err)
+		}
+		userRepo = &cachingUserRepo
+		cacheStats = cachingUserRepo.Stats
+	}
+	searchIndex := repo.NewInMemUserSearchIndex()
+	tenantRepo := repo.NewInMemTenantRepo()
+	groupRepo := repo.NewInMemGroupRepo()
+	userHistory := repo.NewInMemUserHistory()
+	var erasureLog domain.ErasureLog
+	if config.ErasureLogPath != "" {
+		fileErasureLog, err := repo.OpenFileErasureLog(config.ErasureLogPath)
+		if err != nil {
+			return server, err
+		}
+		server.closers = append(server.closers, fileErasureLog.Close)
+		erasureLog = &fileErasureLog
+	} else {
+		inMemErasureLog := repo.NewInMemErasureLog()
+		erasureLog = &inMemErasureLog
+	}
+
+	tenantService, err := domain.NewTenantServiceImpl(&tenantRepo, clock)
+	if err != nil {
+		return server, fmt.Errorf("could not create tenant service: %w", err)
+	}
+	if config.DefaultTenant != "" {
+		if _, err := tenantService.CreateTenant(context.Background(), domain.Tenant{Id: config.DefaultTenant}); err != nil {
+			return server, fmt.Errorf("could not create default tenant: %w", err)
+		}
+	}
+
+	idempotencyRepo := repo.NewInMemIdempotencyRepo()
 
 	// create service
-	service, err := domain.NewUserServiceImpl(&userRepo)
+	service, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(clock),
+		domain.WithIDGenerator(ids),
+		domain.WithSearchIndex(&searchIndex),
+		domain.WithTenants(&tenantRepo),
+		domain.WithGroups(&groupRepo),
+		domain.WithManagerDeletePolicy(deletePolicy),
+		domain.WithAttributeSchema(attributeSchema),
+		domain.WithHistory(&userHistory),
+		domain.WithErasureLog(erasureLog),
+		domain.WithIdempotencyRecords(&idempotencyRepo),
+	)
+	if err != nil {
+		return server, fmt.Errorf("could not create service: %w", err)
+	}
+	groupService, err := domain.NewGroupServiceImpl(&groupRepo, userRepo, clock, ids)
 	if err != nil {
-		return fmt.Errorf("could not create service: %w", err)
+		return server, fmt.Errorf("could not create group service: %w", err)
+	}
+
+	// restored creates the tenants of the users, and rebuilds the search
+	// index, groups, history and idempotency records, which are kept apart
+	// from the users, once every user was replaced
+	restored := func(ctx context.Context, previous, restored []domain.User) error {
+		for _, user := range restored {
+			_, err := tenantService.CreateTenant(ctx, domain.Tenant{Id: user.TenantId})
+			if err != nil && !errors.As(err, &domain.ErrTenantExists{}) {
+				return err
+			}
+		}
+		return service.Reconcile(ctx, previous, restored)
+	}
+	if config.EventLogPath != "" {
+		// tenants and the search index are kept in memory, so those of the
+		// users read back from the log are rebuilt as after a restore
+		snapshotter, ok := userRepo.(domain.Snapshotter)
+		if !ok {
+			return server, fmt.Errorf("could not read stored users: %w", domain.ErrSnapshotUnsupported)
+		}
+		users, err := snapshotter.SnapshotUsers(context.Background())
+		if err != nil {
+			return server, fmt.Errorf("could not read stored users: %w", err)
+		}
+		if err := restored(context.Background(), nil, users); err != nil {
+			return server, fmt.Errorf("could not index stored users: %w", err)
+		}
+	}
+
+	// followers only write what they replicate, the primary purges
+	if config.PurgeRetention > 0 && !following {
+		purgeJob, err := domain.NewPurgeJob(&service, &tenantService, clock, config.PurgeRetention, config.PurgeInterval)
+		if err != nil {
+			return server, fmt.Errorf("could not create purge job: %w", err)
+		}
+		server.jobs = append(server.jobs, purgeJob.Run)
+	}
+
+	var healthOpts []api.HealthApiOption
+	if following {
+		remoteFeed, err := repo.NewRemoteChangeFeed(repo.RemoteFeedConfig{
+			URL:             config.ReplicateFrom,
+			PrincipalHeader: config.PrincipalHeader,
+			Principal:       config.ReplicationPrincipal,
+		})
+		if err != nil {
+			return server, err
+		}
+		follower, err := repo.NewFollower(userRepo, &remoteFeed, repo.FollowerConfig{
+			Primary:  config.ReplicateFrom,
+			Clock:    clock,
+			Interval: config.ReplicationInterval,
+			Applied: func(ctx context.Context, change domain.Change) error {
+				_, err := tenantService.CreateTenant(ctx, domain.Tenant{Id: change.TenantId})
+				if err != nil && !errors.As(err, &domain.ErrTenantExists{}) {
+					return err
+				}
+				// the search index needs the user as the service sees it,
+				// with its fields opened
+				user, err := userRepo.GetUserById(ctx, change.UserId)
+				if err != nil || user.Deleted() {
+					searchIndex.RemoveUser(change.UserId)
+					return nil
+				}
+				searchIndex.IndexUser(user)
+				return nil
+			},
+			Restored: restored,
+		})
+		if err != nil {
+			return server, fmt.Errorf("could not create follower: %w", err)
+		}
+		server.Follower = &follower
+		server.jobs = append(server.jobs, follower.Run)
+		healthOpts = append(healthOpts, api.WithReplica(&follower, config.MaxReplicationLag))
 	}
 
 	// create routes
-	userApi, err := api.NewUserApi(&service)
+	userApi, err := api.NewUserApi(&service,
+		api.WithCacheControl("/users/:id", config.UserCacheControl),
+		api.WithCacheControl("/users", config.UsersCacheControl),
+		api.WithAttributeSchema(attributeSchema),
+	)
+	if err != nil {
+		return server, fmt.Errorf("could not create api: %w", err)
+	}
+	tenantApi, err := api.NewTenantApi(&tenantService)
+	if err != nil {
+		return server, fmt.Errorf("could not create tenant api: %w", err)
+	}
+	groupApi, err := api.NewGroupApi(&groupService)
 	if err != nil {
-		return fmt.Errorf("could not create api: %w", err)
+		return server, fmt.Errorf("could not create group api: %w", err)
+	}
+	healthApi, err := api.NewHealthApi(healthOpts...)
+	if err != nil {
+		return server, fmt.Errorf("could not create health api: %w", err)
 	}
 
-	app := fiber.New()
+	var tenantResolvers []api.TenantResolver
+	if config.TenantHeader != "" {
+		tenantResolvers = append(tenantResolvers, api.TenantFromHeader(config.TenantHeader))
+	}
+	if config.TenantDomain != "" {
+		tenantResolvers = append(tenantResolvers, api.TenantFromSubdomain(config.TenantDomain))
+	}
+	if config.TenantClaim != "" {
+		key, err := os.ReadFile(config.TokenKeyPath)
+		if err != nil {
+			return server, fmt.Errorf("could not read token key: %w", err)
+		}
+		claimResolver, err := api.TenantFromTokenClaim(config.TenantClaim, bytes.TrimSpace(key))
+		if err != nil {
+			return server, err
+		}
+		tenantResolvers = append(tenantResolvers, claimResolver)
+	}
+
+	// clients are told apart by their ip, unless the proxy vouches for
+	// their api key or principal
+	keyFunc := api.DefaultKeyFunc
+	if config.TrustedProxy {
+		keyFunc = api.TrustedProxyKeyFunc
+	}
+	rateLimiter, err := api.NewRateLimiter(api.RateLimiterConfig{
+		Read:    api.RateLimit{Requests: config.ReadLimit, Per: config.LimitWindow},
+		Write:   api.RateLimit{Requests: config.WriteLimit, Per: config.LimitWindow},
+		KeyFunc: keyFunc,
+		Store:   api.NewInMemRateLimitStore(clock),
+	})
+	if err != nil {
+		return server, fmt.Errorf("could not create rate limiter: %w", err)
+	}
+
+	idempotencyStore, err := api.NewRepoIdempotencyStore(&idempotencyRepo)
+	if err != nil {
+		return server, fmt.Errorf("could not create idempotency store: %w", err)
+	}
+	idempotency, err := api.NewIdempotency(api.IdempotencyConfig{
+		Store:   idempotencyStore,
+		TTL:     config.IdempotencyTTL,
+		KeyFunc: keyFunc,
+		Clock:   clock,
+	})
+	if err != nil {
+		return server, fmt.Errorf("could not create idempotency middleware: %w", err)
+	}
+
+	app := fiber.New(fiber.Config{BodyLimit: config.BodyLimit})
+
+	app.Use(api.RecoverPanics)
+
+	// health checks are neither rate limited nor redirected
+	healthApi.AddRoutes(app)
+	if following {
+		app.Use(api.ReadOnly(strings.TrimSuffix(config.ReplicateFrom, "/")))
+	}
+
+	app.Use(api.PrincipalMiddleware(config.PrincipalHeader))
+	tenantMiddleware := api.TenantMiddleware(api.TenantConfig{
+		Resolvers: tenantResolvers,
+		Default:   config.DefaultTenant,
+	})
+	app.Use("/users", tenantMiddleware)
+	app.Use("/groups", tenantMiddleware)
+	app.Use("/admin", api.RequirePrincipal(splitList(config.AdminPrincipals)...))
+	app.Use(rateLimiter.Handler)
+	app.Use(idempotency.Handler)
 
 	userApi.AddRoutes(app)
+	tenantApi.AddRoutes(app)
+	groupApi.AddRoutes(app)
+	if encryptingUserRepo != nil {
+		keyRotationJob, err := domain.NewKeyRotationJob(encryptingUserRepo, &tenantService, clock)
+		if err != nil {
+			return server, fmt.Errorf("could not create key rotation job: %w", err)
+		}
+		keyApi, err := api.NewKeyApi(&keyRotationJob)
+		if err != nil {
+			return server, fmt.Errorf("could not create key api: %w", err)
+		}
+		keyApi.AddRoutes(app)
+	}
+	backups, err := repo.NewBackups(userRepo, repo.BackupConfig{
+		Clock:    clock,
+		Erasures: erasureLog,
+		Restored: restored,
+	})
+	if err != nil {
+		return server, err
+	}
+	backupApi, err := api.NewBackupApi(&backups)
+	if err != nil {
+		return server, fmt.Errorf("could not create backup api: %w", err)
+	}
+	backupApi.AddRoutes(app)
+	if changeFeed != nil {
+		feedPublisher, err := repo.NewFeedPublisher(changeFeed, clock)
+		if err != nil {
+			return server, err
+		}
+		replicationApi, err := api.NewReplicationApi(&feedPublisher)
+		if err != nil {
+			return server, fmt.Errorf("could not create replication api: %w", err)
+		}
+		replicationApi.AddRoutes(app)
+	}
+	if cacheStats != nil {
+		app.Get("/debug/user-cache", func(c *fiber.Ctx) error {
+			return c.JSON(cacheStats())
+		})
+	}
 
-	return app.Listen(getPort(port))
+	server.App = app
+	server.Addr = getPort(config.Port)
+	if config.Unredacted {
+		server.Addr = "127.0.0.1" + server.Addr
+	}
+	return server, nil
 }
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"api-demo/api"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestart(t *testing.T) {
+	args := []string{
+		"-event-log", filepath.Join(t.TempDir(), "users.log"),
+		"-admin-principals", "admin",
+		"-purge-retention", "0",
+		"-trusted-proxy", "-tenant-header", api.DefaultTenantHeader,
+	}
+	globex := map[string]string{api.DefaultTenantHeader: "globex"}
+	admin := map[string]string{api.DefaultPrincipalHeader: "admin"}
+
+	config, err := ParseConfig(args)
+	assert.NoError(t, err, "expected valid flags")
+	server, err := NewServer(config)
+	assert.NoError(t, err, "server creation cannot fail")
+	resp, _ := requestWithHeaders(t, server.App, http.MethodPost, "/admin/tenants", `{"id":"globex"}`, admin)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = requestWithHeaders(t, server.App, http.MethodPost, "/users", `{"name":"Sasi","role":"user"}`, globex)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	server.Close()
+
+	restarted, err := NewServer(config)
+	t.Cleanup(restarted.Close)
+	assert.NoError(t, err, "expected the stored users to be read back")
+	_, body := requestWithHeaders(t, restarted.App, http.MethodGet, "/admin/tenants", "", admin)
+	assert.Contains(t, body, `"id":"globex"`, "expected the tenant of stored users to be rebuilt")
+	_, body = requestWithHeaders(t, restarted.App, http.MethodGet, "/users/search?q=Sasi", "", globex)
+	assert.Contains(t, body, `"name":"Sasi"`, "expected stored users of every tenant to be indexed")
+	resp, _ = requestWithHeaders(t, restarted.App, http.MethodPost, "/users", `{"name":"Jim","role":"user"}`, globex)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "expected users to be created in the rebuilt tenant")
+}
+
+func TestEraseIdempotencyRecords(t *testing.T) {
+	config, err := ParseConfig([]string{"-purge-retention", "0"})
+	assert.NoError(t, err, "expected valid flags")
+	server, err := NewServer(config)
+	t.Cleanup(server.Close)
+	assert.NoError(t, err, "server creation cannot fail")
+	key := map[string]string{api.IdempotencyKeyHeader: "create-sasi"}
+
+	resp, body := requestWithHeaders(t, server.App, http.MethodPost, "/users", `{"name":"Sasi","role":"user"}`, key)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var sasi api.UserDto
+	assert.NoError(t, json.Unmarshal([]byte(body), &sasi))
+	resp, _ = requestWithHeaders(t, server.App, http.MethodPost, "/users", `{"name":"Sasi","role":"user"}`, key)
+	assert.Equal(t, "true", resp.Header.Get(api.IdempotentReplayedHeader), "expected the response to be replayed")
+	// a response shaped to the name alone does not mention the id
+	rename := map[string]string{api.IdempotencyKeyHeader: "rename-sasi"}
+	resp, body = requestWithHeaders(t, server.App, http.MethodPatch, "/users/"+sasi.Id+"?fields=name", `{"name":"Sasi Kiran"}`, rename)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, body, sasi.Id)
+
+	resp, body = request(t, server.App, http.MethodDelete, "/users/"+sasi.Id+"?erase=true", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "idempotency records")
+	resp, body = requestWithHeaders(t, server.App, http.MethodPost, "/users", `{"name":"Sasi","role":"user"}`, key)
+	assert.Empty(t, resp.Header.Get(api.IdempotentReplayedHeader), "expected the response of an erased user not to be replayed")
+	assert.NotContains(t, body, sasi.Id)
+	resp, body = requestWithHeaders(t, server.App, http.MethodPatch, "/users/"+sasi.Id+"?fields=name", `{"name":"Sasi Kiran"}`, rename)
+	assert.Empty(t, resp.Header.Get(api.IdempotentReplayedHeader), "expected the shaped response of an erased user not to be replayed")
+	assert.NotContains(t, body, "Sasi Kiran")
+}
+
+func TestRestoreErased(t *testing.T) {
+	config, err := ParseConfig([]string{"-admin-principals", "admin", "-purge-retention", "0"})
+	assert.NoError(t, err, "expected valid flags")
+	server, err := NewServer(config)
+	t.Cleanup(server.Close)
+	assert.NoError(t, err, "server creation cannot fail")
+	admin := map[string]string{api.DefaultPrincipalHeader: "admin"}
+
+	_, body := request(t, server.App, http.MethodPost, "/users", `{"name":"Sasi","role":"user"}`)
+	var sasi api.UserDto
+	assert.NoError(t, json.Unmarshal([]byte(body), &sasi))
+	resp, archive := requestWithHeaders(t, server.App, http.MethodPost, "/admin/backup", "", admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = request(t, server.App, http.MethodDelete, "/users/"+sasi.Id+"?erase=true", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = requestWithHeaders(t, server.App, http.MethodPost, "/admin/restore", archive, admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"restored":0}`, body)
+	resp, _ = request(t, server.App, http.MethodGet, "/users/"+sasi.Id, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "expected an erased user not to be restored")
+	_, body = request(t, server.App, http.MethodGet, "/users/search?q=Sasi", "")
+	assert.Equal(t, "[]", body)
+}
+
+func TestTenantTrust(t *testing.T) {
+	_, err := ParseConfig([]string{"-tenant-header", api.DefaultTenantHeader})
+	assert.Error(t, err, "expected the tenant header to need a trusted proxy")
+	_, err = ParseConfig([]string{"-tenant-domain", "example.com"})
+	assert.Error(t, err, "expected tenant subdomains to need a trusted proxy")
+	_, err = ParseConfig([]string{"-tenant-claim", "tenant"})
+	assert.Error(t, err, "expected the tenant claim to need a token key")
+
+	config, err := ParseConfig([]string{"-admin-principals", "admin", "-purge-retention", "0"})
+	assert.NoError(t, err, "expected valid flags")
+	server, err := NewServer(config)
+	t.Cleanup(server.Close)
+	assert.NoError(t, err, "server creation cannot fail")
+	resp, _ := requestWithHeaders(t, server.App, http.MethodPost, "/admin/tenants", `{"id":"globex"}`, map[string]string{api.DefaultPrincipalHeader: "admin"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	globex := map[string]string{api.DefaultTenantHeader: "globex"}
+	_, body := requestWithHeaders(t, server.App, http.MethodPost, "/users", `{"name":"Sasi","role":"user"}`, globex)
+	var sasi api.UserDto
+	assert.NoError(t, json.Unmarshal([]byte(body), &sasi))
+	resp, _ = request(t, server.App, http.MethodGet, "/users/"+sasi.Id, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "expected the tenant header to be ignored by default")
+	resp, _ = requestWithHeaders(t, server.App, http.MethodGet, "/users/"+sasi.Id, "", globex)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,274 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AttributeType is the type of the values of a custom attribute
+type AttributeType string
+
+const (
+	AttributeString AttributeType = "string"
+	AttributeInt    AttributeType = "int"
+	AttributeBool   AttributeType = "bool"
+	AttributeEnum   AttributeType = "enum"
+	AttributeDate   AttributeType = "date"
+)
+
+// DateLayout is the layout date attributes are stored in
+const DateLayout = "2006-01-02"
+
+// AttributePrefix prefixes custom attributes in filters, as in
+// `attributes.department eq "eng"`
+const AttributePrefix = "attributes."
+
+// attributeName is the form of attribute names, so that they can be used in
+// filters and as xml element names
+var attributeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// AttributeDef defines a custom attribute users can carry
+type AttributeDef struct {
+	Name     string
+	Type     AttributeType
+	Required bool
+	// Values are the allowed values of an enum attribute
+	Values      []string
+	Description string
+}
+
+// AttributeSchema is the set of custom attributes of a deployment. The zero
+// value allows no attributes
+type AttributeSchema struct {
+	defs   []AttributeDef
+	byName map[string]AttributeDef
+}
+
+// ErrInvalidAttribute is returned when the attributes of a user do not follow
+// the schema
+type ErrInvalidAttribute struct {
+	Name string
+	Msg  string
+}
+
+func (e ErrInvalidAttribute) Error() string {
+	return fmt.Sprintf("invalid attribute %q: %s", e.Name, e.Msg)
+}
+
+// NewAttributeSchema returns a schema of defs, ordered by name
+func NewAttributeSchema(defs ...AttributeDef) (AttributeSchema, error) {
+	schema := AttributeSchema{byName: make(map[string]AttributeDef, len(defs))}
+	for _, def := range defs {
+		if !attributeName.MatchString(def.Name) {
+			return AttributeSchema{}, fmt.Errorf("invalid attribute name %q, expected a letter followed by letters, digits or _", def.Name)
+		}
+		if _, ok := schema.byName[def.Name]; ok {
+			return AttributeSchema{}, fmt.Errorf("attribute %q is defined twice", def.Name)
+		}
+		switch def.Type {
+		case AttributeString, AttributeInt, AttributeBool, AttributeDate:
+			if len(def.Values) > 0 {
+				return AttributeSchema{}, fmt.Errorf("attribute %q has values but is not an enum", def.Name)
+			}
+		case AttributeEnum:
+			if len(def.Values) == 0 {
+				return AttributeSchema{}, fmt.Errorf("enum attribute %q has no values", def.Name)
+			}
+		default:
+			return AttributeSchema{}, fmt.Errorf("attribute %q has unknown type %q, expected string, int, bool, enum or date", def.Name, def.Type)
+		}
+		def.Values = append([]string(nil), def.Values...)
+		schema.byName[def.Name] = def
+		schema.defs = append(schema.defs, def)
+	}
+	sort.Slice(schema.defs, func(a, b int) bool {
+		return schema.defs[a].Name < schema.defs[b].Name
+	})
+	return schema, nil
+}
+
+// Defs returns the attribute definitions ordered by name
+func (s AttributeSchema) Defs() []AttributeDef {
+	return append([]AttributeDef(nil), s.defs...)
+}
+
+// Def returns the definition of the attribute name
+func (s AttributeSchema) Def(name string) (AttributeDef, bool) {
+	def, ok := s.byName[name]
+	return def, ok
+}
+
+// Validate checks attributes against the schema and returns a copy holding
+// their canonical values: string for string, enum and date attributes, in
+// DateLayout for dates, int64 for int attributes and bool for bool ones. The
+// string forms of ints and bools are accepted, as some representations, like
+// xml, carry no types. A nil value is the same as a missing one. The result
+// is nil if there are no attributes
+func (s AttributeSchema) Validate(attributes map[string]any) (map[string]any, error) {
+	var resp map[string]any
+	for name, value := range attributes {
+		if value == nil {
+			continue
+		}
+		def, ok := s.byName[name]
+		if !ok {
+			return nil, ErrInvalidAttribute{Name: name, Msg: "unknown attribute"}
+		}
+		canonical, err := def.normalize(value)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			resp = make(map[string]any, len(attributes))
+		}
+		resp[name] = canonical
+	}
+	for _, def := range s.defs {
+		if _, ok := resp[def.Name]; def.Required && !ok {
+			return nil, ErrInvalidAttribute{Name: def.Name, Msg: "attribute is required"}
+		}
+	}
+	return resp, nil
+}
+
+// CheckFilter checks that the attributes f refers to are in the schema
+func (s AttributeSchema) CheckFilter(f Filter) error {
+	switch f := f.(type) {
+	case And:
+		for _, term := range f.Terms {
+			if err := s.CheckFilter(term); err != nil {
+				return err
+			}
+		}
+	case Or:
+		for _, term := range f.Terms {
+			if err := s.CheckFilter(term); err != nil {
+				return err
+			}
+		}
+	case Not:
+		return s.CheckFilter(f.Term)
+	case Comparison:
+		if strings.HasPrefix(f.Field, AttributePrefix) {
+			name := strings.TrimPrefix(f.Field, AttributePrefix)
+			if _, defined := s.byName[name]; !defined {
+				return ErrInvalidAttribute{Name: name, Msg: "unknown attribute"}
+			}
+		}
+	}
+	return nil
+}
+
+// normalize returns the canonical form of a value of the attribute
+func (d AttributeDef) normalize(value any) (any, error) {
+	invalid := func(expected string) error {
+		return ErrInvalidAttribute{Name: d.Name, Msg: fmt.Sprintf("expected %s, got %s", expected, Mask(value))}
+	}
+	switch d.Type {
+	case AttributeString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid("a string")
+		}
+		return s, nil
+	case AttributeInt:
+		i, ok := toInt64(value)
+		if !ok {
+			return nil, invalid("an integer")
+		}
+		return i, nil
+	case AttributeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, invalid("a boolean")
+	case AttributeEnum:
+		s, ok := value.(string)
+		if ok {
+			for _, allowed := range d.Values {
+				if s == allowed {
+					return s, nil
+				}
+			}
+		}
+		return nil, invalid("one of " + strings.Join(d.Values, ", "))
+	case AttributeDate:
+		switch v := value.(type) {
+		case time.Time:
+			return v.Format(DateLayout), nil
+		case string:
+			if t, err := time.Parse(DateLayout, v); err == nil {
+				return t.Format(DateLayout), nil
+			}
+		}
+		return nil, invalid("a date formatted as " + DateLayout)
+	default:
+		return nil, invalid(string(d.Type))
+	}
+}
+
+// toInt64 converts the integer types decoders produce to int64, including
+// floats without a fractional part, as json numbers decode to float64
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float32:
+		return toInt64(float64(v))
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// FormatAttribute returns the string form of a canonical attribute value, as
+// matched by filters and UserProperties.Attributes
+func FormatAttribute(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
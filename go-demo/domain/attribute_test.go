@@ -0,0 +1,149 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	mockDomain "api-demo/mock/domain"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func testSchema(t *testing.T) domain.AttributeSchema {
+	schema, err := domain.NewAttributeSchema(
+		domain.AttributeDef{Name: "department", Type: domain.AttributeEnum, Required: true, Values: []string{"eng", "ops"}},
+		domain.AttributeDef{Name: "employeeNumber", Type: domain.AttributeInt},
+		domain.AttributeDef{Name: "remote", Type: domain.AttributeBool},
+		domain.AttributeDef{Name: "startDate", Type: domain.AttributeDate},
+		domain.AttributeDef{Name: "location", Type: domain.AttributeString},
+	)
+	assert.NoError(t, err, "expected no error")
+	return schema
+}
+
+func Test_NewAttributeSchema(t *testing.T) {
+	for name, def := range map[string]domain.AttributeDef{
+		"invalid name":        {Name: "1st", Type: domain.AttributeString},
+		"unknown type":        {Name: "height", Type: "float"},
+		"enum without values": {Name: "department", Type: domain.AttributeEnum},
+		"values of non enum":  {Name: "location", Type: domain.AttributeString, Values: []string{"here"}},
+	} {
+		_, err := domain.NewAttributeSchema(def)
+		assert.Error(t, err, name)
+	}
+
+	_, err := domain.NewAttributeSchema(
+		domain.AttributeDef{Name: "location", Type: domain.AttributeString},
+		domain.AttributeDef{Name: "location", Type: domain.AttributeString},
+	)
+	assert.Error(t, err, "expected duplicate attribute to fail")
+
+	var names []string
+	for _, def := range testSchema(t).Defs() {
+		names = append(names, def.Name)
+	}
+	assert.Equal(t, []string{"department", "employeeNumber", "location", "remote", "startDate"}, names)
+}
+
+func TestAttributeSchema_Validate(t *testing.T) {
+	schema := testSchema(t)
+
+	attributes, err := schema.Validate(map[string]any{
+		"department":     "eng",
+		"employeeNumber": float64(42),
+		"remote":         "true",
+		"startDate":      time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		"location":       nil,
+	})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, map[string]any{
+		"department":     "eng",
+		"employeeNumber": int64(42),
+		"remote":         true,
+		"startDate":      "2022-10-01",
+	}, attributes, "expected canonical values")
+
+	for name, attributes := range map[string]map[string]any{
+		"missing required": {"location": "Berlin"},
+		"unknown":          {"department": "eng", "height": "tall"},
+		"not in enum":      {"department": "sales"},
+		"fractional int":   {"department": "eng", "employeeNumber": 4.2},
+		"bad bool":         {"department": "eng", "remote": "sometimes"},
+		"bad date":         {"department": "eng", "startDate": "01/10/2022"},
+		"non string":       {"department": "eng", "location": 12},
+	} {
+		_, err := schema.Validate(attributes)
+		assert.ErrorAs(t, err, &domain.ErrInvalidAttribute{}, name)
+	}
+
+	attributes, err = domain.AttributeSchema{}.Validate(nil)
+	assert.NoError(t, err, "expected no attributes to be valid without a schema")
+	assert.Nil(t, attributes)
+}
+
+func TestUserServiceImpl_Attributes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithAttributeSchema(testSchema(t)),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	user := newUser("Shashank Pachava", "admin")
+	user.Attributes = map[string]any{"department": "eng", "employeeNumber": float64(7)}
+
+	userRepo.EXPECT().SaveUser(testCtx, gomock.Any()).Return(nil)
+	created, err := userService.CreateUser(testCtx, user)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, map[string]any{"department": "eng", "employeeNumber": int64(7)}, created.Attributes)
+
+	user.Attributes = map[string]any{"location": "Berlin"}
+	_, err = userService.CreateUser(testCtx, user)
+	assert.ErrorIs(t, err, domain.ErrInvalidAttribute{Name: "department", Msg: "attribute is required"})
+
+	userRepo.EXPECT().GetUserById(testCtx, created.Id).Return(created, nil)
+	_, err = userService.UpdateUser(testCtx, created.Id, domain.UpdateUser{Attributes: map[string]any{"department": "hr"}})
+	assert.ErrorAs(t, err, &domain.ErrInvalidAttribute{})
+
+	_, err = userService.GetByProperty(testCtx, &domain.UserProperties{Attributes: map[string]string{"height": "tall"}})
+	assert.ErrorIs(t, err, domain.ErrInvalidAttribute{Name: "height", Msg: "unknown attribute"})
+
+	filter, err := domain.ParseFilter(`attributes.height eq "tall"`)
+	assert.NoError(t, err, "expected no error")
+	_, err = userService.GetByProperty(testCtx, &domain.UserProperties{Filter: filter})
+	assert.ErrorAs(t, err, &domain.ErrInvalidAttribute{})
+}
+
+func TestUserServiceImpl_PatchUser_KeepsAttributesOfOldSchema(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithAttributeSchema(testSchema(t)),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	// stored before department was required
+	user := newUser("Shashank Pachava", "admin")
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil).Times(2)
+	userRepo.EXPECT().SaveUser(testCtx, gomock.Any()).Return(nil)
+
+	patched, err := userService.PatchUser(testCtx, user.Id, func(user domain.User) (domain.User, error) {
+		user.Name = "Shank"
+		return user, nil
+	})
+	assert.NoError(t, err, "expected attributes to be left alone")
+	assert.Equal(t, "Shank", patched.Name)
+
+	_, err = userService.PatchUser(testCtx, user.Id, func(user domain.User) (domain.User, error) {
+		user.Attributes = map[string]any{"location": "Berlin"}
+		return user, nil
+	})
+	assert.ErrorAs(t, err, &domain.ErrInvalidAttribute{}, "expected changed attributes to be checked")
+}
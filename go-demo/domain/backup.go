@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+var ErrSnapshotUnsupported = errors.New("the user repo cannot take snapshots")
+
+// ErrInvalidBackup is returned when a backup archive is malformed, of an
+// unknown format, or does not match its checksum
+type ErrInvalidBackup struct {
+	Msg string
+}
+
+func (e ErrInvalidBackup) Error() string {
+	return "invalid backup: " + e.Msg
+}
+
+// Snapshotter is implemented by user repos that can copy and replace every
+// user of every tenant at once, to back them up and restore them. Unlike
+// UserRepo, its methods are not scoped to a tenant
+type Snapshotter interface {
+	// SnapshotUsers returns every user as they all were at one point in
+	// time, while writes go on
+	SnapshotUsers(ctx context.Context) ([]User, error)
+	// RestoreUsers replaces every user with users, all at once. It fails
+	// with ErrConflict, replacing nothing, if users break a unique
+	// constraint
+	RestoreUsers(ctx context.Context, users []User) error
+}
+
+// Reconcile brings what derives from the users in line once every user was
+// replaced with restored, previous being the users replaced. Users left out
+// of restored are dropped from the search index, their groups, the history
+// and the idempotency records, and every user restored is indexed again
+func (u *UserServiceImpl) Reconcile(ctx context.Context, previous, restored []User) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	kept := make(map[uuid.UUID]struct{}, len(restored))
+	for _, user := range restored {
+		kept[user.Id] = struct{}{}
+	}
+	for _, user := range previous {
+		if u.search != nil {
+			u.search.RemoveUser(user.Id)
+		}
+		if _, ok := kept[user.Id]; ok {
+			continue
+		}
+		tenantCtx := WithTenant(ctx, user.TenantId)
+		if u.groups != nil {
+			if err := u.groups.RemoveUserMemberships(tenantCtx, user.Id); err != nil {
+				return fmt.Errorf("could not remove groups of user: %w", err)
+			}
+		}
+		if u.history != nil {
+			err := u.history.Erase(tenantCtx, user.Id)
+			if err != nil && !errors.As(err, &ErrUserIdNotFound{}) {
+				return fmt.Errorf("could not remove history of user: %w", err)
+			}
+		}
+		if u.idempotency != nil {
+			if _, err := u.idempotency.DeleteUserRecords(tenantCtx, user.Id); err != nil {
+				return fmt.Errorf("could not remove idempotency records of user: %w", err)
+			}
+		}
+	}
+	for _, user := range restored {
+		u.indexUser(user)
+	}
+	return nil
+}
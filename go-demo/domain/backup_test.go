@@ -0,0 +1,45 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	mockDomain "api-demo/mock/domain"
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUserServiceImpl_Reconcile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	searchIndex := mockDomain.NewMockUserSearchIndex(ctrl)
+	groupRepo := mockDomain.NewMockGroupRepo(ctrl)
+	history := mockDomain.NewMockUserHistory(ctrl)
+	idempotencyRepo := mockDomain.NewMockIdempotencyRepo(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithSearchIndex(searchIndex),
+		domain.WithGroups(groupRepo),
+		domain.WithHistory(history),
+		domain.WithIdempotencyRecords(idempotencyRepo),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	kept := newUser("Sasi", "user")
+	deleted := newUser("Shank", "user")
+	deleted.DeletedAt = testNow
+	dropped := newUser("Jim", "user")
+
+	// every user replaced leaves the index, the dropped one everything else
+	searchIndex.EXPECT().RemoveUser(kept.Id)
+	searchIndex.EXPECT().RemoveUser(dropped.Id)
+	groupRepo.EXPECT().RemoveUserMemberships(testCtx, dropped.Id).Return(nil)
+	history.EXPECT().Erase(testCtx, dropped.Id).Return(domain.ErrUserIdNotFound{Id: dropped.Id})
+	idempotencyRepo.EXPECT().DeleteUserRecords(testCtx, dropped.Id).Return(1, nil)
+	searchIndex.EXPECT().IndexUser(kept)
+	searchIndex.EXPECT().RemoveUser(deleted.Id)
+
+	err = userService.Reconcile(context.Background(), []domain.User{kept, dropped}, []domain.User{kept, deleted})
+	assert.NoError(t, err, "expected no error")
+}
@@ -0,0 +1,15 @@
+package domain
+
+import "time"
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is a Clock reading the system time
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
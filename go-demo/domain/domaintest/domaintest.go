@@ -0,0 +1,68 @@
+// Package domaintest provides deterministic implementations of the domain
+// package dependencies for use in tests
+package domaintest
+
+import (
+	"encoding/binary"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// FakeClock is a domain.Clock that only moves when told to
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a new instance of FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// SequentialIDGenerator is a domain.IDGenerator returning the ids
+// SequentialID(1), SequentialID(2) and so on
+type SequentialIDGenerator struct {
+	mu   sync.Mutex
+	next uint64
+}
+
+// NewSequentialIDGenerator returns a new instance of SequentialIDGenerator
+func NewSequentialIDGenerator() *SequentialIDGenerator {
+	return &SequentialIDGenerator{next: 1}
+}
+
+func (g *SequentialIDGenerator) NewID() uuid.UUID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id := SequentialID(g.next)
+	g.next++
+	return id
+}
+
+// SequentialID returns the n-th id produced by a SequentialIDGenerator
+func SequentialID(n uint64) uuid.UUID {
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[8:], n)
+	return id
+}
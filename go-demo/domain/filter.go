@@ -0,0 +1,443 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"strconv"
+	"strings"
+)
+
+// Filter is a node of a parsed filter expression, such as
+// `role in ("admin", "ops") and name startswith "Sha"`
+type Filter interface {
+	Matches(user User) bool
+	String() string
+}
+
+// FilterOp is the operator of a Comparison
+type FilterOp string
+
+const (
+	OpEq         FilterOp = "eq"
+	OpNe         FilterOp = "ne"
+	OpIn         FilterOp = "in"
+	OpStartsWith FilterOp = "startswith"
+	OpContains   FilterOp = "contains"
+)
+
+// FilterFields are the user fields a filter can refer to, along with custom
+// attributes prefixed with AttributePrefix
+var FilterFields = []string{"id", "name", "role", "managerId", "createdBy", "updatedBy", AttributePrefix + "<name>"}
+
+// UserField returns the value of the named field of user, as used by filters.
+// Custom attributes the user does not have are empty
+func UserField(user User, field string) (string, bool) {
+	if strings.HasPrefix(field, AttributePrefix) {
+		name := strings.TrimPrefix(field, AttributePrefix)
+		return FormatAttribute(user.Attributes[name]), attributeName.MatchString(name)
+	}
+	switch field {
+	case "id":
+		return user.Id.String(), true
+	case "name":
+		return user.Name, true
+	case "role":
+		return user.Role, true
+	case "managerId":
+		if user.ManagerId == uuid.Nil {
+			return "", true
+		}
+		return user.ManagerId.String(), true
+	case "createdBy":
+		return user.CreatedBy, true
+	case "updatedBy":
+		return user.UpdatedBy, true
+	default:
+		return "", false
+	}
+}
+
+// And matches users matched by every term
+type And struct {
+	Terms []Filter
+}
+
+func (a And) Matches(user User) bool {
+	for _, term := range a.Terms {
+		if !term.Matches(user) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a And) String() string {
+	return joinFilters(a.Terms, " and ")
+}
+
+// Or matches users matched by any term
+type Or struct {
+	Terms []Filter
+}
+
+func (o Or) Matches(user User) bool {
+	for _, term := range o.Terms {
+		if term.Matches(user) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Or) String() string {
+	return joinFilters(o.Terms, " or ")
+}
+
+// Not matches users not matched by Term
+type Not struct {
+	Term Filter
+}
+
+func (n Not) Matches(user User) bool {
+	return !n.Term.Matches(user)
+}
+
+func (n Not) String() string {
+	if _, ok := n.Term.(Comparison); ok {
+		return "not " + n.Term.String()
+	}
+	return "not (" + n.Term.String() + ")"
+}
+
+// Comparison compares a user field against one value, or a list of values
+// for OpIn
+type Comparison struct {
+	Field           string
+	Op              FilterOp
+	Values          []string
+	CaseInsensitive bool
+}
+
+func (c Comparison) Matches(user User) bool {
+	value, _ := UserField(user, c.Field)
+	if c.CaseInsensitive {
+		value = strings.ToLower(value)
+	}
+	for _, v := range c.Values {
+		if c.CaseInsensitive {
+			v = strings.ToLower(v)
+		}
+		switch c.Op {
+		case OpEq, OpIn:
+			if value == v {
+				return true
+			}
+		case OpNe:
+			return value != v
+		case OpStartsWith:
+			return strings.HasPrefix(value, v)
+		case OpContains:
+			return strings.Contains(value, v)
+		}
+	}
+	return false
+}
+
+func (c Comparison) String() string {
+	op := string(c.Op)
+	if c.CaseInsensitive {
+		op = "i" + op
+	}
+	quoted := make([]string, 0, len(c.Values))
+	for _, v := range c.Values {
+		quoted = append(quoted, strconv.Quote(v))
+	}
+	if c.Op == OpIn {
+		return fmt.Sprintf("%s %s (%s)", c.Field, op, strings.Join(quoted, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", c.Field, op, quoted[0])
+}
+
+func joinFilters(terms []Filter, sep string) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		s := term.String()
+		switch term.(type) {
+		case And, Or:
+			s = "(" + s + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, sep)
+}
+
+// ErrInvalidFilter is returned when a filter expression cannot be parsed
+type ErrInvalidFilter struct {
+	Pos int
+	Msg string
+}
+
+func (e ErrInvalidFilter) Error() string {
+	return fmt.Sprintf("invalid filter at position %d: %s", e.Pos, e.Msg)
+}
+
+// maxFilterDepth bounds the nesting of parsed expressions
+const maxFilterDepth = 32
+
+// ParseFilter parses a filter expression. Comparisons are written as
+// `field op "value"` where op is one of eq (or =), ne (or !=), startswith and
+// contains, or `field in ("a", "b")`. Prefixing an operator with i, as in
+// ieq or icontains, makes it case-insensitive. Comparisons are combined with
+// and, or, not and parentheses, in the usual order of precedence
+func ParseFilter(s string) (Filter, error) {
+	tokens, err := lexFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := filterParser{tokens: tokens}
+	f, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, ErrInvalidFilter{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+	}
+	return f, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokLParen
+	tokRParen
+	tokComma
+	tokOp
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lexFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, filterToken{kind: tokComma, text: ",", pos: i})
+			i++
+		case c == '=':
+			tokens = append(tokens, filterToken{kind: tokOp, text: string(OpEq), pos: i})
+			i++
+		case c == '!' && i+1 < len(s) && s[i+1] == '=':
+			tokens = append(tokens, filterToken{kind: tokOp, text: string(OpNe), pos: i})
+			i += 2
+		case c == '"':
+			start := i
+			i++
+			var sb strings.Builder
+			closed := false
+			for i < len(s) {
+				if s[i] == '\\' && i+1 < len(s) {
+					sb.WriteByte(s[i+1])
+					i += 2
+					continue
+				}
+				if s[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(s[i])
+				i++
+			}
+			if !closed {
+				return nil, ErrInvalidFilter{Pos: start, Msg: "unterminated string"}
+			}
+			tokens = append(tokens, filterToken{kind: tokString, text: sb.String(), pos: start})
+		case isIdentByte(c):
+			start := i
+			for i < len(s) && isIdentByte(s[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokIdent, text: s[start:i], pos: start})
+		default:
+			return nil, ErrInvalidFilter{Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, filterToken{kind: tokEOF, pos: len(s)}), nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// keyword consumes the next token if it is the keyword kw
+func (p *filterParser) keyword(kw string) bool {
+	tok := p.peek()
+	if tok.kind == tokIdent && strings.EqualFold(tok.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr(depth int) (Filter, error) {
+	if depth > maxFilterDepth {
+		return nil, ErrInvalidFilter{Pos: p.peek().pos, Msg: "expression nested too deeply"}
+	}
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	terms := []Filter{left}
+	for p.keyword("or") {
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, right)
+	}
+	if len(terms) == 1 {
+		return left, nil
+	}
+	return Or{Terms: terms}, nil
+}
+
+func (p *filterParser) parseAnd(depth int) (Filter, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	terms := []Filter{left}
+	for p.keyword("and") {
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, right)
+	}
+	if len(terms) == 1 {
+		return left, nil
+	}
+	return And{Terms: terms}, nil
+}
+
+func (p *filterParser) parseUnary(depth int) (Filter, error) {
+	if p.keyword("not") {
+		if depth+1 > maxFilterDepth {
+			return nil, ErrInvalidFilter{Pos: p.peek().pos, Msg: "expression nested too deeply"}
+		}
+		term, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Not{Term: term}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		f, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokRParen {
+			return nil, ErrInvalidFilter{Pos: tok.pos, Msg: "expected )"}
+		}
+		return f, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	field := p.next()
+	if field.kind != tokIdent {
+		return nil, ErrInvalidFilter{Pos: field.pos, Msg: "expected field name"}
+	}
+	if _, ok := UserField(User{}, field.text); !ok {
+		return nil, ErrInvalidFilter{
+			Pos: field.pos,
+			Msg: fmt.Sprintf("unknown field %q, expected one of %s", field.text, strings.Join(FilterFields, ", ")),
+		}
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokIdent && opTok.kind != tokOp {
+		return nil, ErrInvalidFilter{Pos: opTok.pos, Msg: "expected operator"}
+	}
+	op, caseInsensitive, ok := parseFilterOp(opTok.text)
+	if !ok {
+		return nil, ErrInvalidFilter{Pos: opTok.pos, Msg: fmt.Sprintf("unknown operator %q", opTok.text)}
+	}
+
+	c := Comparison{Field: field.text, Op: op, CaseInsensitive: caseInsensitive}
+	if op != OpIn {
+		value := p.next()
+		if value.kind != tokString {
+			return nil, ErrInvalidFilter{Pos: value.pos, Msg: "expected quoted value"}
+		}
+		c.Values = []string{value.text}
+		return c, nil
+	}
+
+	if tok := p.next(); tok.kind != tokLParen {
+		return nil, ErrInvalidFilter{Pos: tok.pos, Msg: "expected ( after in"}
+	}
+	for {
+		value := p.next()
+		if value.kind != tokString {
+			return nil, ErrInvalidFilter{Pos: value.pos, Msg: "expected quoted value"}
+		}
+		c.Values = append(c.Values, value.text)
+		tok := p.next()
+		if tok.kind == tokRParen {
+			return c, nil
+		}
+		if tok.kind != tokComma {
+			return nil, ErrInvalidFilter{Pos: tok.pos, Msg: "expected , or )"}
+		}
+	}
+}
+
+func parseFilterOp(s string) (FilterOp, bool, bool) {
+	s = strings.ToLower(s)
+	for _, op := range []FilterOp{OpEq, OpNe, OpIn, OpStartsWith, OpContains} {
+		switch s {
+		case string(op):
+			return op, false, true
+		case "i" + string(op):
+			return op, true, true
+		}
+	}
+	return "", false, false
+}
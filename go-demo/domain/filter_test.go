@@ -0,0 +1,162 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "comparison",
+			filter: `role eq "admin"`,
+			want:   `role eq "admin"`,
+		},
+		{
+			name:   "symbols",
+			filter: `role = "admin" AND name != ""`,
+			want:   `role eq "admin" and name ne ""`,
+		},
+		{
+			name:   "in and startswith",
+			filter: `role in ("admin","ops") and name startswith "Sha"`,
+			want:   `role in ("admin", "ops") and name startswith "Sha"`,
+		},
+		{
+			name:   "precedence",
+			filter: `role eq "a" or role eq "b" and not name contains "x"`,
+			want:   `role eq "a" or (role eq "b" and not name contains "x")`,
+		},
+		{
+			name:   "grouping",
+			filter: `(role eq "a" or role eq "b") and name icontains "x"`,
+			want:   `(role eq "a" or role eq "b") and name icontains "x"`,
+		},
+		{
+			name:   "escaped quote",
+			filter: `name eq "say \"hi\""`,
+			want:   `name eq "say \"hi\""`,
+		},
+		{
+			name:   "attribute",
+			filter: `attributes.department eq "eng"`,
+			want:   `attributes.department eq "eng"`,
+		},
+		{
+			name:    "invalid attribute name",
+			filter:  `attributes.1st eq "a"`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			filter:  `email eq "a"`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown operator",
+			filter:  `name like "a"`,
+			wantErr: true,
+		},
+		{
+			name:    "unquoted value",
+			filter:  `name eq a`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			filter:  `name eq "a`,
+			wantErr: true,
+		},
+		{
+			name:    "unbalanced parentheses",
+			filter:  `(name eq "a"`,
+			wantErr: true,
+		},
+		{
+			name:    "trailing tokens",
+			filter:  `name eq "a" "b"`,
+			wantErr: true,
+		},
+		{
+			name:    "empty in list",
+			filter:  `role in ()`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter(tt.filter)
+			if tt.wantErr {
+				var invalid ErrInvalidFilter
+				if !errors.As(err, &invalid) {
+					t.Errorf("ParseFilter() error = %v, want ErrInvalidFilter", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseFilter() = %v, want %v", got.String(), tt.want)
+			}
+			reparsed, err := ParseFilter(got.String())
+			if err != nil || reparsed.String() != tt.want {
+				t.Errorf("ParseFilter() does not round trip, got %v, %v", reparsed, err)
+			}
+		})
+	}
+}
+
+func TestParseFilter_Depth(t *testing.T) {
+	filter := `name eq "a"`
+	for i := 0; i < maxFilterDepth+1; i++ {
+		filter = "(" + filter + ")"
+	}
+	if _, err := ParseFilter(filter); err == nil {
+		t.Errorf("ParseFilter() expected nesting error")
+	}
+}
+
+func TestFilter_Matches(t *testing.T) {
+	user := User{Name: "Shashank Pachava", Role: "admin", Attributes: map[string]any{"department": "eng", "level": int64(3)}}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: `role eq "admin"`, want: true},
+		{filter: `role eq "Admin"`, want: false},
+		{filter: `role ieq "Admin"`, want: true},
+		{filter: `role ne "admin"`, want: false},
+		{filter: `role in ("ops", "admin")`, want: true},
+		{filter: `role iin ("OPS", "USER")`, want: false},
+		{filter: `name startswith "Sha"`, want: true},
+		{filter: `name istartswith "sha"`, want: true},
+		{filter: `name contains "Pach"`, want: true},
+		{filter: `name icontains "PACH"`, want: true},
+		{filter: `createdBy eq ""`, want: true},
+		{filter: `not role eq "admin"`, want: false},
+		{filter: `role eq "user" or name contains "Sha"`, want: true},
+		{filter: `role eq "user" and name contains "Sha"`, want: false},
+		{filter: `not (role eq "user" or name eq "Sasi")`, want: true},
+		{filter: `attributes.department eq "eng"`, want: true},
+		{filter: `attributes.level in ("3", "4")`, want: true},
+		{filter: `attributes.location eq ""`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			if got := f.Matches(user); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Group is a team of users of one tenant
+type Group struct {
+	Id          uuid.UUID
+	TenantId    string
+	Name        string
+	Description string
+	CreatedAt   time.Time
+	CreatedBy   string
+	UpdatedAt   time.Time
+	UpdatedBy   string
+}
+
+// Membership records that a user was added to a group
+type Membership struct {
+	GroupId uuid.UUID
+	UserId  uuid.UUID `sensitive:"true"`
+	AddedAt time.Time
+	AddedBy string `sensitive:"true"`
+}
+
+type UpdateGroup struct {
+	Name        *string
+	Description *string
+}
+
+// Default and maximum number of members listed at once
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// Page selects Limit items of an ordered list, starting at Offset
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+// normalize fills in the default limit and caps it to MaxPageLimit
+func (p Page) normalize() Page {
+	if p.Offset < 0 {
+		p.Offset = 0
+	}
+	if p.Limit <= 0 {
+		p.Limit = DefaultPageLimit
+	}
+	if p.Limit > MaxPageLimit {
+		p.Limit = MaxPageLimit
+	}
+	return p
+}
+
+// MemberPage is a page of the members of a group, in the order they joined.
+// Total counts every member of the group
+type MemberPage struct {
+	Members []User
+	Page    Page
+	Total   int
+}
+
+var (
+	ErrBadGroupId        = errors.New("invalid group id")
+	ErrGroupNameRequired = errors.New("group name is required")
+)
+
+type ErrGroupIdNotFound struct {
+	Id uuid.UUID
+}
+
+func (e ErrGroupIdNotFound) Error() string {
+	return fmt.Sprintf("could not find group with id %s", e.Id.String())
+}
+
+type ErrMembershipNotFound struct {
+	GroupId uuid.UUID
+	UserId  uuid.UUID `sensitive:"true"`
+}
+
+func (e ErrMembershipNotFound) Error() string {
+	return fmt.Sprintf("user %s is not a member of group %s", Mask(e.UserId), e.GroupId.String())
+}
+
+type GroupService interface {
+	CreateGroup(ctx context.Context, group Group) (Group, error)
+	GetGroupById(ctx context.Context, id uuid.UUID) (Group, error)
+	ListGroups(ctx context.Context) ([]Group, error)
+	UpdateGroup(ctx context.Context, id uuid.UUID, updateGroup UpdateGroup) (Group, error)
+	// DeleteGroup deletes a group along with its memberships
+	DeleteGroup(ctx context.Context, id uuid.UUID) error
+	// AddMember adds an active user to a group, adding a member twice is a no-op
+	AddMember(ctx context.Context, groupId, userId uuid.UUID) error
+	RemoveMember(ctx context.Context, groupId, userId uuid.UUID) error
+	ListMembers(ctx context.Context, groupId uuid.UUID, page Page) (MemberPage, error)
+	// GroupsOfUser returns the groups a user is a member of, ordered by name
+	GroupsOfUser(ctx context.Context, userId uuid.UUID) ([]Group, error)
+}
+
+// GroupServiceImpl is an implementation of GroupService
+type GroupServiceImpl struct {
+	groups GroupRepo
+	users  UserRepo
+	clock  Clock
+	ids    IDGenerator
+	// mu serializes the read-modify-write cycles of changes to existing groups
+	mu *sync.Mutex
+}
+
+// NewGroupServiceImpl returns a new instance of GroupServiceImpl. users is
+// used to check that members exist
+func NewGroupServiceImpl(groups GroupRepo, users UserRepo, clock Clock, ids IDGenerator) (GroupServiceImpl, error) {
+	if groups == nil {
+		return GroupServiceImpl{}, fmt.Errorf("cannot create group service, missing group repo")
+	}
+	if users == nil {
+		return GroupServiceImpl{}, fmt.Errorf("cannot create group service, missing user repo")
+	}
+	if clock == nil {
+		return GroupServiceImpl{}, fmt.Errorf("cannot create group service, missing clock")
+	}
+	if ids == nil {
+		return GroupServiceImpl{}, fmt.Errorf("cannot create group service, missing id generator")
+	}
+	return GroupServiceImpl{groups: groups, users: users, clock: clock, ids: ids, mu: new(sync.Mutex)}, nil
+}
+
+// touch records that the principal in ctx modified group just now
+func (g *GroupServiceImpl) touch(ctx context.Context, group *Group) {
+	principal, _ := PrincipalFromContext(ctx)
+	group.UpdatedAt = g.clock.Now()
+	group.UpdatedBy = principal
+}
+
+// CreateGroup creates group in the tenant of ctx
+func (g *GroupServiceImpl) CreateGroup(ctx context.Context, group Group) (Group, error) {
+	log.Println("creating group")
+
+	tenant, err := RequireTenant(ctx)
+	if err != nil {
+		return Group{}, err
+	}
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return Group{}, ErrGroupNameRequired
+	}
+
+	if group.Id == uuid.Nil {
+		group.Id = g.ids.NewID()
+	}
+	group.TenantId = tenant
+
+	g.touch(ctx, &group)
+	group.CreatedAt = group.UpdatedAt
+	group.CreatedBy = group.UpdatedBy
+
+	if err := g.groups.SaveGroup(ctx, group); err != nil {
+		return Group{}, fmt.Errorf("could not create group: %w", err)
+	}
+	return group, nil
+}
+
+func (g *GroupServiceImpl) GetGroupById(ctx context.Context, id uuid.UUID) (Group, error) {
+	log.Println("fetching group by id")
+
+	if id == uuid.Nil {
+		return Group{}, ErrBadGroupId
+	}
+	group, err := g.groups.GetGroupById(ctx, id)
+	if err != nil {
+		return Group{}, fmt.Errorf("could not fetch group by id: %w", err)
+	}
+	return group, nil
+}
+
+// ListGroups returns the groups of the tenant of ctx ordered by name
+func (g *GroupServiceImpl) ListGroups(ctx context.Context) ([]Group, error) {
+	log.Println("listing groups")
+
+	groups, err := g.groups.ListGroups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list groups: %w", err)
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+func (g *GroupServiceImpl) UpdateGroup(ctx context.Context, id uuid.UUID, updateGroup UpdateGroup) (Group, error) {
+	log.Println("updating group")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if id == uuid.Nil {
+		return Group{}, ErrBadGroupId
+	}
+	group, err := g.groups.GetGroupById(ctx, id)
+	if err != nil {
+		return Group{}, fmt.Errorf("could not fetch group by id: %w", err)
+	}
+
+	if updateGroup.Name != nil {
+		group.Name = strings.TrimSpace(*updateGroup.Name)
+		if group.Name == "" {
+			return Group{}, ErrGroupNameRequired
+		}
+	}
+	if updateGroup.Description != nil {
+		group.Description = *updateGroup.Description
+	}
+
+	g.touch(ctx, &group)
+	if err := g.groups.SaveGroup(ctx, group); err != nil {
+		return Group{}, fmt.Errorf("could not update group: %w", err)
+	}
+	return group, nil
+}
+
+func (g *GroupServiceImpl) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	log.Println("deleting group by id")
+
+	if id == uuid.Nil {
+		return ErrBadGroupId
+	}
+	if err := g.groups.DeleteGroup(ctx, id); err != nil {
+		return fmt.Errorf("could not delete group by id: %w", err)
+	}
+	return nil
+}
+
+// AddMember checks that the user exists and is not deleted. A user deleted
+// while being added can still end up a member, ListMembers skips such users
+func (g *GroupServiceImpl) AddMember(ctx context.Context, groupId, userId uuid.UUID) error {
+	log.Println("adding group member")
+
+	if groupId == uuid.Nil {
+		return ErrBadGroupId
+	}
+	if userId == uuid.Nil {
+		return ErrBadUserId
+	}
+	if _, err := g.activeUser(ctx, userId); err != nil {
+		return fmt.Errorf("could not add member: %w", err)
+	}
+
+	principal, _ := PrincipalFromContext(ctx)
+	membership := Membership{GroupId: groupId, UserId: userId, AddedAt: g.clock.Now(), AddedBy: principal}
+	if err := g.groups.AddMembership(ctx, membership); err != nil {
+		return fmt.Errorf("could not add member: %w", err)
+	}
+	return nil
+}
+
+func (g *GroupServiceImpl) RemoveMember(ctx context.Context, groupId, userId uuid.UUID) error {
+	log.Println("removing group member")
+
+	if groupId == uuid.Nil {
+		return ErrBadGroupId
+	}
+	if userId == uuid.Nil {
+		return ErrBadUserId
+	}
+	if err := g.groups.RemoveMembership(ctx, groupId, userId); err != nil {
+		return fmt.Errorf("could not remove member: %w", err)
+	}
+	return nil
+}
+
+func (g *GroupServiceImpl) ListMembers(ctx context.Context, groupId uuid.UUID, page Page) (MemberPage, error) {
+	log.Println("listing group members")
+
+	if groupId == uuid.Nil {
+		return MemberPage{}, ErrBadGroupId
+	}
+	page = page.normalize()
+	memberships, total, err := g.groups.ListMemberships(ctx, groupId, page)
+	if err != nil {
+		return MemberPage{}, fmt.Errorf("could not list members: %w", err)
+	}
+
+	members := make([]User, 0, len(memberships))
+	for _, membership := range memberships {
+		user, err := g.activeUser(ctx, membership.UserId)
+		var notFound ErrUserIdNotFound
+		if errors.As(err, &notFound) {
+			continue
+		}
+		if err != nil {
+			return MemberPage{}, fmt.Errorf("could not fetch member: %w", err)
+		}
+		members = append(members, user)
+	}
+	return MemberPage{Members: members, Page: page, Total: total}, nil
+}
+
+func (g *GroupServiceImpl) GroupsOfUser(ctx context.Context, userId uuid.UUID) ([]Group, error) {
+	log.Println("listing groups of user")
+
+	if userId == uuid.Nil {
+		return nil, ErrBadUserId
+	}
+	if _, err := g.activeUser(ctx, userId); err != nil {
+		return nil, fmt.Errorf("could not fetch user by id: %w", err)
+	}
+	groups, err := g.groups.ListGroupsOfUser(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("could not list groups of user: %w", err)
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+// activeUser fetches a user, treating soft deleted users as not found
+func (g *GroupServiceImpl) activeUser(ctx context.Context, id uuid.UUID) (User, error) {
+	user, err := g.users.GetUserById(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if user.Deleted() {
+		return User{}, ErrUserIdNotFound{Id: id}
+	}
+	return user, nil
+}
+
+// sortGroups orders groups by name, then id
+func sortGroups(groups []Group) {
+	sort.Slice(groups, func(a, b int) bool {
+		if groups[a].Name != groups[b].Name {
+			return groups[a].Name < groups[b].Name
+		}
+		return bytes.Compare(groups[a].Id[:], groups[b].Id[:]) < 0
+	})
+}
+
+// GroupRepo stores groups and their memberships. Like UserRepo, every method
+// is scoped to the tenant of ctx
+type GroupRepo interface {
+	SaveGroup(ctx context.Context, group Group) error
+	GetGroupById(ctx context.Context, id uuid.UUID) (Group, error)
+	ListGroups(ctx context.Context) ([]Group, error)
+	// DeleteGroup deletes a group and its memberships
+	DeleteGroup(ctx context.Context, id uuid.UUID) error
+	// AddMembership fails with ErrGroupIdNotFound if the group does not exist
+	// and keeps the existing membership if the user already is a member
+	AddMembership(ctx context.Context, membership Membership) error
+	RemoveMembership(ctx context.Context, groupId, userId uuid.UUID) error
+	// ListMemberships returns a page of the memberships of a group, ordered by
+	// when they were added, and how many memberships the group has
+	ListMemberships(ctx context.Context, groupId uuid.UUID, page Page) ([]Membership, int, error)
+	ListGroupsOfUser(ctx context.Context, userId uuid.UUID) ([]Group, error)
+	// RemoveUserMemberships removes a user from every group
+	RemoveUserMemberships(ctx context.Context, userId uuid.UUID) error
+}
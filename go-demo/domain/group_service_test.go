@@ -0,0 +1,143 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	mockDomain "api-demo/mock/domain"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func setupGroupService(t *testing.T) (domain.GroupServiceImpl, *mockDomain.MockGroupRepo, *mockDomain.MockUserRepo) {
+	ctrl := gomock.NewController(t)
+
+	groupRepo := mockDomain.NewMockGroupRepo(ctrl)
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	groupService, err := domain.NewGroupServiceImpl(groupRepo, userRepo,
+		domaintest.NewFakeClock(testNow), domaintest.NewSequentialIDGenerator())
+	assert.NoError(t, err, "expected no error")
+
+	return groupService, groupRepo, userRepo
+}
+
+func Test_NewGroupServiceImpl(t *testing.T) {
+	_, err := domain.NewGroupServiceImpl(nil, nil, nil, nil)
+	assert.Equal(t, fmt.Errorf("cannot create group service, missing group repo"), err)
+}
+
+func TestGroupServiceImpl_CreateGroup(t *testing.T) {
+	groupService, groupRepo, _ := setupGroupService(t)
+
+	ctx := domain.WithPrincipal(testCtx, "admin@acme.com")
+	expected := domain.Group{
+		Id:        domaintest.SequentialID(1),
+		TenantId:  testTenant,
+		Name:      "Platform",
+		CreatedAt: testNow,
+		CreatedBy: "admin@acme.com",
+		UpdatedAt: testNow,
+		UpdatedBy: "admin@acme.com",
+	}
+	groupRepo.EXPECT().SaveGroup(ctx, expected).Return(nil)
+
+	group, err := groupService.CreateGroup(ctx, domain.Group{Name: " Platform "})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, expected, group)
+
+	_, err = groupService.CreateGroup(ctx, domain.Group{Name: " "})
+	assert.Equal(t, domain.ErrGroupNameRequired, err)
+}
+
+func TestGroupServiceImpl_UpdateGroup(t *testing.T) {
+	groupService, groupRepo, _ := setupGroupService(t)
+
+	group := domain.Group{Id: uuid.New(), TenantId: testTenant, Name: "Platform", Description: "infra"}
+	name := "Infrastructure"
+
+	groupRepo.EXPECT().GetGroupById(testCtx, group.Id).Return(group, nil)
+	groupRepo.EXPECT().SaveGroup(testCtx, domain.Group{
+		Id:          group.Id,
+		TenantId:    testTenant,
+		Name:        name,
+		Description: "infra",
+		UpdatedAt:   testNow,
+	}).Return(nil)
+
+	updated, err := groupService.UpdateGroup(testCtx, group.Id, domain.UpdateGroup{Name: &name})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, name, updated.Name)
+}
+
+func TestGroupServiceImpl_AddMember(t *testing.T) {
+	groupService, groupRepo, userRepo := setupGroupService(t)
+
+	groupId := uuid.New()
+	user := newUser("Sasi", "user")
+	deleted := newUser("Sridhar", "user")
+	deleted.DeletedAt = testNow
+
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+	groupRepo.EXPECT().
+		AddMembership(testCtx, domain.Membership{GroupId: groupId, UserId: user.Id, AddedAt: testNow}).
+		Return(nil)
+
+	assert.NoError(t, groupService.AddMember(testCtx, groupId, user.Id))
+
+	userRepo.EXPECT().GetUserById(testCtx, deleted.Id).Return(deleted, nil)
+
+	err := groupService.AddMember(testCtx, groupId, deleted.Id)
+	assert.ErrorIs(t, err, domain.ErrUserIdNotFound{Id: deleted.Id})
+
+	assert.Equal(t, domain.ErrBadUserId, groupService.AddMember(testCtx, groupId, uuid.Nil))
+}
+
+func TestGroupServiceImpl_ListMembers(t *testing.T) {
+	groupService, groupRepo, userRepo := setupGroupService(t)
+
+	groupId := uuid.New()
+	user1 := newUser("Sasi", "user")
+	user2 := newUser("Sridhar", "user")
+	gone := uuid.New()
+
+	// the limit defaults, and members deleted since they were added are skipped
+	groupRepo.EXPECT().
+		ListMemberships(testCtx, groupId, domain.Page{Offset: 2, Limit: domain.DefaultPageLimit}).
+		Return([]domain.Membership{{UserId: user1.Id}, {UserId: gone}, {UserId: user2.Id}}, 5, nil)
+	userRepo.EXPECT().GetUserById(testCtx, user1.Id).Return(user1, nil)
+	userRepo.EXPECT().GetUserById(testCtx, gone).Return(domain.User{}, domain.ErrUserIdNotFound{Id: gone})
+	userRepo.EXPECT().GetUserById(testCtx, user2.Id).Return(user2, nil)
+
+	page, err := groupService.ListMembers(testCtx, groupId, domain.Page{Offset: 2})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, domain.MemberPage{
+		Members: []domain.User{user1, user2},
+		Page:    domain.Page{Offset: 2, Limit: domain.DefaultPageLimit},
+		Total:   5,
+	}, page)
+
+	groupRepo.EXPECT().
+		ListMemberships(testCtx, groupId, domain.Page{Limit: domain.MaxPageLimit}).
+		Return(nil, 0, nil)
+
+	_, err = groupService.ListMembers(testCtx, groupId, domain.Page{Limit: 10_000})
+	assert.NoError(t, err, "expected no error")
+}
+
+func TestGroupServiceImpl_GroupsOfUser(t *testing.T) {
+	groupService, groupRepo, userRepo := setupGroupService(t)
+
+	user := newUser("Sasi", "user")
+	platform := domain.Group{Id: uuid.New(), Name: "Platform"}
+	design := domain.Group{Id: uuid.New(), Name: "Design"}
+
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+	groupRepo.EXPECT().ListGroupsOfUser(testCtx, user.Id).Return([]domain.Group{platform, design}, nil)
+
+	groups, err := groupService.GroupsOfUser(testCtx, user.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []domain.Group{design, platform}, groups)
+}
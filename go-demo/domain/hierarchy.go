@@ -0,0 +1,246 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"sort"
+)
+
+// ManagerDeletePolicy decides what happens to the direct reports of a user
+// when the user is deleted
+type ManagerDeletePolicy string
+
+const (
+	// ReassignReports moves the reports to the manager of the deleted user,
+	// or leaves them without a manager if there is none
+	ReassignReports ManagerDeletePolicy = "reassign"
+	// BlockDelete refuses to delete users that have reports
+	BlockDelete ManagerDeletePolicy = "block"
+	// OrphanReports leaves the reports without a manager
+	OrphanReports ManagerDeletePolicy = "orphan"
+)
+
+// ParseManagerDeletePolicy returns the policy named s
+func ParseManagerDeletePolicy(s string) (ManagerDeletePolicy, error) {
+	switch policy := ManagerDeletePolicy(s); policy {
+	case ReassignReports, BlockDelete, OrphanReports:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown manager delete policy %q, expected reassign, block or orphan", s)
+	}
+}
+
+// MaxReportDepth bounds how many levels of reports Subtree walks down
+const MaxReportDepth = 20
+
+var ErrManagerCycle = errors.New("a user cannot be managed by themselves or one of their reports")
+
+type ErrManagerNotFound struct {
+	Id uuid.UUID `sensitive:"true"`
+}
+
+func (e ErrManagerNotFound) Error() string {
+	return fmt.Sprintf("could not find manager with id %s", Mask(e.Id))
+}
+
+// ErrHasReports is returned when deleting a manager is blocked by BlockDelete
+type ErrHasReports struct {
+	Id      uuid.UUID `sensitive:"true"`
+	Reports int
+}
+
+func (e ErrHasReports) Error() string {
+	return fmt.Sprintf("user %s still has %d direct reports", Mask(e.Id), e.Reports)
+}
+
+// Report is a user in the subtree of a manager, Depth is 1 for direct reports
+type Report struct {
+	User  User
+	Depth int
+}
+
+// WithManagerDeletePolicy sets what happens to the reports of deleted users,
+// defaults to ReassignReports
+func WithManagerDeletePolicy(policy ManagerDeletePolicy) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.managerDeletePolicy = policy
+	}
+}
+
+// sortUsers orders users by name, then id
+func sortUsers(users []User) {
+	sort.Slice(users, func(a, b int) bool {
+		if users[a].Name != users[b].Name {
+			return users[a].Name < users[b].Name
+		}
+		return bytes.Compare(users[a].Id[:], users[b].Id[:]) < 0
+	})
+}
+
+// reportsOf returns the active direct reports of a user ordered by name
+func (u *UserServiceImpl) reportsOf(ctx context.Context, id uuid.UUID) ([]User, error) {
+	reports, err := u.repo.QueryUsers(ctx, UserProperties{ManagerId: &id})
+	if err != nil {
+		return nil, fmt.Errorf("could not query reports: %w", err)
+	}
+	sortUsers(reports)
+	return reports, nil
+}
+
+// validateManager checks that managerId can manage user: the manager exists,
+// is active and is neither user nor one of their reports. u.mu must be held,
+// so that no change to the hierarchy can slip in between
+func (u *UserServiceImpl) validateManager(ctx context.Context, user User, managerId uuid.UUID) error {
+	if managerId == uuid.Nil {
+		return nil
+	}
+	if managerId == user.Id {
+		return ErrManagerCycle
+	}
+	manager, err := u.getActiveUser(ctx, managerId)
+	var notFound ErrUserIdNotFound
+	if errors.As(err, &notFound) {
+		return ErrManagerNotFound{Id: managerId}
+	}
+	if err != nil {
+		return err
+	}
+
+	visited := map[uuid.UUID]bool{manager.Id: true}
+	for next := manager.ManagerId; next != uuid.Nil; {
+		if next == user.Id {
+			return ErrManagerCycle
+		}
+		if visited[next] {
+			// the stored hierarchy already has a cycle, do not loop on it
+			return ErrManagerCycle
+		}
+		visited[next] = true
+		above, err := u.getUser(ctx, next)
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		next = above.ManagerId
+	}
+	return nil
+}
+
+// handleReports applies the manager delete policy to the direct reports of
+// user, which is being deleted. u.mu must be held
+func (u *UserServiceImpl) handleReports(ctx context.Context, user User) error {
+	reports, err := u.reportsOf(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	if len(reports) == 0 {
+		return nil
+	}
+
+	newManager := uuid.Nil
+	switch u.managerDeletePolicy {
+	case BlockDelete:
+		return ErrHasReports{Id: user.Id, Reports: len(reports)}
+	case ReassignReports:
+		newManager = user.ManagerId
+	}
+
+	for _, report := range reports {
+		report.ManagerId = newManager
+		u.touch(ctx, &report)
+		if err := u.save(ctx, report); err != nil {
+			return fmt.Errorf("could not update report %s: %w", Mask(report.Id), err)
+		}
+	}
+	return nil
+}
+
+// DirectReports returns the active users managed by a user, ordered by name
+func (u *UserServiceImpl) DirectReports(ctx context.Context, id uuid.UUID) ([]User, error) {
+	log.Println("fetching direct reports")
+
+	if id == uuid.Nil {
+		return nil, ErrBadUserId
+	}
+	if _, err := u.getActiveUser(ctx, id); err != nil {
+		return nil, fmt.Errorf("could not fetch user by id: %w", err)
+	}
+	return u.reportsOf(ctx, id)
+}
+
+// Subtree returns the users managed by a user directly or indirectly, down
+// to maxDepth levels, breadth first. A maxDepth of 0, or above
+// MaxReportDepth, is MaxReportDepth
+func (u *UserServiceImpl) Subtree(ctx context.Context, id uuid.UUID, maxDepth int) ([]Report, error) {
+	log.Println("fetching report subtree")
+
+	if id == uuid.Nil {
+		return nil, ErrBadUserId
+	}
+	if maxDepth <= 0 || maxDepth > MaxReportDepth {
+		maxDepth = MaxReportDepth
+	}
+	if _, err := u.getActiveUser(ctx, id); err != nil {
+		return nil, fmt.Errorf("could not fetch user by id: %w", err)
+	}
+
+	var subtree []Report
+	visited := map[uuid.UUID]bool{id: true}
+	level := []uuid.UUID{id}
+	for depth := 1; depth <= maxDepth && len(level) > 0; depth++ {
+		var next []uuid.UUID
+		for _, managerId := range level {
+			reports, err := u.reportsOf(ctx, managerId)
+			if err != nil {
+				return nil, err
+			}
+			for _, report := range reports {
+				if visited[report.Id] {
+					continue
+				}
+				visited[report.Id] = true
+				subtree = append(subtree, Report{User: report, Depth: depth})
+				next = append(next, report.Id)
+			}
+		}
+		level = next
+	}
+	return subtree, nil
+}
+
+// ManagementChain returns the managers of a user, from their direct manager
+// up to the root of the hierarchy
+func (u *UserServiceImpl) ManagementChain(ctx context.Context, id uuid.UUID) ([]User, error) {
+	log.Println("fetching management chain")
+
+	if id == uuid.Nil {
+		return nil, ErrBadUserId
+	}
+	user, err := u.getActiveUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch user by id: %w", err)
+	}
+
+	chain := make([]User, 0)
+	visited := map[uuid.UUID]bool{id: true}
+	for next := user.ManagerId; next != uuid.Nil && !visited[next]; {
+		visited[next] = true
+		manager, err := u.getActiveUser(ctx, next)
+		var notFound ErrUserIdNotFound
+		if errors.As(err, &notFound) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch manager: %w", err)
+		}
+		chain = append(chain, manager)
+		next = manager.ManagerId
+	}
+	return chain, nil
+}
@@ -0,0 +1,206 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	mockDomain "api-demo/mock/domain"
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// setupOrg returns a user service over a mock repo that serves reads from
+// users and records saves in the returned map
+func setupOrg(t *testing.T, policy domain.ManagerDeletePolicy, users ...domain.User) (domain.UserServiceImpl, map[uuid.UUID]domain.User) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	stored := make(map[uuid.UUID]domain.User)
+	for _, user := range users {
+		stored[user.Id] = user
+	}
+	userRepo.EXPECT().GetUserById(testCtx, gomock.Any()).AnyTimes().
+		DoAndReturn(func(_ context.Context, id uuid.UUID) (domain.User, error) {
+			user, ok := stored[id]
+			if !ok {
+				return domain.User{}, domain.ErrUserIdNotFound{Id: id}
+			}
+			return user, nil
+		})
+	userRepo.EXPECT().QueryUsers(testCtx, gomock.Any()).AnyTimes().
+		DoAndReturn(func(_ context.Context, properties domain.UserProperties) ([]domain.User, error) {
+			var resp []domain.User
+			for _, user := range stored {
+				if properties.Matches(user) {
+					resp = append(resp, user)
+				}
+			}
+			return resp, nil
+		})
+	userRepo.EXPECT().SaveUser(testCtx, gomock.Any()).AnyTimes().
+		DoAndReturn(func(_ context.Context, user domain.User) error {
+			stored[user.Id] = user
+			return nil
+		})
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithManagerDeletePolicy(policy),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	return userService, stored
+}
+
+// reportingTo returns a new user of testTenant managed by manager
+func reportingTo(name string, manager domain.User) domain.User {
+	user := newUser(name, "user")
+	user.ManagerId = manager.Id
+	return user
+}
+
+func Test_ParseManagerDeletePolicy(t *testing.T) {
+	policy, err := domain.ParseManagerDeletePolicy("block")
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, domain.BlockDelete, policy)
+
+	_, err = domain.ParseManagerDeletePolicy("fire")
+	assert.Error(t, err, "expected unknown policy to fail")
+
+	_, err = domain.NewUserServiceImpl(mockDomain.NewMockUserRepo(gomock.NewController(t)),
+		domain.WithManagerDeletePolicy("fire"))
+	assert.Error(t, err, "expected service with unknown policy to fail")
+}
+
+func TestUserServiceImpl_CreateUser_Manager(t *testing.T) {
+	ceo := newUser("Ada", "admin")
+	userService, _ := setupOrg(t, domain.ReassignReports, ceo)
+
+	created, err := userService.CreateUser(testCtx, reportingTo("Grace", ceo))
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, ceo.Id, created.ManagerId)
+
+	missing := uuid.New()
+	user := newUser("Linus", "user")
+	user.ManagerId = missing
+	_, err = userService.CreateUser(testCtx, user)
+	assert.ErrorIs(t, err, domain.ErrManagerNotFound{Id: missing})
+
+	user.ManagerId = user.Id
+	_, err = userService.CreateUser(testCtx, user)
+	assert.ErrorIs(t, err, domain.ErrManagerCycle)
+}
+
+func TestUserServiceImpl_UpdateUser_ManagerCycle(t *testing.T) {
+	ceo := newUser("Ada", "admin")
+	vp := reportingTo("Grace", ceo)
+	engineer := reportingTo("Linus", vp)
+	userService, stored := setupOrg(t, domain.ReassignReports, ceo, vp, engineer)
+
+	_, err := userService.UpdateUser(testCtx, ceo.Id, domain.UpdateUser{ManagerId: &engineer.Id})
+	assert.ErrorIs(t, err, domain.ErrManagerCycle)
+	assert.Equal(t, uuid.Nil, stored[ceo.Id].ManagerId, "expected manager to be unchanged")
+
+	_, err = userService.PatchUser(testCtx, vp.Id, func(user domain.User) (domain.User, error) {
+		user.ManagerId = engineer.Id
+		return user, nil
+	})
+	assert.ErrorIs(t, err, domain.ErrManagerCycle)
+
+	updated, err := userService.UpdateUser(testCtx, engineer.Id, domain.UpdateUser{ManagerId: &ceo.Id})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, ceo.Id, updated.ManagerId)
+
+	deleted := reportingTo("Ken", ceo)
+	deleted.DeletedAt = testNow
+	stored[deleted.Id] = deleted
+	_, err = userService.UpdateUser(testCtx, engineer.Id, domain.UpdateUser{ManagerId: &deleted.Id})
+	assert.ErrorIs(t, err, domain.ErrManagerNotFound{Id: deleted.Id}, "expected deleted manager to be rejected")
+}
+
+func TestUserServiceImpl_Delete_ManagerPolicies(t *testing.T) {
+	t.Run("Reassign", func(t *testing.T) {
+		ceo := newUser("Ada", "admin")
+		vp := reportingTo("Grace", ceo)
+		engineer := reportingTo("Linus", vp)
+		userService, stored := setupOrg(t, domain.ReassignReports, ceo, vp, engineer)
+
+		assert.NoError(t, userService.Delete(testCtx, vp.Id))
+		assert.Equal(t, ceo.Id, stored[engineer.Id].ManagerId, "expected report to move to the skip-level manager")
+		assert.Equal(t, testNow, stored[engineer.Id].UpdatedAt)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		ceo := newUser("Ada", "admin")
+		engineer := reportingTo("Linus", ceo)
+		userService, stored := setupOrg(t, domain.BlockDelete, ceo, engineer)
+
+		err := userService.Delete(testCtx, ceo.Id)
+		assert.ErrorIs(t, err, domain.ErrHasReports{Id: ceo.Id, Reports: 1})
+		assert.False(t, stored[ceo.Id].Deleted(), "expected manager to be kept")
+
+		assert.NoError(t, userService.Delete(testCtx, engineer.Id), "expected users without reports to be deleted")
+	})
+
+	t.Run("Orphan", func(t *testing.T) {
+		ceo := newUser("Ada", "admin")
+		vp := reportingTo("Grace", ceo)
+		engineer := reportingTo("Linus", vp)
+		userService, stored := setupOrg(t, domain.OrphanReports, ceo, vp, engineer)
+
+		assert.NoError(t, userService.Delete(testCtx, vp.Id))
+		assert.Equal(t, uuid.Nil, stored[engineer.Id].ManagerId, "expected report to lose its manager")
+	})
+}
+
+func TestUserServiceImpl_Restore_MissingManager(t *testing.T) {
+	ceo := newUser("Ada", "admin")
+	ceo.DeletedAt = testNow
+	engineer := reportingTo("Linus", ceo)
+	engineer.DeletedAt = testNow
+	userService, _ := setupOrg(t, domain.ReassignReports, ceo, engineer)
+
+	restored, err := userService.Restore(testCtx, engineer.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, uuid.Nil, restored.ManagerId, "expected deleted manager to be cleared")
+}
+
+func TestUserServiceImpl_OrgChart(t *testing.T) {
+	ceo := newUser("Ada", "admin")
+	cto := reportingTo("Grace", ceo)
+	cfo := reportingTo("Barbara", ceo)
+	engineer := reportingTo("Linus", cto)
+	intern := reportingTo("Ken", engineer)
+	userService, _ := setupOrg(t, domain.ReassignReports, ceo, cto, cfo, engineer, intern)
+
+	reports, err := userService.DirectReports(testCtx, ceo.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []domain.User{cfo, cto}, reports, "expected reports ordered by name")
+
+	subtree, err := userService.Subtree(testCtx, ceo.Id, 0)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []domain.Report{
+		{User: cfo, Depth: 1},
+		{User: cto, Depth: 1},
+		{User: engineer, Depth: 2},
+		{User: intern, Depth: 3},
+	}, subtree)
+
+	subtree, err = userService.Subtree(testCtx, ceo.Id, 2)
+	assert.NoError(t, err, "expected no error")
+	assert.Len(t, subtree, 3, "expected subtree to stop at depth 2")
+
+	chain, err := userService.ManagementChain(testCtx, intern.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []domain.User{engineer, cto, ceo}, chain)
+
+	chain, err = userService.ManagementChain(testCtx, ceo.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Empty(t, chain, "expected no managers above the root")
+
+	_, err = userService.DirectReports(testCtx, uuid.New())
+	assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{})
+}
@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"time"
+)
+
+var ErrHistoryUnavailable = errors.New("user history is not configured")
+
+// ErrVersionNotFound is returned when a user has no version with a number
+type ErrVersionNotFound struct {
+	Id      uuid.UUID `sensitive:"true"`
+	Version int
+}
+
+func (e ErrVersionNotFound) Error() string {
+	return fmt.Sprintf("user %s has no version %d", Mask(e.Id), e.Version)
+}
+
+// UserVersion is a state a user was saved in. Versions of a user are
+// numbered from 1, the User.UpdatedAt of a version is when it was saved
+type UserVersion struct {
+	Version int
+	User    User
+}
+
+// UserHistory keeps every state of every user. UserServiceImpl records a
+// version each time it saves a user, versions outlive purged users.
+// Implementations scope every method to the tenant of ctx
+type UserHistory interface {
+	// Record appends user as its next version
+	Record(ctx context.Context, user User) error
+	// Versions returns the versions of the user with id, oldest first, and
+	// fails with ErrUserIdNotFound if there are none
+	Versions(ctx context.Context, id uuid.UUID) ([]UserVersion, error)
+	// Erase drops every version of the user with id
+	Erase(ctx context.Context, id uuid.UUID) error
+}
+
+// WithHistory enables History, GetUserAsOf and Revert, the service records
+// every state it saves users in
+func WithHistory(history UserHistory) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.history = history
+	}
+}
+
+// versions returns the versions of a user of the tenant of ctx
+func (u *UserServiceImpl) versions(ctx context.Context, id uuid.UUID) ([]UserVersion, error) {
+	if u.history == nil {
+		return nil, ErrHistoryUnavailable
+	}
+	if id == uuid.Nil {
+		return nil, ErrBadUserId
+	}
+	if _, err := RequireTenant(ctx); err != nil {
+		return nil, err
+	}
+	return u.history.Versions(ctx, id)
+}
+
+// History returns every state a user was saved in, oldest first, including
+// its deletion and restoration
+func (u *UserServiceImpl) History(ctx context.Context, id uuid.UUID) ([]UserVersion, error) {
+	log.Println("fetching user history")
+
+	versions, err := u.versions(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch user history: %w", err)
+	}
+	return versions, nil
+}
+
+// GetUserAsOf returns the user as it was at asOf, failing with
+// ErrUserIdNotFound if it did not exist yet or was deleted then
+func (u *UserServiceImpl) GetUserAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (User, error) {
+	log.Println("fetching user as of a time")
+
+	versions, err := u.versions(ctx, id)
+	if err != nil {
+		return User{}, fmt.Errorf("could not fetch user history: %w", err)
+	}
+	for n := len(versions) - 1; n >= 0; n-- {
+		user := versions[n].User
+		if user.UpdatedAt.After(asOf) {
+			continue
+		}
+		if user.Deleted() {
+			break
+		}
+		return user, nil
+	}
+	return User{}, fmt.Errorf("could not fetch user as of %s: %w", asOf.Format(time.RFC3339), ErrUserIdNotFound{Id: id})
+}
+
+// Revert brings the name, role, manager and attributes of an active user
+// back to those of one of its versions, recording a new version
+func (u *UserServiceImpl) Revert(ctx context.Context, id uuid.UUID, version int) (User, error) {
+	log.Println("reverting user")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	versions, err := u.versions(ctx, id)
+	if err != nil {
+		return User{}, fmt.Errorf("could not fetch user history: %w", err)
+	}
+	if version < 1 || version > len(versions) {
+		return User{}, ErrVersionNotFound{Id: id, Version: version}
+	}
+	target := versions[version-1].User
+
+	return u.patch(ctx, id, func(user User) (User, error) {
+		user.Name = target.Name
+		user.Role = target.Role
+		user.ManagerId = target.ManagerId
+		user.Attributes = target.Attributes
+		return user, nil
+	})
+}
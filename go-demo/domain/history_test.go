@@ -0,0 +1,129 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	mockDomain "api-demo/mock/domain"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUserServiceImpl_History(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	history := mockDomain.NewMockUserHistory(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithHistory(history),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	user := newUser("Shashank Pachava", "admin")
+	userRepo.EXPECT().SaveUser(testCtx, gomock.Any()).Return(nil)
+	history.EXPECT().
+		Record(testCtx, gomock.Any()).
+		Do(func(_ any, recorded domain.User) {
+			assert.Equal(t, user.Id, recorded.Id)
+			assert.Equal(t, testNow, recorded.UpdatedAt, "expected the saved state to be recorded")
+		})
+	_, err = userService.CreateUser(testCtx, user)
+	assert.NoError(t, err, "expected no error")
+
+	userRepo.EXPECT().SaveUser(testCtx, gomock.Any()).Return(nil)
+	history.EXPECT().Record(testCtx, gomock.Any()).Return(fmt.Errorf("history unavailable"))
+	_, err = userService.CreateUser(testCtx, newUser("Sasi", "user"))
+	assert.NoError(t, err, "expected a saved user not to fail on its history")
+
+	history.EXPECT().Versions(testCtx, user.Id).Return([]domain.UserVersion{{Version: 1, User: user}}, nil)
+	versions, err := userService.History(testCtx, user.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []domain.UserVersion{{Version: 1, User: user}}, versions)
+
+	withoutHistory, err := domain.NewUserServiceImpl(userRepo)
+	assert.NoError(t, err, "expected no error")
+	_, err = withoutHistory.History(testCtx, user.Id)
+	assert.ErrorIs(t, err, domain.ErrHistoryUnavailable)
+}
+
+func TestUserServiceImpl_GetUserAsOf(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	history := mockDomain.NewMockUserHistory(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithHistory(history))
+	assert.NoError(t, err, "expected no error")
+
+	created := newUser("Sasi", "user")
+	created.UpdatedAt = testNow
+	promoted := created
+	promoted.Role = "admin"
+	promoted.UpdatedAt = testNow.Add(time.Hour)
+	deleted := promoted
+	deleted.UpdatedAt = testNow.Add(2 * time.Hour)
+	deleted.DeletedAt = deleted.UpdatedAt
+
+	history.EXPECT().
+		Versions(testCtx, created.Id).
+		Return([]domain.UserVersion{{Version: 1, User: created}, {Version: 2, User: promoted}, {Version: 3, User: deleted}}, nil).
+		AnyTimes()
+
+	user, err := userService.GetUserAsOf(testCtx, created.Id, testNow.Add(30*time.Minute))
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, "user", user.Role)
+
+	user, err = userService.GetUserAsOf(testCtx, created.Id, testNow.Add(time.Hour))
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, "admin", user.Role, "expected a version to be visible from when it was saved")
+
+	for name, asOf := range map[string]time.Time{
+		"before creation": testNow.Add(-time.Minute),
+		"after deletion":  testNow.Add(3 * time.Hour),
+	} {
+		_, err = userService.GetUserAsOf(testCtx, created.Id, asOf)
+		assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{}, name)
+	}
+}
+
+func TestUserServiceImpl_Revert(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	history := mockDomain.NewMockUserHistory(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithHistory(history),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	original := newUser("Sasi", "user")
+	current := original
+	current.Name = "Sasi K"
+	current.Role = "admin"
+
+	history.EXPECT().
+		Versions(testCtx, original.Id).
+		Return([]domain.UserVersion{{Version: 1, User: original}, {Version: 2, User: current}}, nil).
+		Times(2)
+	userRepo.EXPECT().GetUserById(testCtx, original.Id).Return(current, nil)
+
+	reverted := current
+	reverted.Name = "Sasi"
+	reverted.Role = "user"
+	reverted.UpdatedAt = testNow
+	userRepo.EXPECT().SaveUser(testCtx, reverted).Return(nil)
+	history.EXPECT().Record(testCtx, reverted).Return(nil)
+
+	user, err := userService.Revert(testCtx, original.Id, 1)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, reverted, user)
+
+	_, err = userService.Revert(testCtx, original.Id, 3)
+	assert.ErrorIs(t, err, domain.ErrVersionNotFound{Id: original.Id, Version: 3})
+}
@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// IDGenerator creates ids for new entities
+type IDGenerator interface {
+	NewID() uuid.UUID
+}
+
+// Supported id formats for NewIDGenerator
+const (
+	IDFormatUUIDv4 = "uuidv4"
+	IDFormatUUIDv7 = "uuidv7"
+	IDFormatULID   = "ulid"
+)
+
+// NewIDGenerator returns the IDGenerator for format, one of IDFormatUUIDv4,
+// IDFormatUUIDv7 or IDFormatULID
+func NewIDGenerator(format string, clock Clock) (IDGenerator, error) {
+	switch format {
+	case IDFormatUUIDv4:
+		return UUIDv4Generator{}, nil
+	case IDFormatUUIDv7:
+		if clock == nil {
+			return nil, fmt.Errorf("cannot create id generator, missing clock")
+		}
+		return UUIDv7Generator{Clock: clock}, nil
+	case IDFormatULID:
+		if clock == nil {
+			return nil, fmt.Errorf("cannot create id generator, missing clock")
+		}
+		return &ULIDGenerator{Clock: clock}, nil
+	default:
+		return nil, fmt.Errorf("unknown id format %q", format)
+	}
+}
+
+// UUIDv4Generator generates random ids
+type UUIDv4Generator struct{}
+
+func (UUIDv4Generator) NewID() uuid.UUID {
+	return uuid.New()
+}
+
+// UUIDv7Generator generates time ordered ids as described in RFC 9562
+type UUIDv7Generator struct {
+	Clock Clock
+}
+
+func (g UUIDv7Generator) NewID() uuid.UUID {
+	var id uuid.UUID
+	putMillis(id[:6], g.Clock.Now())
+	mustReadRandom(id[6:])
+	id[6] = (id[6] & 0x0f) | 0x70 // version 7
+	id[8] = (id[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return id
+}
+
+// ULIDGenerator generates ids with the binary layout of a ULID: a 48 bit
+// millisecond timestamp followed by 80 bits of randomness, which is
+// incremented for ids created within the same millisecond so that they
+// stay sortable. The ids are still rendered in the usual uuid form
+type ULIDGenerator struct {
+	Clock Clock
+
+	mu     sync.Mutex
+	last   uint64
+	random [10]byte
+}
+
+func (g *ULIDGenerator) NewID() uuid.UUID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.Clock.Now()
+	ms := uint64(now.UnixMilli())
+	if ms == g.last {
+		incrementBytes(g.random[:])
+	} else {
+		g.last = ms
+		mustReadRandom(g.random[:])
+	}
+
+	var id uuid.UUID
+	putMillis(id[:6], now)
+	copy(id[6:], g.random[:])
+	return id
+}
+
+func putMillis(b []byte, t time.Time) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(t.UnixMilli()))
+	copy(b, buf[2:])
+}
+
+func incrementBytes(b []byte) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+func mustReadRandom(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("could not read random bytes: %s", err))
+	}
+}
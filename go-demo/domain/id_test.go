@@ -0,0 +1,54 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_NewIDGenerator(t *testing.T) {
+	clock := domaintest.NewFakeClock(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, format := range []string{domain.IDFormatUUIDv4, domain.IDFormatUUIDv7, domain.IDFormatULID} {
+		ids, err := domain.NewIDGenerator(format, clock)
+		assert.NoError(t, err, "expected no error for %s", format)
+		assert.NotEqual(t, ids.NewID(), ids.NewID(), "expected unique ids for %s", format)
+	}
+
+	_, err := domain.NewIDGenerator("snowflake", clock)
+	assert.Error(t, err, "expected unknown format error")
+
+	_, err = domain.NewIDGenerator(domain.IDFormatUUIDv7, nil)
+	assert.Error(t, err, "expected missing clock error")
+}
+
+func TestUUIDv7Generator(t *testing.T) {
+	clock := domaintest.NewFakeClock(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC))
+	ids := domain.UUIDv7Generator{Clock: clock}
+
+	first := ids.NewID()
+	assert.Equal(t, 7, int(first.Version()), "expected version 7")
+	assert.Equal(t, "RFC4122", first.Variant().String(), "expected RFC 4122 variant")
+
+	clock.Advance(time.Millisecond)
+	second := ids.NewID()
+	assert.Equal(t, -1, bytes.Compare(first[:], second[:]), "expected ids to be time ordered")
+}
+
+func TestULIDGenerator(t *testing.T) {
+	clock := domaintest.NewFakeClock(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC))
+	ids := domain.ULIDGenerator{Clock: clock}
+
+	prev := ids.NewID()
+	for i := 0; i < 100; i++ {
+		if i%10 == 0 {
+			clock.Advance(time.Millisecond)
+		}
+		next := ids.NewID()
+		assert.Equal(t, -1, bytes.Compare(prev[:], next[:]), "expected ids to be monotonic")
+		prev = next
+	}
+}
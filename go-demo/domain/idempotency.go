@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// IdempotencyRecord is the outcome of the first request made with an
+// idempotency key. Until the request finishes Completed is false and only
+// the key, fingerprint, expiry and tenant are set
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	// TenantId is the tenant of the request, empty if it had none
+	TenantId string
+	// UserIds are the users the stored response describes, set by the
+	// handler whatever the fields it was shaped with
+	UserIds []uuid.UUID
+}
+
+// Expired reports whether the record should no longer be used at time now
+func (r IdempotencyRecord) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// References reports whether the stored response describes user id, like
+// the response to creating or patching it
+func (r IdempotencyRecord) References(id uuid.UUID) bool {
+	for _, userId := range r.UserIds {
+		if userId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// IdempotencyRepo persists idempotency records next to the data they protect
+type IdempotencyRepo interface {
+	// ReserveRecord atomically stores record if no record exists for its key,
+	// or if the existing one expired before record was created. Otherwise, the
+	// existing record is returned and reserved is false
+	ReserveRecord(ctx context.Context, record IdempotencyRecord) (existing IdempotencyRecord, reserved bool, err error)
+	SaveRecord(ctx context.Context, record IdempotencyRecord) error
+	DeleteRecord(ctx context.Context, key string) error
+	// DeleteUserRecords deletes the records of the tenant of ctx referencing
+	// user id, and returns how many it deleted
+	DeleteUserRecords(ctx context.Context, id uuid.UUID) (int, error)
+}
@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrRotationRunning = errors.New("a key rotation is already running")
+
+// KeyRotator re-encrypts what a repo keeps encrypted at rest
+type KeyRotator interface {
+	// ReloadKeys reads the keys again, new data is then encrypted with the
+	// current one
+	ReloadKeys() error
+	// RotateKeys re-encrypts the users of the tenant of ctx not encrypted
+	// with the current key, returning how many
+	RotateKeys(ctx context.Context) (int, error)
+}
+
+// KeyRotation is the progress of the latest key rotation
+type KeyRotation struct {
+	Running    bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Rotated is how many users were re-encrypted so far
+	Rotated int
+	// Err is why the rotation failed, empty if it did not
+	Err string
+}
+
+// KeyRotationJob re-encrypts the users of every tenant in the background,
+// while the server keeps serving them
+type KeyRotationJob struct {
+	rotator KeyRotator
+	tenants TenantService
+	clock   Clock
+	mu      *sync.Mutex
+	status  *KeyRotation
+}
+
+// NewKeyRotationJob returns a new instance of KeyRotationJob
+func NewKeyRotationJob(rotator KeyRotator, tenants TenantService, clock Clock) (KeyRotationJob, error) {
+	if rotator == nil {
+		return KeyRotationJob{}, fmt.Errorf("cannot create key rotation job, missing rotator")
+	}
+	if tenants == nil {
+		return KeyRotationJob{}, fmt.Errorf("cannot create key rotation job, missing tenants")
+	}
+	if clock == nil {
+		return KeyRotationJob{}, fmt.Errorf("cannot create key rotation job, missing clock")
+	}
+	return KeyRotationJob{rotator: rotator, tenants: tenants, clock: clock, mu: new(sync.Mutex), status: new(KeyRotation)}, nil
+}
+
+// Start reloads the keys, then rotates the users of every tenant in the
+// background. It fails with ErrRotationRunning if a rotation has not
+// finished yet
+func (k *KeyRotationJob) Start() (KeyRotation, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.status.Running {
+		return *k.status, ErrRotationRunning
+	}
+	if err := k.rotator.ReloadKeys(); err != nil {
+		return KeyRotation{}, fmt.Errorf("could not reload keys: %w", err)
+	}
+	*k.status = KeyRotation{Running: true, StartedAt: k.clock.Now()}
+	go k.run(context.Background())
+	return *k.status, nil
+}
+
+// Status returns the progress of the latest rotation
+func (k *KeyRotationJob) Status() KeyRotation {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return *k.status
+}
+
+// run rotates the keys of one tenant at a time, stopping at the first that
+// fails, as its users would stay encrypted with a key about to be retired
+func (k *KeyRotationJob) run(ctx context.Context) {
+	err := k.rotateTenants(ctx)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.status.Running = false
+	k.status.FinishedAt = k.clock.Now()
+	if err != nil {
+		k.status.Err = err.Error()
+		log.Println("could not rotate keys", err.Error())
+		return
+	}
+	log.Printf("rotated the keys of %d users", k.status.Rotated)
+}
+
+func (k *KeyRotationJob) rotateTenants(ctx context.Context) error {
+	tenants, err := k.tenants.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		rotated, err := k.rotator.RotateKeys(WithTenant(ctx, tenant.Id))
+		k.mu.Lock()
+		k.status.Rotated += rotated
+		k.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("could not rotate keys of tenant %s: %w", tenant.Id, err)
+		}
+	}
+	return nil
+}
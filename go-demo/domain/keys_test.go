@@ -0,0 +1,82 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	mockDomain "api-demo/mock/domain"
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// waitForRotation waits for the rotation of job to finish
+func waitForRotation(t *testing.T, job *domain.KeyRotationJob) domain.KeyRotation {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := job.Status(); !status.Running {
+			return status
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("key rotation did not finish")
+	return domain.KeyRotation{}
+}
+
+func TestKeyRotationJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	rotator := mockDomain.NewMockKeyRotator(ctrl)
+	tenantService := mockDomain.NewMockTenantService(ctrl)
+
+	job, err := domain.NewKeyRotationJob(rotator, tenantService, domaintest.NewFakeClock(testNow))
+	assert.NoError(t, err, "expected no error")
+
+	release := make(chan struct{})
+	rotator.EXPECT().ReloadKeys().Return(nil)
+	tenantService.EXPECT().
+		ListTenants(gomock.Any()).
+		Return([]domain.Tenant{{Id: "acme"}, {Id: "globex"}}, nil)
+	rotator.EXPECT().
+		RotateKeys(gomock.Any()).
+		DoAndReturn(func(ctx context.Context) (int, error) {
+			tenant, _ := domain.TenantFromContext(ctx)
+			assert.Equal(t, "acme", tenant)
+			<-release
+			return 3, nil
+		})
+	rotator.EXPECT().
+		RotateKeys(gomock.Any()).
+		DoAndReturn(func(ctx context.Context) (int, error) {
+			tenant, _ := domain.TenantFromContext(ctx)
+			assert.Equal(t, "globex", tenant)
+			return 2, nil
+		})
+
+	status, err := job.Start()
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, domain.KeyRotation{Running: true, StartedAt: testNow}, status)
+
+	_, err = job.Start()
+	assert.ErrorIs(t, err, domain.ErrRotationRunning)
+
+	close(release)
+	assert.Equal(t, domain.KeyRotation{StartedAt: testNow, FinishedAt: testNow, Rotated: 5}, waitForRotation(t, &job))
+
+	// a failing tenant ends the rotation
+	rotator.EXPECT().ReloadKeys().Return(nil)
+	tenantService.EXPECT().ListTenants(gomock.Any()).Return([]domain.Tenant{{Id: "acme"}, {Id: "globex"}}, nil)
+	rotator.EXPECT().RotateKeys(gomock.Any()).Return(1, errors.New("unknown encryption key"))
+	_, err = job.Start()
+	assert.NoError(t, err, "expected no error")
+	status = waitForRotation(t, &job)
+	assert.Equal(t, 1, status.Rotated)
+	assert.Contains(t, status.Err, "tenant acme")
+
+	rotator.EXPECT().ReloadKeys().Return(errors.New("could not read keyring"))
+	_, err = job.Start()
+	assert.Error(t, err, "expected reload error")
+	assert.False(t, job.Status().Running)
+}
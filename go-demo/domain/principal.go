@@ -0,0 +1,19 @@
+package domain
+
+import "context"
+
+type principalCtxKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the identity of the caller
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, principal)
+}
+
+// PrincipalFromContext returns the identity of the caller, if one was set
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalCtxKey{}).(string)
+	if !ok || principal == "" {
+		return "", false
+	}
+	return principal, true
+}
@@ -0,0 +1,252 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"time"
+)
+
+var ErrErasureUnavailable = errors.New("erasure receipts are not configured")
+
+// ErrReceiptChainBroken is returned when an erasure receipt does not follow
+// the one before it, so that receipts were changed or removed
+type ErrReceiptChainBroken struct {
+	Seq uint64
+}
+
+func (e ErrReceiptChainBroken) Error() string {
+	return fmt.Sprintf("erasure receipt %d does not follow the receipt before it", e.Seq)
+}
+
+// PersonalData is everything held about a user
+type PersonalData struct {
+	// User is nil if the user was purged, while its history was kept
+	User       *User
+	Versions   []UserVersion
+	Groups     []Group
+	ExportedAt time.Time
+}
+
+// ErasureReceipt records that a user was erased, without holding any of its
+// personal data. Receipts of a tenant form a chain, each hashing the one
+// before it, so that changing or removing one breaks the chain
+type ErasureReceipt struct {
+	Id       uuid.UUID
+	TenantId string
+	UserId   uuid.UUID
+	ErasedAt time.Time
+	ErasedBy string
+	// Erased lists what was erased, like the profile or the history
+	Erased []string
+	// Seq numbers the receipts of a tenant from 1
+	Seq      uint64
+	PrevHash string
+	Hash     string
+}
+
+// computeHash returns the hex sha256 of every field of r but Hash
+func (r ErasureReceipt) computeHash() string {
+	r.Hash = ""
+	r.ErasedAt = r.ErasedAt.UTC()
+	// json encodes the fields in order, so the encoding is canonical
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChainReceipt numbers receipt after prev, the last receipt of its tenant
+// or the zero receipt if there is none, and seals it with its hash
+func ChainReceipt(prev, receipt ErasureReceipt) ErasureReceipt {
+	receipt.Seq = prev.Seq + 1
+	receipt.PrevHash = prev.Hash
+	receipt.Hash = receipt.computeHash()
+	return receipt
+}
+
+// VerifyReceipts checks that the receipts of a tenant, oldest first, form an
+// unbroken chain
+func VerifyReceipts(receipts []ErasureReceipt) error {
+	var prev ErasureReceipt
+	for _, receipt := range receipts {
+		if receipt.Seq != prev.Seq+1 || receipt.PrevHash != prev.Hash || receipt.Hash != receipt.computeHash() {
+			return ErrReceiptChainBroken{Seq: receipt.Seq}
+		}
+		prev = receipt
+	}
+	return nil
+}
+
+// ErasureLog keeps the erasure receipts of every tenant. Implementations
+// scope every method to the tenant of ctx
+type ErasureLog interface {
+	// AppendReceipt chains receipt to the last receipt of the tenant with
+	// ChainReceipt and stores it
+	AppendReceipt(ctx context.Context, receipt ErasureReceipt) (ErasureReceipt, error)
+	// ListReceipts returns the receipts of the tenant, oldest first
+	ListReceipts(ctx context.Context) ([]ErasureReceipt, error)
+}
+
+// WithErasureLog enables Erase, recording a receipt of every erasure in log
+func WithErasureLog(log ErasureLog) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.erasures = log
+	}
+}
+
+// WithIdempotencyRecords makes Erase delete the responses stored for
+// idempotency keys that reference the erased user
+func WithIdempotencyRecords(records IdempotencyRepo) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.idempotency = records
+	}
+}
+
+// versionsOf returns the versions kept of a user, none without a history
+func (u *UserServiceImpl) versionsOf(ctx context.Context, id uuid.UUID) ([]UserVersion, error) {
+	if u.history == nil {
+		return nil, nil
+	}
+	versions, err := u.history.Versions(ctx, id)
+	if errors.As(err, &ErrUserIdNotFound{}) {
+		return nil, nil
+	}
+	return versions, err
+}
+
+// ExportPersonalData returns everything held about a user, deleted or not,
+// including the history of a purged user. There is no audit log apart from
+// the history, whose versions record who made each change and when, so
+// there are no audit entries to export
+func (u *UserServiceImpl) ExportPersonalData(ctx context.Context, id uuid.UUID) (PersonalData, error) {
+	log.Println("exporting personal data")
+
+	if id == uuid.Nil {
+		return PersonalData{}, ErrBadUserId
+	}
+
+	data := PersonalData{ExportedAt: u.clock.Now()}
+	user, err := u.getUser(ctx, id)
+	if err == nil {
+		data.User = &user
+	} else if !errors.As(err, &ErrUserIdNotFound{}) {
+		return PersonalData{}, fmt.Errorf("could not fetch user by id: %w", err)
+	}
+	if data.Versions, err = u.versionsOf(ctx, id); err != nil {
+		return PersonalData{}, fmt.Errorf("could not fetch user history: %w", err)
+	}
+	if data.User == nil && len(data.Versions) == 0 {
+		return PersonalData{}, fmt.Errorf("could not fetch user by id: %w", ErrUserIdNotFound{Id: id})
+	}
+	if data.User != nil && u.groups != nil {
+		if data.Groups, err = u.groups.ListGroupsOfUser(ctx, id); err != nil {
+			return PersonalData{}, fmt.Errorf("could not fetch groups of user: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// Erase irreversibly removes a user, deleted or not, from the repo, its
+// groups, the search index, the history and the stored responses of
+// idempotency keys, handling its reports as Delete
+// does. It returns the receipt recorded in the erasure log
+func (u *UserServiceImpl) Erase(ctx context.Context, id uuid.UUID) (ErasureReceipt, error) {
+	log.Println("erasing user by id")
+
+	if u.erasures == nil {
+		return ErasureReceipt{}, ErrErasureUnavailable
+	}
+	if id == uuid.Nil {
+		return ErasureReceipt{}, ErrBadUserId
+	}
+	tenant, err := RequireTenant(ctx)
+	if err != nil {
+		return ErasureReceipt{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.getUser(ctx, id)
+	found := err == nil
+	if err != nil && !errors.As(err, &ErrUserIdNotFound{}) {
+		return ErasureReceipt{}, fmt.Errorf("could not fetch user by id: %w", err)
+	}
+	versions, err := u.versionsOf(ctx, id)
+	if err != nil {
+		return ErasureReceipt{}, fmt.Errorf("could not fetch user history: %w", err)
+	}
+	if !found && len(versions) == 0 {
+		return ErasureReceipt{}, fmt.Errorf("could not erase user: %w", ErrUserIdNotFound{Id: id})
+	}
+
+	var erased []string
+	if found && !user.Deleted() {
+		if err := u.handleReports(ctx, user); err != nil {
+			return ErasureReceipt{}, fmt.Errorf("could not erase user: %w", err)
+		}
+	}
+	if found && u.groups != nil {
+		if err := u.groups.RemoveUserMemberships(ctx, id); err != nil {
+			return ErasureReceipt{}, fmt.Errorf("could not remove erased user from groups: %w", err)
+		}
+		erased = append(erased, "group memberships")
+	}
+	// a purged user may still be kept by repos that keep past states
+	err = u.repo.EraseUser(ctx, id)
+	if err == nil {
+		erased = append(erased, "profile")
+	} else if !errors.As(err, &ErrUserIdNotFound{}) {
+		return ErasureReceipt{}, fmt.Errorf("could not erase user: %w", err)
+	}
+	if found && u.search != nil {
+		u.search.RemoveUser(id)
+		erased = append(erased, "search index")
+	}
+	if len(versions) > 0 {
+		if err := u.history.Erase(ctx, id); err != nil {
+			return ErasureReceipt{}, fmt.Errorf("could not erase user history: %w", err)
+		}
+		erased = append(erased, "history")
+	}
+	if u.idempotency != nil {
+		if _, err := u.idempotency.DeleteUserRecords(ctx, id); err != nil {
+			return ErasureReceipt{}, fmt.Errorf("could not erase idempotency records of user: %w", err)
+		}
+		erased = append(erased, "idempotency records")
+	}
+
+	principal, _ := PrincipalFromContext(ctx)
+	receipt, err := u.erasures.AppendReceipt(ctx, ErasureReceipt{
+		Id:       u.ids.NewID(),
+		TenantId: tenant,
+		UserId:   id,
+		ErasedAt: u.clock.Now(),
+		ErasedBy: principal,
+		Erased:   erased,
+	})
+	if err != nil {
+		return ErasureReceipt{}, fmt.Errorf("could not record erasure receipt: %w", err)
+	}
+	return receipt, nil
+}
+
+// ErasureReceipts returns the erasure receipts of the tenant of ctx, oldest
+// first
+func (u *UserServiceImpl) ErasureReceipts(ctx context.Context) ([]ErasureReceipt, error) {
+	log.Println("listing erasure receipts")
+
+	if u.erasures == nil {
+		return nil, ErrErasureUnavailable
+	}
+	receipts, err := u.erasures.ListReceipts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list erasure receipts: %w", err)
+	}
+	return receipts, nil
+}
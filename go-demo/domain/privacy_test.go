@@ -0,0 +1,182 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	mockDomain "api-demo/mock/domain"
+	"context"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// chainedLog expects receipts to be appended to log, chaining each to the
+// one appended before
+func chainedLog(log *mockDomain.MockErasureLog) {
+	var last domain.ErasureReceipt
+	log.EXPECT().
+		AppendReceipt(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, receipt domain.ErasureReceipt) (domain.ErasureReceipt, error) {
+			last = domain.ChainReceipt(last, receipt)
+			return last, nil
+		}).
+		AnyTimes()
+}
+
+func TestUserServiceImpl_ExportPersonalData(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	history := mockDomain.NewMockUserHistory(ctrl)
+	groupRepo := mockDomain.NewMockGroupRepo(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithHistory(history),
+		domain.WithGroups(groupRepo),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	user := newUser("Shashank Pachava", "admin")
+	group := domain.Group{Id: uuid.New(), TenantId: testTenant, Name: "engineering"}
+	versions := []domain.UserVersion{{Version: 1, User: user}}
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+	history.EXPECT().Versions(testCtx, user.Id).Return(versions, nil)
+	groupRepo.EXPECT().ListGroupsOfUser(testCtx, user.Id).Return([]domain.Group{group}, nil)
+
+	data, err := userService.ExportPersonalData(testCtx, user.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, domain.PersonalData{User: &user, Versions: versions, Groups: []domain.Group{group}, ExportedAt: testNow}, data)
+
+	purged := newUser("Sasi", "user")
+	userRepo.EXPECT().GetUserById(testCtx, purged.Id).Return(domain.User{}, domain.ErrUserIdNotFound{Id: purged.Id})
+	history.EXPECT().Versions(testCtx, purged.Id).Return([]domain.UserVersion{{Version: 1, User: purged}}, nil)
+	data, err = userService.ExportPersonalData(testCtx, purged.Id)
+	assert.NoError(t, err, "expected the history of a purged user to be exported")
+	assert.Nil(t, data.User)
+	assert.Len(t, data.Versions, 1)
+
+	unknown := uuid.New()
+	userRepo.EXPECT().GetUserById(testCtx, unknown).Return(domain.User{}, domain.ErrUserIdNotFound{Id: unknown})
+	history.EXPECT().Versions(testCtx, unknown).Return(nil, domain.ErrUserIdNotFound{Id: unknown})
+	_, err = userService.ExportPersonalData(testCtx, unknown)
+	assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{})
+}
+
+func TestUserServiceImpl_Erase(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	history := mockDomain.NewMockUserHistory(ctrl)
+	groupRepo := mockDomain.NewMockGroupRepo(ctrl)
+	searchIndex := mockDomain.NewMockUserSearchIndex(ctrl)
+	erasures := mockDomain.NewMockErasureLog(ctrl)
+	chainedLog(erasures)
+	idempotencyRepo := mockDomain.NewMockIdempotencyRepo(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithHistory(history),
+		domain.WithGroups(groupRepo),
+		domain.WithSearchIndex(searchIndex),
+		domain.WithErasureLog(erasures),
+		domain.WithIdempotencyRecords(idempotencyRepo),
+	)
+	assert.NoError(t, err, "expected no error")
+	ctx := domain.WithPrincipal(testCtx, "dpo")
+
+	user := newUser("Shashank Pachava", "admin")
+	userRepo.EXPECT().GetUserById(ctx, user.Id).Return(user, nil)
+	history.EXPECT().Versions(ctx, user.Id).Return([]domain.UserVersion{{Version: 1, User: user}}, nil)
+	userRepo.EXPECT().QueryUsers(ctx, reportsOf(user.Id)).Return(nil, nil)
+	groupRepo.EXPECT().RemoveUserMemberships(ctx, user.Id).Return(nil)
+	userRepo.EXPECT().EraseUser(ctx, user.Id).Return(nil)
+	searchIndex.EXPECT().RemoveUser(user.Id)
+	history.EXPECT().Erase(ctx, user.Id).Return(nil)
+	idempotencyRepo.EXPECT().DeleteUserRecords(ctx, user.Id).Return(2, nil)
+
+	receipt, err := userService.Erase(ctx, user.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, testTenant, receipt.TenantId)
+	assert.Equal(t, user.Id, receipt.UserId)
+	assert.Equal(t, testNow, receipt.ErasedAt)
+	assert.Equal(t, "dpo", receipt.ErasedBy)
+	assert.Equal(t, []string{"group memberships", "profile", "search index", "history", "idempotency records"}, receipt.Erased)
+	assert.Equal(t, uint64(1), receipt.Seq)
+	assert.NotEmpty(t, receipt.Hash)
+	encoded, _ := json.Marshal(receipt)
+	assert.NotContains(t, string(encoded), user.Name, "expected the receipt to hold no personal data")
+
+	// a purged user may only be left in the history
+	purged := newUser("Sasi", "user")
+	userRepo.EXPECT().GetUserById(ctx, purged.Id).Return(domain.User{}, domain.ErrUserIdNotFound{Id: purged.Id})
+	history.EXPECT().Versions(ctx, purged.Id).Return([]domain.UserVersion{{Version: 1, User: purged}}, nil)
+	userRepo.EXPECT().EraseUser(ctx, purged.Id).Return(domain.ErrUserIdNotFound{Id: purged.Id})
+	history.EXPECT().Erase(ctx, purged.Id).Return(nil)
+	idempotencyRepo.EXPECT().DeleteUserRecords(ctx, purged.Id).Return(0, nil)
+
+	second, err := userService.Erase(ctx, purged.Id)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []string{"history", "idempotency records"}, second.Erased)
+	assert.Equal(t, receipt.Hash, second.PrevHash, "expected receipts to be chained")
+	assert.NoError(t, domain.VerifyReceipts([]domain.ErasureReceipt{receipt, second}))
+
+	unknown := uuid.New()
+	userRepo.EXPECT().GetUserById(ctx, unknown).Return(domain.User{}, domain.ErrUserIdNotFound{Id: unknown})
+	history.EXPECT().Versions(ctx, unknown).Return(nil, domain.ErrUserIdNotFound{Id: unknown})
+	_, err = userService.Erase(ctx, unknown)
+	assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{})
+
+	withoutLog, err := domain.NewUserServiceImpl(userRepo)
+	assert.NoError(t, err, "expected no error")
+	_, err = withoutLog.Erase(ctx, user.Id)
+	assert.ErrorIs(t, err, domain.ErrErasureUnavailable)
+}
+
+func TestUserServiceImpl_Erase_BlockedByReports(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	erasures := mockDomain.NewMockErasureLog(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithManagerDeletePolicy(domain.BlockDelete),
+		domain.WithErasureLog(erasures),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	manager := newUser("Sasi", "admin")
+	report := newUser("Shashank", "user")
+	report.ManagerId = manager.Id
+	userRepo.EXPECT().GetUserById(testCtx, manager.Id).Return(manager, nil)
+	userRepo.EXPECT().QueryUsers(testCtx, reportsOf(manager.Id)).Return([]domain.User{report}, nil)
+
+	_, err = userService.Erase(testCtx, manager.Id)
+	assert.ErrorAs(t, err, &domain.ErrHasReports{}, "expected nothing to be erased")
+}
+
+func TestVerifyReceipts(t *testing.T) {
+	var receipts []domain.ErasureReceipt
+	var last domain.ErasureReceipt
+	for n := 0; n < 3; n++ {
+		last = domain.ChainReceipt(last, domain.ErasureReceipt{
+			Id:       uuid.New(),
+			TenantId: testTenant,
+			UserId:   uuid.New(),
+			ErasedAt: testNow.Add(time.Duration(n) * time.Hour),
+			Erased:   []string{"profile"},
+		})
+		receipts = append(receipts, last)
+	}
+	assert.NoError(t, domain.VerifyReceipts(receipts))
+
+	edited := append([]domain.ErasureReceipt(nil), receipts...)
+	edited[1].UserId = uuid.New()
+	assert.Equal(t, domain.ErrReceiptChainBroken{Seq: 2}, domain.VerifyReceipts(edited), "expected an edited receipt to break the chain")
+
+	removed := []domain.ErasureReceipt{receipts[0], receipts[2]}
+	assert.Equal(t, domain.ErrReceiptChainBroken{Seq: 3}, domain.VerifyReceipts(removed), "expected a removed receipt to break the chain")
+}
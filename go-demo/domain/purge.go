@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// PurgeJob periodically hard deletes users of every tenant that have been
+// soft deleted for longer than the retention window
+type PurgeJob struct {
+	service   UserService
+	tenants   TenantService
+	retention time.Duration
+	interval  time.Duration
+	clock     Clock
+}
+
+// NewPurgeJob returns a new instance of PurgeJob
+func NewPurgeJob(service UserService, tenants TenantService, clock Clock, retention, interval time.Duration) (PurgeJob, error) {
+	if service == nil {
+		return PurgeJob{}, fmt.Errorf("cannot create purge job, missing service")
+	}
+	if tenants == nil {
+		return PurgeJob{}, fmt.Errorf("cannot create purge job, missing tenants")
+	}
+	if clock == nil {
+		return PurgeJob{}, fmt.Errorf("cannot create purge job, missing clock")
+	}
+	if retention <= 0 || interval <= 0 {
+		return PurgeJob{}, fmt.Errorf("cannot create purge job, retention and interval must be positive")
+	}
+	return PurgeJob{service: service, tenants: tenants, retention: retention, interval: interval, clock: clock}, nil
+}
+
+// RunOnce purges every user deleted before the retention window, one tenant
+// at a time. A failing tenant does not stop the others from being purged
+func (p *PurgeJob) RunOnce(ctx context.Context) (int, error) {
+	tenants, err := p.tenants.ListTenants(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := p.clock.Now().Add(-p.retention)
+	total := 0
+	var failed []string
+	var firstErr error
+	for _, tenant := range tenants {
+		purged, err := p.service.PurgeDeleted(WithTenant(ctx, tenant.Id), cutoff)
+		total += purged
+		if err != nil {
+			failed = append(failed, tenant.Id)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return total, fmt.Errorf("could not purge tenants %s: %w", strings.Join(failed, ", "), firstErr)
+	}
+	return total, nil
+}
+
+// Run purges users every interval until ctx is done
+func (p *PurgeJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := p.RunOnce(ctx)
+			if err != nil {
+				log.Println("could not purge deleted users", err.Error())
+				continue
+			}
+			log.Printf("purged %d deleted users", purged)
+		}
+	}
+}
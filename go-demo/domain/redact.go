@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// RedactedMask stands in for sensitive values in logs, error messages and
+// examples
+const RedactedMask = "[REDACTED]"
+
+// sensitiveTag marks the fields of a type that hold personal data, as in
+// `sensitive:"true"`
+const sensitiveTag = "sensitive"
+
+// unredacted is set in local development only, to see sensitive values
+var unredacted atomic.Bool
+
+// SetUnredacted turns redaction off, or back on. Only local development
+// servers should ever turn it off
+func SetUnredacted(on bool) {
+	unredacted.Store(on)
+}
+
+// Unredacted reports whether sensitive values are shown as they are
+func Unredacted() bool {
+	return unredacted.Load()
+}
+
+// Mask formats a sensitive value, as RedactedMask unless Unredacted
+func Mask(v any) string {
+	if Unredacted() {
+		return fmt.Sprint(v)
+	}
+	return RedactedMask
+}
+
+// Sensitive reports whether field is marked as holding personal data
+func Sensitive(field reflect.StructField) bool {
+	return field.Tag.Get(sensitiveTag) == "true"
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// Redact formats v like %+v, following pointers, with the value of every
+// field marked sensitive replaced by RedactedMask. Unset sensitive fields
+// are shown, so that logs still tell which were set
+func Redact(v any) string {
+	var b strings.Builder
+	redact(&b, reflect.ValueOf(v), false)
+	return b.String()
+}
+
+func redact(b *strings.Builder, v reflect.Value, sensitive bool) {
+	if !v.IsValid() {
+		b.WriteString("<nil>")
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			b.WriteString("<nil>")
+			return
+		}
+	}
+	if sensitive && !Unredacted() {
+		b.WriteString(RedactedMask)
+		return
+	}
+	if v.Type().Implements(stringerType) && v.CanInterface() {
+		b.WriteString(fmt.Sprint(v.Interface()))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		redact(b, v.Elem(), sensitive)
+	case reflect.Struct:
+		b.WriteByte('{')
+		written := false
+		for n := 0; n < v.NumField(); n++ {
+			field := v.Type().Field(n)
+			if !field.IsExported() {
+				continue
+			}
+			if written {
+				b.WriteByte(' ')
+			}
+			written = true
+			b.WriteString(field.Name)
+			b.WriteByte(':')
+			redact(b, v.Field(n), sensitive || Sensitive(field))
+		}
+		b.WriteByte('}')
+	case reflect.Slice, reflect.Array:
+		b.WriteByte('[')
+		for n := 0; n < v.Len(); n++ {
+			if n > 0 {
+				b.WriteByte(' ')
+			}
+			redact(b, v.Index(n), sensitive)
+		}
+		b.WriteByte(']')
+	case reflect.Map:
+		entries := make([]string, 0, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			var entry strings.Builder
+			redact(&entry, iter.Key(), sensitive)
+			entry.WriteByte(':')
+			redact(&entry, iter.Value(), sensitive)
+			entries = append(entries, entry.String())
+		}
+		sort.Strings(entries)
+		b.WriteString("map[" + strings.Join(entries, " ") + "]")
+	default:
+		if v.CanInterface() {
+			b.WriteString(fmt.Sprint(v.Interface()))
+		}
+	}
+}
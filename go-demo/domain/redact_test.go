@@ -0,0 +1,53 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// unredacted turns redaction off for the rest of the test
+func unredacted(t *testing.T) {
+	domain.SetUnredacted(true)
+	t.Cleanup(func() {
+		domain.SetUnredacted(false)
+	})
+}
+
+func TestRedact(t *testing.T) {
+	name := "Shashank Pachava"
+	role := "admin"
+	managerId := uuid.MustParse("2f1f7c4e-9a5b-4b8e-8a53-2f0c5d3f6a10")
+	up := domain.UserProperties{Name: &name, Role: &role, ManagerId: &managerId, Deleted: domain.IncludeDeleted}
+
+	redacted := domain.Redact(up)
+	assert.NotContains(t, redacted, name)
+	assert.NotContains(t, redacted, managerId.String())
+	assert.Contains(t, redacted, "Name:"+domain.RedactedMask)
+	assert.Contains(t, redacted, "Role:admin", "expected fields not marked sensitive to be shown")
+	assert.Contains(t, redacted, "Attributes:<nil>", "expected unset sensitive fields to be shown")
+
+	user := newUser(name, role)
+	user.Attributes = map[string]any{"employeeNumber": "E-1234"}
+	redacted = domain.Redact(&user)
+	assert.NotContains(t, redacted, name)
+	assert.NotContains(t, redacted, user.Id.String())
+	assert.NotContains(t, redacted, "E-1234")
+	assert.Contains(t, redacted, "TenantId:"+testTenant)
+
+	unredacted(t)
+	redacted = domain.Redact(up)
+	assert.Contains(t, redacted, "Name:"+name)
+	assert.Contains(t, redacted, "ManagerId:"+managerId.String())
+}
+
+func TestMask(t *testing.T) {
+	id := uuid.New()
+	err := domain.ErrUserIdNotFound{Id: id}
+	assert.Equal(t, "could not find user with id "+domain.RedactedMask, err.Error())
+	assert.Equal(t, "user "+domain.RedactedMask+" still has 2 direct reports", domain.ErrHasReports{Id: id, Reports: 2}.Error())
+
+	unredacted(t)
+	assert.Equal(t, "could not find user with id "+id.String(), err.Error())
+}
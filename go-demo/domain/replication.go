@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// ErrFeedGap is returned when a change feed no longer holds, or never held,
+// the changes following After, so that a follower must start over from a
+// snapshot of the feed
+type ErrFeedGap struct {
+	After uint64
+}
+
+func (e ErrFeedGap) Error() string {
+	return fmt.Sprintf("the change feed does not hold the changes after %d", e.After)
+}
+
+// ErrReadOnly is returned when writing to a follower, which only the primary
+// it replicates may be written to
+type ErrReadOnly struct {
+	Primary string
+}
+
+func (e ErrReadOnly) Error() string {
+	return "this server is a read only follower, write to the primary at " + e.Primary
+}
+
+// ChangeOp is the kind of write a Change records
+type ChangeOp string
+
+const (
+	ChangeSave   ChangeOp = "save"
+	ChangeDelete ChangeOp = "delete"
+	ChangeErase  ChangeOp = "erase"
+)
+
+// Change is a write to a user repo, as followers replay it. Restores of the
+// whole repo are not changes, followers start over from a snapshot instead
+type Change struct {
+	// Seq numbers the changes of a feed from 1
+	Seq      uint64
+	At       time.Time
+	TenantId string
+	Op       ChangeOp
+	// UserId is the user saved, deleted or erased
+	UserId uuid.UUID `sensitive:"true"`
+	// User is set on ChangeSave
+	User *User
+}
+
+// ChangeBatch is a run of consecutive changes of a feed
+type ChangeBatch struct {
+	// FeedId tells feeds apart, as a feed numbers its changes from 1 again
+	// when it is recreated, like when the primary restarts
+	FeedId  string
+	Changes []Change
+	// Last is the seq of the last change of the feed, to tell how far behind
+	// a follower is
+	Last uint64
+}
+
+// FeedSnapshot is every user of every tenant as of the change Seq of a feed
+type FeedSnapshot struct {
+	FeedId string
+	Seq    uint64
+	Users  []User
+}
+
+// ChangeFeed is an ordered and resumable log of the writes to a user repo.
+// Unlike UserRepo, its methods are not scoped to a tenant
+type ChangeFeed interface {
+	// Changes returns at most limit changes following the change after, and
+	// fails with ErrFeedGap if the feed does not hold them
+	Changes(ctx context.Context, after uint64, limit int) (ChangeBatch, error)
+	// Snapshot returns every user at one point of the feed, to resume
+	// following it from
+	Snapshot(ctx context.Context) (FeedSnapshot, error)
+}
+
+// ReplicationStatus tells how far a follower is behind the primary it
+// replicates
+type ReplicationStatus struct {
+	Primary string
+	FeedId  string
+	// Applied is the seq of the last change applied, Last that of the last
+	// change of the feed as of LastContactAt
+	Applied       uint64
+	Last          uint64
+	LastContactAt time.Time
+	// CaughtUpAt is when the follower last applied every change of the feed,
+	// Lag how long ago that was
+	CaughtUpAt time.Time
+	Lag        time.Duration
+	// Err is why the last attempt to follow the feed failed, empty if it
+	// did not
+	Err string
+}
+
+// Behind returns the number of changes of the feed not applied yet
+func (s ReplicationStatus) Behind() uint64 {
+	if s.Last < s.Applied {
+		return 0
+	}
+	return s.Last - s.Applied
+}
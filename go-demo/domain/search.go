@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"errors"
+	"github.com/google/uuid"
+)
+
+// DefaultSearchLimit and MaxSearchLimit bound the number of search results
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+var ErrSearchUnavailable = errors.New("search is not configured")
+
+// SearchHit is a user matching a search query, higher scores are more relevant
+type SearchHit struct {
+	Id    uuid.UUID
+	Score float64
+}
+
+// UserSearchIndex is a full-text index over user names. UserServiceImpl keeps
+// it up to date as users are created, updated and deleted
+type UserSearchIndex interface {
+	// IndexUser adds user to the index, replacing any previous version of it
+	IndexUser(user User)
+	RemoveUser(id uuid.UUID)
+	// Search returns at most limit hits for query among the users of tenant,
+	// most relevant first
+	Search(tenant string, query string, limit int) []SearchHit
+}
@@ -0,0 +1,160 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Tenant is a business unit whose users are isolated from every other
+// tenant's
+type Tenant struct {
+	// Id is a lower case slug, usable as a subdomain
+	Id   string
+	Name string
+	// MaxUsers is the quota of users that are not deleted, 0 is unlimited
+	MaxUsers  int
+	CreatedAt time.Time
+	CreatedBy string
+}
+
+var tenantIdPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidTenantId reports whether id can name a tenant
+func ValidTenantId(id string) bool {
+	return tenantIdPattern.MatchString(id)
+}
+
+var (
+	ErrNoTenant        = errors.New("no tenant in context")
+	ErrTenantMismatch  = errors.New("user belongs to another tenant")
+	ErrInvalidTenantId = errors.New("tenant id must be a lower case slug of at most 63 letters, digits and dashes")
+)
+
+type ErrTenantNotFound struct {
+	Id string
+}
+
+func (e ErrTenantNotFound) Error() string {
+	return fmt.Sprintf("tenant %q not found", e.Id)
+}
+
+type ErrTenantExists struct {
+	Id string
+}
+
+func (e ErrTenantExists) Error() string {
+	return fmt.Sprintf("tenant %q already exists", e.Id)
+}
+
+// ErrQuotaExceeded is returned when a tenant has as many users as it is
+// allowed
+type ErrQuotaExceeded struct {
+	Tenant   string
+	MaxUsers int
+}
+
+func (e ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("tenant %q reached its quota of %d users", e.Tenant, e.MaxUsers)
+}
+
+type tenantCtxKey struct{}
+
+// WithTenant returns a copy of ctx scoped to a tenant. Services and repos
+// only see the users of that tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant ctx is scoped to, if one was set
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantCtxKey{}).(string)
+	if !ok || tenant == "" {
+		return "", false
+	}
+	return tenant, true
+}
+
+// RequireTenant returns the tenant ctx is scoped to, or ErrNoTenant
+func RequireTenant(ctx context.Context) (string, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	return tenant, nil
+}
+
+type TenantService interface {
+	CreateTenant(ctx context.Context, tenant Tenant) (Tenant, error)
+	GetTenant(ctx context.Context, id string) (Tenant, error)
+	ListTenants(ctx context.Context) ([]Tenant, error)
+}
+
+// TenantServiceImpl is an implementation of TenantService
+type TenantServiceImpl struct {
+	repo  TenantRepo
+	clock Clock
+}
+
+// NewTenantServiceImpl returns a new instance of TenantServiceImpl
+func NewTenantServiceImpl(repo TenantRepo, clock Clock) (TenantServiceImpl, error) {
+	if repo == nil {
+		return TenantServiceImpl{}, fmt.Errorf("cannot create tenant service, missing repo")
+	}
+	if clock == nil {
+		return TenantServiceImpl{}, fmt.Errorf("cannot create tenant service, missing clock")
+	}
+	return TenantServiceImpl{repo: repo, clock: clock}, nil
+}
+
+func (t *TenantServiceImpl) CreateTenant(ctx context.Context, tenant Tenant) (Tenant, error) {
+	log.Println("creating tenant")
+
+	if !ValidTenantId(tenant.Id) {
+		return Tenant{}, ErrInvalidTenantId
+	}
+	if tenant.MaxUsers < 0 {
+		return Tenant{}, fmt.Errorf("max users cannot be negative")
+	}
+	if tenant.Name == "" {
+		tenant.Name = tenant.Id
+	}
+	tenant.CreatedAt = t.clock.Now()
+	tenant.CreatedBy, _ = PrincipalFromContext(ctx)
+
+	if err := t.repo.CreateTenant(ctx, tenant); err != nil {
+		return Tenant{}, fmt.Errorf("could not create tenant: %w", err)
+	}
+	return tenant, nil
+}
+
+func (t *TenantServiceImpl) GetTenant(ctx context.Context, id string) (Tenant, error) {
+	tenant, err := t.repo.GetTenantById(ctx, id)
+	if err != nil {
+		return Tenant{}, fmt.Errorf("could not fetch tenant: %w", err)
+	}
+	return tenant, nil
+}
+
+// ListTenants returns every tenant ordered by id
+func (t *TenantServiceImpl) ListTenants(ctx context.Context) ([]Tenant, error) {
+	tenants, err := t.repo.ListTenants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list tenants: %w", err)
+	}
+	sort.Slice(tenants, func(a, b int) bool {
+		return tenants[a].Id < tenants[b].Id
+	})
+	return tenants, nil
+}
+
+type TenantRepo interface {
+	// CreateTenant stores a new tenant, or returns ErrTenantExists
+	CreateTenant(ctx context.Context, tenant Tenant) error
+	GetTenantById(ctx context.Context, id string) (Tenant, error)
+	ListTenants(ctx context.Context) ([]Tenant, error)
+}
@@ -0,0 +1,80 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	mockDomain "api-demo/mock/domain"
+	"context"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_ValidTenantId(t *testing.T) {
+	for _, id := range []string{"a", "acme", "acme-2", "0"} {
+		assert.True(t, domain.ValidTenantId(id), id)
+	}
+	for _, id := range []string{"", "Acme", "-acme", "acme-", "ac.me", "ac me", strings.Repeat("a", 64)} {
+		assert.False(t, domain.ValidTenantId(id), id)
+	}
+}
+
+func Test_TenantFromContext(t *testing.T) {
+	_, err := domain.RequireTenant(context.Background())
+	assert.Equal(t, domain.ErrNoTenant, err)
+
+	_, err = domain.RequireTenant(domain.WithTenant(context.Background(), ""))
+	assert.Equal(t, domain.ErrNoTenant, err)
+
+	tenant, err := domain.RequireTenant(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, testTenant, tenant)
+}
+
+func TestTenantServiceImpl_CreateTenant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	tenantRepo := mockDomain.NewMockTenantRepo(ctrl)
+
+	_, err := domain.NewTenantServiceImpl(nil, domaintest.NewFakeClock(testNow))
+	assert.Equal(t, fmt.Errorf("cannot create tenant service, missing repo"), err)
+
+	tenantService, err := domain.NewTenantServiceImpl(tenantRepo, domaintest.NewFakeClock(testNow))
+	assert.NoError(t, err, "expected no error")
+
+	ctx := domain.WithPrincipal(context.Background(), "root")
+	expected := domain.Tenant{Id: "acme", Name: "acme", MaxUsers: 10, CreatedAt: testNow, CreatedBy: "root"}
+	tenantRepo.EXPECT().CreateTenant(ctx, expected).Return(nil)
+
+	tenant, err := tenantService.CreateTenant(ctx, domain.Tenant{Id: "acme", MaxUsers: 10})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, expected, tenant)
+
+	_, err = tenantService.CreateTenant(ctx, domain.Tenant{Id: "Acme"})
+	assert.Equal(t, domain.ErrInvalidTenantId, err)
+
+	_, err = tenantService.CreateTenant(ctx, domain.Tenant{Id: "acme", MaxUsers: -1})
+	assert.Error(t, err, "expected negative quota error")
+
+	tenantRepo.EXPECT().CreateTenant(ctx, gomock.Any()).Return(domain.ErrTenantExists{Id: "acme"})
+	_, err = tenantService.CreateTenant(ctx, domain.Tenant{Id: "acme"})
+	assert.ErrorIs(t, err, domain.ErrTenantExists{Id: "acme"})
+}
+
+func TestTenantServiceImpl_ListTenants(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	tenantRepo := mockDomain.NewMockTenantRepo(ctrl)
+
+	tenantService, err := domain.NewTenantServiceImpl(tenantRepo, domaintest.NewFakeClock(testNow))
+	assert.NoError(t, err, "expected no error")
+
+	ctx := context.Background()
+	tenantRepo.EXPECT().ListTenants(ctx).Return([]domain.Tenant{{Id: "globex"}, {Id: "acme"}}, nil)
+
+	tenants, err := tenantService.ListTenants(ctx)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []domain.Tenant{{Id: "acme"}, {Id: "globex"}}, tenants)
+}
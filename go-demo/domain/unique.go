@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+)
+
+// UniqueConstraint forbids two live users of a tenant from having the same
+// values of Fields, which are named as in filters, like "name" or
+// "attributes.employeeNumber". Users missing a value of one of the fields are
+// not constrained, and soft deleted users release their values
+type UniqueConstraint struct {
+	Fields          []string
+	CaseInsensitive bool
+}
+
+// ParseUniqueConstraints parses a comma separated list of constraints, each
+// a list of fields joined by +, with a :ci suffix making it case
+// insensitive, as in "name:ci,attributes.department+attributes.employeeNumber"
+func ParseUniqueConstraints(spec string) ([]UniqueConstraint, error) {
+	var constraints []UniqueConstraint
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var constraint UniqueConstraint
+		if strings.HasSuffix(part, ":ci") {
+			constraint.CaseInsensitive = true
+			part = strings.TrimSuffix(part, ":ci")
+		}
+		for _, field := range strings.Split(part, "+") {
+			field = strings.TrimSpace(field)
+			if _, ok := UserField(User{}, field); !ok {
+				return nil, fmt.Errorf("invalid unique constraint %q: unknown field %q", part, field)
+			}
+			constraint.Fields = append(constraint.Fields, field)
+		}
+		constraints = append(constraints, constraint)
+	}
+	return constraints, nil
+}
+
+// String returns the constraint in the form ParseUniqueConstraints reads
+func (c UniqueConstraint) String() string {
+	s := strings.Join(c.Fields, "+")
+	if c.CaseInsensitive {
+		s += ":ci"
+	}
+	return s
+}
+
+// Key returns the key user holds under the constraint, it holds none if it
+// is deleted or misses a value of one of the fields. Keys of different
+// tenants never clash
+func (c UniqueConstraint) Key(user User) (string, bool) {
+	if user.Deleted() {
+		return "", false
+	}
+	values := make([]string, 0, len(c.Fields)+1)
+	values = append(values, user.TenantId)
+	for _, field := range c.Fields {
+		value, _ := UserField(user, field)
+		if value == "" {
+			return "", false
+		}
+		if c.CaseInsensitive {
+			value = strings.ToLower(value)
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, "\x00"), true
+}
+
+// ErrConflict is returned by UserRepo implementations when saving a user
+// would violate a unique constraint held by another user
+type ErrConflict struct {
+	Constraint UniqueConstraint
+	ExistingId uuid.UUID `sensitive:"true"`
+}
+
+func (e ErrConflict) Error() string {
+	return fmt.Sprintf("user %s already has the same %s", Mask(e.ExistingId), e.Constraint.String())
+}
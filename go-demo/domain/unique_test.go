@@ -0,0 +1,51 @@
+package domain_test
+
+import (
+	"api-demo/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_ParseUniqueConstraints(t *testing.T) {
+	constraints, err := domain.ParseUniqueConstraints("name:ci, attributes.department+attributes.employeeNumber")
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []domain.UniqueConstraint{
+		{Fields: []string{"name"}, CaseInsensitive: true},
+		{Fields: []string{"attributes.department", "attributes.employeeNumber"}},
+	}, constraints)
+	assert.Equal(t, "name:ci", constraints[0].String())
+
+	constraints, err = domain.ParseUniqueConstraints("")
+	assert.NoError(t, err, "expected no error")
+	assert.Empty(t, constraints)
+
+	_, err = domain.ParseUniqueConstraints("name,email")
+	assert.Error(t, err, "expected unknown field to fail")
+}
+
+func TestUniqueConstraint_Key(t *testing.T) {
+	constraint := domain.UniqueConstraint{Fields: []string{"name", "attributes.department"}, CaseInsensitive: true}
+
+	user := newUser("Jane Doe", "user")
+	user.Attributes = map[string]any{"department": "Eng"}
+	key, ok := constraint.Key(user)
+	assert.True(t, ok, "expected user to hold a key")
+
+	other := newUser("JANE DOE", "admin")
+	other.Attributes = map[string]any{"department": "eng"}
+	otherKey, _ := constraint.Key(other)
+	assert.Equal(t, key, otherKey, "expected case to be ignored")
+
+	other.TenantId = "globex"
+	otherKey, _ = constraint.Key(other)
+	assert.NotEqual(t, key, otherKey, "expected tenants not to clash")
+
+	user.Attributes = nil
+	_, ok = constraint.Key(user)
+	assert.False(t, ok, "expected a missing value to hold no key")
+
+	other.DeletedAt = time.Now()
+	_, ok = constraint.Key(other)
+	assert.False(t, ok, "expected a deleted user to hold no key")
+}
@@ -6,60 +6,284 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"log"
+	"reflect"
+	"sync"
+	"time"
 )
 
+// User is a user of a tenant. Fields tagged sensitive hold personal data,
+// which Redact masks
 type User struct {
-	Id   uuid.UUID
-	Name string
-	Role string
+	Id uuid.UUID `sensitive:"true"`
+	// TenantId is the tenant the user belongs to
+	TenantId string
+	Name     string `sensitive:"true"`
+	Role     string
+	// ManagerId is the user this user reports to, uuid.Nil if none
+	ManagerId uuid.UUID `sensitive:"true"`
+	// Attributes are the custom attributes of the user, holding the canonical
+	// values of their AttributeSchema. Treat them as read-only, they are
+	// shared with the stored user
+	Attributes map[string]any `sensitive:"true"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	// CreatedBy and UpdatedBy are the principals that created and last
+	// modified the user, empty when the request was anonymous
+	CreatedBy string `sensitive:"true"`
+	UpdatedBy string `sensitive:"true"`
+	// DeletedAt is set when the user is soft deleted
+	DeletedAt time.Time
 }
 
+// Deleted reports whether the user is soft deleted
+func (u User) Deleted() bool {
+	return !u.DeletedAt.IsZero()
+}
+
+// DeletedFilter controls whether GetByProperty returns soft deleted users
+type DeletedFilter int
+
+const (
+	ExcludeDeleted DeletedFilter = iota
+	IncludeDeleted
+	OnlyDeleted
+)
+
 type UserProperties struct {
-	Name *string
+	Name *string `sensitive:"true"`
 	Role *string
+	// ManagerId matches the direct reports of a user, uuid.Nil matches users
+	// without a manager
+	ManagerId *uuid.UUID `sensitive:"true"`
+	// Attributes matches users whose custom attributes have these values, in
+	// the form of FormatAttribute
+	Attributes map[string]string `sensitive:"true"`
+	Deleted    DeletedFilter
+	// CreatedSince and UpdatedSince are inclusive, CreatedBefore and
+	// UpdatedBefore are exclusive
+	CreatedSince  *time.Time
+	CreatedBefore *time.Time
+	UpdatedSince  *time.Time
+	UpdatedBefore *time.Time
+	// Filter is a parsed filter expression users must also match
+	Filter Filter `sensitive:"true"`
+}
+
+// Matches checks if user has every property set in p
+func (p UserProperties) Matches(user User) bool {
+	return matchesUser(user, p)
 }
 
 type UpdateUser struct {
-	Name *string
+	Name *string `sensitive:"true"`
 	Role *string
+	// ManagerId set to uuid.Nil removes the manager
+	ManagerId *uuid.UUID `sensitive:"true"`
+	// Attributes replace the custom attributes of the user when not nil
+	Attributes map[string]any `sensitive:"true"`
 }
 
+// UserPatch computes the new state of a user from its current one. Only the
+// name, role, manager and attributes of the result are kept
+type UserPatch func(user User) (User, error)
+
+// NewUser returns a user with a random id. Users created through
+// UserService.CreateUser without an id get one from the service IDGenerator
 func NewUser(name string, role string) User {
 	return User{Name: name, Role: role, Id: uuid.New()}
 }
 
 var ErrBadUserId = errors.New("invalid user id")
 
+var ErrUserNotDeleted = errors.New("user is not deleted")
+
 type UserService interface {
 	CreateUser(ctx context.Context, user User) (User, error)
 	GetUserById(ctx context.Context, id uuid.UUID) (User, error)
 	Delete(ctx context.Context, id uuid.UUID) error
 	UpdateUser(ctx context.Context, id uuid.UUID, updateUser UpdateUser) (User, error)
+	// PatchUser applies patch to the current state of a user, no other change
+	// to the user can happen in between
+	PatchUser(ctx context.Context, id uuid.UUID, patch UserPatch) (User, error)
 	GetByProperty(ctx context.Context, up *UserProperties) ([]User, error)
+	Restore(ctx context.Context, id uuid.UUID) (User, error)
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error)
+	Search(ctx context.Context, query string, limit int) ([]User, error)
+	DirectReports(ctx context.Context, id uuid.UUID) ([]User, error)
+	Subtree(ctx context.Context, id uuid.UUID, maxDepth int) ([]Report, error)
+	ManagementChain(ctx context.Context, id uuid.UUID) ([]User, error)
+	History(ctx context.Context, id uuid.UUID) ([]UserVersion, error)
+	GetUserAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (User, error)
+	Revert(ctx context.Context, id uuid.UUID, version int) (User, error)
+	ExportPersonalData(ctx context.Context, id uuid.UUID) (PersonalData, error)
+	Erase(ctx context.Context, id uuid.UUID) (ErasureReceipt, error)
+	ErasureReceipts(ctx context.Context) ([]ErasureReceipt, error)
 }
 
 // UserServiceImpl is an implementation of UserService
 type UserServiceImpl struct {
-	repo UserRepo
+	repo    UserRepo
+	clock   Clock
+	ids     IDGenerator
+	search  UserSearchIndex
+	tenants TenantRepo
+	groups  GroupRepo
+	schema  AttributeSchema
+	history UserHistory
+	// erasures records the receipts of Erase
+	erasures ErasureLog
+	// idempotency keeps the responses Erase deletes along with the user
+	idempotency IdempotencyRepo
+
+	managerDeletePolicy ManagerDeletePolicy
+	// mu serializes the read-modify-write cycles of changes to existing users
+	mu *sync.Mutex
+}
+
+// UserServiceOption configures optional dependencies of UserServiceImpl
+type UserServiceOption func(*UserServiceImpl)
+
+// WithClock sets the clock used to timestamp changes, defaults to RealClock
+func WithClock(clock Clock) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.clock = clock
+	}
+}
+
+// WithIDGenerator sets the generator for ids of new users, defaults to
+// UUIDv4Generator
+func WithIDGenerator(ids IDGenerator) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.ids = ids
+	}
+}
+
+// WithSearchIndex enables Search, the service keeps index up to date with
+// every change it makes
+func WithSearchIndex(index UserSearchIndex) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.search = index
+	}
+}
+
+// WithTenants makes the service check that tenants exist and enforce their
+// quotas when users are created or restored
+func WithTenants(tenants TenantRepo) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.tenants = tenants
+	}
+}
+
+// WithGroups makes the service remove users from their groups when they are
+// deleted
+func WithGroups(groups GroupRepo) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.groups = groups
+	}
 }
 
 // NewUserServiceImpl returns a new instance of UserServiceImpl
-func NewUserServiceImpl(repo UserRepo) (UserServiceImpl, error) {
+func NewUserServiceImpl(repo UserRepo, opts ...UserServiceOption) (UserServiceImpl, error) {
 	if repo == nil {
 		return UserServiceImpl{},
 			fmt.Errorf("cannot create service, missing repo")
 	}
-	return UserServiceImpl{repo: repo}, nil
+	service := UserServiceImpl{
+		repo:                repo,
+		clock:               RealClock{},
+		ids:                 UUIDv4Generator{},
+		managerDeletePolicy: ReassignReports,
+		mu:                  new(sync.Mutex),
+	}
+	for _, opt := range opts {
+		opt(&service)
+	}
+	if service.clock == nil {
+		return UserServiceImpl{},
+			fmt.Errorf("cannot create service, missing clock")
+	}
+	if service.ids == nil {
+		return UserServiceImpl{},
+			fmt.Errorf("cannot create service, missing id generator")
+	}
+	if _, err := ParseManagerDeletePolicy(string(service.managerDeletePolicy)); err != nil {
+		return UserServiceImpl{},
+			fmt.Errorf("cannot create service: %w", err)
+	}
+	return service, nil
+}
+
+// touch records that the principal in ctx modified user just now
+func (u *UserServiceImpl) touch(ctx context.Context, user *User) {
+	principal, _ := PrincipalFromContext(ctx)
+	user.UpdatedAt = u.clock.Now()
+	user.UpdatedBy = principal
+}
+
+// checkQuota fails if the tenant does not exist or has no room for another
+// user. u.mu must be held, so that the count stays true until the user is saved
+func (u *UserServiceImpl) checkQuota(ctx context.Context, tenantId string) error {
+	if u.tenants == nil {
+		return nil
+	}
+	tenant, err := u.tenants.GetTenantById(ctx, tenantId)
+	if err != nil {
+		return err
+	}
+	if tenant.MaxUsers == 0 {
+		return nil
+	}
+	users, err := u.repo.QueryUsers(ctx, UserProperties{})
+	if err != nil {
+		return fmt.Errorf("could not count users: %w", err)
+	}
+	if len(users) >= tenant.MaxUsers {
+		return ErrQuotaExceeded{Tenant: tenantId, MaxUsers: tenant.MaxUsers}
+	}
+	return nil
+}
+
+// WithAttributeSchema sets the custom attributes users can carry, defaults to
+// none
+func WithAttributeSchema(schema AttributeSchema) UserServiceOption {
+	return func(u *UserServiceImpl) {
+		u.schema = schema
+	}
 }
 
+// CreateUser creates user in the tenant of ctx
 func (u *UserServiceImpl) CreateUser(ctx context.Context, user User) (User, error) {
 	log.Println("creating user")
 
+	tenant, err := RequireTenant(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.checkQuota(ctx, tenant); err != nil {
+		return User{}, fmt.Errorf("could not create user: %w", err)
+	}
+
 	if user.Id == uuid.Nil {
-		return User{}, ErrBadUserId
+		user.Id = u.ids.NewID()
 	}
+	user.TenantId = tenant
 
-	err := u.repo.SaveUser(ctx, user)
+	if err := u.validateManager(ctx, user, user.ManagerId); err != nil {
+		return User{}, fmt.Errorf("could not create user: %w", err)
+	}
+	if user.Attributes, err = u.schema.Validate(user.Attributes); err != nil {
+		return User{}, fmt.Errorf("could not create user: %w", err)
+	}
+
+	u.touch(ctx, &user)
+	user.CreatedAt = user.UpdatedAt
+	user.CreatedBy = user.UpdatedBy
+
+	err = u.save(ctx, user)
 	if err != nil {
 		return User{}, fmt.Errorf("could not create user: %w", err)
 	}
@@ -74,7 +298,7 @@ func (u *UserServiceImpl) GetUserById(ctx context.Context, id uuid.UUID) (User,
 		return User{}, ErrBadUserId
 	}
 
-	user, err := u.repo.GetUserById(ctx, id)
+	user, err := u.getActiveUser(ctx, id)
 	if err != nil {
 		return User{}, fmt.Errorf("could not fetch user by id: %w", err)
 	}
@@ -82,29 +306,231 @@ func (u *UserServiceImpl) GetUserById(ctx context.Context, id uuid.UUID) (User,
 	return user, nil
 }
 
+// getUser fetches a user of the tenant of ctx. Repos already scope lookups
+// to the tenant, the check guards against one that does not
+func (u *UserServiceImpl) getUser(ctx context.Context, id uuid.UUID) (User, error) {
+	tenant, err := RequireTenant(ctx)
+	if err != nil {
+		return User{}, err
+	}
+	user, err := u.repo.GetUserById(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if user.TenantId != tenant {
+		return User{}, ErrUserIdNotFound{Id: id}
+	}
+	return user, nil
+}
+
+// getActiveUser fetches a user, treating soft deleted users as not found
+func (u *UserServiceImpl) getActiveUser(ctx context.Context, id uuid.UUID) (User, error) {
+	user, err := u.getUser(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if user.Deleted() {
+		return User{}, ErrUserIdNotFound{Id: id}
+	}
+	return user, nil
+}
+
 func (u *UserServiceImpl) Delete(ctx context.Context, id uuid.UUID) error {
 	log.Println("deleting user by id")
 
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
 	if id == uuid.Nil {
 		return ErrBadUserId
 	}
 
-	err := u.repo.DeleteUser(ctx, id)
+	user, err := u.getActiveUser(ctx, id)
 	if err != nil {
 		return fmt.Errorf("could not delete user by id: %w", err)
 	}
 
+	if err := u.handleReports(ctx, user); err != nil {
+		return fmt.Errorf("could not delete user by id: %w", err)
+	}
+
+	u.touch(ctx, &user)
+	user.DeletedAt = user.UpdatedAt
+	err = u.save(ctx, user)
+	if err != nil {
+		return fmt.Errorf("could not delete user by id: %w", err)
+	}
+
+	if u.groups != nil {
+		if err := u.groups.RemoveUserMemberships(ctx, id); err != nil {
+			return fmt.Errorf("could not remove deleted user from groups: %w", err)
+		}
+	}
+
 	return nil
 }
 
+func (u *UserServiceImpl) PatchUser(ctx context.Context, id uuid.UUID, patch UserPatch) (User, error) {
+	log.Println("patching user")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.patch(ctx, id, patch)
+}
+
+// patch applies patch to an active user, u.mu must be held
+func (u *UserServiceImpl) patch(ctx context.Context, id uuid.UUID, patch UserPatch) (User, error) {
+	if id == uuid.Nil {
+		return User{}, ErrBadUserId
+	}
+
+	user, err := u.getActiveUser(ctx, id)
+	if err != nil {
+		return User{}, fmt.Errorf("could fetch user by id: %w", err)
+	}
+
+	patched, err := patch(user)
+	if err != nil {
+		return User{}, fmt.Errorf("could not patch user: %w", err)
+	}
+	if patched.ManagerId != user.ManagerId {
+		if err := u.validateManager(ctx, user, patched.ManagerId); err != nil {
+			return User{}, fmt.Errorf("could not patch user: %w", err)
+		}
+	}
+	// only changed attributes are checked, so that users stored before a
+	// schema change can still be patched
+	if !reflect.DeepEqual(patched.Attributes, user.Attributes) {
+		if patched.Attributes, err = u.schema.Validate(patched.Attributes); err != nil {
+			return User{}, fmt.Errorf("could not patch user: %w", err)
+		}
+	}
+	user.Name = patched.Name
+	user.Role = patched.Role
+	user.ManagerId = patched.ManagerId
+	user.Attributes = patched.Attributes
+
+	u.touch(ctx, &user)
+
+	err = u.save(ctx, user)
+	if err != nil {
+		return User{}, fmt.Errorf("could not update user: %w", err)
+	}
+
+	return user, nil
+}
+
+// Restore undoes the soft deletion of a user
+func (u *UserServiceImpl) Restore(ctx context.Context, id uuid.UUID) (User, error) {
+	log.Println("restoring user by id")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if id == uuid.Nil {
+		return User{}, ErrBadUserId
+	}
+
+	user, err := u.getUser(ctx, id)
+	if err != nil {
+		return User{}, fmt.Errorf("could not fetch user by id: %w", err)
+	}
+
+	if !user.Deleted() {
+		return User{}, ErrUserNotDeleted
+	}
+
+	if err := u.checkQuota(ctx, user.TenantId); err != nil {
+		return User{}, fmt.Errorf("could not restore user: %w", err)
+	}
+
+	// the manager may have left, or the hierarchy changed, in the meantime
+	err = u.validateManager(ctx, user, user.ManagerId)
+	var managerNotFound ErrManagerNotFound
+	if errors.Is(err, ErrManagerCycle) || errors.As(err, &managerNotFound) {
+		user.ManagerId = uuid.Nil
+	} else if err != nil {
+		return User{}, fmt.Errorf("could not restore user: %w", err)
+	}
+
+	u.touch(ctx, &user)
+	user.DeletedAt = time.Time{}
+	err = u.save(ctx, user)
+	if err != nil {
+		return User{}, fmt.Errorf("could not restore user: %w", err)
+	}
+
+	return user, nil
+}
+
+// PurgeDeleted permanently removes users of the tenant of ctx soft deleted
+// before deletedBefore, returning how many were removed
+func (u *UserServiceImpl) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	log.Println("purging deleted users")
+
+	if _, err := RequireTenant(ctx); err != nil {
+		return 0, err
+	}
+
+	users, err := u.repo.QueryUsers(ctx, UserProperties{Deleted: OnlyDeleted})
+	if err != nil {
+		return 0, fmt.Errorf("could not query deleted users: %w", err)
+	}
+
+	purged := 0
+	for _, user := range users {
+		if !user.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+		ok, err := u.purgeUser(ctx, user.Id, deletedBefore)
+		if err != nil {
+			return purged, fmt.Errorf("could not purge user: %w", err)
+		}
+		if ok {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeUser hard deletes the user with id if it is still deleted since
+// before deletedBefore, as it may have been restored since it was queried
+func (u *UserServiceImpl) purgeUser(ctx context.Context, id uuid.UUID, deletedBefore time.Time) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.getUser(ctx, id)
+	if errors.As(err, &ErrUserIdNotFound{}) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !user.Deleted() || !user.DeletedAt.Before(deletedBefore) {
+		return false, nil
+	}
+	if err := u.repo.DeleteUser(ctx, id); err != nil {
+		return false, err
+	}
+	if u.search != nil {
+		u.search.RemoveUser(id)
+	}
+	return true, nil
+}
+
 func (u *UserServiceImpl) UpdateUser(ctx context.Context, id uuid.UUID, updateUser UpdateUser) (User, error) {
 	log.Println("updating user")
 
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
 	if id == uuid.Nil {
 		return User{}, ErrBadUserId
 	}
 
-	user, err := u.repo.GetUserById(ctx, id)
+	user, err := u.getActiveUser(ctx, id)
 	if err != nil {
 		return User{}, fmt.Errorf("could fetch user by id: %w", err)
 	}
@@ -117,7 +543,22 @@ func (u *UserServiceImpl) UpdateUser(ctx context.Context, id uuid.UUID, updateUs
 		user.Role = *updateUser.Role
 	}
 
-	err = u.repo.SaveUser(ctx, user)
+	if updateUser.ManagerId != nil && *updateUser.ManagerId != user.ManagerId {
+		if err := u.validateManager(ctx, user, *updateUser.ManagerId); err != nil {
+			return User{}, fmt.Errorf("could not update user: %w", err)
+		}
+		user.ManagerId = *updateUser.ManagerId
+	}
+
+	if updateUser.Attributes != nil {
+		if user.Attributes, err = u.schema.Validate(updateUser.Attributes); err != nil {
+			return User{}, fmt.Errorf("could not update user: %w", err)
+		}
+	}
+
+	u.touch(ctx, &user)
+
+	err = u.save(ctx, user)
 	if err != nil {
 		return User{}, fmt.Errorf("could not update user: %w", err)
 	}
@@ -125,21 +566,121 @@ func (u *UserServiceImpl) UpdateUser(ctx context.Context, id uuid.UUID, updateUs
 	return user, nil
 }
 
-func (u *UserServiceImpl) GetByProperty(ctx context.Context, up *UserProperties) ([]User, error) {
-	log.Printf("fetching users by property %#v", up)
+// save stores user, then brings the search index and the history in line
+// with it. Once the user is stored the write went through, so a version the
+// history could not record is only logged
+func (u *UserServiceImpl) save(ctx context.Context, user User) error {
+	if err := u.repo.SaveUser(ctx, user); err != nil {
+		return err
+	}
+	u.indexUser(user)
+	if u.history != nil {
+		if err := u.history.Record(ctx, user); err != nil {
+			log.Println("could not record history", err.Error())
+		}
+	}
+	return nil
+}
+
+// indexUser brings the search index in line with user, which is removed
+// from it when soft deleted
+func (u *UserServiceImpl) indexUser(user User) {
+	if u.search == nil {
+		return
+	}
+	if user.Deleted() {
+		u.search.RemoveUser(user.Id)
+		return
+	}
+	u.search.IndexUser(user)
+}
+
+// Search returns the users whose name best matches query, tolerating
+// partial words and typos
+func (u *UserServiceImpl) Search(ctx context.Context, query string, limit int) ([]User, error) {
+	log.Println("searching users")
+
+	if u.search == nil {
+		return nil, ErrSearchUnavailable
+	}
+	tenant, err := RequireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	hits := u.search.Search(tenant, query, limit)
+	users := make([]User, 0, len(hits))
+	for _, hit := range hits {
+		user, err := u.getActiveUser(ctx, hit.Id)
+		if errors.As(err, &ErrUserIdNotFound{}) {
+			// the index lags behind changes made outside the service
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch user by id: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// ReindexSearch rebuilds the search index from the repo, for example after
+// users were loaded into it without going through the service
+func (u *UserServiceImpl) ReindexSearch(ctx context.Context) error {
+	if u.search == nil {
+		return ErrSearchUnavailable
+	}
 
 	users, err := u.repo.ListUsers(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not list stored users: %w", err)
+		return fmt.Errorf("could not list stored users: %w", err)
+	}
+	for _, user := range users {
+		u.indexUser(user)
 	}
 
-	if up != nil {
-		users = filterUsers(users, *up)
+	return nil
+}
+
+func (u *UserServiceImpl) GetByProperty(ctx context.Context, up *UserProperties) ([]User, error) {
+	log.Printf("fetching users by property %s", Redact(up))
+
+	if up == nil {
+		up = &UserProperties{}
+	}
+	for name := range up.Attributes {
+		if _, ok := u.schema.Def(name); !ok {
+			return nil, ErrInvalidAttribute{Name: name, Msg: "unknown attribute"}
+		}
+	}
+	if up.Filter != nil {
+		if err := u.schema.CheckFilter(up.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	users, err := u.repo.QueryUsers(ctx, *up)
+	if err != nil {
+		return nil, fmt.Errorf("could not query stored users: %w", err)
 	}
 
 	return users, nil
 }
 
+// FilterUsers returns the users matching properties, for UserRepo
+// implementations that cannot answer QueryUsers any smarter than a scan.
+// It reuses the backing array of users and does not preserve order
+func FilterUsers(users []User, properties UserProperties) []User {
+	return filterUsers(users, properties)
+}
+
 // filterUsers filters users without preserving order
 func filterUsers(users []User, properties UserProperties) []User {
 	i := 0
@@ -158,6 +699,17 @@ func filterUsers(users []User, properties UserProperties) []User {
 
 // matchesUser checks if a single user matches against UserProperties
 func matchesUser(user User, properties UserProperties) bool {
+	switch properties.Deleted {
+	case ExcludeDeleted:
+		if user.Deleted() {
+			return false
+		}
+	case OnlyDeleted:
+		if !user.Deleted() {
+			return false
+		}
+	}
+
 	if properties.Role != nil && *properties.Role != user.Role {
 		return false
 	}
@@ -166,20 +718,62 @@ func matchesUser(user User, properties UserProperties) bool {
 		return false
 	}
 
+	if properties.ManagerId != nil && *properties.ManagerId != user.ManagerId {
+		return false
+	}
+
+	for name, value := range properties.Attributes {
+		if attribute, ok := user.Attributes[name]; !ok || FormatAttribute(attribute) != value {
+			return false
+		}
+	}
+
+	if !inTimeRange(user.CreatedAt, properties.CreatedSince, properties.CreatedBefore) {
+		return false
+	}
+
+	if !inTimeRange(user.UpdatedAt, properties.UpdatedSince, properties.UpdatedBefore) {
+		return false
+	}
+
+	if properties.Filter != nil && !properties.Filter.Matches(user) {
+		return false
+	}
+
+	return true
+}
+
+// inTimeRange checks if t is within [since, before), a nil bound is open
+func inTimeRange(t time.Time, since, before *time.Time) bool {
+	if since != nil && t.Before(*since) {
+		return false
+	}
+	if before != nil && !t.Before(*before) {
+		return false
+	}
 	return true
 }
 
 type ErrUserIdNotFound struct {
-	Id uuid.UUID
+	Id uuid.UUID `sensitive:"true"`
 }
 
 func (e ErrUserIdNotFound) Error() string {
-	return fmt.Sprintf("could not find user with id %s", e.Id.String())
+	return fmt.Sprintf("could not find user with id %s", Mask(e.Id))
 }
 
+// UserRepo stores users. Implementations scope every method to the tenant of
+// ctx, failing with ErrNoTenant when there is none, and never return or
+// change the users of another tenant
 type UserRepo interface {
+	// SaveUser fails with ErrConflict if user would break a unique constraint
+	// the repo was configured with, checking and saving atomically
 	SaveUser(ctx context.Context, user User) error
 	DeleteUser(ctx context.Context, id uuid.UUID) error
+	// EraseUser deletes a user along with any past state of it the repo keeps
+	EraseUser(ctx context.Context, id uuid.UUID) error
 	GetUserById(ctx context.Context, id uuid.UUID) (User, error)
 	ListUsers(ctx context.Context) ([]User, error)
+	// QueryUsers returns the users matching every property set in up
+	QueryUsers(ctx context.Context, up UserProperties) ([]User, error)
 }
@@ -2,18 +2,42 @@ package domain_test
 
 import (
 	"api-demo/domain"
+	"api-demo/domain/domaintest"
 	mockDomain "api-demo/mock/domain"
 	"context"
 	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
+	"time"
 )
 
+var testNow = time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+const testTenant = "acme"
+
+var testCtx = domain.WithTenant(context.Background(), testTenant)
+
+// reportsOf is the query the service makes for the direct reports of a user
+func reportsOf(id uuid.UUID) domain.UserProperties {
+	return domain.UserProperties{ManagerId: &id}
+}
+
+// newUser returns a new user of testTenant
+func newUser(name, role string) domain.User {
+	user := domain.NewUser(name, role)
+	user.TenantId = testTenant
+	return user
+}
+
 func Test_NewUserServiceImpl(t *testing.T) {
 	_, err := domain.NewUserServiceImpl(nil)
 	assert.Equal(t, fmt.Errorf("cannot create service, missing repo"), err, "expected error bad user id")
+
+	_, err = domain.NewUserServiceImpl(mockDomain.NewMockUserRepo(gomock.NewController(t)), domain.WithClock(nil))
+	assert.Equal(t, fmt.Errorf("cannot create service, missing clock"), err, "expected error missing clock")
 }
 
 func TestUserServiceImpl_CreateUser(t *testing.T) {
@@ -21,17 +45,53 @@ func TestUserServiceImpl_CreateUser(t *testing.T) {
 
 	userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-	user := domain.NewUser("Shashank Pachava", "admin")
+	user := newUser("Shashank Pachava", "admin")
+
+	expectedUser := user
+	expectedUser.CreatedAt = testNow
+	expectedUser.CreatedBy = "admin@acme.com"
+	expectedUser.UpdatedAt = testNow
+	expectedUser.UpdatedBy = "admin@acme.com"
+
+	ctx := domain.WithPrincipal(testCtx, "admin@acme.com")
 
-	userRepo.EXPECT().SaveUser(gomock.Any(), gomock.Any()).Return(nil)
+	userRepo.EXPECT().SaveUser(ctx, expectedUser).Return(nil)
 
-	userService, err := domain.NewUserServiceImpl(userRepo)
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 	assert.NoError(t, err, "expected no error")
 
-	savedUser, err := userService.CreateUser(context.Background(), user)
+	savedUser, err := userService.CreateUser(ctx, user)
 	assert.NoError(t, err, "expected no error")
 
-	assert.Equal(t, savedUser, user, "expected saved user to be the same")
+	assert.Equal(t, expectedUser, savedUser, "expected saved user to be the same")
+}
+
+func TestUserServiceImpl_CreateUser_GeneratesId(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	expectedUser := domain.User{
+		Id:        domaintest.SequentialID(1),
+		TenantId:  testTenant,
+		Name:      "Shashank Pachava",
+		Role:      "admin",
+		CreatedAt: testNow,
+		UpdatedAt: testNow,
+	}
+
+	userRepo.EXPECT().SaveUser(testCtx, expectedUser).Return(nil)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithIDGenerator(domaintest.NewSequentialIDGenerator()),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	savedUser, err := userService.CreateUser(testCtx, domain.User{Name: "Shashank Pachava", Role: "admin"})
+	assert.NoError(t, err, "expected no error")
+
+	assert.Equal(t, expectedUser, savedUser, "expected saved user to be the same")
 }
 
 func TestUserServiceImpl_GetUserById(t *testing.T) {
@@ -39,14 +99,14 @@ func TestUserServiceImpl_GetUserById(t *testing.T) {
 
 	userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-	user := domain.NewUser("Shashank Pachava", "admin")
+	user := newUser("Shashank Pachava", "admin")
 
-	userRepo.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil)
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
 
-	userService, err := domain.NewUserServiceImpl(userRepo)
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 	assert.NoError(t, err, "expected no error")
 
-	savedUser, err := userService.GetUserById(context.Background(), user.Id)
+	savedUser, err := userService.GetUserById(testCtx, user.Id)
 	assert.NoError(t, err, "expected no error")
 
 	assert.Equal(t, savedUser, user, "expected saved user to be the same")
@@ -57,10 +117,10 @@ func TestUserServiceImpl_GetUserById_BadUserId(t *testing.T) {
 
 	userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-	userService, err := domain.NewUserServiceImpl(userRepo)
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 	assert.NoError(t, err, "expected no error")
 
-	_, err = userService.GetUserById(context.Background(), uuid.Nil)
+	_, err = userService.GetUserById(testCtx, uuid.Nil)
 	assert.Equal(t, domain.ErrBadUserId, err, "expected error")
 }
 
@@ -69,10 +129,10 @@ func TestUserServiceImpl_UpdateUser_BadUserId(t *testing.T) {
 
 	userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-	userService, err := domain.NewUserServiceImpl(userRepo)
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 	assert.NoError(t, err, "expected no error")
 
-	_, err = userService.UpdateUser(context.Background(), uuid.Nil, domain.UpdateUser{})
+	_, err = userService.UpdateUser(testCtx, uuid.Nil, domain.UpdateUser{})
 	assert.Equal(t, domain.ErrBadUserId, err, "expected error")
 }
 
@@ -82,21 +142,21 @@ func TestUserServiceImpl_UpdateUser(t *testing.T) {
 
 		userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-		user := domain.NewUser("Shashank Pachava", "admin")
-		modifiedUser := domain.User{Id: user.Id, Name: "Shank", Role: user.Role}
+		user := newUser("Shashank Pachava", "admin")
+		modifiedUser := domain.User{Id: user.Id, TenantId: user.TenantId, Name: "Shank", Role: user.Role, UpdatedAt: testNow}
 
-		userRepo.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil)
+		userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
 		userRepo.EXPECT().
 			SaveUser(
-				context.Background(),
+				testCtx,
 				modifiedUser,
 			).
 			Return(nil)
 
-		userService, err := domain.NewUserServiceImpl(userRepo)
+		userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 		assert.NoError(t, err, "expected no error")
 
-		savedModifiedUser, err := userService.UpdateUser(context.Background(), user.Id, domain.UpdateUser{
+		savedModifiedUser, err := userService.UpdateUser(testCtx, user.Id, domain.UpdateUser{
 			Name: &modifiedUser.Name,
 		})
 		assert.Equal(t, modifiedUser, savedModifiedUser, "different user found than expected")
@@ -107,21 +167,21 @@ func TestUserServiceImpl_UpdateUser(t *testing.T) {
 
 		userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-		user := domain.NewUser("Shashank Pachava", "admin")
-		modifiedUser := domain.User{Id: user.Id, Name: user.Name, Role: "role"}
+		user := newUser("Shashank Pachava", "admin")
+		modifiedUser := domain.User{Id: user.Id, TenantId: user.TenantId, Name: user.Name, Role: "role", UpdatedAt: testNow}
 
-		userRepo.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil)
+		userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
 		userRepo.EXPECT().
 			SaveUser(
-				context.Background(),
+				testCtx,
 				modifiedUser,
 			).
 			Return(nil)
 
-		userService, err := domain.NewUserServiceImpl(userRepo)
+		userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 		assert.NoError(t, err, "expected no error")
 
-		savedModifiedUser, err := userService.UpdateUser(context.Background(), user.Id, domain.UpdateUser{
+		savedModifiedUser, err := userService.UpdateUser(testCtx, user.Id, domain.UpdateUser{
 			Role: &modifiedUser.Role,
 		})
 		assert.Equal(t, modifiedUser, savedModifiedUser, "different user found than expected")
@@ -132,21 +192,21 @@ func TestUserServiceImpl_UpdateUser(t *testing.T) {
 
 		userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-		user := domain.NewUser("Shashank Pachava", "admin")
-		modifiedUser := domain.User{Id: user.Id, Name: "Shank", Role: "role"}
+		user := newUser("Shashank Pachava", "admin")
+		modifiedUser := domain.User{Id: user.Id, TenantId: user.TenantId, Name: "Shank", Role: "role", UpdatedAt: testNow}
 
-		userRepo.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil)
+		userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
 		userRepo.EXPECT().
 			SaveUser(
-				context.Background(),
+				testCtx,
 				modifiedUser,
 			).
 			Return(nil)
 
-		userService, err := domain.NewUserServiceImpl(userRepo)
+		userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 		assert.NoError(t, err, "expected no error")
 
-		savedModifiedUser, err := userService.UpdateUser(context.Background(), user.Id, domain.UpdateUser{
+		savedModifiedUser, err := userService.UpdateUser(testCtx, user.Id, domain.UpdateUser{
 			Name: &modifiedUser.Name,
 			Role: &modifiedUser.Role,
 		})
@@ -158,48 +218,126 @@ func TestUserServiceImpl_UpdateUser(t *testing.T) {
 
 		userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-		user := domain.NewUser("Shashank Pachava", "admin")
-		modifiedUser := domain.User{Id: user.Id, Name: user.Name, Role: user.Role}
+		user := newUser("Shashank Pachava", "admin")
+		modifiedUser := domain.User{Id: user.Id, TenantId: user.TenantId, Name: user.Name, Role: user.Role, UpdatedAt: testNow}
 
-		userRepo.EXPECT().GetUserById(context.Background(), user.Id).Return(user, nil)
+		userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
 		userRepo.EXPECT().
 			SaveUser(
-				context.Background(),
+				testCtx,
 				modifiedUser,
 			).
 			Return(nil)
 
-		userService, err := domain.NewUserServiceImpl(userRepo)
+		userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 		assert.NoError(t, err, "expected no error")
 
-		savedModifiedUser, err := userService.UpdateUser(context.Background(), user.Id, domain.UpdateUser{})
+		savedModifiedUser, err := userService.UpdateUser(testCtx, user.Id, domain.UpdateUser{})
 		assert.Equal(t, modifiedUser, savedModifiedUser, "different user found than expected")
 	})
 }
 
+func TestUserServiceImpl_PatchUser(t *testing.T) {
+	t.Run("Only name and role are patched", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+		user := newUser("Shashank Pachava", "admin")
+		modifiedUser := domain.User{Id: user.Id, TenantId: user.TenantId, Name: "Shank", Role: "", UpdatedAt: testNow}
+
+		userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+		userRepo.EXPECT().SaveUser(testCtx, modifiedUser).Return(nil)
+
+		userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
+		assert.NoError(t, err, "expected no error")
+
+		patched, err := userService.PatchUser(testCtx, user.Id, func(user domain.User) (domain.User, error) {
+			return domain.User{Id: uuid.New(), Name: "Shank", CreatedBy: "intruder"}, nil
+		})
+		assert.NoError(t, err, "expected no error")
+		assert.Equal(t, modifiedUser, patched, "different user found than expected")
+	})
+
+	t.Run("Failed patch is not saved", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+		user := newUser("Shashank Pachava", "admin")
+		patchErr := fmt.Errorf("test failed")
+
+		userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+
+		userService, err := domain.NewUserServiceImpl(userRepo)
+		assert.NoError(t, err, "expected no error")
+
+		_, err = userService.PatchUser(testCtx, user.Id, func(user domain.User) (domain.User, error) {
+			return domain.User{}, patchErr
+		})
+		assert.ErrorIs(t, err, patchErr)
+	})
+
+	t.Run("Concurrent patches are serialized", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+		stored := newUser("", "user")
+		id := stored.Id
+		userRepo.EXPECT().GetUserById(gomock.Any(), id).
+			DoAndReturn(func(context.Context, uuid.UUID) (domain.User, error) { return stored, nil }).
+			AnyTimes()
+		userRepo.EXPECT().SaveUser(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, user domain.User) error {
+				stored = user
+				return nil
+			}).
+			AnyTimes()
+
+		userService, err := domain.NewUserServiceImpl(userRepo)
+		assert.NoError(t, err, "expected no error")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := userService.PatchUser(testCtx, id, func(user domain.User) (domain.User, error) {
+					user.Name += "x"
+					return user, nil
+				})
+				assert.NoError(t, err, "expected no error")
+			}()
+		}
+		wg.Wait()
+
+		assert.Len(t, stored.Name, 50, "lost update")
+	})
+}
+
 func TestUserServiceImpl_GetByProperty(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-	user1 := domain.NewUser("Shashank Pachava", "admin")
-	user2 := domain.NewUser("Shank", "user")
-	user3 := domain.NewUser("Sasi", "user")
-	user4 := domain.NewUser("Sridhar", "user")
+	user1 := newUser("Shashank Pachava", "admin")
+	user2 := newUser("Shank", "user")
+	user3 := newUser("Sasi", "user")
+	user4 := newUser("Sridhar", "user")
 
-	userRepo.EXPECT().ListUsers(context.Background()).Return([]domain.User{
-		user1,
-		user2,
-		user3,
-		user4,
-	}, nil)
+	role := "admin"
 
-	userService, err := domain.NewUserServiceImpl(userRepo)
-	assert.NoError(t, err, "expected no error")
+	userRepo.EXPECT().
+		QueryUsers(testCtx, domain.UserProperties{Role: &role}).
+		DoAndReturn(func(_ context.Context, up domain.UserProperties) ([]domain.User, error) {
+			return domain.FilterUsers([]domain.User{user1, user2, user3, user4}, up), nil
+		})
 
-	role := "admin"
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
+	assert.NoError(t, err, "expected no error")
 
-	filteredUsers, err := userService.GetByProperty(context.Background(), &domain.UserProperties{
+	filteredUsers, err := userService.GetByProperty(testCtx, &domain.UserProperties{
 		Role: &role,
 	})
 	assert.Equal(t, []domain.User{
@@ -212,25 +350,353 @@ func TestUserServiceImpl_Delete(t *testing.T) {
 
 	userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-	user := domain.NewUser("Shashank Pachava", "admin")
+	user := newUser("Shashank Pachava", "admin")
 
-	userRepo.EXPECT().DeleteUser(context.Background(), user.Id).Return(nil)
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+	userRepo.EXPECT().QueryUsers(testCtx, reportsOf(user.Id)).Return(nil, nil)
+	userRepo.EXPECT().
+		SaveUser(testCtx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, deletedUser domain.User) error {
+			assert.Equal(t, testNow, deletedUser.DeletedAt, "expected user to be soft deleted")
+			assert.Equal(t, user.Id, deletedUser.Id)
+			return nil
+		})
 
-	userService, err := domain.NewUserServiceImpl(userRepo)
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 	assert.NoError(t, err, "expected no error")
 
-	err = userService.Delete(context.Background(), user.Id)
+	err = userService.Delete(testCtx, user.Id)
 	assert.NoError(t, err, "expected no error")
 }
 
+func TestUserServiceImpl_Delete_RemovesMemberships(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	groupRepo := mockDomain.NewMockGroupRepo(ctrl)
+
+	user := newUser("Shashank Pachava", "admin")
+
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+	userRepo.EXPECT().QueryUsers(testCtx, reportsOf(user.Id)).Return(nil, nil)
+	gomock.InOrder(
+		userRepo.EXPECT().SaveUser(testCtx, gomock.Any()).Return(nil),
+		groupRepo.EXPECT().RemoveUserMemberships(testCtx, user.Id).Return(nil),
+	)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithGroups(groupRepo),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	err = userService.Delete(testCtx, user.Id)
+	assert.NoError(t, err, "expected no error")
+}
+
+func TestUserServiceImpl_Delete_AlreadyDeleted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	user := newUser("Shashank Pachava", "admin")
+	user.DeletedAt = time.Now()
+
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
+	assert.NoError(t, err, "expected no error")
+
+	err = userService.Delete(testCtx, user.Id)
+	assert.ErrorIs(t, err, domain.ErrUserIdNotFound{Id: user.Id})
+}
+
+func TestUserServiceImpl_GetUserById_Deleted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	user := newUser("Shashank Pachava", "admin")
+	user.DeletedAt = time.Now()
+
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
+	assert.NoError(t, err, "expected no error")
+
+	_, err = userService.GetUserById(testCtx, user.Id)
+	assert.ErrorIs(t, err, domain.ErrUserIdNotFound{Id: user.Id})
+}
+
+func TestUserServiceImpl_Restore(t *testing.T) {
+	t.Run("Deleted user", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+		user := newUser("Shashank Pachava", "admin")
+		deletedUser := user
+		deletedUser.DeletedAt = time.Now()
+
+		restoredUser := user
+		restoredUser.UpdatedAt = testNow
+
+		userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(deletedUser, nil)
+		userRepo.EXPECT().SaveUser(testCtx, restoredUser).Return(nil)
+
+		userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
+		assert.NoError(t, err, "expected no error")
+
+		savedUser, err := userService.Restore(testCtx, user.Id)
+		assert.NoError(t, err, "expected no error")
+		assert.Equal(t, restoredUser, savedUser, "different user found than expected")
+	})
+
+	t.Run("Active user", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+		user := newUser("Shashank Pachava", "admin")
+
+		userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+
+		userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
+		assert.NoError(t, err, "expected no error")
+
+		_, err = userService.Restore(testCtx, user.Id)
+		assert.Equal(t, domain.ErrUserNotDeleted, err, "expected error")
+	})
+}
+
+func TestUserServiceImpl_PurgeDeleted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	searchIndex := mockDomain.NewMockUserSearchIndex(ctrl)
+
+	cutoff := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	old := newUser("Shank", "user")
+	old.DeletedAt = cutoff.Add(-time.Hour)
+	recent := newUser("Sasi", "user")
+	recent.DeletedAt = cutoff.Add(time.Hour)
+
+	userRepo.EXPECT().
+		QueryUsers(testCtx, domain.UserProperties{Deleted: domain.OnlyDeleted}).
+		Return([]domain.User{old, recent}, nil)
+	userRepo.EXPECT().GetUserById(testCtx, old.Id).Return(old, nil)
+	userRepo.EXPECT().DeleteUser(testCtx, old.Id).Return(nil)
+	searchIndex.EXPECT().RemoveUser(old.Id)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithSearchIndex(searchIndex),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	purged, err := userService.PurgeDeleted(testCtx, cutoff)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 1, purged)
+
+	// users restored or gone since they were queried are left alone
+	restored := old
+	restored.DeletedAt = time.Time{}
+	userRepo.EXPECT().
+		QueryUsers(testCtx, domain.UserProperties{Deleted: domain.OnlyDeleted}).
+		Return([]domain.User{old, recent}, nil)
+	userRepo.EXPECT().GetUserById(testCtx, old.Id).Return(restored, nil)
+
+	purged, err = userService.PurgeDeleted(testCtx, cutoff)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 0, purged)
+}
+
+func TestPurgeJob_RunOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userService := mockDomain.NewMockUserService(ctrl)
+	tenantService := mockDomain.NewMockTenantService(ctrl)
+
+	clock := domaintest.NewFakeClock(testNow)
+
+	_, err := domain.NewPurgeJob(userService, tenantService, clock, 0, time.Hour)
+	assert.Error(t, err, "expected invalid retention error")
+
+	_, err = domain.NewPurgeJob(userService, tenantService, nil, time.Hour, time.Hour)
+	assert.Error(t, err, "expected missing clock error")
+
+	_, err = domain.NewPurgeJob(userService, nil, clock, time.Hour, time.Hour)
+	assert.Error(t, err, "expected missing tenants error")
+
+	job, err := domain.NewPurgeJob(userService, tenantService, clock, time.Hour, time.Hour)
+	assert.NoError(t, err, "expected no error")
+
+	ctx := context.Background()
+	tenantService.EXPECT().ListTenants(ctx).Return([]domain.Tenant{{Id: "acme"}, {Id: "globex"}, {Id: "initech"}}, nil)
+	userService.EXPECT().PurgeDeleted(domain.WithTenant(ctx, "acme"), testNow.Add(-time.Hour)).Return(2, nil)
+	userService.EXPECT().PurgeDeleted(domain.WithTenant(ctx, "globex"), testNow.Add(-time.Hour)).Return(0, fmt.Errorf("boom"))
+	userService.EXPECT().PurgeDeleted(domain.WithTenant(ctx, "initech"), testNow.Add(-time.Hour)).Return(1, nil)
+
+	// a failing tenant does not stop the others from being purged
+	purged, err := job.RunOnce(ctx)
+	assert.EqualError(t, err, "could not purge tenants globex: boom")
+	assert.Equal(t, 3, purged)
+}
+
 func TestUserServiceImpl_Delete_BadUserId(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	userRepo := mockDomain.NewMockUserRepo(ctrl)
 
-	userService, err := domain.NewUserServiceImpl(userRepo)
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
 	assert.NoError(t, err, "expected no error")
 
-	err = userService.Delete(context.Background(), uuid.Nil)
+	err = userService.Delete(testCtx, uuid.Nil)
 	assert.Equal(t, domain.ErrBadUserId, err, "expected error")
 }
+
+func TestUserServiceImpl_Search(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	searchIndex := mockDomain.NewMockUserSearchIndex(ctrl)
+
+	user1 := newUser("Shashank Pachava", "admin")
+	user2 := newUser("Shank", "user")
+	stale := newUser("Sasi", "user")
+
+	searchIndex.EXPECT().Search(testTenant, "sha", domain.DefaultSearchLimit).Return([]domain.SearchHit{
+		{Id: user2.Id, Score: 0.7},
+		{Id: stale.Id, Score: 0.5},
+		{Id: user1.Id, Score: 0.5},
+	})
+	userRepo.EXPECT().GetUserById(testCtx, user2.Id).Return(user2, nil)
+	userRepo.EXPECT().GetUserById(testCtx, stale.Id).Return(domain.User{}, domain.ErrUserIdNotFound{Id: stale.Id})
+	userRepo.EXPECT().GetUserById(testCtx, user1.Id).Return(user1, nil)
+
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithSearchIndex(searchIndex))
+	assert.NoError(t, err, "expected no error")
+
+	users, err := userService.Search(testCtx, "sha", 0)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, []domain.User{user2, user1}, users, "expected ranked users without stale hits")
+}
+
+func TestUserServiceImpl_Search_Unavailable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userService, err := domain.NewUserServiceImpl(mockDomain.NewMockUserRepo(ctrl))
+	assert.NoError(t, err, "expected no error")
+
+	_, err = userService.Search(testCtx, "sha", 0)
+	assert.Equal(t, domain.ErrSearchUnavailable, err, "expected error")
+}
+
+func TestUserServiceImpl_SearchIndexUpdates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	searchIndex := mockDomain.NewMockUserSearchIndex(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithIDGenerator(domaintest.NewSequentialIDGenerator()),
+		domain.WithSearchIndex(searchIndex),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	created := domain.User{
+		Id:        domaintest.SequentialID(1),
+		TenantId:  testTenant,
+		Name:      "Shashank Pachava",
+		Role:      "admin",
+		CreatedAt: testNow,
+		UpdatedAt: testNow,
+	}
+	renamed := created
+	renamed.Name = "Shank"
+
+	gomock.InOrder(
+		userRepo.EXPECT().SaveUser(testCtx, created).Return(nil),
+		searchIndex.EXPECT().IndexUser(created),
+		userRepo.EXPECT().GetUserById(testCtx, created.Id).Return(created, nil),
+		userRepo.EXPECT().SaveUser(testCtx, renamed).Return(nil),
+		searchIndex.EXPECT().IndexUser(renamed),
+		userRepo.EXPECT().GetUserById(testCtx, created.Id).Return(renamed, nil),
+		userRepo.EXPECT().QueryUsers(testCtx, reportsOf(created.Id)).Return(nil, nil),
+		userRepo.EXPECT().SaveUser(testCtx, gomock.Any()).Return(nil),
+		searchIndex.EXPECT().RemoveUser(created.Id),
+	)
+
+	_, err = userService.CreateUser(testCtx, domain.User{Name: created.Name, Role: created.Role})
+	assert.NoError(t, err, "expected no error")
+	_, err = userService.UpdateUser(testCtx, created.Id, domain.UpdateUser{Name: &renamed.Name})
+	assert.NoError(t, err, "expected no error")
+	assert.NoError(t, userService.Delete(testCtx, created.Id), "expected no error")
+}
+
+func TestUserServiceImpl_RequiresTenant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
+	assert.NoError(t, err, "expected no error")
+
+	ctx := context.Background()
+	_, err = userService.CreateUser(ctx, newUser("Sasi", "user"))
+	assert.Equal(t, domain.ErrNoTenant, err)
+	_, err = userService.GetUserById(ctx, uuid.New())
+	assert.ErrorIs(t, err, domain.ErrNoTenant)
+	_, err = userService.PurgeDeleted(ctx, testNow)
+	assert.Equal(t, domain.ErrNoTenant, err)
+}
+
+func TestUserServiceImpl_GetUserById_OtherTenant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+
+	// a repo that does not scope lookups still cannot leak other tenants
+	user := domain.NewUser("Sasi", "user")
+	user.TenantId = "globex"
+	userRepo.EXPECT().GetUserById(testCtx, user.Id).Return(user, nil)
+
+	userService, err := domain.NewUserServiceImpl(userRepo, domain.WithClock(domaintest.NewFakeClock(testNow)))
+	assert.NoError(t, err, "expected no error")
+
+	_, err = userService.GetUserById(testCtx, user.Id)
+	assert.ErrorIs(t, err, domain.ErrUserIdNotFound{Id: user.Id})
+}
+
+func TestUserServiceImpl_CreateUser_Quota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	userRepo := mockDomain.NewMockUserRepo(ctrl)
+	tenantRepo := mockDomain.NewMockTenantRepo(ctrl)
+
+	userService, err := domain.NewUserServiceImpl(userRepo,
+		domain.WithClock(domaintest.NewFakeClock(testNow)),
+		domain.WithTenants(tenantRepo),
+	)
+	assert.NoError(t, err, "expected no error")
+
+	tenantRepo.EXPECT().GetTenantById(testCtx, testTenant).Return(domain.Tenant{Id: testTenant, MaxUsers: 2}, nil).Times(2)
+	userRepo.EXPECT().QueryUsers(testCtx, domain.UserProperties{}).Return([]domain.User{newUser("a", "user")}, nil)
+	userRepo.EXPECT().SaveUser(testCtx, gomock.Any()).Return(nil)
+
+	_, err = userService.CreateUser(testCtx, domain.User{Name: "b", Role: "user"})
+	assert.NoError(t, err, "expected no error")
+
+	userRepo.EXPECT().QueryUsers(testCtx, domain.UserProperties{}).Return([]domain.User{newUser("a", "user"), newUser("b", "user")}, nil)
+
+	_, err = userService.CreateUser(testCtx, domain.User{Name: "c", Role: "user"})
+	assert.ErrorIs(t, err, domain.ErrQuotaExceeded{Tenant: testTenant, MaxUsers: 2})
+
+	ctx := domain.WithTenant(context.Background(), "unknown")
+	tenantRepo.EXPECT().GetTenantById(ctx, "unknown").Return(domain.Tenant{}, domain.ErrTenantNotFound{Id: "unknown"})
+
+	_, err = userService.CreateUser(ctx, domain.User{Name: "c", Role: "user"})
+	assert.ErrorIs(t, err, domain.ErrTenantNotFound{Id: "unknown"})
+}
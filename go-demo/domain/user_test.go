@@ -3,12 +3,17 @@ package domain
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func stringPtr(s string) *string {
 	return &s
 }
 
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
 func Test_matchesUser(t *testing.T) {
 
 	type args struct {
@@ -84,6 +89,84 @@ func Test_matchesUser(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "exclude deleted",
+			args: args{
+				user: User{
+					Name:      "Shashank Pachava",
+					Role:      "admin",
+					DeletedAt: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+				},
+				properties: UserProperties{},
+			},
+			want: false,
+		},
+		{
+			name: "include deleted",
+			args: args{
+				user: User{
+					Name:      "Shashank Pachava",
+					Role:      "admin",
+					DeletedAt: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+				},
+				properties: UserProperties{Deleted: IncludeDeleted},
+			},
+			want: true,
+		},
+		{
+			name: "only deleted",
+			args: args{
+				user: User{
+					Name: "Shashank Pachava",
+					Role: "admin",
+				},
+				properties: UserProperties{Deleted: OnlyDeleted},
+			},
+			want: false,
+		},
+		{
+			name: "created in range",
+			args: args{
+				user: User{
+					Name:      "Shashank Pachava",
+					Role:      "admin",
+					CreatedAt: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+				},
+				properties: UserProperties{
+					CreatedSince:  timePtr(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)),
+					CreatedBefore: timePtr(time.Date(2022, 10, 2, 0, 0, 0, 0, time.UTC)),
+				},
+			},
+			want: true,
+		},
+		{
+			name: "created before range",
+			args: args{
+				user: User{
+					Name:      "Shashank Pachava",
+					Role:      "admin",
+					CreatedAt: time.Date(2022, 9, 30, 0, 0, 0, 0, time.UTC),
+				},
+				properties: UserProperties{
+					CreatedSince: timePtr(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)),
+				},
+			},
+			want: false,
+		},
+		{
+			name: "updated at exclusive bound",
+			args: args{
+				user: User{
+					Name:      "Shashank Pachava",
+					Role:      "admin",
+					UpdatedAt: time.Date(2022, 10, 2, 0, 0, 0, 0, time.UTC),
+				},
+				properties: UserProperties{
+					UpdatedBefore: timePtr(time.Date(2022, 10, 2, 0, 0, 0, 0, time.UTC)),
+				},
+			},
+			want: false,
+		},
 		{
 			name: "don't match role",
 			args: args{
@@ -97,6 +180,31 @@ func Test_matchesUser(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "attributes",
+			args: args{
+				user: User{
+					Name:       "Shashank Pachava",
+					Attributes: map[string]any{"department": "eng", "remote": true},
+				},
+				properties: UserProperties{
+					Attributes: map[string]string{"department": "eng", "remote": "true"},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "missing attribute",
+			args: args{
+				user: User{
+					Name: "Shashank Pachava",
+				},
+				properties: UserProperties{
+					Attributes: map[string]string{"department": ""},
+				},
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
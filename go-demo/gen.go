@@ -2,4 +2,11 @@ package main
 
 //go:generate rm -rf mock/domain
 //go:generate go run github.com/golang/mock/mockgen@v1.6.0 -source=domain/user.go -destination=mock/domain/user.go
+//go:generate go run github.com/golang/mock/mockgen@v1.6.0 -source=domain/idempotency.go -destination=mock/domain/idempotency.go
+//go:generate go run github.com/golang/mock/mockgen@v1.6.0 -source=domain/search.go -destination=mock/domain/search.go
+//go:generate go run github.com/golang/mock/mockgen@v1.6.0 -source=domain/tenant.go -destination=mock/domain/tenant.go
+//go:generate go run github.com/golang/mock/mockgen@v1.6.0 -source=domain/group.go -destination=mock/domain/group.go
+//go:generate go run github.com/golang/mock/mockgen@v1.6.0 -source=domain/history.go -destination=mock/domain/history.go
+//go:generate go run github.com/golang/mock/mockgen@v1.6.0 -source=domain/keys.go -destination=mock/domain/keys.go
+//go:generate go run github.com/golang/mock/mockgen@v1.6.0 -source=domain/privacy.go -destination=mock/domain/privacy.go
 //go:generate go run github.com/swaggo/swag/cmd/swag@latest init
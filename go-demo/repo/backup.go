@@ -0,0 +1,191 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// backupFormat is the version of the backup archive format
+const backupFormat = 1
+
+// backupJSON is the form of a backup archive. Checksum is the sha256 of the
+// users exactly as they are in the archive, and Count how many there are
+type backupJSON struct {
+	Format    int             `json:"format"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Count     int             `json:"count"`
+	Checksum  string          `json:"checksum"`
+	Users     json.RawMessage `json:"users"`
+}
+
+// Backup is every user of every tenant at a point in time
+type Backup struct {
+	CreatedAt time.Time
+	Users     []domain.User
+}
+
+func backupChecksum(users []byte) string {
+	sum := sha256.Sum256(users)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// EncodeBackup returns the archive of backup
+func EncodeBackup(backup Backup) ([]byte, error) {
+	j := make([]userJSON, 0, len(backup.Users))
+	for _, user := range backup.Users {
+		j = append(j, toUserJSON(user))
+	}
+	users, err := json.Marshal(j)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode users: %w", err)
+	}
+	return json.Marshal(backupJSON{
+		Format:    backupFormat,
+		CreatedAt: backup.CreatedAt,
+		Count:     len(j),
+		Checksum:  backupChecksum(users),
+		Users:     users,
+	})
+}
+
+// DecodeBackup checks the format, checksum and count of an archive, and that
+// every user in it has an id of its own and a tenant, failing with
+// domain.ErrInvalidBackup otherwise
+func DecodeBackup(archive []byte) (Backup, error) {
+	var j backupJSON
+	if err := json.Unmarshal(archive, &j); err != nil {
+		return Backup{}, domain.ErrInvalidBackup{Msg: err.Error()}
+	}
+	if j.Format != backupFormat {
+		return Backup{}, domain.ErrInvalidBackup{Msg: fmt.Sprintf("unknown format %d", j.Format)}
+	}
+	if checksum := backupChecksum(j.Users); checksum != j.Checksum {
+		return Backup{}, domain.ErrInvalidBackup{Msg: fmt.Sprintf("checksum %s does not match the users, whose checksum is %s", j.Checksum, checksum)}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(j.Users))
+	decoder.UseNumber()
+	var users []userJSON
+	if err := decoder.Decode(&users); err != nil {
+		return Backup{}, domain.ErrInvalidBackup{Msg: err.Error()}
+	}
+	if len(users) != j.Count {
+		return Backup{}, domain.ErrInvalidBackup{Msg: fmt.Sprintf("expected %d users, found %d", j.Count, len(users))}
+	}
+
+	backup := Backup{CreatedAt: j.CreatedAt, Users: make([]domain.User, 0, len(users))}
+	ids := make(map[uuid.UUID]struct{}, len(users))
+	for n, user := range users {
+		if user.Id == uuid.Nil || user.TenantId == "" {
+			return Backup{}, domain.ErrInvalidBackup{Msg: fmt.Sprintf("user %d has no id or tenant", n)}
+		}
+		if _, ok := ids[user.Id]; ok {
+			return Backup{}, domain.ErrInvalidBackup{Msg: fmt.Sprintf("user %d has the id of another", n)}
+		}
+		ids[user.Id] = struct{}{}
+		backup.Users = append(backup.Users, user.user())
+	}
+	return backup, nil
+}
+
+type BackupConfig struct {
+	Clock domain.Clock
+	// Erasures, when set, keeps users erased since a backup was taken out of
+	// its restore
+	Erasures domain.ErasureLog
+	// Restored is called after a restore with the users replaced and the
+	// users restored, to rebuild what is derived from them, like the search
+	// index
+	Restored func(ctx context.Context, previous, restored []domain.User) error
+}
+
+// Backups takes and restores backup archives of a user repo implementing
+// domain.Snapshotter
+type Backups struct {
+	users  domain.Snapshotter
+	config BackupConfig
+}
+
+func NewBackups(users domain.UserRepo, config BackupConfig) (Backups, error) {
+	if users == nil {
+		return Backups{}, fmt.Errorf("cannot create backups, missing user repo")
+	}
+	snapshotter, ok := users.(domain.Snapshotter)
+	if !ok {
+		return Backups{}, fmt.Errorf("cannot create backups: %w", domain.ErrSnapshotUnsupported)
+	}
+	if config.Clock == nil {
+		return Backups{}, fmt.Errorf("cannot create backups, missing clock")
+	}
+	return Backups{users: snapshotter, config: config}, nil
+}
+
+// Backup returns the archive of a snapshot of every user
+func (b *Backups) Backup(ctx context.Context) ([]byte, error) {
+	users, err := b.users.SnapshotUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not snapshot users: %w", err)
+	}
+	return EncodeBackup(Backup{CreatedAt: b.config.Clock.Now(), Users: users})
+}
+
+// Restore validates archive with DecodeBackup, then replaces every user
+// with its users but the erased ones, returning how many
+func (b *Backups) Restore(ctx context.Context, archive []byte) (int, error) {
+	backup, err := DecodeBackup(archive)
+	if err != nil {
+		return 0, err
+	}
+	users, err := b.withoutErased(ctx, backup.Users)
+	if err != nil {
+		return 0, err
+	}
+	previous, err := b.users.SnapshotUsers(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not snapshot users: %w", err)
+	}
+	if err := b.users.RestoreUsers(ctx, users); err != nil {
+		return 0, fmt.Errorf("could not restore users: %w", err)
+	}
+	if b.config.Restored != nil {
+		if err := b.config.Restored(ctx, previous, users); err != nil {
+			return 0, fmt.Errorf("users were restored, but not what derives from them: %w", err)
+		}
+	}
+	return len(users), nil
+}
+
+// withoutErased returns users without those having an erasure receipt in
+// their tenant, as an erasure cannot be undone
+func (b *Backups) withoutErased(ctx context.Context, users []domain.User) ([]domain.User, error) {
+	if b.config.Erasures == nil {
+		return users, nil
+	}
+	erased := make(map[string]map[uuid.UUID]struct{})
+	kept := make([]domain.User, 0, len(users))
+	for _, user := range users {
+		ids, ok := erased[user.TenantId]
+		if !ok {
+			receipts, err := b.config.Erasures.ListReceipts(domain.WithTenant(ctx, user.TenantId))
+			if err != nil {
+				return nil, fmt.Errorf("could not list erasure receipts: %w", err)
+			}
+			ids = make(map[uuid.UUID]struct{}, len(receipts))
+			for _, receipt := range receipts {
+				ids[receipt.UserId] = struct{}{}
+			}
+			erased[user.TenantId] = ids
+		}
+		if _, ok := ids[user.Id]; !ok {
+			kept = append(kept, user)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBackupArchive(t *testing.T) {
+	sasi := newUser("Sasi", "user")
+	sasi.Attributes = map[string]any{"level": int64(3), "remote": true}
+	sasi.CreatedAt, sasi.UpdatedAt = eventTime, eventTime
+	globex := newUser("Shashank Pachava", "admin")
+	globex.TenantId = "globex"
+
+	archive, err := EncodeBackup(Backup{CreatedAt: eventTime, Users: []domain.User{sasi, globex}})
+	assert.NoError(t, err)
+	backup, err := DecodeBackup(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, Backup{CreatedAt: eventTime, Users: []domain.User{sasi, globex}}, backup)
+
+	duplicated, err := EncodeBackup(Backup{Users: []domain.User{sasi, sasi}})
+	assert.NoError(t, err)
+	for name, data := range map[string][]byte{
+		"tampered":       bytes.Replace(archive, []byte("Sasi"), []byte("Sam"), 1),
+		"unknown format": bytes.Replace(archive, []byte(`"format":1`), []byte(`"format":2`), 1),
+		"count":          bytes.Replace(archive, []byte(`"count":2`), []byte(`"count":1`), 1),
+		"truncated":      archive[:len(archive)/2],
+		"duplicated id":  duplicated,
+	} {
+		_, err := DecodeBackup(data)
+		assert.ErrorAs(t, err, &domain.ErrInvalidBackup{}, name)
+	}
+}
+
+func TestBackups(t *testing.T) {
+	constraint := domain.UniqueConstraint{Fields: []string{"name"}}
+	source := NewInMemUserRepo(constraint)
+	sasi := newUser("Sasi", "user")
+	shashank := newUser("Shashank Pachava", "admin")
+	assert.NoError(t, source.SaveUser(testCtx, sasi))
+	assert.NoError(t, source.SaveUser(testCtx, shashank))
+
+	sourceBackups, err := NewBackups(&source, BackupConfig{Clock: domaintest.NewFakeClock(eventTime)})
+	assert.NoError(t, err)
+	archive, err := sourceBackups.Backup(testCtx)
+	assert.NoError(t, err)
+
+	target := NewInMemUserRepo(constraint)
+	stale := newUser("Jim", "user")
+	assert.NoError(t, target.SaveUser(testCtx, stale))
+	var previous, restored []domain.User
+	targetBackups, err := NewBackups(&target, BackupConfig{
+		Clock: domaintest.NewFakeClock(eventTime),
+		Restored: func(_ context.Context, p, r []domain.User) error {
+			previous, restored = p, r
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = targetBackups.Restore(testCtx, archive[:len(archive)-2])
+	assert.ErrorAs(t, err, &domain.ErrInvalidBackup{})
+	users, err := target.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{stale}, users, "expected nothing to be replaced by an invalid backup")
+
+	count, err := targetBackups.Restore(testCtx, archive)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	users, err = target.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []domain.User{sasi, shashank}, users)
+	assert.Equal(t, []domain.User{stale}, previous)
+	assert.Len(t, restored, 2)
+
+	sam := newUser("Sasi", "user")
+	conflicting, err := EncodeBackup(Backup{Users: []domain.User{sasi, sam}})
+	assert.NoError(t, err)
+	_, err = targetBackups.Restore(testCtx, conflicting)
+	assert.ErrorAs(t, err, &domain.ErrConflict{}, "expected unique constraints to hold on restore")
+}
+
+func TestBackups_Erased(t *testing.T) {
+	source := NewInMemUserRepo()
+	sasi := newUser("Sasi", "user")
+	shashank := newUser("Shashank Pachava", "admin")
+	assert.NoError(t, source.SaveUser(testCtx, sasi))
+	assert.NoError(t, source.SaveUser(testCtx, shashank))
+	erasures := NewInMemErasureLog()
+	backups, err := NewBackups(&source, BackupConfig{Clock: domaintest.NewFakeClock(eventTime), Erasures: &erasures})
+	assert.NoError(t, err)
+	archive, err := backups.Backup(testCtx)
+	assert.NoError(t, err)
+
+	// sasi is erased after the backup was taken
+	assert.NoError(t, source.EraseUser(testCtx, sasi.Id))
+	_, err = erasures.AppendReceipt(testCtx, domain.ErasureReceipt{Id: uuid.New(), TenantId: testTenant, UserId: sasi.Id, Erased: []string{"profile"}})
+	assert.NoError(t, err)
+
+	count, err := backups.Restore(testCtx, archive)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	users, err := source.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{shashank}, users, "expected an erased user not to be restored")
+}
+
+func TestBackups_WhileWriting(t *testing.T) {
+	store := NewInMemEventStore()
+	userRepo, err := NewEventSourcedUserRepo(&store, EventSourcedConfig{})
+	assert.NoError(t, err)
+	backups, err := NewBackups(&userRepo, BackupConfig{Clock: domaintest.NewFakeClock(eventTime)})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 100; n++ {
+			// every save changes name and role, two events
+			user := newUser("Sasi", "user")
+			_ = userRepo.SaveUser(testCtx, user)
+			user.Name, user.Role = "Sasi K", "admin"
+			_ = userRepo.SaveUser(testCtx, user)
+		}
+	}()
+	for n := 0; n < 20; n++ {
+		archive, err := backups.Backup(testCtx)
+		assert.NoError(t, err)
+		backup, err := DecodeBackup(archive)
+		assert.NoError(t, err)
+		for _, user := range backup.Users {
+			assert.Equal(t, user.Name == "Sasi K", user.Role == "admin", "expected no save to be half applied")
+		}
+	}
+	wg.Wait()
+}
+
+func TestEventSourcedUserRepo_RestoreUsers(t *testing.T) {
+	dir := t.TempDir()
+	userRepo, store := openFileRepo(t, dir, EventSourcedConfig{})
+
+	stale := newUser("Jim", "user")
+	assert.NoError(t, userRepo.SaveUser(testCtx, stale))
+	sasi := newUser("Sasi", "user")
+	sasi.CreatedAt, sasi.UpdatedAt = eventTime, eventTime
+	assert.NoError(t, userRepo.RestoreUsers(testCtx, []domain.User{sasi}))
+	assert.NoError(t, store.Close())
+
+	reopened, _ := openFileRepo(t, dir, EventSourcedConfig{})
+	users, err := reopened.SnapshotUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{sasi}, users, "expected the restore to be persisted")
+}
+
+func TestEncryptingUserRepo_Snapshot(t *testing.T) {
+	inMemUserRepo := NewInMemUserRepo()
+	userRepo, err := NewEncryptingUserRepo(&inMemUserRepo, EncryptionConfig{Keyring: testKeyring(t, "k1", "k1")})
+	assert.NoError(t, err)
+	cachingUserRepo, err := NewCachingUserRepo(&userRepo, CacheConfig{Size: 10, Clock: domaintest.NewFakeClock(eventTime)})
+	assert.NoError(t, err)
+
+	sasi := newUser("Sasi", "user")
+	assert.NoError(t, cachingUserRepo.SaveUser(testCtx, sasi))
+	_, err = cachingUserRepo.GetUserById(testCtx, sasi.Id)
+	assert.NoError(t, err)
+
+	users, err := cachingUserRepo.SnapshotUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.True(t, strings.HasPrefix(users[0].Name, sealedPrefix), "expected snapshots to stay sealed")
+
+	renamed := users[0]
+	sealed, err := userRepo.seal(userRepo.currentKeyring(), domain.User{Id: sasi.Id, TenantId: testTenant, Name: "Sasi K", Role: "user"})
+	assert.NoError(t, err)
+	renamed.Name = sealed.Name
+	assert.NoError(t, cachingUserRepo.RestoreUsers(testCtx, []domain.User{renamed}))
+	user, err := cachingUserRepo.GetUserById(testCtx, sasi.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sasi K", user.Name, "expected the cache to be dropped on restore")
+}
@@ -0,0 +1,301 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the number of users CachingUserRepo keeps by default
+const DefaultCacheSize = 10_000
+
+// CacheConfig configures CachingUserRepo
+type CacheConfig struct {
+	// Size is the maximum number of cached users, least recently used ones are
+	// evicted first. Defaults to DefaultCacheSize
+	Size int
+	// TTL bounds how long a user stays cached, 0 keeps users until they are
+	// evicted or invalidated
+	TTL   time.Duration
+	Clock domain.Clock
+}
+
+// CacheStats counts the outcomes of cached lookups
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// cacheKey scopes cached users to a tenant, so a lookup never sees the user
+// of another tenant even if the wrapped repo is not scoped
+type cacheKey struct {
+	tenant string
+	id     uuid.UUID
+}
+
+type cacheEntry struct {
+	key       cacheKey
+	user      domain.User
+	expiresAt time.Time
+}
+
+// cacheCall is a lookup of the wrapped repo that concurrent misses wait for
+type cacheCall struct {
+	done chan struct{}
+	user domain.User
+	err  error
+	// stale is set when the user changes while the lookup is running, so its
+	// result must not be cached
+	stale bool
+}
+
+// CachingUserRepo is a read-through cache of GetUserById in front of another
+// domain.UserRepo. Writes go to the wrapped repo and invalidate the user, and
+// concurrent misses on a user share a single lookup, which each of them can
+// stop waiting for without cancelling it for the others
+type CachingUserRepo struct {
+	repo   domain.UserRepo
+	config CacheConfig
+
+	mu      *sync.Mutex
+	entries map[cacheKey]*list.Element
+	// lru holds the cached users, most recently used first
+	lru   *list.List
+	calls map[cacheKey]*cacheCall
+	stats *CacheStats
+}
+
+func NewCachingUserRepo(repo domain.UserRepo, config CacheConfig) (CachingUserRepo, error) {
+	if repo == nil {
+		return CachingUserRepo{}, fmt.Errorf("cannot create caching repo, missing repo")
+	}
+	if config.Size <= 0 {
+		config.Size = DefaultCacheSize
+	}
+	if config.Clock == nil {
+		config.Clock = domain.RealClock{}
+	}
+	return CachingUserRepo{
+		repo:    repo,
+		config:  config,
+		mu:      new(sync.Mutex),
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+		calls:   make(map[cacheKey]*cacheCall),
+		stats:   new(CacheStats),
+	}, nil
+}
+
+// Stats returns the statistics since the repo was created
+func (c *CachingUserRepo) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return *c.stats
+}
+
+// Len returns the number of cached users
+func (c *CachingUserRepo) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// add caches user, evicting the least recently used users over the size
+// limit. The lock must be held
+func (c *CachingUserRepo) add(key cacheKey, user domain.User) {
+	entry := &cacheEntry{key: key, user: user}
+	if c.config.TTL > 0 {
+		entry.expiresAt = c.config.Clock.Now().Add(c.config.TTL)
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.config.Size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops a cached user, the lock must be held
+func (c *CachingUserRepo) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// invalidate drops any cached copy of the user and keeps lookups running
+// since before the change from caching their result
+func (c *CachingUserRepo) invalidate(key cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	if call, ok := c.calls[key]; ok {
+		call.stale = true
+		delete(c.calls, key)
+	}
+}
+
+// invalidateAll drops every cached user and keeps running lookups from
+// caching their result
+func (c *CachingUserRepo) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, el := range c.entries {
+		c.remove(el)
+	}
+	for key, call := range c.calls {
+		call.stale = true
+		delete(c.calls, key)
+	}
+}
+
+func (c *CachingUserRepo) GetUserById(ctx context.Context, id uuid.UUID) (domain.User, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return domain.User{}, err
+	}
+	key := cacheKey{tenant: tenant, id: id}
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if entry.expiresAt.IsZero() || c.config.Clock.Now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return entry.user, nil
+		}
+		c.remove(el)
+		c.stats.Evictions++
+	}
+	c.stats.Misses++
+
+	call, ok := c.calls[key]
+	if !ok {
+		call = &cacheCall{done: make(chan struct{})}
+		c.calls[key] = call
+		// the lookup is shared, so the caller that started it giving up must
+		// not fail the others
+		go c.lookup(detachedContext{ctx}, key, call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.user, call.err
+	case <-ctx.Done():
+		return domain.User{}, ctx.Err()
+	}
+}
+
+// lookup runs call, reading the user of key from the wrapped repo and caching
+// it unless it changed in the meantime
+func (c *CachingUserRepo) lookup(ctx context.Context, key cacheKey, call *cacheCall) {
+	call.user, call.err = c.repo.GetUserById(ctx, key.id)
+
+	c.mu.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	if call.err == nil && !call.stale {
+		c.add(key, call.user)
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// detachedContext keeps the values of a context, like its tenant, but is
+// never cancelled with it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key any) any {
+	return d.parent.Value(key)
+}
+
+// SaveUser invalidates the user even when the wrapped repo fails, as the
+// write may still have happened
+func (c *CachingUserRepo) SaveUser(ctx context.Context, user domain.User) error {
+	err := c.repo.SaveUser(ctx, user)
+	if tenant, ok := domain.TenantFromContext(ctx); ok {
+		c.invalidate(cacheKey{tenant: tenant, id: user.Id})
+	}
+	return err
+}
+
+func (c *CachingUserRepo) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	err := c.repo.DeleteUser(ctx, id)
+	if tenant, ok := domain.TenantFromContext(ctx); ok {
+		c.invalidate(cacheKey{tenant: tenant, id: id})
+	}
+	return err
+}
+
+func (c *CachingUserRepo) EraseUser(ctx context.Context, id uuid.UUID) error {
+	err := c.repo.EraseUser(ctx, id)
+	if tenant, ok := domain.TenantFromContext(ctx); ok {
+		c.invalidate(cacheKey{tenant: tenant, id: id})
+	}
+	return err
+}
+
+func (c *CachingUserRepo) ListUsers(ctx context.Context) ([]domain.User, error) {
+	return c.repo.ListUsers(ctx)
+}
+
+func (c *CachingUserRepo) QueryUsers(ctx context.Context, up domain.UserProperties) ([]domain.User, error) {
+	return c.repo.QueryUsers(ctx, up)
+}
+
+// SnapshotUsers reads past the cache, which may not hold every user
+func (c *CachingUserRepo) SnapshotUsers(ctx context.Context) ([]domain.User, error) {
+	snapshotter, ok := c.repo.(domain.Snapshotter)
+	if !ok {
+		return nil, domain.ErrSnapshotUnsupported
+	}
+	return snapshotter.SnapshotUsers(ctx)
+}
+
+func (c *CachingUserRepo) RestoreUsers(ctx context.Context, users []domain.User) error {
+	snapshotter, ok := c.repo.(domain.Snapshotter)
+	if !ok {
+		return domain.ErrSnapshotUnsupported
+	}
+	err := snapshotter.RestoreUsers(ctx, users)
+	c.invalidateAll()
+	return err
+}
+
+// ApplyChange passes a replicated change on to the wrapped repo, and
+// invalidates the user it changes
+func (c *CachingUserRepo) ApplyChange(ctx context.Context, change domain.Change) error {
+	err := applyChange(ctx, c.repo, change)
+	c.invalidate(cacheKey{tenant: change.TenantId, id: change.UserId})
+	return err
+}
@@ -0,0 +1,277 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRepo counts lookups and can hold them until released
+type countingRepo struct {
+	InMemUserRepo
+	lookups *int64
+	// hold, when set, is waited on by every lookup after reading the user
+	hold chan struct{}
+}
+
+func newCountingRepo() countingRepo {
+	return countingRepo{InMemUserRepo: NewInMemUserRepo(), lookups: new(int64)}
+}
+
+func (r *countingRepo) GetUserById(ctx context.Context, id uuid.UUID) (domain.User, error) {
+	atomic.AddInt64(r.lookups, 1)
+	user, err := r.InMemUserRepo.GetUserById(ctx, id)
+	if r.hold != nil {
+		<-r.hold
+	}
+	if ctx.Err() != nil {
+		return domain.User{}, ctx.Err()
+	}
+	return user, err
+}
+
+func TestCachingUserRepo_GetUserById(t *testing.T) {
+	backend := newCountingRepo()
+	cache, err := NewCachingUserRepo(&backend, CacheConfig{Size: 2})
+	assert.NoError(t, err)
+	ctx := testCtx
+
+	user := newUser("Sasi", "user")
+	assert.NoError(t, cache.SaveUser(ctx, user))
+
+	for i := 0; i < 3; i++ {
+		got, err := cache.GetUserById(ctx, user.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, user, got)
+	}
+	assert.Equal(t, int64(1), *backend.lookups)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1}, cache.Stats())
+
+	// missing users are not cached
+	missing := uuid.New()
+	for i := 0; i < 2; i++ {
+		_, err = cache.GetUserById(ctx, missing)
+		assert.Equal(t, domain.ErrUserIdNotFound{Id: missing}, err)
+	}
+	assert.Equal(t, int64(3), *backend.lookups)
+}
+
+func TestCachingUserRepo_Invalidation(t *testing.T) {
+	backend := newCountingRepo()
+	cache, err := NewCachingUserRepo(&backend, CacheConfig{})
+	assert.NoError(t, err)
+	ctx := testCtx
+
+	user := newUser("Sasi", "user")
+	assert.NoError(t, cache.SaveUser(ctx, user))
+	_, err = cache.GetUserById(ctx, user.Id)
+	assert.NoError(t, err)
+
+	user.Role = "admin"
+	assert.NoError(t, cache.SaveUser(ctx, user))
+	got, err := cache.GetUserById(ctx, user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, user, got)
+
+	assert.NoError(t, cache.DeleteUser(ctx, user.Id))
+	_, err = cache.GetUserById(ctx, user.Id)
+	assert.Equal(t, domain.ErrUserIdNotFound{Id: user.Id}, err)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestCachingUserRepo_Eviction(t *testing.T) {
+	backend := newCountingRepo()
+	clock := domaintest.NewFakeClock(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC))
+	cache, err := NewCachingUserRepo(&backend, CacheConfig{Size: 2, TTL: time.Minute, Clock: clock})
+	assert.NoError(t, err)
+	ctx := testCtx
+
+	users := []domain.User{newUser("a", "user"), newUser("b", "user"), newUser("c", "user")}
+	for _, user := range users {
+		assert.NoError(t, backend.SaveUser(ctx, user))
+	}
+
+	// a is the least recently used when c comes in
+	for _, n := range []int{0, 1, 1, 2} {
+		_, err = cache.GetUserById(ctx, users[n].Id)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 3, Evictions: 1}, cache.Stats())
+	_, err = cache.GetUserById(ctx, users[1].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 3, Evictions: 1}, cache.Stats())
+
+	clock.Advance(time.Minute)
+	_, err = cache.GetUserById(ctx, users[1].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 4, Evictions: 2}, cache.Stats())
+}
+
+func TestCachingUserRepo_Singleflight(t *testing.T) {
+	backend := newCountingRepo()
+	backend.hold = make(chan struct{})
+	cache, err := NewCachingUserRepo(&backend, CacheConfig{})
+	assert.NoError(t, err)
+	ctx := testCtx
+
+	user := newUser("Sasi", "user")
+	assert.NoError(t, backend.InMemUserRepo.SaveUser(ctx, user))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := cache.GetUserById(ctx, user.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, user, got)
+		}()
+	}
+	for cache.Stats().Misses < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.hold)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(backend.lookups))
+}
+
+func TestCachingUserRepo_SingleflightCancel(t *testing.T) {
+	backend := newCountingRepo()
+	backend.hold = make(chan struct{})
+	cache, err := NewCachingUserRepo(&backend, CacheConfig{})
+	assert.NoError(t, err)
+
+	user := newUser("Sasi", "user")
+	assert.NoError(t, backend.InMemUserRepo.SaveUser(testCtx, user))
+
+	leaderCtx, cancelLeader := context.WithCancel(testCtx)
+	leader := make(chan error)
+	go func() {
+		_, err := cache.GetUserById(leaderCtx, user.Id)
+		leader <- err
+	}()
+	for atomic.LoadInt64(backend.lookups) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	waiter := make(chan domain.User)
+	go func() {
+		got, err := cache.GetUserById(testCtx, user.Id)
+		assert.NoError(t, err, "expected the waiter not to fail with the leader")
+		waiter <- got
+	}()
+	for cache.Stats().Misses < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancelLeader()
+	assert.ErrorIs(t, <-leader, context.Canceled, "expected the leader to give up without waiting")
+
+	waiterCtx, cancelWaiter := context.WithCancel(testCtx)
+	cancelWaiter()
+	_, err = cache.GetUserById(waiterCtx, user.Id)
+	assert.ErrorIs(t, err, context.Canceled, "expected a cancelled waiter not to wait")
+
+	close(backend.hold)
+	assert.Equal(t, user, <-waiter)
+	assert.Equal(t, int64(1), atomic.LoadInt64(backend.lookups))
+	got, err := cache.GetUserById(testCtx, user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, user, got, "expected the shared lookup to be cached")
+	assert.Equal(t, int64(1), atomic.LoadInt64(backend.lookups))
+}
+
+func TestCachingUserRepo_WriteDuringMiss(t *testing.T) {
+	backend := newCountingRepo()
+	backend.hold = make(chan struct{})
+	cache, err := NewCachingUserRepo(&backend, CacheConfig{})
+	assert.NoError(t, err)
+	ctx := testCtx
+
+	user := newUser("Sasi", "user")
+	assert.NoError(t, backend.InMemUserRepo.SaveUser(ctx, user))
+
+	done := make(chan domain.User)
+	go func() {
+		got, _ := cache.GetUserById(ctx, user.Id)
+		done <- got
+	}()
+	for atomic.LoadInt64(backend.lookups) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the lookup read the old user, the write lands before it returns
+	updated := user
+	updated.Role = "admin"
+	assert.NoError(t, cache.SaveUser(ctx, updated))
+	backend.hold <- struct{}{}
+	assert.Equal(t, user, <-done)
+
+	close(backend.hold)
+	got, err := cache.GetUserById(ctx, user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, updated, got, "stale user cached")
+}
+
+func TestCachingUserRepo_ConcurrentConsistency(t *testing.T) {
+	backend := NewInMemUserRepo()
+	cache, err := NewCachingUserRepo(&backend, CacheConfig{Size: 8})
+	assert.NoError(t, err)
+	ctx := testCtx
+
+	ids := make([]uuid.UUID, 16)
+	for n := range ids {
+		ids[n] = uuid.New()
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				id := ids[(w+n)%len(ids)]
+				switch n % 4 {
+				case 0:
+					_ = cache.SaveUser(ctx, domain.User{Id: id, TenantId: testTenant, Name: fmt.Sprintf("user %d", n)})
+				case 1:
+					_ = cache.DeleteUser(ctx, id)
+				default:
+					_, _ = cache.GetUserById(ctx, id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// once writers are done, the cache agrees with the backend
+	for _, id := range ids {
+		want, wantErr := backend.GetUserById(ctx, id)
+		got, err := cache.GetUserById(ctx, id)
+		assert.Equal(t, wantErr, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestCachingUserRepo_TenantIsolation(t *testing.T) {
+	backend := newCountingRepo()
+	cache, err := NewCachingUserRepo(&backend, CacheConfig{})
+	assert.NoError(t, err)
+
+	user := newUser("Sasi", "user")
+	assert.NoError(t, cache.SaveUser(testCtx, user))
+	_, err = cache.GetUserById(testCtx, user.Id)
+	assert.NoError(t, err)
+
+	// a cached user is not served to another tenant
+	_, err = cache.GetUserById(domain.WithTenant(context.Background(), "globex"), user.Id)
+	assert.Equal(t, domain.ErrUserIdNotFound{Id: user.Id}, err)
+	assert.Equal(t, int64(2), *backend.lookups)
+}
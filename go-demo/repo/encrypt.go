@@ -0,0 +1,416 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"os"
+	"strings"
+	"sync"
+)
+
+// sealedPrefix starts every sealed value, followed by the id of the key it
+// was sealed with and the base64 of the nonce and ciphertext
+const sealedPrefix = "sealed:v1:"
+
+// sealedFields are the fields of users sealed at rest, named as in filters
+var sealedFields = []struct {
+	name  string
+	value func(user *domain.User) *string
+}{
+	{name: "name", value: func(user *domain.User) *string { return &user.Name }},
+}
+
+// SealedField reports whether field is sealed at rest, so that a repo below
+// an EncryptingUserRepo cannot compare its values
+func SealedField(field string) bool {
+	for _, sealed := range sealedFields {
+		if sealed.name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrUnknownKey is returned when a value was sealed with a key the keyring
+// does not have
+type ErrUnknownKey struct {
+	Id string
+}
+
+func (e ErrUnknownKey) Error() string {
+	return fmt.Sprintf("unknown encryption key %q", e.Id)
+}
+
+// Keyring holds the AES keys values can be sealed with, by id. New values
+// are sealed with the current key, the others open values sealed before a
+// rotation
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// keyringJSON is the form of a keyring file, keys are base64 encoded and 16,
+// 24 or 32 bytes long
+type keyringJSON struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewKeyring returns a keyring sealing new values with the key current
+func NewKeyring(current string, keys map[string][]byte) (Keyring, error) {
+	keyring := Keyring{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return Keyring{}, fmt.Errorf("invalid key id %q, expected a non empty id without :", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return Keyring{}, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return Keyring{}, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		keyring.keys[id] = aead
+	}
+	if _, ok := keyring.keys[current]; !ok {
+		return Keyring{}, fmt.Errorf("current key %q is not in the keyring", current)
+	}
+	return keyring, nil
+}
+
+// ParseKeyring parses a keyring file, as in
+// {"current": "k2", "keys": {"k1": "<base64>", "k2": "<base64>"}}
+func ParseKeyring(data []byte) (Keyring, error) {
+	var j keyringJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return Keyring{}, fmt.Errorf("could not parse keyring: %w", err)
+	}
+	keys := make(map[string][]byte, len(j.Keys))
+	for id, encoded := range j.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return Keyring{}, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(j.Current, keys)
+}
+
+// LoadKeyring reads the keyring file at path
+func LoadKeyring(path string) (Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Keyring{}, fmt.Errorf("could not read keyring: %w", err)
+	}
+	return ParseKeyring(data)
+}
+
+// Current returns the id of the key new values are sealed with
+func (k Keyring) Current() string {
+	return k.current
+}
+
+// sealedAAD binds a sealed value to the field of the user it belongs to, so
+// that it cannot be moved to another
+func sealedAAD(user domain.User, field string) []byte {
+	return []byte(user.TenantId + "/" + user.Id.String() + "/" + field)
+}
+
+func (k Keyring) seal(plaintext string, aad []byte) (string, error) {
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), aad)
+	return sealedPrefix + k.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open returns the plaintext of a sealed value, values stored before
+// encryption was enabled are returned as they are
+func (k Keyring) open(value string, aad []byte) (string, error) {
+	keyId, ok := sealedKey(value)
+	if !ok {
+		return value, nil
+	}
+	aead, ok := k.keys[keyId]
+	if !ok {
+		return "", ErrUnknownKey{Id: keyId}
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(value[len(sealedPrefix)+len(keyId)+1:])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed sealed value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// sealedKey returns the id of the key value was sealed with, reporting false
+// if it is not sealed
+func sealedKey(value string) (string, bool) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(value, sealedPrefix)
+	keyId, _, ok := strings.Cut(rest, ":")
+	return keyId, ok
+}
+
+// EncryptingUserRepo seals the sealed fields of users, like their name, with
+// AES-GCM before they reach the repo it decorates, and opens them on the way
+// back. The decorated repo cannot match on sealed fields, so they are
+// matched here after opening
+type EncryptingUserRepo struct {
+	repo   domain.UserRepo
+	reload func() (Keyring, error)
+	// mu guards keyring
+	mu      *sync.RWMutex
+	keyring *Keyring
+	// writes serializes saves with the rotation of the user they change, so
+	// that a rotation never overwrites a newer state
+	writes *sync.Mutex
+}
+
+type EncryptionConfig struct {
+	Keyring Keyring
+	// Reload returns the keyring ReloadKeys switches to, ReloadKeys fails
+	// if nil
+	Reload func() (Keyring, error)
+}
+
+func NewEncryptingUserRepo(repo domain.UserRepo, config EncryptionConfig) (EncryptingUserRepo, error) {
+	if repo == nil {
+		return EncryptingUserRepo{}, fmt.Errorf("cannot create encrypting user repo, missing repo")
+	}
+	if config.Keyring.keys == nil {
+		return EncryptingUserRepo{}, fmt.Errorf("cannot create encrypting user repo, missing keyring")
+	}
+	keyring := config.Keyring
+	return EncryptingUserRepo{
+		repo:    repo,
+		reload:  config.Reload,
+		mu:      new(sync.RWMutex),
+		keyring: &keyring,
+		writes:  new(sync.Mutex),
+	}, nil
+}
+
+func (e *EncryptingUserRepo) currentKeyring() Keyring {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return *e.keyring
+}
+
+// ReloadKeys switches to the keyring returned by EncryptionConfig.Reload,
+// new values are sealed with its current key
+func (e *EncryptingUserRepo) ReloadKeys() error {
+	if e.reload == nil {
+		return errors.New("keyring cannot be reloaded")
+	}
+	keyring, err := e.reload()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	*e.keyring = keyring
+	return nil
+}
+
+func (e *EncryptingUserRepo) seal(keyring Keyring, user domain.User) (domain.User, error) {
+	for _, field := range sealedFields {
+		value := field.value(&user)
+		sealed, err := keyring.seal(*value, sealedAAD(user, field.name))
+		if err != nil {
+			return domain.User{}, err
+		}
+		*value = sealed
+	}
+	return user, nil
+}
+
+func (e *EncryptingUserRepo) open(keyring Keyring, user domain.User) (domain.User, error) {
+	for _, field := range sealedFields {
+		value := field.value(&user)
+		plaintext, err := keyring.open(*value, sealedAAD(user, field.name))
+		if err != nil {
+			return domain.User{}, fmt.Errorf("could not open %s of user %s: %w", field.name, domain.Mask(user.Id), err)
+		}
+		*value = plaintext
+	}
+	return user, nil
+}
+
+func (e *EncryptingUserRepo) openAll(users []domain.User) ([]domain.User, error) {
+	keyring := e.currentKeyring()
+	for n, user := range users {
+		opened, err := e.open(keyring, user)
+		if err != nil {
+			return nil, err
+		}
+		users[n] = opened
+	}
+	return users, nil
+}
+
+func (e *EncryptingUserRepo) SaveUser(ctx context.Context, user domain.User) error {
+	sealed, err := e.seal(e.currentKeyring(), user)
+	if err != nil {
+		return err
+	}
+
+	e.writes.Lock()
+	defer e.writes.Unlock()
+
+	return e.repo.SaveUser(ctx, sealed)
+}
+
+func (e *EncryptingUserRepo) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	e.writes.Lock()
+	defer e.writes.Unlock()
+
+	return e.repo.DeleteUser(ctx, id)
+}
+
+func (e *EncryptingUserRepo) EraseUser(ctx context.Context, id uuid.UUID) error {
+	e.writes.Lock()
+	defer e.writes.Unlock()
+
+	return e.repo.EraseUser(ctx, id)
+}
+
+func (e *EncryptingUserRepo) GetUserById(ctx context.Context, id uuid.UUID) (domain.User, error) {
+	user, err := e.repo.GetUserById(ctx, id)
+	if err != nil {
+		return domain.User{}, err
+	}
+	return e.open(e.currentKeyring(), user)
+}
+
+func (e *EncryptingUserRepo) ListUsers(ctx context.Context) ([]domain.User, error) {
+	users, err := e.repo.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.openAll(users)
+}
+
+// SnapshotUsers returns the users with their fields sealed, so that backups
+// stay encrypted and restore with the same keyring
+func (e *EncryptingUserRepo) SnapshotUsers(ctx context.Context) ([]domain.User, error) {
+	snapshotter, ok := e.repo.(domain.Snapshotter)
+	if !ok {
+		return nil, domain.ErrSnapshotUnsupported
+	}
+	return snapshotter.SnapshotUsers(ctx)
+}
+
+// RestoreUsers stores users as they are, with the fields sealed by the
+// SnapshotUsers they come from
+func (e *EncryptingUserRepo) RestoreUsers(ctx context.Context, users []domain.User) error {
+	snapshotter, ok := e.repo.(domain.Snapshotter)
+	if !ok {
+		return domain.ErrSnapshotUnsupported
+	}
+
+	e.writes.Lock()
+	defer e.writes.Unlock()
+
+	return snapshotter.RestoreUsers(ctx, users)
+}
+
+// ApplyChange passes a replicated change on to the decorated repo as it is,
+// with the fields sealed by the primary it comes from
+func (e *EncryptingUserRepo) ApplyChange(ctx context.Context, change domain.Change) error {
+	e.writes.Lock()
+	defer e.writes.Unlock()
+
+	return applyChange(ctx, e.repo, change)
+}
+
+// QueryUsers leaves the name and the filter, which may refer to sealed
+// fields, out of the query of the decorated repo, and matches the users on
+// them once opened
+func (e *EncryptingUserRepo) QueryUsers(ctx context.Context, up domain.UserProperties) ([]domain.User, error) {
+	stored := up
+	stored.Name = nil
+	stored.Filter = nil
+	users, err := e.repo.QueryUsers(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+	users, err = e.openAll(users)
+	if err != nil {
+		return nil, err
+	}
+	return domain.FilterUsers(users, up), nil
+}
+
+// RotateKeys seals again, with the current key, the fields of the users of
+// the tenant of ctx sealed with another key or stored before encryption was
+// enabled. Users are rotated one at a time, other writes go on in between
+func (e *EncryptingUserRepo) RotateKeys(ctx context.Context) (int, error) {
+	users, err := e.repo.ListUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, user := range users {
+		done, err := e.rotate(ctx, user.Id)
+		if err != nil {
+			return rotated, fmt.Errorf("could not rotate keys of user %s: %w", domain.Mask(user.Id), err)
+		}
+		if done {
+			rotated++
+		}
+	}
+	return rotated, nil
+}
+
+// rotate seals the user with id again if it needs to, reporting whether it
+// did
+func (e *EncryptingUserRepo) rotate(ctx context.Context, id uuid.UUID) (bool, error) {
+	e.writes.Lock()
+	defer e.writes.Unlock()
+
+	// read again under the lock, the user may have changed since it was listed
+	stored, err := e.repo.GetUserById(ctx, id)
+	if errors.As(err, &domain.ErrUserIdNotFound{}) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	keyring := e.currentKeyring()
+	current := true
+	for _, field := range sealedFields {
+		if keyId, ok := sealedKey(*field.value(&stored)); !ok || keyId != keyring.current {
+			current = false
+		}
+	}
+	if current {
+		return false, nil
+	}
+
+	user, err := e.open(keyring, stored)
+	if err != nil {
+		return false, err
+	}
+	sealed, err := e.seal(keyring, user)
+	if err != nil {
+		return false, err
+	}
+	return true, e.repo.SaveUser(ctx, sealed)
+}
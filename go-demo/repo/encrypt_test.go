@@ -0,0 +1,117 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, current string, ids ...string) Keyring {
+	keys := make(map[string][]byte, len(ids))
+	for n, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(n + 1)}, 32)
+	}
+	keyring, err := NewKeyring(current, keys)
+	assert.NoError(t, err)
+	return keyring
+}
+
+func Test_ParseKeyring(t *testing.T) {
+	keyring, err := ParseKeyring([]byte(`{"current":"k2","keys":{"k1":"AAAAAAAAAAAAAAAAAAAAAA==","k2":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", keyring.Current())
+
+	for name, data := range map[string]string{
+		"unknown current": `{"current":"k3","keys":{"k1":"AAAAAAAAAAAAAAAAAAAAAA=="}}`,
+		"short key":       `{"current":"k1","keys":{"k1":"AAAA"}}`,
+		"id with colon":   `{"current":"k:1","keys":{"k:1":"AAAAAAAAAAAAAAAAAAAAAA=="}}`,
+		"malformed":       `{`,
+	} {
+		_, err := ParseKeyring([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestEncryptingUserRepo(t *testing.T) {
+	inMemUserRepo := NewInMemUserRepo()
+	userRepo, err := NewEncryptingUserRepo(&inMemUserRepo, EncryptionConfig{Keyring: testKeyring(t, "k1", "k1")})
+	assert.NoError(t, err)
+
+	sasi := newUser("Sasi", "user")
+	shashank := newUser("Shashank Pachava", "admin")
+	assert.NoError(t, userRepo.SaveUser(testCtx, sasi))
+	assert.NoError(t, userRepo.SaveUser(testCtx, shashank))
+
+	stored, err := inMemUserRepo.GetUserById(testCtx, sasi.Id)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Name, "sealed:v1:k1:"), "expected the name to be sealed at rest")
+	assert.NotContains(t, stored.Name, "Sasi")
+
+	user, err := userRepo.GetUserById(testCtx, sasi.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, sasi, user)
+
+	users, err := userRepo.QueryUsers(testCtx, domain.UserProperties{Name: stringPtr("Sasi")})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{sasi}, users, "expected names to be matched once opened")
+	filter, err := domain.ParseFilter(`name contains "Pachava"`)
+	assert.NoError(t, err)
+	users, err = userRepo.QueryUsers(testCtx, domain.UserProperties{Filter: filter})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{shashank}, users)
+
+	// a sealed value only opens for the user it was sealed for
+	stored.Id = shashank.Id
+	assert.NoError(t, inMemUserRepo.SaveUser(testCtx, stored))
+	_, err = userRepo.GetUserById(testCtx, shashank.Id)
+	assert.Error(t, err, "expected a moved sealed value not to open")
+}
+
+func TestEncryptingUserRepo_RotateKeys(t *testing.T) {
+	inMemUserRepo := NewInMemUserRepo()
+	keyring := testKeyring(t, "k1", "k1")
+	userRepo, err := NewEncryptingUserRepo(&inMemUserRepo, EncryptionConfig{
+		Keyring: keyring,
+		Reload: func() (Keyring, error) {
+			return keyring, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	sasi := newUser("Sasi", "user")
+	assert.NoError(t, userRepo.SaveUser(testCtx, sasi))
+	// stored before encryption was enabled
+	legacy := newUser("Shashank Pachava", "admin")
+	assert.NoError(t, inMemUserRepo.SaveUser(testCtx, legacy))
+
+	user, err := userRepo.GetUserById(testCtx, legacy.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy, user, "expected names in the clear to be read as they are")
+
+	keyring = testKeyring(t, "k2", "k1", "k2")
+	assert.NoError(t, userRepo.ReloadKeys())
+	rotated, err := userRepo.RotateKeys(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rotated)
+
+	for _, user := range []domain.User{sasi, legacy} {
+		stored, err := inMemUserRepo.GetUserById(testCtx, user.Id)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(stored.Name, "sealed:v1:k2:"), "expected the name to be sealed with the current key")
+		opened, err := userRepo.GetUserById(testCtx, user.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, user, opened)
+	}
+
+	rotated, err = userRepo.RotateKeys(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rotated, "expected nothing left to rotate")
+
+	// retiring a key before rotating leaves the users sealed with it unreadable
+	keyring = testKeyring(t, "k3", "k3")
+	assert.NoError(t, userRepo.ReloadKeys())
+	_, err = userRepo.GetUserById(testCtx, sasi.Id)
+	assert.ErrorIs(t, err, ErrUnknownKey{Id: "k2"})
+}
@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// InMemErasureLog keeps the erasure receipts of every tenant in memory.
+// Every method is scoped to the tenant of its context
+type InMemErasureLog struct {
+	mu       *sync.RWMutex
+	receipts map[string][]domain.ErasureReceipt
+}
+
+func NewInMemErasureLog() InMemErasureLog {
+	return InMemErasureLog{
+		mu:       new(sync.RWMutex),
+		receipts: make(map[string][]domain.ErasureReceipt),
+	}
+}
+
+// AppendReceipt fails with domain.ErrTenantMismatch if receipt is not of the
+// tenant of ctx
+func (i *InMemErasureLog) AppendReceipt(ctx context.Context, receipt domain.ErasureReceipt) (domain.ErasureReceipt, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return domain.ErasureReceipt{}, err
+	}
+	if receipt.TenantId != tenant {
+		return domain.ErasureReceipt{}, domain.ErrTenantMismatch
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	receipt = domain.ChainReceipt(i.last(tenant), receipt)
+	i.receipts[tenant] = append(i.receipts[tenant], receipt)
+	return receipt, nil
+}
+
+// last returns the last receipt of tenant, the zero receipt if none
+func (i *InMemErasureLog) last(tenant string) domain.ErasureReceipt {
+	receipts := i.receipts[tenant]
+	if len(receipts) == 0 {
+		return domain.ErasureReceipt{}
+	}
+	return receipts[len(receipts)-1]
+}
+
+func (i *InMemErasureLog) ListReceipts(ctx context.Context) ([]domain.ErasureReceipt, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return append([]domain.ErasureReceipt(nil), i.receipts[tenant]...), nil
+}
+
+// FileErasureLog keeps the erasure receipts of every tenant in a file, one
+// json object per line, and in memory to list them. Receipts are read back
+// as they were written, so that domain.VerifyReceipts catches any edit
+type FileErasureLog struct {
+	receipts InMemErasureLog
+	file     *os.File
+}
+
+// OpenFileErasureLog opens the log at path, creating it if needed. As with
+// OpenFileEventStore, a last line cut short by a crash is dropped
+func OpenFileErasureLog(path string) (FileErasureLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return FileErasureLog{}, fmt.Errorf("could not open erasure log: %w", err)
+	}
+	f := FileErasureLog{receipts: NewInMemErasureLog(), file: file}
+	if err := f.load(); err != nil {
+		_ = file.Close()
+		return FileErasureLog{}, err
+	}
+	return f, nil
+}
+
+func (f *FileErasureLog) load() error {
+	reader := bufio.NewReader(f.file)
+	read := 0
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				if err := f.file.Truncate(int64(read)); err != nil {
+					return fmt.Errorf("could not drop torn erasure receipt: %w", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read erasure log: %w", err)
+		}
+		read += len(data)
+		var receipt domain.ErasureReceipt
+		if err := json.Unmarshal(data, &receipt); err != nil {
+			return fmt.Errorf("could not decode erasure receipt on line %d: %w", line, err)
+		}
+		f.receipts.receipts[receipt.TenantId] = append(f.receipts.receipts[receipt.TenantId], receipt)
+	}
+}
+
+// AppendReceipt fails with domain.ErrTenantMismatch if receipt is not of the
+// tenant of ctx. The receipt is synced to the file before it is returned
+func (f *FileErasureLog) AppendReceipt(ctx context.Context, receipt domain.ErasureReceipt) (domain.ErasureReceipt, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return domain.ErasureReceipt{}, err
+	}
+	if receipt.TenantId != tenant {
+		return domain.ErasureReceipt{}, domain.ErrTenantMismatch
+	}
+
+	f.receipts.mu.Lock()
+	defer f.receipts.mu.Unlock()
+
+	receipt = domain.ChainReceipt(f.receipts.last(tenant), receipt)
+	line, err := json.Marshal(receipt)
+	if err != nil {
+		return domain.ErasureReceipt{}, fmt.Errorf("could not encode erasure receipt: %w", err)
+	}
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return domain.ErasureReceipt{}, fmt.Errorf("could not append erasure receipt: %w", err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return domain.ErasureReceipt{}, fmt.Errorf("could not sync erasure log: %w", err)
+	}
+	f.receipts.receipts[tenant] = append(f.receipts.receipts[tenant], receipt)
+	return receipt, nil
+}
+
+func (f *FileErasureLog) ListReceipts(ctx context.Context) ([]domain.ErasureReceipt, error) {
+	return f.receipts.ListReceipts(ctx)
+}
+
+func (f *FileErasureLog) Close() error {
+	return f.file.Close()
+}
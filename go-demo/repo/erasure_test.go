@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileErasureLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "erasures.log")
+	erasures, err := OpenFileErasureLog(path)
+	assert.NoError(t, err)
+
+	first, err := erasures.AppendReceipt(testCtx, domain.ErasureReceipt{Id: uuid.New(), TenantId: testTenant, UserId: uuid.New(), Erased: []string{"profile"}})
+	assert.NoError(t, err)
+	second, err := erasures.AppendReceipt(testCtx, domain.ErasureReceipt{Id: uuid.New(), TenantId: testTenant, UserId: uuid.New(), Erased: []string{"history"}})
+	assert.NoError(t, err)
+	assert.Equal(t, first.Hash, second.PrevHash)
+
+	globexCtx := domain.WithTenant(context.Background(), "globex")
+	_, err = erasures.AppendReceipt(globexCtx, domain.ErasureReceipt{TenantId: testTenant})
+	assert.ErrorIs(t, err, domain.ErrTenantMismatch)
+	receipts, err := erasures.ListReceipts(globexCtx)
+	assert.NoError(t, err)
+	assert.Empty(t, receipts, "expected receipts of another tenant to be hidden")
+	assert.NoError(t, erasures.Close())
+
+	reopened, err := OpenFileErasureLog(path)
+	assert.NoError(t, err)
+	receipts, err = reopened.ListReceipts(testCtx)
+	assert.NoError(t, err)
+	assert.Len(t, receipts, 2)
+	assert.NoError(t, domain.VerifyReceipts(receipts))
+	assert.NoError(t, reopened.Close())
+
+	// edit the first receipt in place
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	edited := strings.Replace(string(data), `"profile"`, `"nothing"`, 1)
+	assert.NoError(t, os.WriteFile(path, []byte(edited), 0o600))
+
+	tampered, err := OpenFileErasureLog(path)
+	assert.NoError(t, err)
+	defer tampered.Close()
+	receipts, err = tampered.ListReceipts(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.ErrReceiptChainBroken{Seq: 1}, domain.VerifyReceipts(receipts), "expected the edit to be detected")
+}
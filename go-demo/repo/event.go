@@ -0,0 +1,248 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"encoding/json"
+	"github.com/google/uuid"
+	"reflect"
+	"time"
+)
+
+// UserEventType is the kind of change a UserEvent records
+type UserEventType string
+
+const (
+	// UserCreated and UserReplaced carry the whole state of the user, the
+	// latter for changes no finer event describes
+	UserCreated  UserEventType = "UserCreated"
+	UserReplaced UserEventType = "UserReplaced"
+
+	UserRenamed           UserEventType = "UserRenamed"
+	UserRoleChanged       UserEventType = "UserRoleChanged"
+	UserManagerChanged    UserEventType = "UserManagerChanged"
+	UserAttributesChanged UserEventType = "UserAttributesChanged"
+	UserDeleted           UserEventType = "UserDeleted"
+	UserRestored          UserEventType = "UserRestored"
+	// UserTouched is a save that changed nothing but when and by whom the
+	// user was last updated
+	UserTouched UserEventType = "UserTouched"
+	// UserPurged is the hard deletion of a user
+	UserPurged UserEventType = "UserPurged"
+	// UserErased is the hard deletion of a user that also replaced every
+	// earlier event of the user, keeping only its number
+	UserErased UserEventType = "UserErased"
+)
+
+// UserEvent is a change to a user. Events of a log are numbered by Seq from 1
+type UserEvent struct {
+	Seq      uint64
+	Type     UserEventType
+	TenantId string
+	UserId   uuid.UUID
+	// At and By are when and by whom the user was updated, zero for purges
+	// and erasures
+	At time.Time
+	By string
+	// User is set on UserCreated and UserReplaced, the other fields on the
+	// event of the same name
+	User       *domain.User
+	Name       string
+	Role       string
+	ManagerId  uuid.UUID
+	Attributes map[string]any
+}
+
+// userJSON is the json form of a domain.User in logs and snapshots
+type userJSON struct {
+	Id         uuid.UUID      `json:"id"`
+	TenantId   string         `json:"tenantId"`
+	Name       string         `json:"name"`
+	Role       string         `json:"role"`
+	ManagerId  uuid.UUID      `json:"managerId"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	UpdatedAt  time.Time      `json:"updatedAt"`
+	CreatedBy  string         `json:"createdBy,omitempty"`
+	UpdatedBy  string         `json:"updatedBy,omitempty"`
+	DeletedAt  time.Time      `json:"deletedAt"`
+}
+
+func toUserJSON(user domain.User) userJSON {
+	return userJSON(user)
+}
+
+func (u userJSON) user() domain.User {
+	user := domain.User(u)
+	user.Attributes = decodeAttributes(user.Attributes)
+	return user
+}
+
+// decodeAttributes turns the numbers of attributes decoded with UseNumber
+// back into the int64 canonical attribute values are
+func decodeAttributes(attributes map[string]any) map[string]any {
+	for name, value := range attributes {
+		if number, ok := value.(json.Number); ok {
+			if i, err := number.Int64(); err == nil {
+				attributes[name] = i
+			}
+		}
+	}
+	return attributes
+}
+
+// userEventJSON is the json form of a UserEvent
+type userEventJSON struct {
+	Seq        uint64         `json:"seq"`
+	Type       UserEventType  `json:"type"`
+	TenantId   string         `json:"tenantId"`
+	UserId     uuid.UUID      `json:"userId"`
+	At         time.Time      `json:"at"`
+	By         string         `json:"by,omitempty"`
+	User       *userJSON      `json:"user,omitempty"`
+	Name       string         `json:"name,omitempty"`
+	Role       string         `json:"role,omitempty"`
+	ManagerId  *uuid.UUID     `json:"managerId,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+func (e UserEvent) MarshalJSON() ([]byte, error) {
+	j := userEventJSON{
+		Seq:        e.Seq,
+		Type:       e.Type,
+		TenantId:   e.TenantId,
+		UserId:     e.UserId,
+		At:         e.At,
+		By:         e.By,
+		Name:       e.Name,
+		Role:       e.Role,
+		Attributes: e.Attributes,
+	}
+	if e.User != nil {
+		user := toUserJSON(*e.User)
+		j.User = &user
+	}
+	if e.Type == UserManagerChanged {
+		j.ManagerId = &e.ManagerId
+	}
+	return json.Marshal(j)
+}
+
+func (e *UserEvent) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var j userEventJSON
+	if err := decoder.Decode(&j); err != nil {
+		return err
+	}
+	*e = UserEvent{
+		Seq:        j.Seq,
+		Type:       j.Type,
+		TenantId:   j.TenantId,
+		UserId:     j.UserId,
+		At:         j.At,
+		By:         j.By,
+		Name:       j.Name,
+		Role:       j.Role,
+		Attributes: decodeAttributes(j.Attributes),
+	}
+	if j.User != nil {
+		user := j.User.user()
+		e.User = &user
+	}
+	if j.ManagerId != nil {
+		e.ManagerId = *j.ManagerId
+	}
+	return nil
+}
+
+// ApplyUserEvent folds event into the state of the user it is about. The
+// state of a purged user is left as is, the caller drops it
+func ApplyUserEvent(user domain.User, event UserEvent) domain.User {
+	switch event.Type {
+	case UserCreated, UserReplaced:
+		if event.User != nil {
+			return *event.User
+		}
+	case UserRenamed:
+		user.Name = event.Name
+	case UserRoleChanged:
+		user.Role = event.Role
+	case UserManagerChanged:
+		user.ManagerId = event.ManagerId
+	case UserAttributesChanged:
+		user.Attributes = event.Attributes
+	case UserDeleted:
+		user.DeletedAt = event.At
+	case UserRestored:
+		user.DeletedAt = time.Time{}
+	case UserPurged, UserErased:
+		return user
+	}
+	user.UpdatedAt = event.At
+	user.UpdatedBy = event.By
+	return user
+}
+
+// userEvents returns the events that turn prev, if the user existed, into
+// user. Seq is left for the log to assign
+func userEvents(prev domain.User, existed bool, user domain.User) []UserEvent {
+	event := func(eventType UserEventType) UserEvent {
+		return UserEvent{
+			Type:     eventType,
+			TenantId: user.TenantId,
+			UserId:   user.Id,
+			At:       user.UpdatedAt,
+			By:       user.UpdatedBy,
+		}
+	}
+	if !existed {
+		created := event(UserCreated)
+		created.User = &user
+		return []UserEvent{created}
+	}
+
+	var events []UserEvent
+	if prev.Name != user.Name {
+		renamed := event(UserRenamed)
+		renamed.Name = user.Name
+		events = append(events, renamed)
+	}
+	if prev.Role != user.Role {
+		roleChanged := event(UserRoleChanged)
+		roleChanged.Role = user.Role
+		events = append(events, roleChanged)
+	}
+	if prev.ManagerId != user.ManagerId {
+		managerChanged := event(UserManagerChanged)
+		managerChanged.ManagerId = user.ManagerId
+		events = append(events, managerChanged)
+	}
+	if !reflect.DeepEqual(prev.Attributes, user.Attributes) {
+		attributesChanged := event(UserAttributesChanged)
+		attributesChanged.Attributes = user.Attributes
+		events = append(events, attributesChanged)
+	}
+	switch {
+	case !prev.Deleted() && user.Deleted():
+		events = append(events, event(UserDeleted))
+	case prev.Deleted() && !user.Deleted():
+		events = append(events, event(UserRestored))
+	}
+	if len(events) == 0 {
+		events = append(events, event(UserTouched))
+	}
+
+	// changes no finer event describes, like a new creation time, replace
+	// the whole user
+	folded := prev
+	for _, e := range events {
+		folded = ApplyUserEvent(folded, e)
+	}
+	if !reflect.DeepEqual(folded, user) {
+		replaced := event(UserReplaced)
+		replaced.User = &user
+		return []UserEvent{replaced}
+	}
+	return events
+}
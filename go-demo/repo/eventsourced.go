@@ -0,0 +1,412 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"sync"
+)
+
+// DefaultSnapshotEvery is how many events are appended between snapshots
+// when EventSourcedConfig sets none
+const DefaultSnapshotEvery = 1000
+
+// Projection is a read model built by folding user events. Apply is called
+// with every event appended to the log, in order, and may be called while
+// the projection is read
+type Projection interface {
+	// Reset drops everything applied so far, before the projection is
+	// rebuilt from the start of the log
+	Reset()
+	Apply(event UserEvent)
+}
+
+type EventSourcedConfig struct {
+	// Snapshots keeps snapshots of the users, so that opening the repo only
+	// replays the events appended since. None are taken if nil
+	Snapshots SnapshotStore
+	// SnapshotEvery is how many events are appended between snapshots,
+	// defaults to DefaultSnapshotEvery
+	SnapshotEvery int
+	Constraints   []domain.UniqueConstraint
+	// Projections are rebuilt from the log when the repo is opened, then
+	// kept up to date with every event appended
+	Projections []Projection
+}
+
+// EventSourcedUserRepo persists users as an append-only log of the changes
+// made to them. The current state of the users is folded from the log into
+// an InMemUserRepo, which answers reads and enforces unique constraints.
+// Every method is scoped to the tenant of its context
+type EventSourcedUserRepo struct {
+	// mu serializes writes, so that events are appended in the order they
+	// are applied
+	mu     *sync.Mutex
+	events EventStore
+	state  InMemUserRepo
+	config EventSourcedConfig
+	// seq is the number of the last event appended, sinceSnapshot how many
+	// were appended after the last snapshot
+	seq           *uint64
+	sinceSnapshot *int
+}
+
+// NewEventSourcedUserRepo opens the repo stored in events, from the latest
+// snapshot if there is one
+func NewEventSourcedUserRepo(events EventStore, config EventSourcedConfig) (EventSourcedUserRepo, error) {
+	if events == nil {
+		return EventSourcedUserRepo{}, fmt.Errorf("cannot create event sourced user repo, missing event store")
+	}
+	if config.SnapshotEvery < 0 {
+		return EventSourcedUserRepo{}, fmt.Errorf("cannot create event sourced user repo, negative snapshot interval")
+	}
+	if config.SnapshotEvery == 0 {
+		config.SnapshotEvery = DefaultSnapshotEvery
+	}
+	e := EventSourcedUserRepo{
+		mu:            new(sync.Mutex),
+		events:        events,
+		state:         NewInMemUserRepo(config.Constraints...),
+		config:        config,
+		seq:           new(uint64),
+		sinceSnapshot: new(int),
+	}
+
+	if config.Snapshots != nil {
+		snapshot, ok, err := config.Snapshots.LoadSnapshot()
+		if err != nil {
+			return EventSourcedUserRepo{}, fmt.Errorf("could not load user snapshot: %w", err)
+		}
+		if ok {
+			for _, user := range snapshot.Users {
+				e.state.put(user)
+			}
+			*e.seq = snapshot.Seq
+		}
+	}
+	err := events.Load(*e.seq, func(event UserEvent) error {
+		if event.Seq != *e.seq+1 {
+			return fmt.Errorf("expected event %d, found %d", *e.seq+1, event.Seq)
+		}
+		e.apply(event)
+		*e.seq = event.Seq
+		*e.sinceSnapshot++
+		return nil
+	})
+	if err != nil {
+		return EventSourcedUserRepo{}, fmt.Errorf("could not replay user events: %w", err)
+	}
+
+	for _, projection := range config.Projections {
+		if err := e.rebuild(projection); err != nil {
+			return EventSourcedUserRepo{}, err
+		}
+	}
+	return e, nil
+}
+
+// apply folds event into the state, the state lock is taken
+func (e *EventSourcedUserRepo) apply(event UserEvent) {
+	e.state.mu.Lock()
+	defer e.state.mu.Unlock()
+
+	user, ok := e.state.users[event.UserId]
+	if event.Type == UserPurged || event.Type == UserErased {
+		if ok {
+			e.state.remove(user)
+		}
+		return
+	}
+	e.state.put(ApplyUserEvent(user, event))
+}
+
+// Rebuild resets projection and folds the whole log into it
+func (e *EventSourcedUserRepo) Rebuild(projection Projection) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.rebuild(projection)
+}
+
+func (e *EventSourcedUserRepo) rebuild(projection Projection) error {
+	projection.Reset()
+	err := e.events.Load(0, func(event UserEvent) error {
+		projection.Apply(event)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not rebuild projection: %w", err)
+	}
+	return nil
+}
+
+// append numbers events, appends them to the log, then applies them to the
+// state and the projections. e.mu must be held
+func (e *EventSourcedUserRepo) append(events []UserEvent) error {
+	for n := range events {
+		events[n].Seq = *e.seq + uint64(n) + 1
+	}
+	if err := e.events.Append(events); err != nil {
+		return err
+	}
+	*e.seq += uint64(len(events))
+
+	for _, event := range events {
+		e.apply(event)
+		for _, projection := range e.config.Projections {
+			projection.Apply(event)
+		}
+	}
+
+	*e.sinceSnapshot += len(events)
+	if e.config.Snapshots != nil && *e.sinceSnapshot >= e.config.SnapshotEvery {
+		// the events are already durable, a failed snapshot only makes the
+		// next open replay more of them
+		if err := e.snapshot(); err != nil {
+			log.Println("could not snapshot users", err.Error())
+		} else {
+			*e.sinceSnapshot = 0
+		}
+	}
+	return nil
+}
+
+// snapshot saves the state of every user, e.mu must be held
+func (e *EventSourcedUserRepo) snapshot() error {
+	e.state.mu.RLock()
+	snapshot := UserSnapshot{Seq: *e.seq, Users: e.state.all()}
+	e.state.mu.RUnlock()
+
+	return e.config.Snapshots.SaveSnapshot(snapshot)
+}
+
+// SaveUser fails with domain.ErrTenantMismatch if user is not of the tenant
+// of ctx, or if its id is taken by a user of another tenant, and with
+// domain.ErrConflict if it breaks a unique constraint
+func (e *EventSourcedUserRepo) SaveUser(ctx context.Context, user domain.User) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+	if user.TenantId != tenant {
+		return domain.ErrTenantMismatch
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// only writers change the state, so it holds until the events are applied
+	e.state.mu.RLock()
+	prev, existed := e.state.users[user.Id]
+	if existed && prev.TenantId != tenant {
+		e.state.mu.RUnlock()
+		return domain.ErrTenantMismatch
+	}
+	err = e.state.checkUnique(user)
+	e.state.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return e.append(userEvents(prev, existed, user))
+}
+
+func (e *EventSourcedUserRepo) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	user, err := e.state.GetUserById(ctx, id)
+	if err != nil {
+		return err
+	}
+	return e.append([]UserEvent{{Type: UserPurged, TenantId: user.TenantId, UserId: id}})
+}
+
+// EraseUser replaces every event of the user, purged already or not, with a
+// bare UserErased keeping its number, then appends one and replaces the
+// snapshot, so that neither keeps any state of the user
+func (e *EventSourcedUserRepo) EraseUser(ctx context.Context, id uuid.UUID) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	found := false
+	err = e.events.Rewrite(func(event UserEvent) UserEvent {
+		if event.UserId != id || event.TenantId != tenant || event.Type == UserErased {
+			return event
+		}
+		found = true
+		return UserEvent{Seq: event.Seq, Type: UserErased, TenantId: tenant, UserId: id}
+	})
+	if err != nil {
+		return fmt.Errorf("could not rewrite user events: %w", err)
+	}
+	if !found {
+		return domain.ErrUserIdNotFound{Id: id}
+	}
+	if err := e.append([]UserEvent{{Type: UserErased, TenantId: tenant, UserId: id}}); err != nil {
+		return err
+	}
+	if e.config.Snapshots != nil {
+		if err := e.snapshot(); err != nil {
+			return fmt.Errorf("could not replace the snapshot holding the user: %w", err)
+		}
+		*e.sinceSnapshot = 0
+	}
+	return nil
+}
+
+// SnapshotUsers waits for the write in progress, as a save may append more
+// than one event
+func (e *EventSourcedUserRepo) SnapshotUsers(ctx context.Context) ([]domain.User, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.state.SnapshotUsers(ctx)
+}
+
+// RestoreUsers appends, in one batch, the purge of every user followed by
+// the creation of every restored user, then replaces the snapshot
+func (e *EventSourcedUserRepo) RestoreUsers(ctx context.Context, users []domain.User) error {
+	if err := e.state.checkRestore(users); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.state.mu.RLock()
+	current := e.state.all()
+	e.state.mu.RUnlock()
+
+	events := make([]UserEvent, 0, len(current)+len(users))
+	for _, user := range current {
+		events = append(events, UserEvent{Type: UserPurged, TenantId: user.TenantId, UserId: user.Id})
+	}
+	for n := range users {
+		user := users[n]
+		events = append(events, UserEvent{Type: UserCreated, TenantId: user.TenantId, UserId: user.Id, At: user.UpdatedAt, By: user.UpdatedBy, User: &user})
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	if err := e.append(events); err != nil {
+		return err
+	}
+	if e.config.Snapshots != nil {
+		if err := e.snapshot(); err != nil {
+			log.Println("could not snapshot users", err.Error())
+		} else {
+			*e.sinceSnapshot = 0
+		}
+	}
+	return nil
+}
+
+func (e *EventSourcedUserRepo) GetUserById(ctx context.Context, id uuid.UUID) (domain.User, error) {
+	return e.state.GetUserById(ctx, id)
+}
+
+func (e *EventSourcedUserRepo) ListUsers(ctx context.Context) ([]domain.User, error) {
+	return e.state.ListUsers(ctx)
+}
+
+func (e *EventSourcedUserRepo) QueryUsers(ctx context.Context, up domain.UserProperties) ([]domain.User, error) {
+	return e.state.QueryUsers(ctx, up)
+}
+
+// RoleIndex is a projection of the live users of every role of each tenant
+type RoleIndex struct {
+	mu *sync.RWMutex
+	// users keeps the tenant, role and deletion of every user, to move it
+	// between roles as events come in
+	users  map[uuid.UUID]roleEntry
+	byRole map[string]idSet
+}
+
+type roleEntry struct {
+	tenant  string
+	role    string
+	deleted bool
+}
+
+func NewRoleIndex() RoleIndex {
+	return RoleIndex{
+		mu:     new(sync.RWMutex),
+		users:  make(map[uuid.UUID]roleEntry),
+		byRole: make(map[string]idSet),
+	}
+}
+
+// roleKey is the key of the users of role in tenant
+func roleKey(tenant, role string) string {
+	return tenant + "\x00" + role
+}
+
+func (r *RoleIndex) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users = make(map[uuid.UUID]roleEntry)
+	r.byRole = make(map[string]idSet)
+}
+
+func (r *RoleIndex) Apply(event UserEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.users[event.UserId]
+	if ok && !entry.deleted {
+		removeFromIndex(r.byRole, roleKey(entry.tenant, entry.role), event.UserId)
+	}
+	switch event.Type {
+	case UserCreated, UserReplaced:
+		entry = roleEntry{tenant: event.User.TenantId, role: event.User.Role, deleted: event.User.Deleted()}
+	case UserRoleChanged:
+		entry.role = event.Role
+	case UserDeleted:
+		entry.deleted = true
+	case UserRestored:
+		entry.deleted = false
+	case UserPurged, UserErased:
+		delete(r.users, event.UserId)
+		return
+	}
+	r.users[event.UserId] = entry
+	if !entry.deleted {
+		addToIndex(r.byRole, roleKey(entry.tenant, entry.role), event.UserId)
+	}
+}
+
+// Users returns the ids of the live users of role in tenant
+func (r *RoleIndex) Users(tenant, role string) []uuid.UUID {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := r.byRole[roleKey(tenant, role)]
+	resp := make([]uuid.UUID, 0, len(ids))
+	for id := range ids {
+		resp = append(resp, id)
+	}
+	return resp
+}
+
+// Counts returns how many live users of tenant have each role
+func (r *RoleIndex) Counts(tenant string) map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	resp := make(map[string]int)
+	for _, entry := range r.users {
+		if entry.tenant == tenant && !entry.deleted {
+			resp[entry.role]++
+		}
+	}
+	return resp
+}
@@ -0,0 +1,224 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var eventTime = time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+
+func Test_userEvents(t *testing.T) {
+	user := newUser("Sasi", "user")
+	user.UpdatedAt = eventTime
+
+	types := func(events []UserEvent) []UserEventType {
+		var resp []UserEventType
+		for _, event := range events {
+			resp = append(resp, event.Type)
+		}
+		return resp
+	}
+
+	changed := user
+	changed.Name = "Sasi K"
+	changed.Role = "admin"
+	changed.Attributes = map[string]any{"level": int64(3)}
+	changed.UpdatedAt = eventTime.Add(time.Hour)
+	deleted := changed
+	deleted.DeletedAt = deleted.UpdatedAt
+	touched := user
+	touched.UpdatedBy = "admin"
+	recreated := user
+	recreated.CreatedAt = eventTime
+
+	for name, tc := range map[string]struct {
+		prev     domain.User
+		existed  bool
+		user     domain.User
+		expected []UserEventType
+	}{
+		"created":  {user: user, expected: []UserEventType{UserCreated}},
+		"changed":  {prev: user, existed: true, user: changed, expected: []UserEventType{UserRenamed, UserRoleChanged, UserAttributesChanged}},
+		"deleted":  {prev: changed, existed: true, user: deleted, expected: []UserEventType{UserDeleted}},
+		"restored": {prev: deleted, existed: true, user: changed, expected: []UserEventType{UserRestored}},
+		"touched":  {prev: user, existed: true, user: touched, expected: []UserEventType{UserTouched}},
+		"replaced": {prev: user, existed: true, user: recreated, expected: []UserEventType{UserReplaced}},
+	} {
+		events := userEvents(tc.prev, tc.existed, tc.user)
+		assert.Equal(t, tc.expected, types(events), name)
+
+		folded := tc.prev
+		for _, event := range events {
+			folded = ApplyUserEvent(folded, event)
+		}
+		assert.Equal(t, tc.user, folded, name)
+	}
+}
+
+// openFileRepo opens the event sourced repo kept in dir
+func openFileRepo(t *testing.T, dir string, config EventSourcedConfig) (EventSourcedUserRepo, *FileEventStore) {
+	store, err := OpenFileEventStore(filepath.Join(dir, "users.log"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	config.Snapshots = NewFileSnapshotStore(filepath.Join(dir, "users.snapshot"))
+	userRepo, err := NewEventSourcedUserRepo(&store, config)
+	assert.NoError(t, err)
+	return userRepo, &store
+}
+
+func TestEventSourcedUserRepo_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	userRepo, store := openFileRepo(t, dir, EventSourcedConfig{SnapshotEvery: 3})
+
+	sasi := newUser("Sasi", "user")
+	sasi.Attributes = map[string]any{"level": int64(3), "remote": true}
+	sasi.CreatedAt, sasi.UpdatedAt = eventTime, eventTime
+	shashank := newUser("Shashank Pachava", "admin")
+	shashank.ManagerId = sasi.Id
+	purged := newUser("Jim", "user")
+
+	for _, user := range []domain.User{sasi, shashank, purged} {
+		assert.NoError(t, userRepo.SaveUser(testCtx, user))
+	}
+	sasi.Role = "admin"
+	sasi.UpdatedAt = eventTime.Add(time.Hour)
+	assert.NoError(t, userRepo.SaveUser(testCtx, sasi))
+	assert.NoError(t, userRepo.DeleteUser(testCtx, purged.Id))
+	assert.NoError(t, store.Close())
+
+	_, err := os.Stat(filepath.Join(dir, "users.snapshot"))
+	assert.NoError(t, err, "expected a snapshot after 3 events")
+
+	reopened, _ := openFileRepo(t, dir, EventSourcedConfig{SnapshotEvery: 3})
+	users, err := reopened.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []domain.User{sasi, shashank}, users, "expected the state folded from the log")
+
+	// the log keeps going where it stopped
+	shashank.Name = "Shank"
+	assert.NoError(t, reopened.SaveUser(testCtx, shashank))
+	user, err := reopened.GetUserById(testCtx, shashank.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Shank", user.Name)
+	admins, err := reopened.QueryUsers(testCtx, domain.UserProperties{Role: stringPtr("admin")})
+	assert.NoError(t, err)
+	assert.Len(t, admins, 2)
+}
+
+func TestEventSourcedUserRepo_TornEvent(t *testing.T) {
+	dir := t.TempDir()
+	userRepo, store := openFileRepo(t, dir, EventSourcedConfig{})
+
+	user := newUser("Sasi", "user")
+	assert.NoError(t, userRepo.SaveUser(testCtx, user))
+	assert.NoError(t, store.Close())
+
+	// a crash in the middle of an append
+	log, err := os.OpenFile(filepath.Join(dir, "users.log"), os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.NoError(t, err)
+	_, err = log.WriteString(`{"seq":2,"type":"UserRen`)
+	assert.NoError(t, err)
+	assert.NoError(t, log.Close())
+
+	reopened, _ := openFileRepo(t, dir, EventSourcedConfig{})
+	users, err := reopened.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	user.Name = "Sasi K"
+	assert.NoError(t, reopened.SaveUser(testCtx, user), "expected the torn event to be dropped")
+}
+
+func TestEventSourcedUserRepo_UniqueConstraints(t *testing.T) {
+	store := NewInMemEventStore()
+	userRepo, err := NewEventSourcedUserRepo(&store, EventSourcedConfig{
+		Constraints: []domain.UniqueConstraint{{Fields: []string{"name"}, CaseInsensitive: true}},
+	})
+	assert.NoError(t, err)
+
+	jane := newUser("Jane Doe", "user")
+	assert.NoError(t, userRepo.SaveUser(testCtx, jane))
+	err = userRepo.SaveUser(testCtx, newUser("jane doe", "user"))
+	assert.ErrorAs(t, err, &domain.ErrConflict{})
+
+	var appended int
+	assert.NoError(t, store.Load(0, func(UserEvent) error {
+		appended++
+		return nil
+	}))
+	assert.Equal(t, 1, appended, "expected no event for the conflicting user")
+}
+
+func TestRoleIndex(t *testing.T) {
+	store := NewInMemEventStore()
+	roles := NewRoleIndex()
+	userRepo, err := NewEventSourcedUserRepo(&store, EventSourcedConfig{Projections: []Projection{&roles}})
+	assert.NoError(t, err)
+
+	sasi := newUser("Sasi", "user")
+	shashank := newUser("Shashank Pachava", "user")
+	assert.NoError(t, userRepo.SaveUser(testCtx, sasi))
+	assert.NoError(t, userRepo.SaveUser(testCtx, shashank))
+	shashank.Role = "admin"
+	assert.NoError(t, userRepo.SaveUser(testCtx, shashank))
+	sasi.DeletedAt = eventTime
+	assert.NoError(t, userRepo.SaveUser(testCtx, sasi))
+
+	assert.Equal(t, []uuid.UUID{shashank.Id}, roles.Users(testTenant, "admin"))
+	assert.Empty(t, roles.Users(testTenant, "user"), "expected deleted users to be left out")
+	assert.Equal(t, map[string]int{"admin": 1}, roles.Counts(testTenant))
+
+	// a read model added later is built from the whole log
+	rebuilt := NewRoleIndex()
+	assert.NoError(t, userRepo.Rebuild(&rebuilt))
+	assert.Equal(t, roles.Counts(testTenant), rebuilt.Counts(testTenant))
+
+	assert.NoError(t, userRepo.DeleteUser(testCtx, shashank.Id))
+	assert.Empty(t, roles.Counts(testTenant))
+}
+
+func TestEventSourcedUserRepo_EraseUser(t *testing.T) {
+	dir := t.TempDir()
+	roles := NewRoleIndex()
+	userRepo, store := openFileRepo(t, dir, EventSourcedConfig{SnapshotEvery: 2, Projections: []Projection{&roles}})
+
+	erased := newUser("Jim Halpert", "admin")
+	purged := newUser("Dwight Schrute", "user")
+	kept := newUser("Sasi", "user")
+	for _, user := range []domain.User{erased, purged, kept} {
+		assert.NoError(t, userRepo.SaveUser(testCtx, user))
+	}
+	erased.Name = "Jim H"
+	assert.NoError(t, userRepo.SaveUser(testCtx, erased))
+	assert.NoError(t, userRepo.DeleteUser(testCtx, purged.Id))
+
+	assert.NoError(t, userRepo.EraseUser(testCtx, erased.Id))
+	assert.NoError(t, userRepo.EraseUser(testCtx, purged.Id), "expected the events of a purged user to be erased")
+	assert.ErrorAs(t, userRepo.EraseUser(testCtx, erased.Id), &domain.ErrUserIdNotFound{})
+	_, err := userRepo.GetUserById(testCtx, erased.Id)
+	assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{})
+	assert.Equal(t, map[string]int{"user": 1}, roles.Counts(testTenant))
+
+	for _, name := range []string{"users.log", "users.snapshot"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		for _, erasedName := range []string{"Jim", "Dwight"} {
+			assert.NotContains(t, string(data), erasedName, "expected no trace of the erased users in %s", name)
+		}
+		assert.Contains(t, string(data), "Sasi", name)
+	}
+
+	// the log keeps its numbering and the rewritten file is appended to
+	assert.NoError(t, userRepo.SaveUser(testCtx, newUser("Pam", "user")))
+	assert.NoError(t, store.Close())
+	reopened, _ := openFileRepo(t, dir, EventSourcedConfig{})
+	users, err := reopened.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+}
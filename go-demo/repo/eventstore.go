@@ -0,0 +1,282 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// EventStore is an append-only log of user events
+type EventStore interface {
+	// Append durably adds events, numbered in order, to the end of the log
+	Append(events []UserEvent) error
+	// Load calls fn with the events numbered after after, in order
+	Load(after uint64, fn func(UserEvent) error) error
+	// Rewrite replaces every event of the log with edit(event), keeping
+	// their order. It is how the events of an erased user are removed
+	Rewrite(edit func(UserEvent) UserEvent) error
+}
+
+// InMemEventStore keeps user events in memory
+type InMemEventStore struct {
+	mu     *sync.RWMutex
+	events []UserEvent
+}
+
+func NewInMemEventStore() InMemEventStore {
+	return InMemEventStore{mu: new(sync.RWMutex)}
+}
+
+func (i *InMemEventStore) Append(events []UserEvent) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.events = append(i.events, events...)
+	return nil
+}
+
+func (i *InMemEventStore) Load(after uint64, fn func(UserEvent) error) error {
+	i.mu.RLock()
+	events := i.events
+	i.mu.RUnlock()
+
+	for _, event := range events {
+		if event.Seq <= after {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *InMemEventStore) Rewrite(edit func(UserEvent) UserEvent) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// Load iterates over the events without the lock, so they are copied
+	events := make([]UserEvent, len(i.events))
+	for n, event := range i.events {
+		events[n] = edit(event)
+	}
+	i.events = events
+	return nil
+}
+
+// FileEventStore keeps user events in a file, one json object per line
+type FileEventStore struct {
+	mu   *sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenFileEventStore opens the log at path, creating it if needed. A last
+// line cut short by a crash is dropped, its events were never acknowledged
+func OpenFileEventStore(path string) (FileEventStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return FileEventStore{}, fmt.Errorf("could not open event log: %w", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		_ = file.Close()
+		return FileEventStore{}, fmt.Errorf("could not read event log: %w", err)
+	}
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		if err := file.Truncate(int64(complete)); err != nil {
+			_ = file.Close()
+			return FileEventStore{}, fmt.Errorf("could not drop torn event: %w", err)
+		}
+	}
+	return FileEventStore{mu: new(sync.Mutex), path: path, file: file}, nil
+}
+
+func (f *FileEventStore) Append(events []UserEvent) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("could not encode event: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("could not append events: %w", err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync event log: %w", err)
+	}
+	return nil
+}
+
+func (f *FileEventStore) Load(after uint64, fn func(UserEvent) error) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("could not open event log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a line without its newline is still being appended
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read event log: %w", err)
+		}
+		var event UserEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("could not decode event on line %d: %w", line, err)
+		}
+		if event.Seq <= after {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
+
+// Rewrite writes the edited events to a new file, then swaps it for the log
+func (f *FileEventStore) Rewrite(edit func(UserEvent) UserEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var buf bytes.Buffer
+	err := f.Load(0, func(event UserEvent) error {
+		line, err := json.Marshal(edit(event))
+		if err != nil {
+			return fmt.Errorf("could not encode event: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(f.path, buf.Bytes()); err != nil {
+		return fmt.Errorf("could not replace event log: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open event log: %w", err)
+	}
+	_ = f.file.Close()
+	f.file = file
+	return nil
+}
+
+func (f *FileEventStore) Close() error {
+	return f.file.Close()
+}
+
+// UserSnapshot is the state of every user after the event numbered Seq
+type UserSnapshot struct {
+	Seq   uint64
+	Users []domain.User
+}
+
+// SnapshotStore keeps the latest snapshot of an event sourced repo
+type SnapshotStore interface {
+	SaveSnapshot(snapshot UserSnapshot) error
+	// LoadSnapshot reports false if no snapshot was saved yet
+	LoadSnapshot() (UserSnapshot, bool, error)
+}
+
+// snapshotFormat is the version of the snapshot file format
+const snapshotFormat = 1
+
+type snapshotJSON struct {
+	Format int        `json:"format"`
+	Seq    uint64     `json:"seq"`
+	Users  []userJSON `json:"users"`
+}
+
+// FileSnapshotStore keeps the latest snapshot in a json file, replaced
+// atomically
+type FileSnapshotStore struct {
+	path string
+}
+
+func NewFileSnapshotStore(path string) FileSnapshotStore {
+	return FileSnapshotStore{path: path}
+}
+
+func (f FileSnapshotStore) SaveSnapshot(snapshot UserSnapshot) error {
+	j := snapshotJSON{Format: snapshotFormat, Seq: snapshot.Seq, Users: make([]userJSON, 0, len(snapshot.Users))}
+	for _, user := range snapshot.Users {
+		j.Users = append(j.Users, toUserJSON(user))
+	}
+	data, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("could not encode snapshot: %w", err)
+	}
+
+	if err := replaceFile(f.path, data); err != nil {
+		return fmt.Errorf("could not replace snapshot: %w", err)
+	}
+	return nil
+}
+
+// replaceFile atomically replaces the file at path with one holding data,
+// written and synced aside first
+func replaceFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f FileSnapshotStore) LoadSnapshot() (UserSnapshot, bool, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return UserSnapshot{}, false, nil
+	}
+	if err != nil {
+		return UserSnapshot{}, false, fmt.Errorf("could not read snapshot: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var j snapshotJSON
+	if err := decoder.Decode(&j); err != nil {
+		return UserSnapshot{}, false, fmt.Errorf("could not decode snapshot: %w", err)
+	}
+	if j.Format != snapshotFormat {
+		return UserSnapshot{}, false, fmt.Errorf("unknown snapshot format %d", j.Format)
+	}
+	snapshot := UserSnapshot{Seq: j.Seq, Users: make([]domain.User, 0, len(j.Users))}
+	for _, user := range j.Users {
+		snapshot.Users = append(snapshot.Users, user.user())
+	}
+	return snapshot, true, nil
+}
@@ -0,0 +1,304 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// DefaultFeedRetention is the number of changes ChangeFeedUserRepo keeps by
+// default
+const DefaultFeedRetention = 100_000
+
+type FeedConfig struct {
+	// Retention is the number of latest changes kept, followers further
+	// behind start over from a snapshot. Defaults to DefaultFeedRetention
+	Retention int
+	Clock     domain.Clock
+}
+
+// ChangeFeedUserRepo records every write to the domain.UserRepo it decorates
+// as a domain.Change, numbered in the order the writes were applied, for
+// followers to replicate. The feed is kept in memory: a new feed, with an id
+// of its own, starts when the repo is created
+type ChangeFeedUserRepo struct {
+	repo   domain.UserRepo
+	config FeedConfig
+	id     string
+
+	// mu serializes writes, so that changes are numbered in the order they
+	// are applied to the decorated repo, and snapshots with them
+	mu      *sync.Mutex
+	changes *[]domain.Change
+	last    *uint64
+}
+
+func NewChangeFeedUserRepo(repo domain.UserRepo, config FeedConfig) (ChangeFeedUserRepo, error) {
+	if repo == nil {
+		return ChangeFeedUserRepo{}, fmt.Errorf("cannot create change feed, missing repo")
+	}
+	if _, ok := repo.(domain.Snapshotter); !ok {
+		return ChangeFeedUserRepo{}, fmt.Errorf("cannot create change feed: %w", domain.ErrSnapshotUnsupported)
+	}
+	if config.Retention <= 0 {
+		config.Retention = DefaultFeedRetention
+	}
+	if config.Clock == nil {
+		config.Clock = domain.RealClock{}
+	}
+	return ChangeFeedUserRepo{
+		repo:    repo,
+		config:  config,
+		id:      uuid.NewString(),
+		mu:      new(sync.Mutex),
+		changes: new([]domain.Change),
+		last:    new(uint64),
+	}, nil
+}
+
+// Id returns the id of the feed
+func (f *ChangeFeedUserRepo) Id() string {
+	return f.id
+}
+
+// record numbers change after the last one and keeps it, dropping the oldest
+// changes past the retention. The lock must be held
+func (f *ChangeFeedUserRepo) record(change domain.Change) {
+	*f.last++
+	change.Seq = *f.last
+	change.At = f.config.Clock.Now()
+	changes := append(*f.changes, change)
+	if over := len(changes) - f.config.Retention; over > 0 {
+		changes = changes[over:]
+	}
+	*f.changes = changes
+}
+
+func (f *ChangeFeedUserRepo) SaveUser(ctx context.Context, user domain.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.repo.SaveUser(ctx, user); err != nil {
+		return err
+	}
+	f.record(domain.Change{TenantId: user.TenantId, Op: domain.ChangeSave, UserId: user.Id, User: &user})
+	return nil
+}
+
+func (f *ChangeFeedUserRepo) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.repo.DeleteUser(ctx, id); err != nil {
+		return err
+	}
+	tenant, _ := domain.TenantFromContext(ctx)
+	f.record(domain.Change{TenantId: tenant, Op: domain.ChangeDelete, UserId: id})
+	return nil
+}
+
+// EraseUser also drops the user from the changes kept, each save of it is
+// replaced by an erasure, which followers apply whether the user is there or
+// not
+func (f *ChangeFeedUserRepo) EraseUser(ctx context.Context, id uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.repo.EraseUser(ctx, id); err != nil {
+		return err
+	}
+	tenant, _ := domain.TenantFromContext(ctx)
+	for n, change := range *f.changes {
+		if change.TenantId == tenant && change.UserId == id && change.User != nil {
+			(*f.changes)[n].Op = domain.ChangeErase
+			(*f.changes)[n].User = nil
+		}
+	}
+	f.record(domain.Change{TenantId: tenant, Op: domain.ChangeErase, UserId: id})
+	return nil
+}
+
+func (f *ChangeFeedUserRepo) GetUserById(ctx context.Context, id uuid.UUID) (domain.User, error) {
+	return f.repo.GetUserById(ctx, id)
+}
+
+func (f *ChangeFeedUserRepo) ListUsers(ctx context.Context) ([]domain.User, error) {
+	return f.repo.ListUsers(ctx)
+}
+
+func (f *ChangeFeedUserRepo) QueryUsers(ctx context.Context, up domain.UserProperties) ([]domain.User, error) {
+	return f.repo.QueryUsers(ctx, up)
+}
+
+func (f *ChangeFeedUserRepo) SnapshotUsers(ctx context.Context) ([]domain.User, error) {
+	return f.repo.(domain.Snapshotter).SnapshotUsers(ctx)
+}
+
+// RestoreUsers drops every change kept and skips a seq, so that followers
+// start over from a snapshot rather than replay the restore
+func (f *ChangeFeedUserRepo) RestoreUsers(ctx context.Context, users []domain.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.repo.(domain.Snapshotter).RestoreUsers(ctx, users); err != nil {
+		return err
+	}
+	*f.last++
+	*f.changes = nil
+	return nil
+}
+
+// Changes fails with domain.ErrFeedGap if after is older than the changes
+// kept, or newer than the last change. A limit of 0 or less returns every
+// change following after
+func (f *ChangeFeedUserRepo) Changes(ctx context.Context, after uint64, limit int) (domain.ChangeBatch, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	changes := *f.changes
+	oldest := *f.last - uint64(len(changes))
+	if after < oldest || after > *f.last {
+		return domain.ChangeBatch{}, domain.ErrFeedGap{After: after}
+	}
+	changes = changes[after-oldest:]
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return domain.ChangeBatch{
+		FeedId:  f.id,
+		Changes: append([]domain.Change(nil), changes...),
+		Last:    *f.last,
+	}, nil
+}
+
+// Snapshot holds writes back while the decorated repo takes its snapshot, so
+// that it has every change up to its seq and none after
+func (f *ChangeFeedUserRepo) Snapshot(ctx context.Context) (domain.FeedSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	users, err := f.repo.(domain.Snapshotter).SnapshotUsers(ctx)
+	if err != nil {
+		return domain.FeedSnapshot{}, err
+	}
+	return domain.FeedSnapshot{FeedId: f.id, Seq: *f.last, Users: users}, nil
+}
+
+// changeJSON is the json form of a domain.Change on the wire
+type changeJSON struct {
+	Seq      uint64          `json:"seq"`
+	At       time.Time       `json:"at"`
+	TenantId string          `json:"tenantId"`
+	Op       domain.ChangeOp `json:"op"`
+	UserId   uuid.UUID       `json:"userId"`
+	User     *userJSON       `json:"user,omitempty"`
+}
+
+type changeBatchJSON struct {
+	FeedId  string       `json:"feedId"`
+	Last    uint64       `json:"last"`
+	Changes []changeJSON `json:"changes"`
+}
+
+// feedSnapshotJSON holds the users of a snapshot as a backup archive, so
+// that they are checked against its checksum
+type feedSnapshotJSON struct {
+	FeedId string          `json:"feedId"`
+	Seq    uint64          `json:"seq"`
+	Backup json.RawMessage `json:"backup"`
+}
+
+// EncodeChangeBatch returns the json form of batch
+func EncodeChangeBatch(batch domain.ChangeBatch) ([]byte, error) {
+	j := changeBatchJSON{FeedId: batch.FeedId, Last: batch.Last, Changes: make([]changeJSON, 0, len(batch.Changes))}
+	for _, change := range batch.Changes {
+		c := changeJSON{Seq: change.Seq, At: change.At, TenantId: change.TenantId, Op: change.Op, UserId: change.UserId}
+		if change.User != nil {
+			user := toUserJSON(*change.User)
+			c.User = &user
+		}
+		j.Changes = append(j.Changes, c)
+	}
+	return json.Marshal(j)
+}
+
+// DecodeChangeBatch reads a batch encoded by EncodeChangeBatch
+func DecodeChangeBatch(data []byte) (domain.ChangeBatch, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var j changeBatchJSON
+	if err := decoder.Decode(&j); err != nil {
+		return domain.ChangeBatch{}, fmt.Errorf("could not decode changes: %w", err)
+	}
+	batch := domain.ChangeBatch{FeedId: j.FeedId, Last: j.Last, Changes: make([]domain.Change, 0, len(j.Changes))}
+	for _, c := range j.Changes {
+		change := domain.Change{Seq: c.Seq, At: c.At, TenantId: c.TenantId, Op: c.Op, UserId: c.UserId}
+		if c.User != nil {
+			user := c.User.user()
+			change.User = &user
+		}
+		batch.Changes = append(batch.Changes, change)
+	}
+	return batch, nil
+}
+
+// EncodeFeedSnapshot returns the json form of snapshot, taken at createdAt
+func EncodeFeedSnapshot(snapshot domain.FeedSnapshot, createdAt time.Time) ([]byte, error) {
+	archive, err := EncodeBackup(Backup{CreatedAt: createdAt, Users: snapshot.Users})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(feedSnapshotJSON{FeedId: snapshot.FeedId, Seq: snapshot.Seq, Backup: archive})
+}
+
+// DecodeFeedSnapshot reads a snapshot encoded by EncodeFeedSnapshot, failing
+// with domain.ErrInvalidBackup if its users do not match their checksum
+func DecodeFeedSnapshot(data []byte) (domain.FeedSnapshot, error) {
+	var j feedSnapshotJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return domain.FeedSnapshot{}, fmt.Errorf("could not decode snapshot: %w", err)
+	}
+	backup, err := DecodeBackup(j.Backup)
+	if err != nil {
+		return domain.FeedSnapshot{}, err
+	}
+	return domain.FeedSnapshot{FeedId: j.FeedId, Seq: j.Seq, Users: backup.Users}, nil
+}
+
+// FeedPublisher serves a domain.ChangeFeed in the json forms followers read
+// with RemoteChangeFeed
+type FeedPublisher struct {
+	feed  domain.ChangeFeed
+	clock domain.Clock
+}
+
+func NewFeedPublisher(feed domain.ChangeFeed, clock domain.Clock) (FeedPublisher, error) {
+	if feed == nil {
+		return FeedPublisher{}, fmt.Errorf("cannot create feed publisher, missing feed")
+	}
+	if clock == nil {
+		return FeedPublisher{}, fmt.Errorf("cannot create feed publisher, missing clock")
+	}
+	return FeedPublisher{feed: feed, clock: clock}, nil
+}
+
+func (p *FeedPublisher) Changes(ctx context.Context, after uint64, limit int) ([]byte, error) {
+	batch, err := p.feed.Changes(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeChangeBatch(batch)
+}
+
+func (p *FeedPublisher) Snapshot(ctx context.Context) ([]byte, error) {
+	snapshot, err := p.feed.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeFeedSnapshot(snapshot, p.clock.Now())
+}
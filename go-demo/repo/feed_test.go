@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestFeed(t *testing.T, retention int) (ChangeFeedUserRepo, *InMemUserRepo) {
+	inMemUserRepo := NewInMemUserRepo()
+	feed, err := NewChangeFeedUserRepo(&inMemUserRepo, FeedConfig{Retention: retention, Clock: domaintest.NewFakeClock(eventTime)})
+	assert.NoError(t, err, "change feed creation cannot fail")
+	return feed, &inMemUserRepo
+}
+
+func TestChangeFeedUserRepo(t *testing.T) {
+	feed, _ := newTestFeed(t, 3)
+	sasi := newUser("Sasi", "user")
+	jim := newUser("Jim", "user")
+	assert.NoError(t, feed.SaveUser(testCtx, sasi))
+	assert.NoError(t, feed.SaveUser(testCtx, jim))
+	assert.ErrorAs(t, feed.DeleteUser(testCtx, newUser("Sam", "user").Id), &domain.ErrUserIdNotFound{})
+	assert.NoError(t, feed.DeleteUser(testCtx, jim.Id))
+
+	batch, err := feed.Changes(testCtx, 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, feed.Id(), batch.FeedId)
+	assert.Equal(t, uint64(3), batch.Last, "expected failed writes not to be recorded")
+	assert.Equal(t, []domain.Change{
+		{Seq: 1, At: eventTime, TenantId: testTenant, Op: domain.ChangeSave, UserId: sasi.Id, User: &sasi},
+		{Seq: 2, At: eventTime, TenantId: testTenant, Op: domain.ChangeSave, UserId: jim.Id, User: &jim},
+	}, batch.Changes)
+	batch, err = feed.Changes(testCtx, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Change{{Seq: 3, At: eventTime, TenantId: testTenant, Op: domain.ChangeDelete, UserId: jim.Id}}, batch.Changes)
+	batch, err = feed.Changes(testCtx, 3, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, batch.Changes)
+
+	assert.NoError(t, feed.SaveUser(testCtx, jim))
+	_, err = feed.Changes(testCtx, 0, 0)
+	assert.ErrorAs(t, err, &domain.ErrFeedGap{}, "expected changes past the retention to be dropped")
+	_, err = feed.Changes(testCtx, 5, 0)
+	assert.ErrorAs(t, err, &domain.ErrFeedGap{}, "expected no changes after the last one")
+	batch, err = feed.Changes(testCtx, 1, 0)
+	assert.NoError(t, err)
+	assert.Len(t, batch.Changes, 3)
+
+	snapshot, err := feed.Snapshot(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), snapshot.Seq)
+	assert.ElementsMatch(t, []domain.User{sasi, jim}, snapshot.Users)
+}
+
+func TestChangeFeedUserRepo_Erase(t *testing.T) {
+	feed, _ := newTestFeed(t, 0)
+	sasi := newUser("Sasi", "user")
+	jim := newUser("Jim", "user")
+	assert.NoError(t, feed.SaveUser(testCtx, sasi))
+	assert.NoError(t, feed.SaveUser(testCtx, jim))
+	assert.NoError(t, feed.EraseUser(testCtx, sasi.Id))
+
+	batch, err := feed.Changes(testCtx, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Change{
+		{Seq: 1, At: eventTime, TenantId: testTenant, Op: domain.ChangeErase, UserId: sasi.Id},
+		{Seq: 2, At: eventTime, TenantId: testTenant, Op: domain.ChangeSave, UserId: jim.Id, User: &jim},
+		{Seq: 3, At: eventTime, TenantId: testTenant, Op: domain.ChangeErase, UserId: sasi.Id},
+	}, batch.Changes, "expected no change to keep the erased user")
+}
+
+func TestChangeFeedUserRepo_Restore(t *testing.T) {
+	feed, _ := newTestFeed(t, 0)
+	assert.NoError(t, feed.SaveUser(testCtx, newUser("Sasi", "user")))
+	jim := newUser("Jim", "user")
+	assert.NoError(t, feed.RestoreUsers(testCtx, []domain.User{jim}))
+
+	_, err := feed.Changes(testCtx, 1, 0)
+	assert.ErrorAs(t, err, &domain.ErrFeedGap{}, "expected followers to start over after a restore")
+	snapshot, err := feed.Snapshot(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.FeedSnapshot{FeedId: feed.Id(), Seq: 2, Users: []domain.User{jim}}, snapshot)
+}
+
+func TestChangeBatchJSON(t *testing.T) {
+	sasi := newUser("Sasi", "user")
+	sasi.Attributes = map[string]any{"level": int64(3)}
+	sasi.CreatedAt, sasi.UpdatedAt = eventTime, eventTime
+	batch := domain.ChangeBatch{FeedId: "feed", Last: 9, Changes: []domain.Change{
+		{Seq: 8, At: eventTime, TenantId: testTenant, Op: domain.ChangeSave, UserId: sasi.Id, User: &sasi},
+		{Seq: 9, At: eventTime, TenantId: testTenant, Op: domain.ChangeDelete, UserId: sasi.Id},
+	}}
+	data, err := EncodeChangeBatch(batch)
+	assert.NoError(t, err)
+	decoded, err := DecodeChangeBatch(data)
+	assert.NoError(t, err)
+	assert.Equal(t, batch, decoded)
+
+	snapshot := domain.FeedSnapshot{FeedId: "feed", Seq: 9, Users: []domain.User{sasi}}
+	data, err = EncodeFeedSnapshot(snapshot, eventTime)
+	assert.NoError(t, err)
+	decodedSnapshot, err := DecodeFeedSnapshot(data)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot, decodedSnapshot)
+}
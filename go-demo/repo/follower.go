@@ -0,0 +1,344 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFollowInterval is how often a Follower polls the feed by default,
+// and DefaultFollowBatch how many changes it asks for at once
+const (
+	DefaultFollowInterval = time.Second
+	DefaultFollowBatch    = 1000
+)
+
+type FollowerConfig struct {
+	// Primary names the server the feed is of in the status
+	Primary  string
+	Clock    domain.Clock
+	Interval time.Duration
+	Batch    int
+	// Applied is called after a change was applied, and Restored after the
+	// users were replaced by a snapshot of the feed, to keep what is derived
+	// from users up to date, like the search index
+	Applied  func(ctx context.Context, change domain.Change) error
+	Restored func(ctx context.Context, previous, restored []domain.User) error
+}
+
+// changeApplier is implemented by the decorating repos, to pass changes on
+// to the repo they decorate as they were recorded
+type changeApplier interface {
+	ApplyChange(ctx context.Context, change domain.Change) error
+}
+
+// applyChange writes change to users, in the tenant of the change. Deleting
+// or erasing a user users does not have is not an error, as a feed replaces
+// the saves of an erased user by erasures
+func applyChange(ctx context.Context, users domain.UserRepo, change domain.Change) error {
+	ctx = domain.WithTenant(ctx, change.TenantId)
+	if applier, ok := users.(changeApplier); ok {
+		return applier.ApplyChange(ctx, change)
+	}
+
+	var err error
+	switch change.Op {
+	case domain.ChangeSave:
+		if change.User == nil {
+			return fmt.Errorf("change %d saves no user", change.Seq)
+		}
+		err = users.SaveUser(ctx, *change.User)
+	case domain.ChangeDelete:
+		err = users.DeleteUser(ctx, change.UserId)
+	case domain.ChangeErase:
+		err = users.EraseUser(ctx, change.UserId)
+	default:
+		return fmt.Errorf("change %d has unknown op %q", change.Seq, change.Op)
+	}
+	if errors.As(err, &domain.ErrUserIdNotFound{}) {
+		return nil
+	}
+	return err
+}
+
+// Follower replicates a domain.ChangeFeed into a user repo implementing
+// domain.Snapshotter, applying the changes in order. It starts over from a
+// snapshot of the feed when it first syncs, when the feed is recreated, and
+// when the feed no longer holds the changes it needs
+type Follower struct {
+	users  domain.UserRepo
+	feed   domain.ChangeFeed
+	config FollowerConfig
+
+	// syncing allows one sync at a time
+	syncing *sync.Mutex
+	// mu guards status
+	mu        *sync.Mutex
+	status    *domain.ReplicationStatus
+	startedAt time.Time
+}
+
+func NewFollower(users domain.UserRepo, feed domain.ChangeFeed, config FollowerConfig) (Follower, error) {
+	if users == nil {
+		return Follower{}, fmt.Errorf("cannot create follower, missing user repo")
+	}
+	if _, ok := users.(domain.Snapshotter); !ok {
+		return Follower{}, fmt.Errorf("cannot create follower: %w", domain.ErrSnapshotUnsupported)
+	}
+	if feed == nil {
+		return Follower{}, fmt.Errorf("cannot create follower, missing feed")
+	}
+	if config.Clock == nil {
+		return Follower{}, fmt.Errorf("cannot create follower, missing clock")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultFollowInterval
+	}
+	if config.Batch <= 0 {
+		config.Batch = DefaultFollowBatch
+	}
+	return Follower{
+		users:     users,
+		feed:      feed,
+		config:    config,
+		syncing:   new(sync.Mutex),
+		mu:        new(sync.Mutex),
+		status:    &domain.ReplicationStatus{Primary: config.Primary},
+		startedAt: config.Clock.Now(),
+	}, nil
+}
+
+// Status returns how far the follower is behind. Until it first catches up,
+// its lag is how long it has been running
+func (f *Follower) Status() domain.ReplicationStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := *f.status
+	caughtUpAt := status.CaughtUpAt
+	if caughtUpAt.IsZero() {
+		caughtUpAt = f.startedAt
+	}
+	status.Lag = f.config.Clock.Now().Sub(caughtUpAt)
+	return status
+}
+
+func (f *Follower) update(edit func(status *domain.ReplicationStatus)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	edit(f.status)
+}
+
+// Sync applies the changes of the feed the follower does not have yet, until
+// it has them all
+func (f *Follower) Sync(ctx context.Context) error {
+	f.syncing.Lock()
+	defer f.syncing.Unlock()
+
+	err := f.sync(ctx)
+	f.update(func(status *domain.ReplicationStatus) {
+		status.Err = ""
+		if err != nil {
+			status.Err = err.Error()
+		}
+	})
+	return err
+}
+
+func (f *Follower) sync(ctx context.Context) error {
+	for {
+		status := f.Status()
+		if status.FeedId == "" {
+			if err := f.resync(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		batch, err := f.feed.Changes(ctx, status.Applied, f.config.Batch)
+		if errors.As(err, &domain.ErrFeedGap{}) {
+			log.Println("change feed no longer holds the changes after", status.Applied, "starting over from a snapshot")
+			if err := f.resync(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not read change feed: %w", err)
+		}
+		now := f.config.Clock.Now()
+		if batch.FeedId != status.FeedId {
+			log.Println("change feed was recreated, starting over from a snapshot")
+			if err := f.resync(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		applied := status.Applied
+		for _, change := range batch.Changes {
+			if change.Seq != applied+1 {
+				return fmt.Errorf("change feed skipped from %d to %d", applied, change.Seq)
+			}
+			if err := f.apply(ctx, change); err != nil {
+				return fmt.Errorf("could not apply change %d: %w", change.Seq, err)
+			}
+			applied = change.Seq
+			f.update(func(status *domain.ReplicationStatus) {
+				status.Applied = applied
+			})
+		}
+		f.update(func(status *domain.ReplicationStatus) {
+			status.Last = batch.Last
+			status.LastContactAt = now
+			if applied >= batch.Last {
+				status.CaughtUpAt = now
+			}
+		})
+		if applied >= batch.Last || len(batch.Changes) == 0 {
+			return nil
+		}
+	}
+}
+
+func (f *Follower) apply(ctx context.Context, change domain.Change) error {
+	if err := applyChange(ctx, f.users, change); err != nil {
+		return err
+	}
+	if f.config.Applied != nil {
+		return f.config.Applied(domain.WithTenant(ctx, change.TenantId), change)
+	}
+	return nil
+}
+
+// resync replaces every user with a snapshot of the feed
+func (f *Follower) resync(ctx context.Context) error {
+	snapshot, err := f.feed.Snapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("could not read snapshot of change feed: %w", err)
+	}
+	snapshotter := f.users.(domain.Snapshotter)
+	previous, err := snapshotter.SnapshotUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("could not snapshot users: %w", err)
+	}
+	if err := snapshotter.RestoreUsers(ctx, snapshot.Users); err != nil {
+		return fmt.Errorf("could not restore snapshot of change feed: %w", err)
+	}
+	now := f.config.Clock.Now()
+	f.update(func(status *domain.ReplicationStatus) {
+		status.FeedId = snapshot.FeedId
+		status.Applied = snapshot.Seq
+		status.Last = snapshot.Seq
+		status.LastContactAt = now
+	})
+	if f.config.Restored != nil {
+		if err := f.config.Restored(ctx, previous, snapshot.Users); err != nil {
+			return fmt.Errorf("users were restored, but not what derives from them: %w", err)
+		}
+	}
+	return nil
+}
+
+// Run syncs every interval until ctx is done
+func (f *Follower) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Println("could not follow change feed", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type RemoteFeedConfig struct {
+	// URL is the base url of the primary
+	URL string
+	// Principal is the admin the primary is called as, in PrincipalHeader
+	PrincipalHeader string
+	Principal       string
+	// Client defaults to one timing out after a minute
+	Client *http.Client
+}
+
+// RemoteChangeFeed reads the change feed a primary serves over http
+type RemoteChangeFeed struct {
+	config RemoteFeedConfig
+}
+
+func NewRemoteChangeFeed(config RemoteFeedConfig) (RemoteChangeFeed, error) {
+	if config.URL == "" {
+		return RemoteChangeFeed{}, fmt.Errorf("cannot create remote change feed, missing url")
+	}
+	if config.PrincipalHeader == "" {
+		return RemoteChangeFeed{}, fmt.Errorf("cannot create remote change feed, missing principal header")
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: time.Minute}
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	return RemoteChangeFeed{config: config}, nil
+}
+
+// get returns the body of a GET of path, and the status of the response
+func (r *RemoteChangeFeed) get(ctx context.Context, path string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.config.URL+path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if r.config.Principal != "" {
+		req.Header.Set(r.config.PrincipalHeader, r.config.Principal)
+	}
+	resp, err := r.config.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("primary answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, resp.StatusCode, nil
+}
+
+// Changes fails with domain.ErrFeedGap when the primary answers 410 Gone
+func (r *RemoteChangeFeed) Changes(ctx context.Context, after uint64, limit int) (domain.ChangeBatch, error) {
+	query := url.Values{}
+	query.Set("after", strconv.FormatUint(after, 10))
+	query.Set("limit", strconv.Itoa(limit))
+	body, status, err := r.get(ctx, "/admin/replication/changes?"+query.Encode())
+	if status == http.StatusGone {
+		return domain.ChangeBatch{}, domain.ErrFeedGap{After: after}
+	}
+	if err != nil {
+		return domain.ChangeBatch{}, err
+	}
+	return DecodeChangeBatch(body)
+}
+
+func (r *RemoteChangeFeed) Snapshot(ctx context.Context) (domain.FeedSnapshot, error) {
+	body, _, err := r.get(ctx, "/admin/replication/snapshot")
+	if err != nil {
+		return domain.FeedSnapshot{}, err
+	}
+	return DecodeFeedSnapshot(body)
+}
@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"api-demo/domain/domaintest"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFollower(t *testing.T, users domain.UserRepo, feed domain.ChangeFeed, clock domain.Clock) (Follower, *[]domain.Change, *int) {
+	applied := new([]domain.Change)
+	restores := new(int)
+	follower, err := NewFollower(users, feed, FollowerConfig{
+		Primary: "http://primary",
+		Clock:   clock,
+		Batch:   2,
+		Applied: func(_ context.Context, change domain.Change) error {
+			*applied = append(*applied, change)
+			return nil
+		},
+		Restored: func(context.Context, []domain.User, []domain.User) error {
+			*restores++
+			return nil
+		},
+	})
+	assert.NoError(t, err, "follower creation cannot fail")
+	return follower, applied, restores
+}
+
+func TestFollower(t *testing.T) {
+	clock := domaintest.NewFakeClock(eventTime)
+	feed, _ := newTestFeed(t, 4)
+	sasi := newUser("Sasi", "user")
+	assert.NoError(t, feed.SaveUser(testCtx, sasi))
+
+	followerRepo := NewInMemUserRepo()
+	follower, applied, restores := newTestFollower(t, &followerRepo, &feed, clock)
+	clock.Advance(time.Minute)
+	assert.Equal(t, domain.ReplicationStatus{Primary: "http://primary", Lag: time.Minute}, follower.Status())
+
+	assert.NoError(t, follower.Sync(testCtx))
+	assert.Equal(t, 1, *restores, "expected the first sync to start from a snapshot")
+	user, err := followerRepo.GetUserById(testCtx, sasi.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, sasi, user)
+
+	jim := newUser("Jim", "user")
+	assert.NoError(t, feed.SaveUser(testCtx, jim))
+	sasi.Role = "admin"
+	assert.NoError(t, feed.SaveUser(testCtx, sasi))
+	assert.NoError(t, feed.DeleteUser(testCtx, jim.Id))
+	clock.Advance(time.Minute)
+	status := follower.Status()
+	assert.Equal(t, uint64(1), status.Applied)
+	assert.Equal(t, time.Minute, status.Lag)
+
+	assert.NoError(t, follower.Sync(testCtx))
+	assert.Len(t, *applied, 3, "expected every change to be applied, two at a time")
+	users, err := followerRepo.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{sasi}, users)
+	status = follower.Status()
+	assert.Equal(t, uint64(4), status.Applied)
+	assert.Equal(t, uint64(0), status.Behind())
+	assert.Equal(t, time.Duration(0), status.Lag)
+	assert.Equal(t, 1, *restores)
+
+	for n := 0; n < 5; n++ {
+		assert.NoError(t, feed.SaveUser(testCtx, sasi))
+	}
+	assert.NoError(t, follower.Sync(testCtx))
+	assert.Equal(t, 2, *restores, "expected to start over once the feed no longer holds the changes needed")
+	assert.Equal(t, uint64(9), follower.Status().Applied)
+
+	recreated, _ := newTestFeed(t, 0)
+	follower.feed = &recreated
+	assert.NoError(t, follower.Sync(testCtx))
+	assert.Equal(t, 3, *restores, "expected to start over from a recreated feed")
+	users, err = followerRepo.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestFollower_ApplyErasure(t *testing.T) {
+	feed, _ := newTestFeed(t, 0)
+	followerRepo := NewInMemUserRepo()
+	follower, _, _ := newTestFollower(t, &followerRepo, &feed, domaintest.NewFakeClock(eventTime))
+	assert.NoError(t, follower.Sync(testCtx))
+
+	sasi := newUser("Sasi", "user")
+	assert.NoError(t, feed.SaveUser(testCtx, sasi))
+	assert.NoError(t, feed.EraseUser(testCtx, sasi.Id))
+	assert.NoError(t, follower.Sync(testCtx), "expected erasures of users the follower never had to apply")
+	_, err := followerRepo.GetUserById(testCtx, sasi.Id)
+	assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{})
+}
+
+func TestFollower_EncryptedAndCached(t *testing.T) {
+	keyring := testKeyring(t, "k1", "k1")
+	primaryBase := NewInMemUserRepo()
+	feed, err := NewChangeFeedUserRepo(&primaryBase, FeedConfig{Clock: domaintest.NewFakeClock(eventTime)})
+	assert.NoError(t, err)
+	primary, err := NewEncryptingUserRepo(&feed, EncryptionConfig{Keyring: keyring})
+	assert.NoError(t, err)
+
+	followerBase := NewInMemUserRepo()
+	encrypting, err := NewEncryptingUserRepo(&followerBase, EncryptionConfig{Keyring: keyring})
+	assert.NoError(t, err)
+	caching, err := NewCachingUserRepo(&encrypting, CacheConfig{Size: 10, Clock: domaintest.NewFakeClock(eventTime)})
+	assert.NoError(t, err)
+	follower, _, _ := newTestFollower(t, &caching, &feed, domaintest.NewFakeClock(eventTime))
+
+	sasi := newUser("Sasi", "user")
+	assert.NoError(t, primary.SaveUser(testCtx, sasi))
+	assert.NoError(t, follower.Sync(testCtx))
+	user, err := caching.GetUserById(testCtx, sasi.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sasi", user.Name)
+
+	sasi.Name = "Sasi K"
+	assert.NoError(t, primary.SaveUser(testCtx, sasi))
+	batch, err := feed.Changes(testCtx, 1, 0)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(batch.Changes[0].User.Name, sealedPrefix), "expected changes to carry sealed names")
+	assert.NoError(t, follower.Sync(testCtx))
+	stored, err := followerBase.GetUserById(testCtx, sasi.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, batch.Changes[0].User.Name, stored.Name, "expected sealed names to be stored as they are")
+	user, err = caching.GetUserById(testCtx, sasi.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sasi K", user.Name, "expected the cached user to be invalidated")
+}
@@ -0,0 +1,242 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+)
+
+// InMemGroupRepo keeps groups and their memberships in memory, indexed both
+// by group and by user. Every method is scoped to the tenant of its context
+type InMemGroupRepo struct {
+	mu     *sync.RWMutex
+	groups map[uuid.UUID]domain.Group
+	// members maps a group id to its memberships by user id
+	members map[uuid.UUID]map[uuid.UUID]domain.Membership
+	// byUser maps a user id to the ids of its groups
+	byUser map[uuid.UUID]idSet
+}
+
+func NewInMemGroupRepo() InMemGroupRepo {
+	return InMemGroupRepo{
+		mu:      new(sync.RWMutex),
+		groups:  make(map[uuid.UUID]domain.Group),
+		members: make(map[uuid.UUID]map[uuid.UUID]domain.Membership),
+		byUser:  make(map[uuid.UUID]idSet),
+	}
+}
+
+// get returns the group with id if it belongs to tenant, the lock must be held
+func (i *InMemGroupRepo) get(tenant string, id uuid.UUID) (domain.Group, bool) {
+	group, ok := i.groups[id]
+	if !ok || group.TenantId != tenant {
+		return domain.Group{}, false
+	}
+	return group, true
+}
+
+// removeMembership drops a membership from both indexes, the write lock must
+// be held
+func (i *InMemGroupRepo) removeMembership(groupId, userId uuid.UUID) {
+	delete(i.members[groupId], userId)
+	groups := i.byUser[userId]
+	delete(groups, groupId)
+	if len(groups) == 0 {
+		delete(i.byUser, userId)
+	}
+}
+
+// SaveGroup fails with domain.ErrTenantMismatch if group is not of the
+// tenant of ctx, or if its id is taken by a group of another tenant
+func (i *InMemGroupRepo) SaveGroup(ctx context.Context, group domain.Group) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+	if group.TenantId != tenant {
+		return domain.ErrTenantMismatch
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if existing, ok := i.groups[group.Id]; ok && existing.TenantId != tenant {
+		return domain.ErrTenantMismatch
+	}
+	i.groups[group.Id] = group
+	if _, ok := i.members[group.Id]; !ok {
+		i.members[group.Id] = make(map[uuid.UUID]domain.Membership)
+	}
+	return nil
+}
+
+func (i *InMemGroupRepo) GetGroupById(ctx context.Context, id uuid.UUID) (domain.Group, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return domain.Group{}, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	group, ok := i.get(tenant, id)
+	if !ok {
+		return domain.Group{}, domain.ErrGroupIdNotFound{Id: id}
+	}
+	return group, nil
+}
+
+func (i *InMemGroupRepo) ListGroups(ctx context.Context) ([]domain.Group, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	resp := make([]domain.Group, 0)
+	for _, group := range i.groups {
+		if group.TenantId == tenant {
+			resp = append(resp, group)
+		}
+	}
+	return resp, nil
+}
+
+func (i *InMemGroupRepo) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.get(tenant, id); !ok {
+		return domain.ErrGroupIdNotFound{Id: id}
+	}
+	for userId := range i.members[id] {
+		i.removeMembership(id, userId)
+	}
+	delete(i.members, id)
+	delete(i.groups, id)
+	return nil
+}
+
+func (i *InMemGroupRepo) AddMembership(ctx context.Context, membership domain.Membership) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.get(tenant, membership.GroupId); !ok {
+		return domain.ErrGroupIdNotFound{Id: membership.GroupId}
+	}
+	members := i.members[membership.GroupId]
+	if _, ok := members[membership.UserId]; ok {
+		return nil
+	}
+	members[membership.UserId] = membership
+	groups, ok := i.byUser[membership.UserId]
+	if !ok {
+		groups = make(idSet)
+		i.byUser[membership.UserId] = groups
+	}
+	groups[membership.GroupId] = struct{}{}
+	return nil
+}
+
+func (i *InMemGroupRepo) RemoveMembership(ctx context.Context, groupId, userId uuid.UUID) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.get(tenant, groupId); !ok {
+		return domain.ErrGroupIdNotFound{Id: groupId}
+	}
+	if _, ok := i.members[groupId][userId]; !ok {
+		return domain.ErrMembershipNotFound{GroupId: groupId, UserId: userId}
+	}
+	i.removeMembership(groupId, userId)
+	return nil
+}
+
+func (i *InMemGroupRepo) ListMemberships(ctx context.Context, groupId uuid.UUID, page domain.Page) ([]domain.Membership, int, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if _, ok := i.get(tenant, groupId); !ok {
+		return nil, 0, domain.ErrGroupIdNotFound{Id: groupId}
+	}
+	memberships := make([]domain.Membership, 0, len(i.members[groupId]))
+	for _, membership := range i.members[groupId] {
+		memberships = append(memberships, membership)
+	}
+	sort.Slice(memberships, func(a, b int) bool {
+		if !memberships[a].AddedAt.Equal(memberships[b].AddedAt) {
+			return memberships[a].AddedAt.Before(memberships[b].AddedAt)
+		}
+		return bytes.Compare(memberships[a].UserId[:], memberships[b].UserId[:]) < 0
+	})
+
+	total := len(memberships)
+	start, end := page.Offset, page.Offset+page.Limit
+	if start > total {
+		start = total
+	}
+	if end > total || page.Limit <= 0 {
+		end = total
+	}
+	return memberships[start:end], total, nil
+}
+
+func (i *InMemGroupRepo) ListGroupsOfUser(ctx context.Context, userId uuid.UUID) ([]domain.Group, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	resp := make([]domain.Group, 0, len(i.byUser[userId]))
+	for groupId := range i.byUser[userId] {
+		if group, ok := i.get(tenant, groupId); ok {
+			resp = append(resp, group)
+		}
+	}
+	return resp, nil
+}
+
+func (i *InMemGroupRepo) RemoveUserMemberships(ctx context.Context, userId uuid.UUID) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for groupId := range i.byUser[userId] {
+		if _, ok := i.get(tenant, groupId); ok {
+			i.removeMembership(groupId, userId)
+		}
+	}
+	return nil
+}
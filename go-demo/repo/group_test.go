@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newGroup(name string) domain.Group {
+	return domain.Group{Id: uuid.New(), TenantId: testTenant, Name: name}
+}
+
+func TestInMemGroupRepo_Memberships(t *testing.T) {
+	groupRepo := NewInMemGroupRepo()
+
+	platform, design := newGroup("Platform"), newGroup("Design")
+	assert.NoError(t, groupRepo.SaveGroup(testCtx, platform))
+	assert.NoError(t, groupRepo.SaveGroup(testCtx, design))
+
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	users := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for n, userId := range users {
+		membership := domain.Membership{GroupId: platform.Id, UserId: userId, AddedAt: now.Add(time.Duration(n) * time.Second)}
+		assert.NoError(t, groupRepo.AddMembership(testCtx, membership))
+	}
+	assert.NoError(t, groupRepo.AddMembership(testCtx, domain.Membership{GroupId: design.Id, UserId: users[0], AddedAt: now}))
+
+	// adding a member again keeps the original membership
+	assert.NoError(t, groupRepo.AddMembership(testCtx, domain.Membership{GroupId: platform.Id, UserId: users[0], AddedAt: now.Add(time.Hour)}))
+
+	memberships, total, err := groupRepo.ListMemberships(testCtx, platform.Id, domain.Page{Offset: 1, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []domain.Membership{{GroupId: platform.Id, UserId: users[1], AddedAt: now.Add(time.Second)}}, memberships)
+
+	memberships, _, err = groupRepo.ListMemberships(testCtx, platform.Id, domain.Page{Offset: 5, Limit: 1})
+	assert.NoError(t, err)
+	assert.Empty(t, memberships)
+
+	groups, err := groupRepo.ListGroupsOfUser(testCtx, users[0])
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Group{platform, design}, groups)
+
+	assert.NoError(t, groupRepo.RemoveUserMemberships(testCtx, users[0]))
+	groups, err = groupRepo.ListGroupsOfUser(testCtx, users[0])
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+
+	assert.Equal(t, domain.ErrMembershipNotFound{GroupId: design.Id, UserId: users[0]},
+		groupRepo.RemoveMembership(testCtx, design.Id, users[0]))
+	assert.NoError(t, groupRepo.RemoveMembership(testCtx, platform.Id, users[1]))
+
+	// deleting a group drops its memberships
+	assert.NoError(t, groupRepo.DeleteGroup(testCtx, platform.Id))
+	groups, err = groupRepo.ListGroupsOfUser(testCtx, users[2])
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+	assert.Equal(t, domain.ErrGroupIdNotFound{Id: platform.Id},
+		groupRepo.AddMembership(testCtx, domain.Membership{GroupId: platform.Id, UserId: users[2]}))
+}
+
+func TestInMemGroupRepo_TenantIsolation(t *testing.T) {
+	groupRepo := NewInMemGroupRepo()
+	globexCtx := domain.WithTenant(context.Background(), "globex")
+
+	platform := newGroup("Platform")
+	assert.NoError(t, groupRepo.SaveGroup(testCtx, platform))
+
+	_, err := groupRepo.GetGroupById(globexCtx, platform.Id)
+	assert.Equal(t, domain.ErrGroupIdNotFound{Id: platform.Id}, err)
+
+	groups, err := groupRepo.ListGroups(globexCtx)
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+
+	assert.Equal(t, domain.ErrGroupIdNotFound{Id: platform.Id},
+		groupRepo.AddMembership(globexCtx, domain.Membership{GroupId: platform.Id, UserId: uuid.New()}))
+
+	hijacked := platform
+	hijacked.TenantId = "globex"
+	assert.Equal(t, domain.ErrTenantMismatch, groupRepo.SaveGroup(globexCtx, hijacked))
+}
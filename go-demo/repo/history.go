@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/google/uuid"
+	"sync"
+)
+
+// InMemUserHistory keeps every version of every user in memory. Every method
+// is scoped to the tenant of its context
+type InMemUserHistory struct {
+	mu       *sync.RWMutex
+	versions map[uuid.UUID][]domain.UserVersion
+}
+
+func NewInMemUserHistory() InMemUserHistory {
+	return InMemUserHistory{
+		mu:       new(sync.RWMutex),
+		versions: make(map[uuid.UUID][]domain.UserVersion),
+	}
+}
+
+// Record fails with domain.ErrTenantMismatch if user is not of the tenant of
+// ctx, or if its id has versions in another tenant
+func (i *InMemUserHistory) Record(ctx context.Context, user domain.User) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+	if user.TenantId != tenant {
+		return domain.ErrTenantMismatch
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	versions := i.versions[user.Id]
+	if len(versions) > 0 && versions[0].User.TenantId != tenant {
+		return domain.ErrTenantMismatch
+	}
+	i.versions[user.Id] = append(versions, domain.UserVersion{Version: len(versions) + 1, User: user})
+	return nil
+}
+
+func (i *InMemUserHistory) Versions(ctx context.Context, id uuid.UUID) ([]domain.UserVersion, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	versions := i.versions[id]
+	if len(versions) == 0 || versions[0].User.TenantId != tenant {
+		return nil, domain.ErrUserIdNotFound{Id: id}
+	}
+	return append([]domain.UserVersion(nil), versions...), nil
+}
+
+func (i *InMemUserHistory) Erase(ctx context.Context, id uuid.UUID) error {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	versions := i.versions[id]
+	if len(versions) == 0 || versions[0].User.TenantId != tenant {
+		return domain.ErrUserIdNotFound{Id: id}
+	}
+	delete(i.versions, id)
+	return nil
+}
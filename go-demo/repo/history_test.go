@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestInMemUserHistory(t *testing.T) {
+	history := NewInMemUserHistory()
+
+	user := newUser("Sasi", "user")
+	assert.NoError(t, history.Record(testCtx, user))
+	user.Role = "admin"
+	assert.NoError(t, history.Record(testCtx, user))
+
+	versions, err := history.Versions(testCtx, user.Id)
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Equal(t, "user", versions[0].User.Role)
+	assert.Equal(t, 2, versions[1].Version)
+	assert.Equal(t, "admin", versions[1].User.Role)
+
+	globexCtx := domain.WithTenant(context.Background(), "globex")
+	_, err = history.Versions(globexCtx, user.Id)
+	assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{}, "expected versions of another tenant to be hidden")
+	user.TenantId = "globex"
+	assert.ErrorIs(t, history.Record(globexCtx, user), domain.ErrTenantMismatch)
+
+	assert.ErrorAs(t, history.Erase(globexCtx, user.Id), &domain.ErrUserIdNotFound{}, "expected versions of another tenant to be kept")
+	assert.NoError(t, history.Erase(testCtx, user.Id))
+	_, err = history.Versions(testCtx, user.Id)
+	assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{}, "expected every version to be erased")
+}
@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// idempotencySweepInterval is how often expired records are dropped
+const idempotencySweepInterval = time.Minute
+
+type InMemIdempotencyRepo struct {
+	mu        *sync.Mutex
+	records   map[string]domain.IdempotencyRecord
+	lastSweep *time.Time
+}
+
+func NewInMemIdempotencyRepo() InMemIdempotencyRepo {
+	return InMemIdempotencyRepo{
+		mu:        new(sync.Mutex),
+		records:   make(map[string]domain.IdempotencyRecord),
+		lastSweep: new(time.Time),
+	}
+}
+
+func (i *InMemIdempotencyRepo) ReserveRecord(ctx context.Context, record domain.IdempotencyRecord) (domain.IdempotencyRecord, bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if record.CreatedAt.Sub(*i.lastSweep) >= idempotencySweepInterval {
+		*i.lastSweep = record.CreatedAt
+		for key, r := range i.records {
+			if r.Expired(record.CreatedAt) {
+				delete(i.records, key)
+			}
+		}
+	}
+
+	existing, ok := i.records[record.Key]
+	if ok && !existing.Expired(record.CreatedAt) {
+		return existing, false, nil
+	}
+	i.records[record.Key] = record
+	return domain.IdempotencyRecord{}, true, nil
+}
+
+func (i *InMemIdempotencyRepo) SaveRecord(ctx context.Context, record domain.IdempotencyRecord) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.records[record.Key] = record
+	return nil
+}
+
+func (i *InMemIdempotencyRepo) DeleteRecord(ctx context.Context, key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.records, key)
+	return nil
+}
+
+func (i *InMemIdempotencyRepo) DeleteUserRecords(ctx context.Context, id uuid.UUID) (int, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	deleted := 0
+	for key, r := range i.records {
+		if r.TenantId == tenant && r.References(id) {
+			delete(i.records, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestInMemIdempotencyRepo_Expiry(t *testing.T) {
+	idempotencyRepo := NewInMemIdempotencyRepo()
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	record := domain.IdempotencyRecord{Key: "abc", CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+
+	_, reserved, err := idempotencyRepo.ReserveRecord(testCtx, record)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	_, reserved, err = idempotencyRepo.ReserveRecord(testCtx, record)
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+
+	record.CreatedAt = now.Add(time.Minute)
+	_, reserved, err = idempotencyRepo.ReserveRecord(testCtx, record)
+	assert.NoError(t, err)
+	assert.True(t, reserved, "expected expired record to be replaced")
+}
+
+func TestInMemIdempotencyRepo_DeleteUserRecords(t *testing.T) {
+	idempotencyRepo := NewInMemIdempotencyRepo()
+	user, other := uuid.New(), uuid.New()
+	records := []domain.IdempotencyRecord{
+		{Key: "acme|created", TenantId: testTenant, Completed: true, Body: []byte(`{"name":"Sasi"}`), UserIds: []uuid.UUID{user}},
+		{Key: "acme|managed", TenantId: testTenant, Completed: true, UserIds: []uuid.UUID{other, user}},
+		{Key: "acme|other", TenantId: testTenant, Completed: true, Body: []byte(`{"id":"` + user.String() + `"}`), UserIds: []uuid.UUID{other}},
+		{Key: "globex|created", TenantId: "globex", Completed: true, UserIds: []uuid.UUID{user}},
+	}
+	for _, record := range records {
+		assert.NoError(t, idempotencyRepo.SaveRecord(testCtx, record))
+	}
+
+	deleted, err := idempotencyRepo.DeleteUserRecords(testCtx, user)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	for key, want := range map[string]bool{"acme|created": false, "acme|managed": false, "acme|other": true, "globex|created": true} {
+		_, reserved, err := idempotencyRepo.ReserveRecord(testCtx, domain.IdempotencyRecord{Key: key})
+		assert.NoError(t, err)
+		assert.Equal(t, !want, reserved, "unexpected record kept for %s", key)
+	}
+
+	_, err = idempotencyRepo.DeleteUserRecords(context.Background(), user)
+	assert.ErrorIs(t, err, domain.ErrNoTenant)
+}
@@ -0,0 +1,224 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"bytes"
+	"github.com/google/uuid"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Relevance of a query token matching an indexed term
+const (
+	exactMatchScore  = 1.0
+	prefixMatchScore = 0.7
+	fuzzyMatchScore  = 0.5
+)
+
+// InMemUserSearchIndex is an inverted index over user names, supporting
+// prefix matching and typo tolerance
+type InMemUserSearchIndex struct {
+	mu *sync.RWMutex
+	// postings maps a term to the ids of the users whose name contains it
+	postings map[string]idSet
+	// terms is the sorted vocabulary, used for prefix lookups
+	terms []string
+	// docs maps a user id to the terms of its name
+	docs map[uuid.UUID][]string
+	// tenants maps a user id to its tenant
+	tenants map[uuid.UUID]string
+}
+
+func NewInMemUserSearchIndex() InMemUserSearchIndex {
+	return InMemUserSearchIndex{
+		mu:       new(sync.RWMutex),
+		postings: make(map[string]idSet),
+		docs:     make(map[uuid.UUID][]string),
+		tenants:  make(map[uuid.UUID]string),
+	}
+}
+
+// tokenize lower cases s and splits it on anything but letters and digits,
+// dropping repeated tokens
+func tokenize(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	seen := make(map[string]bool, len(fields))
+	tokens := fields[:0]
+	for _, f := range fields {
+		if !seen[f] {
+			seen[f] = true
+			tokens = append(tokens, f)
+		}
+	}
+	return tokens
+}
+
+func (i *InMemUserSearchIndex) IndexUser(user domain.User) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(user.Id)
+	terms := tokenize(user.Name)
+	i.docs[user.Id] = terms
+	i.tenants[user.Id] = user.TenantId
+	for _, term := range terms {
+		ids, ok := i.postings[term]
+		if !ok {
+			ids = make(idSet)
+			i.postings[term] = ids
+			n := sort.SearchStrings(i.terms, term)
+			i.terms = append(i.terms, "")
+			copy(i.terms[n+1:], i.terms[n:])
+			i.terms[n] = term
+		}
+		ids[user.Id] = struct{}{}
+	}
+}
+
+func (i *InMemUserSearchIndex) RemoveUser(id uuid.UUID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(id)
+}
+
+// remove drops id from the index, the write lock must be held
+func (i *InMemUserSearchIndex) remove(id uuid.UUID) {
+	for _, term := range i.docs[id] {
+		ids := i.postings[term]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(i.postings, term)
+			n := sort.SearchStrings(i.terms, term)
+			i.terms = append(i.terms[:n], i.terms[n+1:]...)
+		}
+	}
+	delete(i.docs, id)
+	delete(i.tenants, id)
+}
+
+// maxEdits is the number of typos tolerated in a query token of length n
+func maxEdits(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Search scores every user by the best match of each query token against the
+// terms of its name, summed over the query tokens. Ties go to shorter names.
+// The vocabulary is shared by all tenants, hits of other tenants are dropped
+// before limiting
+func (i *InMemUserSearchIndex) Search(tenant string, query string, limit int) []domain.SearchHit {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	scores := make(map[uuid.UUID]float64)
+	for _, token := range tokenize(query) {
+		best := make(map[uuid.UUID]float64)
+		match := func(term string, score float64) {
+			for id := range i.postings[term] {
+				if score > best[id] {
+					best[id] = score
+				}
+			}
+		}
+
+		match(token, exactMatchScore)
+
+		for n := sort.SearchStrings(i.terms, token); n < len(i.terms) && strings.HasPrefix(i.terms[n], token); n++ {
+			if i.terms[n] != token {
+				match(i.terms[n], prefixMatchScore)
+			}
+		}
+
+		if edits := maxEdits(len([]rune(token))); edits > 0 {
+			for _, term := range i.terms {
+				if term == token {
+					continue
+				}
+				if d, ok := editDistance(token, term, edits); ok {
+					match(term, fuzzyMatchScore/float64(d))
+				}
+			}
+		}
+
+		for id, score := range best {
+			scores[id] += score
+		}
+	}
+
+	hits := make([]domain.SearchHit, 0, len(scores))
+	for id, score := range scores {
+		if i.tenants[id] != tenant {
+			continue
+		}
+		hits = append(hits, domain.SearchHit{Id: id, Score: score})
+	}
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		if la, lb := len(i.docs[hits[a].Id]), len(i.docs[hits[b].Id]); la != lb {
+			return la < lb
+		}
+		return bytes.Compare(hits[a].Id[:], hits[b].Id[:]) < 0
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b, which counts insertions, deletions, substitutions and transpositions of
+// adjacent characters, if it is at most max
+func editDistance(a, b string, max int) (int, bool) {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return 0, false
+	}
+
+	prevPrev := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for y := range prev {
+		prev[y] = y
+	}
+	for x := 1; x <= len(ra); x++ {
+		curr[0] = x
+		for y := 1; y <= len(rb); y++ {
+			cost := 1
+			if ra[x-1] == rb[y-1] {
+				cost = 0
+			}
+			curr[y] = minInt(prev[y]+1, curr[y-1]+1, prev[y-1]+cost)
+			if x > 1 && y > 1 && ra[x-1] == rb[y-2] && ra[x-2] == rb[y-1] {
+				curr[y] = minInt(curr[y], prevPrev[y-2]+1)
+			}
+		}
+		prevPrev, prev, curr = prev, curr, prevPrev
+	}
+	if prev[len(rb)] > max {
+		return 0, false
+	}
+	return prev[len(rb)], true
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func searchIds(index *InMemUserSearchIndex, query string) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, hit := range index.Search(testTenant, query, 0) {
+		ids = append(ids, hit.Id)
+	}
+	return ids
+}
+
+func TestInMemUserSearchIndex_Search(t *testing.T) {
+	index := NewInMemUserSearchIndex()
+
+	shashank := newUser("Shashank Pachava", "admin")
+	shank := newUser("Shank", "user")
+	sasi := newUser("Sasi Pachava", "user")
+	sridhar := newUser("Sridhar", "user")
+	for _, u := range []domain.User{shashank, shank, sasi, sridhar} {
+		index.IndexUser(u)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []uuid.UUID
+	}{
+		{name: "exact", query: "sridhar", want: []uuid.UUID{sridhar.Id}},
+		{name: "case insensitive", query: "SHANK", want: []uuid.UUID{shank.Id}},
+		{name: "prefix", query: "sha", want: []uuid.UUID{shank.Id, shashank.Id}},
+		{name: "typo", query: "sridahr", want: []uuid.UUID{sridhar.Id}},
+		{name: "two typos in a long word", query: "shashnak", want: []uuid.UUID{shashank.Id}},
+		{name: "more matching tokens rank higher", query: "pachava sasi", want: []uuid.UUID{sasi.Id, shashank.Id}},
+		{name: "no match", query: "zed", want: nil},
+		{name: "empty", query: " ", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, searchIds(&index, tt.query))
+		})
+	}
+
+	assert.Len(t, index.Search(testTenant, "s", 2), 2, "expected results to be limited")
+}
+
+func TestInMemUserSearchIndex_Update(t *testing.T) {
+	index := NewInMemUserSearchIndex()
+
+	user := newUser("Anna Anna", "admin")
+	other := newUser("Annabel", "user")
+	index.IndexUser(user)
+	index.IndexUser(other)
+
+	user.Name = "Jane Doe"
+	index.IndexUser(user)
+	assert.Equal(t, []uuid.UUID{other.Id}, searchIds(&index, "anna"))
+	assert.Equal(t, []uuid.UUID{user.Id}, searchIds(&index, "jane"))
+
+	index.RemoveUser(user.Id)
+	assert.Nil(t, searchIds(&index, "jane"))
+	assert.Equal(t, []string{"annabel"}, index.terms, "expected vocabulary to shrink")
+}
+
+func Test_editDistance(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		max    int
+		want   int
+		wantOk bool
+	}{
+		{a: "kitten", b: "sitting", max: 3, want: 3, wantOk: true},
+		{a: "kitten", b: "sitting", max: 2, wantOk: false},
+		{a: "same", b: "same", max: 1, want: 0, wantOk: true},
+		{a: "sridahr", b: "sridhar", max: 1, want: 1, wantOk: true},
+		{a: "ab", b: "abcd", max: 1, wantOk: false},
+		{a: "héllo", b: "hello", max: 1, want: 1, wantOk: true},
+	}
+	for _, tt := range tests {
+		got, ok := editDistance(tt.a, tt.b, tt.max)
+		assert.Equal(t, tt.wantOk, ok, "%s %s", tt.a, tt.b)
+		if tt.wantOk {
+			assert.Equal(t, tt.want, got, "%s %s", tt.a, tt.b)
+		}
+	}
+}
+
+func TestInMemUserSearchIndex_TenantIsolation(t *testing.T) {
+	index := NewInMemUserSearchIndex()
+
+	acme := newUser("Sasi Pachava", "user")
+	globex := domain.NewUser("Sasi", "user")
+	globex.TenantId = "globex"
+	index.IndexUser(globex)
+	index.IndexUser(acme)
+
+	// the better hit of another tenant does not take the only slot
+	hits := index.Search(testTenant, "sasi", 1)
+	assert.Equal(t, []domain.SearchHit{{Id: acme.Id, Score: 1}}, hits)
+}
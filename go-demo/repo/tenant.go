@@ -0,0 +1,52 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"sync"
+)
+
+type InMemTenantRepo struct {
+	mu      *sync.RWMutex
+	tenants map[string]domain.Tenant
+}
+
+func NewInMemTenantRepo() InMemTenantRepo {
+	return InMemTenantRepo{
+		mu:      new(sync.RWMutex),
+		tenants: make(map[string]domain.Tenant),
+	}
+}
+
+func (i *InMemTenantRepo) CreateTenant(ctx context.Context, tenant domain.Tenant) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.tenants[tenant.Id]; ok {
+		return domain.ErrTenantExists{Id: tenant.Id}
+	}
+	i.tenants[tenant.Id] = tenant
+	return nil
+}
+
+func (i *InMemTenantRepo) GetTenantById(ctx context.Context, id string) (domain.Tenant, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	tenant, ok := i.tenants[id]
+	if !ok {
+		return domain.Tenant{}, domain.ErrTenantNotFound{Id: id}
+	}
+	return tenant, nil
+}
+
+func (i *InMemTenantRepo) ListTenants(ctx context.Context) ([]domain.Tenant, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	resp := make([]domain.Tenant, 0, len(i.tenants))
+	for _, tenant := range i.tenants {
+		resp = append(resp, tenant)
+	}
+	return resp, nil
+}
@@ -0,0 +1,28 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestInMemTenantRepo(t *testing.T) {
+	tenantRepo := NewInMemTenantRepo()
+	ctx := context.Background()
+
+	acme := domain.Tenant{Id: "acme", Name: "Acme", MaxUsers: 10}
+	assert.NoError(t, tenantRepo.CreateTenant(ctx, acme))
+	assert.Equal(t, domain.ErrTenantExists{Id: "acme"}, tenantRepo.CreateTenant(ctx, domain.Tenant{Id: "acme"}))
+
+	got, err := tenantRepo.GetTenantById(ctx, "acme")
+	assert.NoError(t, err)
+	assert.Equal(t, acme, got)
+
+	_, err = tenantRepo.GetTenantById(ctx, "globex")
+	assert.Equal(t, domain.ErrTenantNotFound{Id: "globex"}, err)
+
+	tenants, err := tenantRepo.ListTenants(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Tenant{acme}, tenants)
+}
@@ -3,55 +3,375 @@ package repo
 import (
 	"api-demo/domain"
 	"context"
-	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"sort"
+	"strings"
 	"sync"
 )
 
+// idSet is a set of user ids
+type idSet map[uuid.UUID]struct{}
+
+// InMemUserRepo keeps users in memory, along with secondary indexes on
+// tenant, role, normalized name and manager that are updated under the same
+// lock as the users. Every method is scoped to the tenant of its context.
+// Unique constraints are checked under the write lock, so concurrent saves
+// cannot both take the same key
 type InMemUserRepo struct {
-	*sync.Map
+	mu       *sync.RWMutex
+	users    map[uuid.UUID]domain.User
+	byTenant map[string]idSet
+	byRole   map[string]idSet
+	byName   map[string]idSet
+	// byManager is keyed by the string form of the manager id
+	byManager map[string]idSet
+	// unique holds, for each constraint, the id of the user holding a key
+	constraints []domain.UniqueConstraint
+	unique      []map[string]uuid.UUID
+}
+
+// NewInMemUserRepo returns a repo enforcing constraints
+func NewInMemUserRepo(constraints ...domain.UniqueConstraint) InMemUserRepo {
+	unique := make([]map[string]uuid.UUID, len(constraints))
+	for n := range unique {
+		unique[n] = make(map[string]uuid.UUID)
+	}
+	return InMemUserRepo{
+		mu:          new(sync.RWMutex),
+		users:       make(map[uuid.UUID]domain.User),
+		byTenant:    make(map[string]idSet),
+		byRole:      make(map[string]idSet),
+		byName:      make(map[string]idSet),
+		byManager:   make(map[string]idSet),
+		constraints: append([]domain.UniqueConstraint(nil), constraints...),
+		unique:      unique,
+	}
+}
+
+// normalizeName is the key of the name index
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func addToIndex(index map[string]idSet, key string, id uuid.UUID) {
+	ids, ok := index[key]
+	if !ok {
+		ids = make(idSet)
+		index[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func removeFromIndex(index map[string]idSet, key string, id uuid.UUID) {
+	ids := index[key]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(index, key)
+	}
+}
+
+// index adds user to the secondary indexes, the write lock must be held
+func (i *InMemUserRepo) index(user domain.User) {
+	addToIndex(i.byTenant, user.TenantId, user.Id)
+	addToIndex(i.byRole, user.Role, user.Id)
+	addToIndex(i.byName, normalizeName(user.Name), user.Id)
+	addToIndex(i.byManager, user.ManagerId.String(), user.Id)
+	for n, constraint := range i.constraints {
+		if key, ok := constraint.Key(user); ok {
+			i.unique[n][key] = user.Id
+		}
+	}
+}
+
+// unindex removes user from the secondary indexes, the write lock must be held
+func (i *InMemUserRepo) unindex(user domain.User) {
+	removeFromIndex(i.byTenant, user.TenantId, user.Id)
+	removeFromIndex(i.byRole, user.Role, user.Id)
+	removeFromIndex(i.byName, normalizeName(user.Name), user.Id)
+	removeFromIndex(i.byManager, user.ManagerId.String(), user.Id)
+	for n, constraint := range i.constraints {
+		if key, ok := constraint.Key(user); ok && i.unique[n][key] == user.Id {
+			delete(i.unique[n], key)
+		}
+	}
+}
+
+// checkUnique returns domain.ErrConflict if a user other than user holds one
+// of its keys, the lock must be held
+func (i *InMemUserRepo) checkUnique(user domain.User) error {
+	for n, constraint := range i.constraints {
+		key, ok := constraint.Key(user)
+		if !ok {
+			continue
+		}
+		if holder, taken := i.unique[n][key]; taken && holder != user.Id {
+			return domain.ErrConflict{Constraint: constraint, ExistingId: holder}
+		}
+	}
+	return nil
+}
+
+// put stores user in place of any previous version of it, the write lock
+// must be held
+func (i *InMemUserRepo) put(user domain.User) {
+	if existing, ok := i.users[user.Id]; ok {
+		i.unindex(existing)
+	}
+	i.users[user.Id] = user
+	i.index(user)
+}
+
+// remove drops a stored user, the write lock must be held
+func (i *InMemUserRepo) remove(user domain.User) {
+	delete(i.users, user.Id)
+	i.unindex(user)
+}
+
+// all returns every user of every tenant, sorted by id, the lock must be
+// held
+func (i *InMemUserRepo) all() []domain.User {
+	users := make([]domain.User, 0, len(i.users))
+	for _, user := range i.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(a, b int) bool {
+		return users[a].Id.String() < users[b].Id.String()
+	})
+	return users
+}
+
+// checkRestore returns an error if users hold the same id twice or break a
+// unique constraint of i. It needs no lock
+func (i *InMemUserRepo) checkRestore(users []domain.User) error {
+	scratch := NewInMemUserRepo(i.constraints...)
+	for _, user := range users {
+		if _, ok := scratch.users[user.Id]; ok {
+			return fmt.Errorf("user %s is restored twice", domain.Mask(user.Id))
+		}
+		if err := scratch.checkUnique(user); err != nil {
+			return err
+		}
+		scratch.put(user)
+	}
+	return nil
 }
 
-func NewInMemUserRepo() InMemUserRepo {
-	return InMemUserRepo{Map: new(sync.Map)}
+// replace drops every user, then stores users, the write lock must be held
+func (i *InMemUserRepo) replace(users []domain.User) {
+	for _, user := range i.users {
+		i.remove(user)
+	}
+	for _, user := range users {
+		i.put(user)
+	}
 }
 
+// get returns the user with id if it belongs to tenant, the lock must be held
+func (i *InMemUserRepo) get(tenant string, id uuid.UUID) (domain.User, bool) {
+	user, ok := i.users[id]
+	if !ok || user.TenantId != tenant {
+		return domain.User{}, false
+	}
+	return user, true
+}
+
+// SaveUser fails with domain.ErrTenantMismatch if user is not of the tenant
+// of ctx, or if its id is taken by a user of another tenant, and with
+// domain.ErrConflict if it breaks a unique constraint
 func (i *InMemUserRepo) SaveUser(ctx context.Context, user domain.User) error {
-	i.Store(user.Id, user)
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+	if user.TenantId != tenant {
+		return domain.ErrTenantMismatch
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if existing, ok := i.users[user.Id]; ok {
+		if existing.TenantId != tenant {
+			return domain.ErrTenantMismatch
+		}
+	}
+	if err := i.checkUnique(user); err != nil {
+		return err
+	}
+	i.put(user)
 	return nil
 }
 
 func (i *InMemUserRepo) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	_, found := i.LoadAndDelete(id)
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	user, found := i.get(tenant, id)
 	if !found {
 		return domain.ErrUserIdNotFound{Id: id}
 	}
+	i.remove(user)
 	return nil
 }
 
+// EraseUser deletes the user, the repo keeps no past state of it
+func (i *InMemUserRepo) EraseUser(ctx context.Context, id uuid.UUID) error {
+	return i.DeleteUser(ctx, id)
+}
+
 func (i *InMemUserRepo) GetUserById(ctx context.Context, id uuid.UUID) (domain.User, error) {
-	val, ok := i.Load(id)
-	if !ok {
-		return domain.User{}, domain.ErrUserIdNotFound{Id: id}
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return domain.User{}, err
 	}
-	user, ok := val.(domain.User)
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	user, ok := i.get(tenant, id)
 	if !ok {
-		return user, errors.New("could not cast map val to user type")
+		return domain.User{}, domain.ErrUserIdNotFound{Id: id}
 	}
 	return user, nil
 }
 
 func (i *InMemUserRepo) ListUsers(ctx context.Context) ([]domain.User, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	ids := i.byTenant[tenant]
+	resp := make([]domain.User, 0, len(ids))
+	for id := range ids {
+		resp = append(resp, i.users[id])
+	}
+	return resp, nil
+}
+
+// QueryUsers narrows the candidates down with the smallest matching index,
+// including the parts of up.Filter the indexes can answer, before checking
+// them against up
+func (i *InMemUserRepo) QueryUsers(ctx context.Context, up domain.UserProperties) ([]domain.User, error) {
+	tenant, err := domain.RequireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	// the tenant index is always a candidate, the others may be smaller
+	candidates := i.byTenant[tenant]
+	narrow := func(ids idSet) {
+		if len(ids) < len(candidates) {
+			candidates = ids
+		}
+	}
+	if up.Role != nil {
+		narrow(i.byRole[*up.Role])
+	}
+	if up.Name != nil {
+		narrow(i.byName[normalizeName(*up.Name)])
+	}
+	if up.ManagerId != nil {
+		narrow(i.byManager[up.ManagerId.String()])
+	}
+	if up.Filter != nil {
+		if ids, ok := i.filterCandidates(up.Filter); ok {
+			narrow(ids)
+		}
+	}
+
 	var resp []domain.User
-	var err error
-	i.Range(func(_, val any) bool {
-		user, ok := val.(domain.User)
-		if !ok {
-			err = errors.New("could not cast map val to user type")
-			return false
+	for id := range candidates {
+		if user, ok := i.get(tenant, id); ok && up.Matches(user) {
+			resp = append(resp, user)
 		}
-		resp = append(resp, user)
-		return true
-	})
-	return resp, err
+	}
+	return resp, nil
+}
+
+// filterCandidates returns a superset of the ids matching f using the
+// indexes, or false if f cannot be answered from them. The read lock must be
+// held
+func (i *InMemUserRepo) filterCandidates(f domain.Filter) (idSet, bool) {
+	switch f := f.(type) {
+	case domain.Comparison:
+		if f.Op != domain.OpEq && f.Op != domain.OpIn {
+			return nil, false
+		}
+		var index map[string]idSet
+		key := func(v string) string { return v }
+		switch {
+		case f.Field == "role" && !f.CaseInsensitive:
+			index = i.byRole
+		case f.Field == "name":
+			// the name index is normalized, so it also covers ieq
+			index, key = i.byName, normalizeName
+		default:
+			return nil, false
+		}
+		if len(f.Values) == 1 {
+			return index[key(f.Values[0])], true
+		}
+		union := make(idSet)
+		for _, v := range f.Values {
+			for id := range index[key(v)] {
+				union[id] = struct{}{}
+			}
+		}
+		return union, true
+	case domain.And:
+		var smallest idSet
+		found := false
+		for _, term := range f.Terms {
+			if ids, ok := i.filterCandidates(term); ok && (!found || len(ids) < len(smallest)) {
+				smallest, found = ids, true
+			}
+		}
+		return smallest, found
+	case domain.Or:
+		union := make(idSet)
+		for _, term := range f.Terms {
+			ids, ok := i.filterCandidates(term)
+			if !ok {
+				return nil, false
+			}
+			for id := range ids {
+				union[id] = struct{}{}
+			}
+		}
+		return union, true
+	default:
+		return nil, false
+	}
+}
+
+func (i *InMemUserRepo) SnapshotUsers(ctx context.Context) ([]domain.User, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.all(), nil
+}
+
+func (i *InMemUserRepo) RestoreUsers(ctx context.Context, users []domain.User) error {
+	if err := i.checkRestore(users); err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.replace(users)
+	return nil
 }
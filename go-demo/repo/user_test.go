@@ -0,0 +1,349 @@
+package repo
+
+import (
+	"api-demo/domain"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTenant = "acme"
+
+var testCtx = domain.WithTenant(context.Background(), testTenant)
+
+// newUser returns a new user of testTenant
+func newUser(name, role string) domain.User {
+	user := domain.NewUser(name, role)
+	user.TenantId = testTenant
+	return user
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestInMemUserRepo_QueryUsers(t *testing.T) {
+	userRepo := NewInMemUserRepo()
+	ctx := testCtx
+
+	admin := newUser("Shashank Pachava", "admin")
+	user1 := newUser("Sasi", "user")
+	user2 := newUser("Sridhar", "user")
+	for _, u := range []domain.User{admin, user1, user2} {
+		assert.NoError(t, userRepo.SaveUser(ctx, u))
+	}
+
+	users, err := userRepo.QueryUsers(ctx, domain.UserProperties{Role: stringPtr("user")})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []domain.User{user1, user2}, users)
+
+	users, err = userRepo.QueryUsers(ctx, domain.UserProperties{Role: stringPtr("user"), Name: stringPtr("Sasi")})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{user1}, users)
+
+	// the name index is normalized, but matching is still exact
+	users, err = userRepo.QueryUsers(ctx, domain.UserProperties{Name: stringPtr("sasi")})
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+
+	users, err = userRepo.QueryUsers(ctx, domain.UserProperties{})
+	assert.NoError(t, err)
+	assert.Len(t, users, 3)
+
+	// moving a user to another role updates the index
+	user1.Role = "admin"
+	assert.NoError(t, userRepo.SaveUser(ctx, user1))
+	users, err = userRepo.QueryUsers(ctx, domain.UserProperties{Role: stringPtr("admin")})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []domain.User{admin, user1}, users)
+
+	assert.NoError(t, userRepo.DeleteUser(ctx, admin.Id))
+	users, err = userRepo.QueryUsers(ctx, domain.UserProperties{Role: stringPtr("admin")})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{user1}, users)
+
+	assert.Equal(t, domain.ErrUserIdNotFound{Id: admin.Id}, userRepo.DeleteUser(ctx, admin.Id))
+}
+
+func TestInMemUserRepo_QueryUsers_Manager(t *testing.T) {
+	userRepo := NewInMemUserRepo()
+	ctx := testCtx
+
+	ceo := newUser("Shashank Pachava", "admin")
+	report1 := newUser("Sasi", "user")
+	report1.ManagerId = ceo.Id
+	report2 := newUser("Sridhar", "user")
+	report2.ManagerId = ceo.Id
+	for _, u := range []domain.User{ceo, report1, report2} {
+		assert.NoError(t, userRepo.SaveUser(ctx, u))
+	}
+
+	users, err := userRepo.QueryUsers(ctx, domain.UserProperties{ManagerId: &ceo.Id})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []domain.User{report1, report2}, users)
+
+	// uuid.Nil matches the users without a manager
+	users, err = userRepo.QueryUsers(ctx, domain.UserProperties{ManagerId: &uuid.Nil})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{ceo}, users)
+
+	// moving a user to another manager updates the index
+	report2.ManagerId = report1.Id
+	assert.NoError(t, userRepo.SaveUser(ctx, report2))
+	users, err = userRepo.QueryUsers(ctx, domain.UserProperties{ManagerId: &ceo.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{report1}, users)
+}
+
+func TestInMemUserRepo_QueryUsers_Filter(t *testing.T) {
+	userRepo := NewInMemUserRepo()
+	ctx := testCtx
+
+	admin := newUser("Shashank Pachava", "admin")
+	ops := newUser("Sasi", "ops")
+	user := newUser("Sridhar", "user")
+	for _, u := range []domain.User{admin, ops, user} {
+		assert.NoError(t, userRepo.SaveUser(ctx, u))
+	}
+
+	tests := []struct {
+		filter  string
+		indexed bool
+		want    []domain.User
+	}{
+		{filter: `role in ("admin", "ops")`, indexed: true, want: []domain.User{admin, ops}},
+		{filter: `role in ("admin", "ops") and name startswith "Sa"`, indexed: true, want: []domain.User{ops}},
+		{filter: `name ieq "sridhar" or role eq "admin"`, indexed: true, want: []domain.User{admin, user}},
+		{filter: `name startswith "S"`, indexed: false, want: []domain.User{admin, ops, user}},
+		{filter: `not role eq "admin"`, indexed: false, want: []domain.User{ops, user}},
+		{filter: `role eq "admin" or name contains "i"`, indexed: false, want: []domain.User{admin, ops, user}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := domain.ParseFilter(tt.filter)
+			assert.NoError(t, err)
+
+			_, indexed := userRepo.filterCandidates(f)
+			assert.Equal(t, tt.indexed, indexed, "unexpected index use")
+
+			users, err := userRepo.QueryUsers(ctx, domain.UserProperties{Filter: f})
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, users)
+		})
+	}
+}
+
+func TestInMemUserRepo_ConcurrentIndexConsistency(t *testing.T) {
+	userRepo := NewInMemUserRepo()
+	ctx := testCtx
+
+	ids := make([]uuid.UUID, 50)
+	for n := range ids {
+		ids[n] = uuid.New()
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < 500; n++ {
+				id := ids[(w+n)%len(ids)]
+				if n%3 == 0 {
+					_ = userRepo.DeleteUser(ctx, id)
+					continue
+				}
+				_ = userRepo.SaveUser(ctx, domain.User{
+					Id:   id,
+					Name: fmt.Sprintf("user %d", n%7),
+					Role: fmt.Sprintf("role %d", n%5),
+				})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	indexed := 0
+	for role, roleIds := range userRepo.byRole {
+		for id := range roleIds {
+			assert.Equal(t, role, userRepo.users[id].Role, "role index out of sync")
+			indexed++
+		}
+	}
+	assert.Equal(t, len(userRepo.users), indexed, "role index out of sync")
+
+	indexed = 0
+	for name, nameIds := range userRepo.byName {
+		for id := range nameIds {
+			assert.Equal(t, name, normalizeName(userRepo.users[id].Name), "name index out of sync")
+			indexed++
+		}
+	}
+	assert.Equal(t, len(userRepo.users), indexed, "name index out of sync")
+}
+
+// benchmarkRepo returns a repo holding n users spread over 100 roles
+func benchmarkRepo(b *testing.B, n int) InMemUserRepo {
+	userRepo := NewInMemUserRepo()
+	for i := 0; i < n; i++ {
+		err := userRepo.SaveUser(testCtx,
+			newUser(fmt.Sprintf("user %d", i), fmt.Sprintf("role %d", i%100)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	return userRepo
+}
+
+func BenchmarkInMemUserRepo_RoleLookup(b *testing.B) {
+	userRepo := benchmarkRepo(b, 200_000)
+	up := domain.UserProperties{Role: stringPtr("role 42")}
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			users, err := userRepo.ListUsers(testCtx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			domain.FilterUsers(users, up)
+		}
+	})
+
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := userRepo.QueryUsers(testCtx, up); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkInMemUserRepo_NameLookup(b *testing.B) {
+	userRepo := benchmarkRepo(b, 200_000)
+	up := domain.UserProperties{Name: stringPtr("user 4242")}
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			users, err := userRepo.ListUsers(testCtx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			domain.FilterUsers(users, up)
+		}
+	})
+
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := userRepo.QueryUsers(testCtx, up); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestInMemUserRepo_TenantIsolation(t *testing.T) {
+	userRepo := NewInMemUserRepo()
+	globexCtx := domain.WithTenant(context.Background(), "globex")
+
+	acmeUser := newUser("Sasi", "user")
+	globexUser := domain.NewUser("Sasi", "user")
+	globexUser.TenantId = "globex"
+	assert.NoError(t, userRepo.SaveUser(testCtx, acmeUser))
+	assert.NoError(t, userRepo.SaveUser(globexCtx, globexUser))
+
+	_, err := userRepo.GetUserById(globexCtx, acmeUser.Id)
+	assert.Equal(t, domain.ErrUserIdNotFound{Id: acmeUser.Id}, err)
+	assert.Equal(t, domain.ErrUserIdNotFound{Id: acmeUser.Id}, userRepo.DeleteUser(globexCtx, acmeUser.Id))
+
+	users, err := userRepo.ListUsers(globexCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{globexUser}, users)
+
+	users, err = userRepo.QueryUsers(testCtx, domain.UserProperties{Name: stringPtr("Sasi")})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.User{acmeUser}, users)
+
+	// users cannot be saved into, or taken over from, another tenant
+	assert.Equal(t, domain.ErrTenantMismatch, userRepo.SaveUser(globexCtx, acmeUser))
+	hijacked := acmeUser
+	hijacked.TenantId = "globex"
+	assert.Equal(t, domain.ErrTenantMismatch, userRepo.SaveUser(globexCtx, hijacked))
+
+	got, err := userRepo.GetUserById(testCtx, acmeUser.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, acmeUser, got)
+
+	_, err = userRepo.ListUsers(context.Background())
+	assert.Equal(t, domain.ErrNoTenant, err)
+}
+
+func TestInMemUserRepo_UniqueConstraints(t *testing.T) {
+	name := domain.UniqueConstraint{Fields: []string{"name"}, CaseInsensitive: true}
+	number := domain.UniqueConstraint{Fields: []string{"attributes.employeeNumber"}}
+	userRepo := NewInMemUserRepo(name, number)
+
+	jane := newUser("Jane Doe", "admin")
+	jane.Attributes = map[string]any{"employeeNumber": int64(7)}
+	assert.NoError(t, userRepo.SaveUser(testCtx, jane))
+
+	other := newUser("JANE DOE", "user")
+	err := userRepo.SaveUser(testCtx, other)
+	assert.Equal(t, domain.ErrConflict{Constraint: name, ExistingId: jane.Id}, err)
+	_, err = userRepo.GetUserById(testCtx, other.Id)
+	assert.ErrorAs(t, err, &domain.ErrUserIdNotFound{}, "expected conflicting user not to be saved")
+
+	other.Name = "John Doe"
+	other.Attributes = map[string]any{"employeeNumber": int64(7)}
+	assert.Equal(t, domain.ErrConflict{Constraint: number, ExistingId: jane.Id}, userRepo.SaveUser(testCtx, other))
+
+	// users without a value are not constrained
+	other.Attributes = nil
+	assert.NoError(t, userRepo.SaveUser(testCtx, other))
+	assert.NoError(t, userRepo.SaveUser(testCtx, newUser("Jim", "user")))
+
+	// saving a user again keeps its own keys, renaming releases the old ones
+	jane.Name = "Jane Roe"
+	assert.NoError(t, userRepo.SaveUser(testCtx, jane))
+	other.Name = "jane doe"
+	assert.NoError(t, userRepo.SaveUser(testCtx, other))
+
+	// soft deleted users release their keys
+	other.DeletedAt = time.Now()
+	assert.NoError(t, userRepo.SaveUser(testCtx, other))
+	assert.NoError(t, userRepo.SaveUser(testCtx, newUser("Jane Doe", "user")))
+
+	// another tenant has its own keys
+	globex := domain.NewUser("Jane Roe", "user")
+	globex.TenantId = "globex"
+	assert.NoError(t, userRepo.SaveUser(domain.WithTenant(context.Background(), "globex"), globex))
+}
+
+func TestInMemUserRepo_UniqueConstraints_Concurrent(t *testing.T) {
+	userRepo := NewInMemUserRepo(domain.UniqueConstraint{Fields: []string{"name"}})
+
+	var wg sync.WaitGroup
+	saved := make(chan uuid.UUID, 20)
+	for w := 0; w < cap(saved); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user := newUser("Jane Doe", "user")
+			if err := userRepo.SaveUser(testCtx, user); err == nil {
+				saved <- user.Id
+			} else {
+				assert.ErrorAs(t, err, &domain.ErrConflict{})
+			}
+		}()
+	}
+	wg.Wait()
+	close(saved)
+
+	assert.Len(t, saved, 1, "expected a single user to take the name")
+	users, err := userRepo.ListUsers(testCtx)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+}